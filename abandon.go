@@ -0,0 +1,135 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// AbandonedWorker describes a job routine DrainWithDeadline stopped waiting for because its
+	// job did not return within the grace period, see WithAbandonStuckWorkers.
+	AbandonedWorker struct {
+		Routine  int               // The index of the job routine.
+		Name     string            // The name the routine uses in logs and panic reports.
+		JobID    uint64            // The ID of the job the routine is stuck in.
+		Job      string            // The name of the job the routine is stuck in.
+		Metadata map[string]string // The key/value pairs attached to the job.
+		Started  time.Time         // When the job started.
+		Stack    string            // The stack of the routine when it was abandoned.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithAbandonStuckWorkers bounds how long DrainWithDeadline waits for the job routines once it
+// shuts the pool down. The routines still running a job after grace are left behind and reported
+// in ShutdownReport.AbandonedWorkers with their job and stack, so a job that blocks forever can't
+// keep the process from exiting. An abandoned routine is never stopped and keeps its job's
+// resources until the job returns, if it ever does.
+func WithAbandonStuckWorkers(grace time.Duration) Option {
+	return func(config *Config) {
+		config.StuckWorkerGrace = grace
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// goroutineStacks returns the stack of every goroutine in the process keyed by goroutine ID.
+func goroutineStacks() map[uint64]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[uint64]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		header := bytes.TrimPrefix(stack, []byte("goroutine "))
+		end := bytes.IndexByte(header, ' ')
+		if end <= 0 {
+			continue
+		}
+
+		if id, err := strconv.ParseUint(string(header[:end]), 10, 64); err == nil {
+			stacks[id] = string(stack)
+		}
+	}
+
+	return stacks
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// waitForWorkers waits for the job routines to go down. With a grace period greater than zero
+// it stops waiting once the grace period is over and returns the routines that are still up.
+func (jobPool *JobPool) waitForWorkers(goRoutine string, grace time.Duration) []AbandonedWorker {
+	if grace <= 0 {
+		jobPool.shutdownWaitGroup.Wait()
+		return nil
+	}
+
+	// The waiting routine is left behind with the stuck job routines.
+	down := make(chan struct{})
+	go func() {
+		jobPool.shutdownWaitGroup.Wait()
+		close(down)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-down:
+		return nil
+
+	case <-timer.C:
+	}
+
+	abandonedWorkers := jobPool.stuckWorkers()
+	for _, abandonedWorker := range abandonedWorkers {
+		jobPool.writeLogf(LogError, goRoutine, "waitForWorkers", "ERROR : Abandoned Stuck Worker : Routine[%d] ID[%d] Job[%s] Running[%v]", abandonedWorker.Routine, abandonedWorker.JobID, abandonedWorker.Job, time.Since(abandonedWorker.Started))
+	}
+
+	return abandonedWorkers
+}
+
+// stuckWorkers describes the job routines that have not gone down, with the stack of each.
+func (jobPool *JobPool) stuckWorkers() []AbandonedWorker {
+	stacks := goroutineStacks()
+	now := time.Now()
+
+	var abandonedWorkers []AbandonedWorker
+	for jobRoutine, workerState := range jobPool.workerStates() {
+		id := atomic.LoadUint64(&workerState.goroutineID)
+		if id == 0 {
+			continue
+		}
+
+		workerStat := workerState.stat(jobRoutine, now)
+		abandonedWorkers = append(abandonedWorkers, AbandonedWorker{
+			Routine:  jobRoutine,
+			Name:     workerStat.Name,
+			JobID:    workerStat.JobID,
+			Job:      workerStat.Job,
+			Metadata: workerStat.Metadata,
+			Started:  workerStat.LastJobStarted,
+			Stack:    stacks[id],
+		})
+	}
+
+	return abandonedWorkers
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// AdmissionInfo describes where the queue routine placed a job. The depths are read by the
+	// queue routine as the job is placed, so they can't be changed by other submitters in between.
+	AdmissionInfo struct {
+		ID         uint64 // The number given to the job, the ID accepted by Cancel and reported in JobMeta.
+		Priority   bool   // If the job was placed in a priority queue. It can differ from the priority asked for.
+		QueueDepth int32  // The number of jobs in the queue the job was placed in across tenants, the job included.
+		QueuedJobs int32  // The number of pending jobs in both queues, the job included.
+		CallerRan  bool   // If the queue was at capacity and the job was run by its submitter under CallerRuns.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobInfo queues a job like QueueJob and reports where it was placed. The AdmissionInfo is
+// the zero value when the job is rejected.
+func (jobPool *JobPool) QueueJobInfo(goRoutine string, jober Jobber, priority bool, options ...JobOption) (admission AdmissionInfo, err error) {
+	options = append(options[:len(options):len(options)], func(queueJob *queueJob) {
+		queueJob.admissionInfo = &admission
+	})
+
+	err = jobPool.QueueJob(goRoutine, jober, priority, options...)
+	return admission, err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// admissionInfo describes where a job was just placed. It is only called by the queue routine.
+func (jobPool *JobPool) admissionInfo(queueJob *queueJob) AdmissionInfo {
+	admission := AdmissionInfo{
+		ID:         queueJob.id,
+		Priority:   queueJob.queue == queueJob.tenantQueue.priorityJobQueue,
+		QueuedJobs: atomic.LoadInt32(&jobPool.gauges.queuedJobs),
+	}
+
+	admission.QueueDepth = atomic.LoadInt32(&jobPool.gauges.priorityJobs)
+	if admission.Priority == false {
+		admission.QueueDepth = admission.QueuedJobs - admission.QueueDepth
+	}
+
+	return admission
+}
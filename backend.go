@@ -0,0 +1,428 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// Lease is a job handed out by a Backend. The job is hidden from other consumers until the
+	// lease expires, so a consumer that dies without acknowledging it doesn't lose the job.
+	Lease struct {
+		ID         string        // Identifies this delivery of the job to Extend, Ack and Release.
+		Data       []byte        // The job serialized by MarshalJob.
+		TTL        time.Duration // How long the job stays hidden unless the lease is extended.
+		Deliveries int           // The number of times the job has been handed out, this time included.
+	}
+
+	// MemoryBackend is a Backend held in memory. It gives a single process the same lease
+	// semantics as a durable backend, which makes it useful for tests.
+	MemoryBackend struct {
+		ttl      time.Duration           // How long a lease lasts.
+		clock    Clock                   // Tells the time leases expire against.
+		priority *list.List              // The priority jobs waiting to be handed out.
+		normal   *list.List              // The normal jobs waiting to be handed out.
+		leases   map[string]*memoryEntry // The jobs handed out by lease ID.
+		sequence uint64                  // The number given to the last lease.
+		mutex    sync.Mutex              // Protects the lists and leases.
+	}
+
+	// memoryEntry is a job in a MemoryBackend.
+	memoryEntry struct {
+		data        []byte    // The job serialized by MarshalJob.
+		priority    bool      // If the job is handed out before the normal jobs.
+		deliveries  int       // The number of times the job has been handed out.
+		leaseID     string    // The ID of the lease the job is handed out under.
+		leasedUntil time.Time // When the lease expires.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// backendMinWait is the first wait of FeedBackend when the backend is empty or failing.
+	backendMinWait = 10 * time.Millisecond
+
+	// backendMaxWait is the longest FeedBackend waits before asking the backend again.
+	backendMaxWait = time.Second
+)
+
+//** VARIABLES
+
+var (
+	// ErrLeaseLost is returned by a Backend when a lease has expired and its job has been handed
+	// out again.
+	ErrLeaseLost = errors.New("Lease Lost")
+)
+
+//** INTERFACES
+
+// Backend holds jobs outside the pool and hands them out under a lease. Pop returns nil and no
+// error when no job is waiting. A job whose lease expires is handed out again with its
+// Deliveries counted up. Extend renews the lease for another TTL, Ack removes the job once it
+// has finished and Release hands the job out again straight away. They return ErrLeaseLost
+// once the job has been handed out again under another lease. Len returns the number of jobs
+// waiting to be handed out.
+type Backend interface {
+	Put(ctx context.Context, data []byte, priority bool) error
+	Pop(ctx context.Context) (*Lease, error)
+	Extend(ctx context.Context, lease *Lease) error
+	Ack(ctx context.Context, lease *Lease) error
+	Release(ctx context.Context, lease *Lease) error
+	Len(ctx context.Context) (int64, error)
+}
+
+//** PUBLIC FUNCTIONS
+
+// NewMemoryBackend creates an empty MemoryBackend whose leases last for the ttl. A nil clock
+// uses the system clock.
+func NewMemoryBackend(ttl time.Duration, clock Clock) *MemoryBackend {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &MemoryBackend{
+		ttl:      ttl,
+		clock:    clock,
+		priority: list.New(),
+		normal:   list.New(),
+		leases:   make(map[string]*memoryEntry),
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// FeedBackend pops jobs from the backend and queues them in the pool until the context is done
+// or the pool is shut down. The lease of each job is extended every third of its TTL while the
+// pool holds the job. It is acknowledged once the job has finished running, and released so the
+// job is handed out again if the pool cancels it, for example when it is shut down. A job
+// handed out more than once reads its Deliveries from its JobMeta. Jobs that can't be decoded
+// are acknowledged so they are not handed out forever, and are passed to the rejection handler.
+func (jobPool *JobPool) FeedBackend(ctx context.Context, backend Backend) error {
+	return jobPool.feedBackend(ctx, backend, false)
+}
+
+// Put places a serialized job at the back of its queue.
+func (memoryBackend *MemoryBackend) Put(ctx context.Context, data []byte, priority bool) error {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	memoryBackend.queue(priority).PushBack(&memoryEntry{
+		data:     data,
+		priority: priority,
+	})
+
+	return nil
+}
+
+// Pop hands out the next job, priority jobs first, after returning the jobs whose leases have
+// expired to the front of their queues.
+func (memoryBackend *MemoryBackend) Pop(ctx context.Context) (*Lease, error) {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	now := memoryBackend.clock.Now()
+	for leaseID, memoryEntry := range memoryBackend.leases {
+		if now.Before(memoryEntry.leasedUntil) == false {
+			delete(memoryBackend.leases, leaseID)
+			memoryBackend.queue(memoryEntry.priority).PushFront(memoryEntry)
+		}
+	}
+
+	element := memoryBackend.priority.Front()
+	if element == nil {
+		element = memoryBackend.normal.Front()
+	}
+
+	if element == nil {
+		return nil, nil
+	}
+
+	memoryEntry := memoryBackend.queue(element.Value.(*memoryEntry).priority).Remove(element).(*memoryEntry)
+
+	memoryBackend.sequence++
+	memoryEntry.deliveries++
+	memoryEntry.leaseID = strconv.FormatUint(memoryBackend.sequence, 10)
+	memoryEntry.leasedUntil = now.Add(memoryBackend.ttl)
+	memoryBackend.leases[memoryEntry.leaseID] = memoryEntry
+
+	return &Lease{
+		ID:         memoryEntry.leaseID,
+		Data:       memoryEntry.data,
+		TTL:        memoryBackend.ttl,
+		Deliveries: memoryEntry.deliveries,
+	}, nil
+}
+
+// Extend renews the lease for another TTL.
+func (memoryBackend *MemoryBackend) Extend(ctx context.Context, lease *Lease) error {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	memoryEntry, err := memoryBackend.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	memoryEntry.leasedUntil = memoryBackend.clock.Now().Add(memoryBackend.ttl)
+	return nil
+}
+
+// Ack removes the job of the lease.
+func (memoryBackend *MemoryBackend) Ack(ctx context.Context, lease *Lease) error {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	if _, err := memoryBackend.leased(lease); err != nil {
+		return err
+	}
+
+	delete(memoryBackend.leases, lease.ID)
+	return nil
+}
+
+// Release returns the job of the lease to the front of its queue.
+func (memoryBackend *MemoryBackend) Release(ctx context.Context, lease *Lease) error {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	memoryEntry, err := memoryBackend.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	delete(memoryBackend.leases, lease.ID)
+	memoryBackend.queue(memoryEntry.priority).PushFront(memoryEntry)
+	return nil
+}
+
+// Len returns the number of jobs waiting to be handed out. Jobs whose leases have expired are
+// counted once the next Pop returns them to their queues.
+func (memoryBackend *MemoryBackend) Len(ctx context.Context) (int64, error) {
+	memoryBackend.mutex.Lock()
+	defer memoryBackend.mutex.Unlock()
+
+	return int64(memoryBackend.priority.Len() + memoryBackend.normal.Len()), nil
+}
+
+//** PRIVATE FUNCTIONS
+
+// waitBackend waits for the duration unless the context is done first.
+func waitBackend(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// queue returns the list holding the jobs of the priority. The mutex must be held.
+func (memoryBackend *MemoryBackend) queue(priority bool) *list.List {
+	if priority == true {
+		return memoryBackend.priority
+	}
+
+	return memoryBackend.normal
+}
+
+// leased returns the job of a lease that has not been handed out again. An expired lease is
+// still held until the next Pop. The mutex must be held.
+func (memoryBackend *MemoryBackend) leased(lease *Lease) (*memoryEntry, error) {
+	memoryEntry, found := memoryBackend.leases[lease.ID]
+	if found == false {
+		return nil, ErrLeaseLost
+	}
+
+	return memoryEntry, nil
+}
+
+// feedBackend pops jobs from the backend and queues them in the pool for FeedBackend. A pool
+// sharing the backend only pops a job while it has a job routine to spare, so the jobs are split
+// between the pools, and it measures the depth of the backend as it goes. A failing backend is
+// asked again after a longer wait each time instead of in a loop.
+func (jobPool *JobPool) feedBackend(ctx context.Context, backend Backend, shared bool) error {
+	wait := backendMinWait
+	failing := false
+
+	var measuredAt time.Time
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+			return ErrPoolClosed
+		}
+
+		if shared == true {
+			if now := time.Now(); now.Sub(measuredAt) >= sharedDepthInterval {
+				measuredAt = now
+				jobPool.measureShared(ctx, backend)
+			}
+
+			if jobPool.sharedRoom() == false {
+				if err := waitBackend(ctx, backendMinWait); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		lease, err := backend.Pop(ctx)
+		switch {
+		case err != nil && failing == false:
+			jobPool.writeLogf(LogError, "Backend", "feedBackend", "ERROR : Pop Failed : %s", err)
+
+		case err == nil && failing == true:
+			jobPool.writeLog(LogInfo, "Backend", "feedBackend", "Backend Available")
+		}
+
+		failing = err != nil
+		if shared == true {
+			jobPool.markShared(failing)
+		}
+
+		if err == nil && lease != nil {
+			wait = backendMinWait
+
+			if shared == true {
+				atomic.AddInt64(&jobPool.sharedDepth, -1)
+			}
+
+			if err := jobPool.offerLease(ctx, backend, lease); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Wait longer each time the backend is empty or failing.
+		if err := waitBackend(ctx, wait); err != nil {
+			return err
+		}
+
+		if wait *= 2; wait > backendMaxWait {
+			wait = backendMaxWait
+		}
+	}
+}
+
+// offerLease queues the job of a lease in the pool, waiting while the queue or the job's tenant
+// is full. The lease is released if the job can't be queued.
+func (jobPool *JobPool) offerLease(ctx context.Context, backend Backend, lease *Lease) error {
+	jober, priority, err := UnmarshalJob(lease.Data)
+	if err != nil {
+		jobPool.reject("Backend", nil, fmt.Errorf("%w : %w", ErrInvalidJob, err))
+		if err := backend.Ack(context.Background(), lease); err != nil {
+			jobPool.writeLogf(LogError, "Backend", "offerLease", "ERROR : Ack Failed : Lease[%s] : %s", lease.ID, err)
+		}
+		return nil
+	}
+
+	withDeliveries := func(queueJob *queueJob) {
+		queueJob.deliveries = lease.Deliveries
+	}
+
+	wait := consumeMinWait
+
+	for {
+		handle := jobPool.QueueJobAsync("Backend", jober, priority, withDeliveries, WithRetainOnReject())
+
+		err := handle.Wait()
+		switch {
+		case err == nil:
+			go jobPool.holdLease(backend, lease, handle)
+			return nil
+
+		case errors.Is(err, ErrPoolAtCapacity), errors.Is(err, ErrTenantQuotaExceeded):
+
+		default:
+			jobPool.releaseLease(backend, lease)
+
+			// A job refused for good has been passed to the rejection handler.
+			if errors.Is(err, ErrPoolClosed) == true {
+				return err
+			}
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			jobPool.releaseLease(backend, lease)
+			return ctx.Err()
+		}
+
+		if wait *= 2; wait > consumeMaxWait {
+			wait = consumeMaxWait
+		}
+	}
+}
+
+// holdLease extends the lease of a job the pool holds until the job is done, then acknowledges
+// the lease, or releases it if the job was cancelled.
+func (jobPool *JobPool) holdLease(backend Backend, lease *Lease, handle *JobHandle) {
+	heartbeat := lease.TTL / 3
+
+	for {
+		var timer Timer
+		var beat <-chan time.Time
+		if heartbeat > 0 {
+			timer = jobPool.clock().NewTimer(heartbeat)
+			beat = timer.C()
+		}
+
+		select {
+		case <-handle.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+
+			if handle.State() != StateDone {
+				jobPool.releaseLease(backend, lease)
+				return
+			}
+
+			if err := backend.Ack(context.Background(), lease); err != nil {
+				jobPool.writeLogf(LogError, "Backend", "holdLease", "ERROR : Ack Failed : Lease[%s] : %s", lease.ID, err)
+			}
+			return
+
+		case <-beat:
+			// A lease that was lost can't be won back. The job may run again elsewhere.
+			if err := backend.Extend(context.Background(), lease); errors.Is(err, ErrLeaseLost) == true {
+				jobPool.writeLogf(LogError, "Backend", "holdLease", "ERROR : Lease Lost : Lease[%s]", lease.ID)
+				heartbeat = 0
+			}
+		}
+	}
+}
+
+// releaseLease hands the job of a lease back to the backend.
+func (jobPool *JobPool) releaseLease(backend Backend, lease *Lease) {
+	if err := backend.Release(context.Background(), lease); err != nil {
+		jobPool.writeLogf(LogError, "Backend", "releaseLease", "ERROR : Release Failed : Lease[%s] : %s", lease.ID, err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"math/rand"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// BackoffFunc allows an ordinary function to be used as a BackoffStrategy.
+	BackoffFunc func(attempt int, lastErr error) time.Duration
+
+	// constantBackoff waits the same amount of time before every retry.
+	constantBackoff struct {
+		delay time.Duration // The time to wait before each retry.
+	}
+
+	// exponentialBackoff doubles the delay after every attempt and picks a random delay up to it.
+	exponentialBackoff struct {
+		base time.Duration // The delay before the first retry.
+		max  time.Duration // The longest delay allowed.
+	}
+
+	// decorrelatedBackoff picks a random delay between the base and three times the previous delay.
+	decorrelatedBackoff struct {
+		base time.Duration // The shortest delay allowed.
+		max  time.Duration // The longest delay allowed.
+	}
+)
+
+//** INTERFACES
+
+// BackoffStrategy decides how long to wait before a failed job is retried. The attempt is the
+// number of times the job has run so far and lastErr is why it failed. A zero delay retries the
+// job immediately.
+type BackoffStrategy interface {
+	NextDelay(attempt int, lastErr error) time.Duration
+}
+
+//** PUBLIC FUNCTIONS
+
+// ConstantBackoff returns a strategy that waits delay before every retry.
+func ConstantBackoff(delay time.Duration) BackoffStrategy {
+	return constantBackoff{delay: delay}
+}
+
+// ExponentialBackoff returns a strategy that doubles the delay from base after every attempt, up
+// to max, and waits a random time up to that delay.
+func ExponentialBackoff(base time.Duration, max time.Duration) BackoffStrategy {
+	return exponentialBackoff{base: base, max: max}
+}
+
+// DecorrelatedJitterBackoff returns a strategy that waits a random time between base and three
+// times the previous delay, up to max.
+func DecorrelatedJitterBackoff(base time.Duration, max time.Duration) BackoffStrategy {
+	return decorrelatedBackoff{base: base, max: max}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// NextDelay calls the function.
+func (backoffFunc BackoffFunc) NextDelay(attempt int, lastErr error) time.Duration {
+	return backoffFunc(attempt, lastErr)
+}
+
+// NextDelay returns the constant delay.
+func (constantBackoff constantBackoff) NextDelay(attempt int, lastErr error) time.Duration {
+	return constantBackoff.delay
+}
+
+// NextDelay returns a random delay up to base doubled for each attempt after the first.
+func (exponentialBackoff exponentialBackoff) NextDelay(attempt int, lastErr error) time.Duration {
+	if exponentialBackoff.base <= 0 {
+		return 0
+	}
+
+	delay := exponentialBackoff.base
+	for i := 1; i < attempt && delay < exponentialBackoff.max; i++ {
+		delay *= 2
+	}
+
+	if exponentialBackoff.max > 0 && delay > exponentialBackoff.max {
+		delay = exponentialBackoff.max
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// NextDelay replays the random walk for the attempt since the strategy keeps no state per job.
+func (decorrelatedBackoff decorrelatedBackoff) NextDelay(attempt int, lastErr error) time.Duration {
+	if decorrelatedBackoff.base <= 0 {
+		return 0
+	}
+
+	delay := decorrelatedBackoff.base
+	for i := 0; i < attempt; i++ {
+		delay = decorrelatedBackoff.base + time.Duration(rand.Int63n(int64(delay*3-decorrelatedBackoff.base)+1))
+
+		if decorrelatedBackoff.max > 0 && delay >= decorrelatedBackoff.max {
+			return decorrelatedBackoff.max
+		}
+	}
+
+	return delay
+}
@@ -0,0 +1,112 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// barrier holds back the jobs queued after it until every job queued before it has
+	// completed or been cancelled.
+	barrier struct {
+		id     uint64      // The barrier's place among the job IDs. Jobs with a higher ID are held back.
+		waiter *noneWaiter // Waits on the jobs queued before the barrier.
+		handle *JobHandle  // Reports the barrier done once it is lifted.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueBarrier places a barrier in the pool. The jobs admitted after it, priority jobs included,
+// are not handed to a job routine until every job admitted before it, in both queues, has
+// completed or been cancelled, retries included. The handle reports StateDone once the barrier
+// is lifted. Barriers queued one after the other split the jobs into phases that run in turn.
+// A job submitted before the barrier that is still in the intake buffer is admitted after it.
+// While a barrier is up a job the queue has no room for is not run by its submitter under
+// CallerRuns, since that would pass the barrier.
+func (jobPool *JobPool) QueueBarrier(goRoutine string) (handle *JobHandle, err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "QueueBarrier")
+
+	// Barriers can't be queued once the pool is shutting down.
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return nil, ErrPoolClosed
+	}
+
+	barrier := barrier{
+		handle: &JobHandle{
+			admitted: make(chan struct{}),
+			done:     make(chan struct{}),
+		},
+	}
+
+	barrier.waiter = newNoneWaiter(func(queueJob *queueJob) bool {
+		return queueJob.id < barrier.id
+	})
+
+	err = jobPool.runInQueue(func() {
+		atomic.AddInt32(&jobPool.raisedBarriers, 1)
+		barrier.id = atomic.AddUint64(&jobPool.jobSequence, 1)
+
+		jobPool.barriers = append(jobPool.barriers, &barrier)
+		jobPool.outstanding.wait(barrier.waiter)
+
+		barrier.handle.admit(AdmissionInfo{
+			ID:         barrier.id,
+			QueuedJobs: atomic.LoadInt32(&jobPool.gauges.queuedJobs),
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	go jobPool.liftBarrier(&barrier)
+
+	return barrier.handle, nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// liftBarrier waits for the jobs queued before the barrier, then has the queue routine hand out
+// the jobs it held back.
+func (jobPool *JobPool) liftBarrier(barrier *barrier) {
+	<-barrier.waiter.done
+
+	// A pool that has shut down has no jobs left to hand out.
+	jobPool.runInQueue(jobPool.queueRoutineLift)
+
+	barrier.handle.setState(StateDone)
+}
+
+// queueRoutineLift takes down the barriers at the front whose jobs are gone and wakes the job
+// routines that found only held back jobs. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineLift() {
+	var lifted bool
+	for len(jobPool.barriers) > 0 && jobPool.barriers[0].waiter.finished() == true {
+		jobPool.barriers[0] = nil
+		jobPool.barriers = jobPool.barriers[1:]
+		atomic.AddInt32(&jobPool.raisedBarriers, -1)
+		lifted = true
+	}
+
+	if lifted == true && jobPool.heldWakeUps > 0 {
+		jobPool.wakeUps.post(jobPool.heldWakeUps)
+		jobPool.heldWakeUps = 0
+	}
+}
+
+// heldAfter returns the ID above which jobs are held back by the first barrier. It is only
+// called by the queue routine.
+func (jobPool *JobPool) heldAfter() uint64 {
+	if len(jobPool.barriers) == 0 {
+		return math.MaxUint64
+	}
+
+	return jobPool.barriers[0].id
+}
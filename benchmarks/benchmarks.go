@@ -0,0 +1,483 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package benchmarks is the throughput and latency yardstick for the jobpool package. Changes made for
+performance should include its numbers from before and after the change.
+
+Scenarios returns the maintained set of scenarios: no-op jobs and 1ms jobs across 1, 8 and 64 producers and
+1, 8 and 64 job routines, a mix of priority and normal jobs, and producers pressing against a small queue.
+The async scenarios queue with QueueJobAsync from 64 producers and compare with the QueueJob scenarios of
+the same name. The control-buffers scenarios buffer the pool's QueueJob and dequeue requests with
+WithControlBuffers and compare with the unbuffered scenarios of the same name. The prefetch scenarios create
+the pool WithPrefetch and compare with the scenarios of the same name that run with prefetch off.
+Each scenario is warmed up before it is measured. Besides the throughput the harness records how long every
+job waited in queue and how long it took from being queued to finishing, and reports the percentiles.
+
+Run measures a scenario from a program. The package's tests run every scenario as a sub-benchmark of
+BenchmarkJobPool, so the numbers can be reproduced with:
+
+	go test -run none -bench . ./benchmarks
+
+Each sub-benchmark reports the p50 and p99 queue and total latency and the number of times a producer found
+the queue full per job, alongside ns/op.
+
+QueuedJobs, ActiveRoutines and PercentFull are called on every admission decision and must not allocate.
+BenchmarkAccessors benchmarks each of them and fails when one allocates.
+
+*/
+package benchmarks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// Scenario describes a workload to measure.
+	Scenario struct {
+		Name          string        // The name the scenario is reported under.
+		Routines      int           // The number of job routines in the pool.
+		Producers     int           // The number of routines queuing jobs at the same time.
+		Capacity      int32         // The capacity of the pool's queue.
+		Work          time.Duration // How long each job runs. Zero runs a no-op job.
+		PriorityShare float64       // The fraction of jobs queued as priority jobs.
+		InlineIfIdle  bool          // If jobs are queued with WithInlineIfIdle.
+		Async         bool          // If jobs are queued with QueueJobAsync.
+		QueueBuffer   int           // The buffer of QueueJob requests set with WithControlBuffers.
+		DequeueBuffer int           // The buffer of dequeue requests set with WithControlBuffers.
+		Prefetch      bool          // If the pool is created WithPrefetch.
+	}
+
+	// Percentiles summarizes a set of latencies.
+	Percentiles struct {
+		P50  time.Duration // The median latency.
+		P90  time.Duration // The 90th percentile latency.
+		P99  time.Duration // The 99th percentile latency.
+		P999 time.Duration // The 99.9th percentile latency.
+		Max  time.Duration // The largest latency.
+	}
+
+	// Result holds the measurements of a single run of a scenario.
+	Result struct {
+		Scenario      Scenario      // The scenario that was run.
+		Jobs          int           // The number of jobs measured, not counting the warmup.
+		Elapsed       time.Duration // The time from the first job being queued to the last job finishing.
+		JobsPerSecond float64       // The number of jobs completed per second.
+		FullRetries   int64         // The number of times a producer found the queue full and tried again.
+		QueueLatency  Percentiles   // The time from a job being queued to a job routine starting it.
+		TotalLatency  Percentiles   // The time from a job being queued to the job finishing.
+		InlineRuns    int64         // The number of jobs run by their producer with WithInlineIfIdle.
+		OutOfOrder    int           // The jobs started before a job their producer queued ahead of them. Only counted with one job routine.
+	}
+
+	// recorder collects the latencies of a batch of jobs. Each job writes its own slot so no
+	// locking is needed.
+	recorder struct {
+		queueLatency []time.Duration // The queue latency of each job.
+		totalLatency []time.Duration // The total latency of each job.
+		startOrder   []int64         // The order each job started in.
+		producer     []int           // The producer that queued each job.
+		started      int64           // Counts the jobs as they start.
+		begun        time.Time       // When the first job of the batch was queued.
+		finished     time.Time       // When the last job of the batch finished.
+		waitGroup    sync.WaitGroup  // Done once every job has finished.
+	}
+
+	// benchJob is the job queued by the harness.
+	benchJob struct {
+		index    int           // The job's slot in the recorder.
+		work     time.Duration // How long the job runs.
+		queuedAt time.Time     // When the producer queued the job.
+		recorder *recorder     // Receives the job's latencies.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// defaultCapacity is the queue capacity of the scenarios that don't test capacity pressure.
+	defaultCapacity = 1024
+
+	// defaultWarmup is the number of jobs run before a scenario is measured.
+	defaultWarmup = 1000
+)
+
+//** VARIABLES
+
+var (
+	// ErrNoJobs is returned by Run when asked to measure no jobs.
+	ErrNoJobs = errors.New("No Jobs To Measure")
+)
+
+//** PUBLIC FUNCTIONS
+
+// Scenarios returns the maintained set of scenarios.
+func Scenarios() []Scenario {
+	var scenarios []Scenario
+
+	for _, work := range []time.Duration{0, time.Millisecond} {
+		workName := "noop"
+		if work > 0 {
+			workName = "1ms"
+		}
+
+		for _, routines := range []int{1, 8, 64} {
+			for _, producers := range []int{1, 8, 64} {
+				scenarios = append(scenarios, Scenario{
+					Name:      fmt.Sprintf("%s/routines=%d/producers=%d", workName, routines, producers),
+					Routines:  routines,
+					Producers: producers,
+					Capacity:  defaultCapacity,
+					Work:      work,
+				})
+			}
+		}
+	}
+
+	scenarios = append(scenarios,
+		Scenario{
+			Name:          "mixed-priority/routines=8/producers=8",
+			Routines:      8,
+			Producers:     8,
+			Capacity:      defaultCapacity,
+			PriorityShare: 0.25,
+		},
+		Scenario{
+			Name:      "capacity-pressure/routines=8/producers=64",
+			Routines:  8,
+			Producers: 64,
+			Capacity:  16,
+		},
+		Scenario{
+			Name:         "inline-idle/noop/routines=8/producers=1",
+			Routines:     8,
+			Producers:    1,
+			Capacity:     defaultCapacity,
+			InlineIfIdle: true,
+		},
+		Scenario{
+			Name:         "inline-busy/1ms/routines=1/producers=8",
+			Routines:     1,
+			Producers:    8,
+			Capacity:     defaultCapacity,
+			Work:         time.Millisecond,
+			InlineIfIdle: true,
+		},
+		Scenario{
+			Name:      "async/noop/routines=8/producers=64",
+			Routines:  8,
+			Producers: 64,
+			Capacity:  defaultCapacity,
+			Async:     true,
+		},
+		Scenario{
+			Name:      "async/1ms/routines=64/producers=64",
+			Routines:  64,
+			Producers: 64,
+			Capacity:  defaultCapacity,
+			Work:      time.Millisecond,
+			Async:     true,
+		},
+		Scenario{
+			Name:          "control-buffers/noop/routines=8/producers=64",
+			Routines:      8,
+			Producers:     64,
+			Capacity:      defaultCapacity,
+			QueueBuffer:   64,
+			DequeueBuffer: 8,
+		},
+		Scenario{
+			Name:          "control-buffers/1ms/routines=64/producers=64",
+			Routines:      64,
+			Producers:     64,
+			Capacity:      defaultCapacity,
+			Work:          time.Millisecond,
+			QueueBuffer:   64,
+			DequeueBuffer: 64,
+		},
+		Scenario{
+			Name:      "prefetch/noop/routines=8/producers=8",
+			Routines:  8,
+			Producers: 8,
+			Capacity:  defaultCapacity,
+			Prefetch:  true,
+		},
+		Scenario{
+			Name:      "prefetch/1ms/routines=8/producers=8",
+			Routines:  8,
+			Producers: 8,
+			Capacity:  defaultCapacity,
+			Work:      time.Millisecond,
+			Prefetch:  true,
+		},
+	)
+
+	return scenarios
+}
+
+// Run measures the scenario over the number of jobs after running the warmup jobs on the same pool.
+func Run(scenario Scenario, jobs int, warmup int) (result Result, err error) {
+	if jobs <= 0 {
+		return result, ErrNoJobs
+	}
+
+	jobPool := newPool(scenario)
+	defer jobPool.Shutdown("benchmarks")
+
+	if warmup > 0 {
+		if _, _, err = runBatch(jobPool, scenario, warmup); err != nil {
+			return result, err
+		}
+	}
+
+	inlineRuns := jobPool.Stats().InlineRuns
+
+	recorder, fullRetries, err := runBatch(jobPool, scenario, jobs)
+	if err != nil {
+		return result, err
+	}
+
+	result = recorder.result(scenario, fullRetries)
+	result.InlineRuns = jobPool.Stats().InlineRuns - inlineRuns
+
+	return result, nil
+}
+
+// WriteResults writes the results as a table.
+func WriteResults(w io.Writer, results []Result) error {
+	if _, err := fmt.Fprintf(w, "%-45s %10s %12s %12s %12s %12s %12s %12s\n", "Scenario", "Jobs", "Jobs/s", "Queue P50", "Queue P99", "Total P50", "Total P99", "Full Retries"); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if _, err := fmt.Fprintf(w, "%-45s %10d %12.0f %12v %12v %12v %12v %12d\n", result.Scenario.Name, result.Jobs, result.JobsPerSecond, result.QueueLatency.P50, result.QueueLatency.P99, result.TotalLatency.P50, result.TotalLatency.P99, result.FullRetries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Percentile returns the latency at or below which the given percent of the latencies fall,
+// using the nearest rank. The latencies must be sorted.
+func Percentile(sorted []time.Duration, percent float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(percent / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
+
+// Summarize sorts the latencies and returns their percentiles.
+func Summarize(latencies []time.Duration) Percentiles {
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	return Percentiles{
+		P50:  Percentile(latencies, 50),
+		P90:  Percentile(latencies, 90),
+		P99:  Percentile(latencies, 99),
+		P999: Percentile(latencies, 99.9),
+		Max:  Percentile(latencies, 100),
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob records how long the job waited, runs the work and records the total latency.
+func (benchJob *benchJob) RunJob(jobRoutine int) {
+	started := time.Now()
+
+	recorder := benchJob.recorder
+	recorder.startOrder[benchJob.index] = atomic.AddInt64(&recorder.started, 1)
+
+	if benchJob.work > 0 {
+		time.Sleep(benchJob.work)
+	}
+
+	recorder.queueLatency[benchJob.index] = started.Sub(benchJob.queuedAt)
+	recorder.totalLatency[benchJob.index] = time.Since(benchJob.queuedAt)
+	recorder.waitGroup.Done()
+}
+
+//** PRIVATE FUNCTIONS
+
+// newPool creates the pool a scenario runs on.
+func newPool(scenario Scenario) *jobpool.JobPool {
+	options := []jobpool.Option{
+		jobpool.WithName("benchmarks"),
+		jobpool.WithoutManager(),
+		jobpool.WithLogLevel(jobpool.LogOff, true),
+		jobpool.WithControlBuffers(scenario.QueueBuffer, scenario.DequeueBuffer),
+	}
+
+	if scenario.Prefetch == true {
+		options = append(options, jobpool.WithPrefetch())
+	}
+
+	return jobpool.New(scenario.Routines, scenario.Capacity, options...)
+}
+
+// runBatch queues the jobs from the scenario's producers and waits for every job to finish.
+// A producer that finds the queue full yields and tries the same job again.
+func runBatch(jobPool *jobpool.JobPool, scenario Scenario, jobs int) (*recorder, int64, error) {
+	recorder := recorder{
+		queueLatency: make([]time.Duration, jobs),
+		totalLatency: make([]time.Duration, jobs),
+		startOrder:   make([]int64, jobs),
+		producer:     make([]int, jobs),
+	}
+
+	recorder.waitGroup.Add(jobs)
+
+	var next int64 = -1
+	var fullRetries int64
+	var batchErr error
+	var errOnce sync.Once
+
+	producers := scenario.Producers
+	if producers < 1 {
+		producers = 1
+	}
+
+	// Every n-th job is a priority job so the share is spread evenly over the batch.
+	priorityEvery := 0
+	if scenario.PriorityShare > 0 {
+		priorityEvery = int(math.Round(1 / scenario.PriorityShare))
+	}
+
+	var options []jobpool.JobOption
+	if scenario.InlineIfIdle == true {
+		options = append(options, jobpool.WithInlineIfIdle())
+	}
+
+	recorder.begun = time.Now()
+
+	var producerGroup sync.WaitGroup
+	for producer := 0; producer < producers; producer++ {
+		producerGroup.Add(1)
+		go func(producer int) {
+			defer producerGroup.Done()
+
+			for {
+				index := int(atomic.AddInt64(&next, 1))
+				if index >= jobs {
+					return
+				}
+
+				priority := priorityEvery > 0 && index%priorityEvery == 0
+				recorder.producer[index] = producer
+
+				for {
+					benchJob := benchJob{
+						index:    index,
+						work:     scenario.Work,
+						queuedAt: time.Now(),
+						recorder: &recorder,
+					}
+
+					var err error
+					if scenario.Async == true {
+						// A full queue rejects the job before QueueJobAsync returns.
+						err = jobPool.QueueJobAsync("benchmarks", &benchJob, priority, options...).Err()
+					} else {
+						err = jobPool.QueueJob("benchmarks", &benchJob, priority, options...)
+					}
+
+					if err == nil {
+						break
+					}
+
+					if errors.Is(err, jobpool.ErrPoolAtCapacity) == true {
+						atomic.AddInt64(&fullRetries, 1)
+						runtime.Gosched()
+						continue
+					}
+
+					// The job will never run so its slot is released.
+					errOnce.Do(func() { batchErr = err })
+					recorder.waitGroup.Done()
+					break
+				}
+			}
+		}(producer)
+	}
+
+	producerGroup.Wait()
+	recorder.waitGroup.Wait()
+
+	recorder.finished = time.Now()
+
+	if batchErr != nil {
+		return nil, fullRetries, batchErr
+	}
+
+	return &recorder, fullRetries, nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// result summarizes the recorded latencies.
+func (recorder *recorder) result(scenario Scenario, fullRetries int64) Result {
+	jobs := len(recorder.totalLatency)
+
+	result := Result{
+		Scenario:    scenario,
+		Jobs:        jobs,
+		FullRetries: fullRetries,
+	}
+
+	result.Elapsed = recorder.finished.Sub(recorder.begun)
+	if result.Elapsed > 0 {
+		result.JobsPerSecond = float64(jobs) / result.Elapsed.Seconds()
+	}
+
+	if scenario.Routines == 1 {
+		result.OutOfOrder = recorder.outOfOrder()
+	}
+
+	result.QueueLatency = Summarize(recorder.queueLatency)
+	result.TotalLatency = Summarize(recorder.totalLatency)
+
+	return result
+}
+
+// outOfOrder counts the jobs that started before a job their producer queued ahead of them.
+func (recorder *recorder) outOfOrder() int {
+	var outOfOrder int
+	lastStarted := make(map[int]int64)
+
+	for index, startOrder := range recorder.startOrder {
+		producer := recorder.producer[index]
+		if startOrder < lastStarted[producer] {
+			outOfOrder++
+		}
+
+		lastStarted[producer] = startOrder
+	}
+
+	return outOfOrder
+}
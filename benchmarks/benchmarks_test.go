@@ -0,0 +1,182 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchmarks
+
+import (
+	"testing"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// accessor is a pool read that must not allocate.
+	accessor struct {
+		name string                     // The name the accessor is reported under.
+		read func(*jobpool.JobPool) int // Calls the accessor.
+	}
+
+	// blockJob holds a job routine until the channel is closed.
+	blockJob chan struct{}
+)
+
+//** VARIABLES
+
+var (
+	// accessors are the pool reads made on every admission decision.
+	accessors = []accessor{
+		{"QueuedJobs", func(jobPool *jobpool.JobPool) int { return int(jobPool.QueuedJobs()) }},
+		{"ActiveRoutines", func(jobPool *jobpool.JobPool) int { return int(jobPool.ActiveRoutines()) }},
+		{"PercentFull", func(jobPool *jobpool.JobPool) int { return int(jobPool.PercentFull()) }},
+	}
+
+	// accessorSink keeps the accessor reads from being optimized away.
+	accessorSink int
+)
+
+//** PUBLIC FUNCTIONS
+
+// BenchmarkJobPool runs every scenario as a sub-benchmark.
+func BenchmarkJobPool(b *testing.B) {
+	for _, scenario := range Scenarios() {
+		scenario := scenario
+		b.Run(scenario.Name, func(b *testing.B) {
+			benchmarkScenario(b, scenario)
+		})
+	}
+}
+
+// BenchmarkAccessors runs each hot accessor as a sub-benchmark and fails the accessors that
+// allocate.
+func BenchmarkAccessors(b *testing.B) {
+	jobPool, release := newBusyPool()
+	defer jobPool.Shutdown("benchmarks")
+	defer close(release)
+
+	for _, accessor := range accessors {
+		accessor := accessor
+		b.Run(accessor.name, func(b *testing.B) {
+			if allocs := testing.AllocsPerRun(100, func() { accessorSink += accessor.read(jobPool) }); allocs != 0 {
+				b.Fatalf("%s Allocates : Allocs[%v]", accessor.name, allocs)
+			}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				accessorSink += accessor.read(jobPool)
+			}
+		})
+	}
+}
+
+// TestAccessorAllocs checks the hot accessors don't allocate against a pool with jobs running
+// and pending.
+func TestAccessorAllocs(t *testing.T) {
+	jobPool, release := newBusyPool()
+	defer jobPool.Shutdown("benchmarks")
+	defer close(release)
+
+	for _, accessor := range accessors {
+		if allocs := testing.AllocsPerRun(1000, func() { accessorSink += accessor.read(jobPool) }); allocs != 0 {
+			t.Errorf("%s Allocates : Allocs[%v]", accessor.name, allocs)
+		}
+	}
+}
+
+// TestRun checks a scenario runs and measures every job.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario Scenario
+	}{
+		{"QueueJob", Scenario{}},
+		{"Async", Scenario{Async: true}},
+		{"ControlBuffers", Scenario{QueueBuffer: 8, DequeueBuffer: 4}},
+		{"Prefetch", Scenario{Prefetch: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scenario := test.scenario
+			scenario.Name = "test"
+			scenario.Routines = 4
+			scenario.Producers = 4
+			scenario.Capacity = 16
+
+			result, err := Run(scenario, 1000, 100)
+			if err != nil {
+				t.Fatalf("Run : %v", err)
+			}
+
+			if result.Jobs != 1000 || result.JobsPerSecond <= 0 {
+				t.Fatalf("Run : Jobs[%d] JobsPerSecond[%v]", result.Jobs, result.JobsPerSecond)
+			}
+
+			if _, err := Run(scenario, 0, 0); err != ErrNoJobs {
+				t.Fatalf("Run : Expected ErrNoJobs : %v", err)
+			}
+		})
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob holds the job routine until the job is released.
+func (blockJob blockJob) RunJob(jobRoutine int) {
+	<-blockJob
+}
+
+//** PRIVATE FUNCTIONS
+
+// benchmarkScenario measures the scenario over b.N jobs and reports the latency percentiles as
+// extra metrics.
+func benchmarkScenario(b *testing.B, scenario Scenario) {
+	jobPool := newPool(scenario)
+	defer jobPool.Shutdown("benchmarks")
+
+	if _, _, err := runBatch(jobPool, scenario, defaultWarmup); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	recorder, fullRetries, err := runBatch(jobPool, scenario, b.N)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.StopTimer()
+
+	result := recorder.result(scenario, fullRetries)
+
+	b.ReportMetric(float64(result.QueueLatency.P50), "queue-p50-ns")
+	b.ReportMetric(float64(result.QueueLatency.P99), "queue-p99-ns")
+	b.ReportMetric(float64(result.TotalLatency.P50), "total-p50-ns")
+	b.ReportMetric(float64(result.TotalLatency.P99), "total-p99-ns")
+	b.ReportMetric(float64(fullRetries)/float64(b.N), "full-retries/op")
+
+	if scenario.InlineIfIdle == true {
+		b.ReportMetric(float64(jobPool.Stats().InlineRuns)/float64(b.N+defaultWarmup), "inline/op")
+		b.ReportMetric(float64(result.OutOfOrder), "out-of-order")
+	}
+}
+
+// newBusyPool creates a pool whose job routines are held by jobs until release is closed, with
+// more jobs pending behind them.
+func newBusyPool() (*jobpool.JobPool, chan struct{}) {
+	scenario := Scenario{
+		Routines: 8,
+		Capacity: defaultCapacity,
+	}
+
+	jobPool := newPool(scenario)
+	release := make(chan struct{})
+
+	for i := 0; i < 2*scenario.Routines; i++ {
+		jobPool.QueueJob("benchmarks", blockJob(release), false)
+	}
+
+	return jobPool, release
+}
@@ -0,0 +1,80 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//** CONSTANTS
+
+const (
+	// CallerRoutine is the routine number passed to a job that is run by its submitter under the
+	// CallerRuns overflow policy.
+	CallerRoutine = -1
+)
+
+//** VARIABLES
+
+var (
+	// errCallerRuns tells QueueJob the queue was at capacity and the job is to be run by its
+	// submitter.
+	errCallerRuns = errors.New("Caller Runs Job")
+)
+
+//** PRIVATE FUNCTIONS
+
+// withoutCallerRuns refuses the job when the queue is at capacity instead of running it on the
+// routine that queued it, for submitters that must not block such as the cron scheduler.
+func withoutCallerRuns() JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.callerRuns = false
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// admitCallerRun gives a job the queue had no room for its ID and adds it to its group, so it
+// is accounted for like a job that was dequeued. It is only called by the queue routine.
+func (jobPool *JobPool) admitCallerRun(queueJob *queueJob) {
+	queueJob.setState(jobClaimed)
+	queueJob.enqueuedAt = time.Now()
+	queueJob.firstEnqueuedAt = queueJob.enqueuedAt
+
+	queueJob.id = atomic.AddUint64(&jobPool.jobSequence, 1)
+
+	jobPool.outstanding.admit(queueJob)
+	jobPool.trackJob(queueJob)
+	jobPool.admitGroupJob(queueJob)
+	jobPool.dequeueGroupJob(queueJob)
+
+	if queueJob.admissionInfo != nil {
+		*queueJob.admissionInfo = AdmissionInfo{
+			ID:         queueJob.id,
+			QueuedJobs: atomic.LoadInt32(&jobPool.gauges.queuedJobs),
+			CallerRan:  true,
+		}
+	}
+}
+
+// runInCaller runs a job the queue had no room for on the submitting routine. It goes through
+// the same wrapper as a job routine, so panics are recovered and the job is counted, recorded
+// and retried the same way. A retry is placed in the queue.
+func (jobPool *JobPool) runInCaller(queueJob *queueJob) {
+	atomic.AddInt64(&jobPool.callerRuns, 1)
+
+	jobPool.runJob(queueJob, CallerRoutine)
+}
+
+// workerName returns the name the routine running a job uses in logs and panic reports.
+func (jobPool *JobPool) workerName(jobRoutine int) string {
+	if jobRoutine == CallerRoutine {
+		return jobPool.routineName(jobRoutine)
+	}
+
+	return jobPool.worker(jobRoutine).name
+}
@@ -0,0 +1,179 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// parityJob is a job that completes, fails or panics and records the routine it ran on.
+	parityJob struct {
+		name    string // The name of the job and how it ends: completes, fails or panics.
+		routine int32  // The routine the job ran on.
+	}
+
+	// parityRun is what the pool reported about the parity jobs.
+	parityRun struct {
+		jobTypes map[string]JobTypeStats // The counters of each parity job with the durations cleared.
+		outcomes map[string]JobOutcome   // The history outcome of each parity job.
+		panics   []string                // The names of the jobs given to the panic handler.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestCallerRunsParity runs a job that completes, one that fails and one that panics on a job
+// routine and then on the submitter under CallerRuns, and proves both paths report them the
+// same way through the stats, the history and the panic handler.
+func TestCallerRunsParity(t *testing.T) {
+	routineRun, routines := runParityJobs(t, false)
+	callerRun, callerRoutines := runParityJobs(t, true)
+
+	for name, routine := range routines {
+		if routine != 0 {
+			t.Errorf("%s ran on routine %d, want 0", name, routine)
+		}
+
+		if callerRoutine := callerRoutines[name]; callerRoutine != CallerRoutine {
+			t.Errorf("%s ran on routine %d under CallerRuns, want %d", name, callerRoutine, CallerRoutine)
+		}
+	}
+
+	if reflect.DeepEqual(routineRun, callerRun) == false {
+		t.Fatalf("The paths differ :\nJob Routine %+v\nCaller Runs %+v", routineRun, callerRun)
+	}
+
+	want := map[string]JobOutcome{"completes": OutcomeCompleted, "fails": OutcomeFailed, "panics": OutcomePanicked}
+	if reflect.DeepEqual(routineRun.outcomes, want) == false {
+		t.Fatalf("Outcomes[%v], want %v", routineRun.outcomes, want)
+	}
+
+	if reflect.DeepEqual(routineRun.panics, []string{"panics"}) == false {
+		t.Fatalf("Panics[%v], want [panics]", routineRun.panics)
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Name returns the job's name.
+func (parityJob *parityJob) Name() string {
+	return parityJob.name
+}
+
+// RunJob runs the job without reporting its error.
+func (parityJob *parityJob) RunJob(jobRoutine int) {
+	parityJob.RunJobError(jobRoutine)
+}
+
+// RunJobError records the routine and ends the way the job is named.
+func (parityJob *parityJob) RunJobError(jobRoutine int) error {
+	atomic.StoreInt32(&parityJob.routine, int32(jobRoutine))
+
+	switch parityJob.name {
+	case "fails":
+		return errors.New("Job Failed")
+
+	case "panics":
+		panic("Job Panicked")
+	}
+
+	return nil
+}
+
+//** PRIVATE FUNCTIONS
+
+// runParityJobs runs the parity jobs on a job routine, or on the test's routine under
+// CallerRuns, and returns what the pool reported and the routine each job ran on.
+func runParityJobs(t *testing.T, callerRuns bool) (parityRun, map[string]int32) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var panics []string
+
+	jobPool := newTestPool(t, 1, 1,
+		WithOverflowPolicy(CallerRuns, nil),
+		WithHistory(10),
+		WithPanicHandler(func(panicInfo PanicInfo) {
+			mutex.Lock()
+			panics = append(panics, panicInfo.JobName)
+			mutex.Unlock()
+		}),
+	)
+
+	// Saturate the pool so every parity job is run by its submitter.
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	if callerRuns == true {
+		blocker, started := blockingJob(release)
+		if err := jobPool.QueueJob("test", blocker, false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+		<-started
+
+		if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	jobs := []*parityJob{{name: "completes"}, {name: "fails"}, {name: "panics"}}
+	for _, job := range jobs {
+		job.routine = -2
+
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob %s : %s", job.name, err)
+		}
+
+		waitFor(t, 5*time.Second, job.name+" to run", func() bool {
+			return jobPool.Stats().JobTypes[job.name].Processed == 1
+		})
+	}
+
+	if stats := jobPool.Stats(); (stats.CallerRuns == int64(len(jobs))) != callerRuns {
+		t.Fatalf("CallerRuns[%d] with CallerRuns %v", stats.CallerRuns, callerRuns)
+	}
+
+	releaseOnce()
+
+	waitFor(t, 5*time.Second, "the pool to go idle", func() bool {
+		return jobPool.QueuedJobs() == 0 && jobPool.ActiveRoutines() == 0
+	})
+
+	parityRun := parityRun{
+		jobTypes: make(map[string]JobTypeStats),
+		outcomes: make(map[string]JobOutcome),
+	}
+
+	routines := make(map[string]int32)
+	jobTypes := jobPool.Stats().JobTypes
+	for _, job := range jobs {
+		jobTypeStats := jobTypes[job.name]
+		jobTypeStats.TotalDuration, jobTypeStats.MaxDuration = 0, 0
+
+		parityRun.jobTypes[job.name] = jobTypeStats
+		routines[job.name] = atomic.LoadInt32(&job.routine)
+	}
+
+	for _, jobRecord := range jobPool.History() {
+		if _, found := routines[jobRecord.Type]; found == true {
+			parityRun.outcomes[jobRecord.Type] = jobRecord.Outcome
+		}
+	}
+
+	mutex.Lock()
+	parityRun.panics = panics
+	mutex.Unlock()
+
+	return parityRun, routines
+}
@@ -0,0 +1,176 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// raceJob counts the times it ran.
+type raceJob struct {
+	runs int32 // The number of times the job ran.
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob counts the run.
+func (raceJob *raceJob) RunJob(jobRoutine int) {
+	atomic.AddInt32(&raceJob.runs, 1)
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestCancelRacesDequeue cancels jobs while the job routines dequeue them and checks each job
+// either ran once or was reported cancelled, never both. Run it with -race.
+func TestCancelRacesDequeue(t *testing.T) {
+	trials := 2000
+	if testing.Short() == true {
+		trials = 200
+	}
+
+	tests := []struct {
+		name       string
+		options    []Option
+		jobOptions []JobOption
+		cancel     func(jobPool *JobPool, handles []*JobHandle)
+	}{
+		{
+			name: "Cancel",
+			cancel: func(jobPool *JobPool, handles []*JobHandle) {
+				for i := len(handles) - 1; i >= 0; i-- {
+					jobPool.Cancel(handles[i].Sequence())
+				}
+			},
+		},
+		{
+			name: "CancelPending",
+			cancel: func(jobPool *JobPool, handles []*JobHandle) {
+				jobPool.CancelPending("test", false, false, nil)
+			},
+		},
+		{
+			name:       "CancelGroup",
+			jobOptions: []JobOption{WithGroup("race")},
+			cancel: func(jobPool *JobPool, handles []*JobHandle) {
+				jobPool.CancelGroup("test", "race")
+			},
+		},
+		{
+			name:    "PrefetchTakeback",
+			options: []Option{WithPrefetch()},
+			cancel: func(jobPool *JobPool, handles []*JobHandle) {
+				for i := len(handles) - 1; i >= 0; i-- {
+					jobPool.Cancel(handles[i].Sequence())
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 2, 64, test.options...)
+
+			var jobs []*raceJob
+			var handles []*JobHandle
+			for trial := 0; trial < trials; trial++ {
+				batch := make([]*JobHandle, 4)
+				for i := range batch {
+					job := &raceJob{}
+					batch[i] = jobPool.QueueJobAsync("test", job, false, test.jobOptions...)
+					if err := batch[i].WaitAdmitted(context.Background()); err != nil {
+						t.Fatalf("Trial %d : QueueJobAsync : %v", trial, err)
+					}
+
+					jobs = append(jobs, job)
+				}
+
+				test.cancel(jobPool, batch)
+
+				for _, handle := range batch {
+					select {
+					case <-handle.Done():
+					case <-time.After(5 * time.Second):
+						t.Fatalf("Trial %d : Job %d neither ran nor was cancelled : State[%s]", trial, handle.Sequence(), handle.State())
+					}
+				}
+
+				handles = append(handles, batch...)
+			}
+
+			// A job reported cancelled must not run later either.
+			jobPool.Shutdown("test")
+
+			var ran, cancelled int
+			for i, handle := range handles {
+				runs := atomic.LoadInt32(&jobs[i].runs)
+				switch state := handle.State(); {
+				case state == StateDone && runs == 1:
+					ran++
+				case state == StateCancelled && runs == 0:
+					cancelled++
+				default:
+					t.Fatalf("Job %d : State[%s] Runs[%d]", handle.Sequence(), state, runs)
+				}
+			}
+
+			t.Logf("Ran[%d] Cancelled[%d]", ran, cancelled)
+		})
+	}
+}
+
+// TestQueueJobContextRacesAdmission cancels the context of QueueJobContext while the queue
+// routine admits the job and checks a job runs once if and only if it was not withdrawn.
+func TestQueueJobContextRacesAdmission(t *testing.T) {
+	trials := 5000
+	if testing.Short() == true {
+		trials = 500
+	}
+
+	// Every job can be queued at once so none is refused for capacity.
+	jobPool := newTestPool(t, 2, int32(trials))
+
+	jobs := make([]*raceJob, trials)
+	errs := make([]error, trials)
+	var accepted int32
+	for trial := range jobs {
+		jobs[trial] = &raceJob{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go cancel()
+
+		errs[trial] = jobPool.QueueJobContext(ctx, "test", jobs[trial], false)
+		switch {
+		case errs[trial] == nil:
+			accepted++
+		case errors.Is(errs[trial], context.Canceled) == false:
+			t.Fatalf("Trial %d : QueueJobContext : %v", trial, errs[trial])
+		}
+	}
+
+	waitFor(t, 5*time.Second, "the accepted jobs to run", func() bool {
+		var runs int32
+		for _, job := range jobs {
+			runs += atomic.LoadInt32(&job.runs)
+		}
+		return runs >= accepted
+	})
+
+	jobPool.Shutdown("test")
+
+	for trial, job := range jobs {
+		runs := atomic.LoadInt32(&job.runs)
+		if (errs[trial] == nil && runs != 1) || (errs[trial] != nil && runs != 0) {
+			t.Fatalf("Trial %d : Err[%v] Runs[%d]", trial, errs[trial], runs)
+		}
+	}
+
+	t.Logf("Accepted[%d] Withdrawn[%d]", accepted, int32(trials)-accepted)
+}
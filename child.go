@@ -0,0 +1,297 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"sync"
+)
+
+//** TYPES
+
+type (
+	// ChildPool is a view of a JobPool with its own concurrency and pending limits. Jobs queued
+	// through the child run on the parent's job routines and use the parent's queue capacity.
+	ChildPool struct {
+		parent         *JobPool               // The pool the child's jobs run on.
+		name           string                 // The name of the child.
+		maxConcurrency int                    // The max number of the child's jobs in the parent's queues or running.
+		maxPending     int32                  // The max number of the child's jobs that have not started.
+		pendingJobs    *tenantQueue           // The child's jobs waiting to be handed to the parent.
+		forwardedJobs  map[*queueJob]struct{} // The child's jobs handed to the parent that have not started.
+		inFlight       int                    // The number of the child's jobs handed to the parent that have not completed.
+		closed         bool                   // Set once the child or the parent has been shut down.
+		mutex          sync.Mutex             // Protects the child's state.
+	}
+
+	// ChildOption configures a ChildPool when it is created.
+	ChildOption func(childPool *ChildPool)
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithMaxConcurrency sets the maximum number of the child's jobs that can run at the same time.
+func WithMaxConcurrency(maxConcurrency int) ChildOption {
+	return func(childPool *ChildPool) {
+		childPool.maxConcurrency = maxConcurrency
+	}
+}
+
+// WithMaxPending sets the maximum number of the child's jobs that can be waiting to run.
+func WithMaxPending(maxPending int32) ChildOption {
+	return func(childPool *ChildPool) {
+		childPool.maxPending = maxPending
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Child creates a view of the pool with its own limits. Without options the child is limited
+// only by the parent. Shutting down the parent shuts down every child.
+func (jobPool *JobPool) Child(name string, options ...ChildOption) *ChildPool {
+	childPool := ChildPool{
+		parent:        jobPool,
+		name:          name,
+		pendingJobs:   newTenantQueue(name),
+		forwardedJobs: make(map[*queueJob]struct{}),
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&childPool)
+	}
+
+	jobPool.childMutex.Lock()
+	jobPool.children = append(jobPool.children, &childPool)
+	jobPool.childMutex.Unlock()
+
+	return &childPool
+}
+
+// Name returns the name of the child.
+func (childPool *ChildPool) Name() string {
+	return childPool.name
+}
+
+// QueueJob queues a job to be processed on the parent's job routines. The job is rejected if the
+// child is at its pending limit or the parent's queue is at capacity.
+func (childPool *ChildPool) QueueJob(goRoutine string, jober Jobber, priority bool, options ...JobOption) (err error) {
+	defer childPool.parent.catchPanic(&err, goRoutine, "ChildPool.QueueJob")
+
+	// A nil or malformed job is rejected at the call site rather than on a job routine.
+	if err = childPool.parent.checkJob(jober); err != nil {
+		return childPool.parent.reject(goRoutine, jober, err)
+	}
+
+	// A FaultInjector can delay or refuse the job.
+	if err = childPool.parent.admitFault(jober); err != nil {
+		return childPool.parent.reject(goRoutine, jober, err)
+	}
+
+	// A gang larger than the pool could never run.
+	gangSize := jobGangSize(jober)
+	if err = childPool.parent.checkGang(gangSize); err != nil {
+		return childPool.parent.reject(goRoutine, jober, err)
+	}
+
+	// Create the job object to queue.
+	job := queueJob{
+		Jobber:   jober,
+		name:     jobName(jober),
+		size:     jobSize(jober),
+		gangSize: gangSize,
+		priority: childPool.parent.admitPriority(jober, priority),
+		child:    childPool,
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	if err = childPool.admit(&job); err != nil {
+		return childPool.parent.reject(goRoutine, jober, err)
+	}
+
+	childPool.forward(goRoutine)
+	return nil
+}
+
+// QueuedJobs returns the number of the child's jobs that have not started.
+func (childPool *ChildPool) QueuedJobs() int32 {
+	childPool.mutex.Lock()
+	defer childPool.mutex.Unlock()
+
+	return childPool.queuedJobs()
+}
+
+// Shutdown stops the child from accepting jobs and cancels its jobs that have not started. The
+// child's running jobs and the parent are not affected. It returns the number of jobs cancelled.
+func (childPool *ChildPool) Shutdown(goRoutine string) (n int, err error) {
+	defer childPool.parent.catchPanic(&err, goRoutine, "ChildPool.Shutdown")
+
+	n = childPool.close()
+
+	// Cancel the jobs already handed to the parent. Removing a job from the parent's queues
+	// gives back its place under the child's limits.
+	err = childPool.parent.runInQueue(func() {
+		childPool.mutex.Lock()
+		forwardedJobs := make([]*queueJob, 0, len(childPool.forwardedJobs))
+		for queueJob := range childPool.forwardedJobs {
+			forwardedJobs = append(forwardedJobs, queueJob)
+		}
+		childPool.mutex.Unlock()
+
+		for _, queueJob := range forwardedJobs {
+			if childPool.parent.removeQueuedJob(queueJob, DispositionCancelled) == false {
+				continue
+			}
+
+			childPool.parent.finishGroupJob(queueJob)
+			n++
+		}
+	})
+
+	return n, err
+}
+
+//** PRIVATE FUNCTIONS
+
+// dropChildJobs gives back the places under their child's limits of the child jobs in a
+// queue that will never run. It is called once the queue routine is down.
+func dropChildJobs(goRoutine string, queue *list.List) {
+	for element := queue.Front(); element != nil; element = element.Next() {
+		if queueJob := element.Value.(*queueJob); queueJob.child != nil {
+			queueJob.child.dropped(goRoutine, queueJob)
+		}
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// queuedJobs returns the number of the child's jobs that have not started. The mutex must be held.
+func (childPool *ChildPool) queuedJobs() int32 {
+	return int32(childPool.pendingJobs.len() + len(childPool.forwardedJobs))
+}
+
+// admit holds the job in the child's queues once it has taken a slot in the parent's queue.
+func (childPool *ChildPool) admit(queueJob *queueJob) error {
+	childPool.mutex.Lock()
+	defer childPool.mutex.Unlock()
+
+	if childPool.closed == true {
+		return ErrPoolClosed
+	}
+
+	if childPool.maxPending > 0 && childPool.queuedJobs() >= childPool.maxPending {
+		return ErrPoolAtCapacity
+	}
+
+	if childPool.parent.reserveSlot() == false {
+		return ErrPoolAtCapacity
+	}
+
+	childPool.pendingJobs.push(queueJob)
+	return nil
+}
+
+// forward hands the child's pending jobs to the parent while the child is under its
+// concurrency limit. The jobs already hold a slot so the parent admits them without a
+// capacity check.
+func (childPool *ChildPool) forward(goRoutine string) {
+	defer childPool.parent.catchPanic(nil, goRoutine, "ChildPool.forward")
+
+	for {
+		childPool.mutex.Lock()
+		if childPool.closed == true || (childPool.maxConcurrency > 0 && childPool.inFlight >= childPool.maxConcurrency) {
+			childPool.mutex.Unlock()
+			return
+		}
+
+		queueJob := childPool.pendingJobs.pop(true)
+		if queueJob == nil {
+			childPool.mutex.Unlock()
+			return
+		}
+
+		childPool.inFlight++
+		childPool.forwardedJobs[queueJob] = struct{}{}
+		childPool.mutex.Unlock()
+
+		// The parent has shut down so the job is dropped.
+		if err := childPool.parent.submitIntake(queueJob); err != nil {
+			childPool.parent.releaseSlots(1)
+			childPool.mutex.Lock()
+			childPool.inFlight--
+			delete(childPool.forwardedJobs, queueJob)
+			childPool.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// started records that one of the child's jobs has been dequeued by a job routine.
+func (childPool *ChildPool) started(queueJob *queueJob) {
+	childPool.mutex.Lock()
+	defer childPool.mutex.Unlock()
+
+	delete(childPool.forwardedJobs, queueJob)
+}
+
+// finished records that one of the child's jobs has completed and hands the next pending job
+// to the parent.
+func (childPool *ChildPool) finished(goRoutine string) {
+	childPool.mutex.Lock()
+	childPool.inFlight--
+	childPool.mutex.Unlock()
+
+	childPool.forward(goRoutine)
+}
+
+// dropped records that one of the child's jobs handed to the parent was not admitted.
+func (childPool *ChildPool) dropped(goRoutine string, queueJob *queueJob) {
+	childPool.started(queueJob)
+	childPool.finished(goRoutine)
+}
+
+// close stops the child from accepting jobs and releases the parent slots held by the jobs
+// that were not handed to the parent. It returns the number of jobs released.
+func (childPool *ChildPool) close() int {
+	childPool.mutex.Lock()
+
+	childPool.closed = true
+
+	pendingJobs := childPool.pendingJobs
+	released := pendingJobs.len()
+	childPool.parent.releaseSlots(released)
+	childPool.pendingJobs = newTenantQueue(childPool.name)
+
+	childPool.mutex.Unlock()
+
+	// The jobs never reached the parent so they are cancelled here, outside the lock since
+	// releasing a job calls into it.
+	childPool.parent.cancelQueuedJobs("ChildPool", pendingJobs.priorityJobQueue, DispositionCancelled)
+	childPool.parent.cancelQueuedJobs("ChildPool", pendingJobs.normalJobQueue, DispositionCancelled)
+
+	return released
+}
+
+// closeChildren stops every child from accepting jobs during shutdown and returns the number
+// of priority and normal jobs the children were still holding.
+func (jobPool *JobPool) closeChildren() (priorityJobs int, normalJobs int) {
+	jobPool.childMutex.Lock()
+	defer jobPool.childMutex.Unlock()
+
+	for _, childPool := range jobPool.children {
+		childPool.mutex.Lock()
+		priorityJobs += childPool.pendingJobs.priorityJobQueue.Len()
+		normalJobs += childPool.pendingJobs.normalJobQueue.Len()
+		childPool.mutex.Unlock()
+
+		childPool.close()
+	}
+
+	return priorityJobs, normalJobs
+}
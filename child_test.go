@@ -0,0 +1,88 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestChildKeepsSchedulingAfterParentCancels proves a child job the parent removes from its
+// queues gives back its place under the child's concurrency limit, so the child's other jobs
+// still run.
+func TestChildKeepsSchedulingAfterParentCancels(t *testing.T) {
+	tests := []struct {
+		name       string
+		jobOptions []JobOption
+		cancel     func(jobPool *JobPool) (int, error)
+	}{
+		{
+			name: "CancelPending",
+			cancel: func(jobPool *JobPool) (int, error) {
+				return jobPool.CancelPending("test", false, false, nil)
+			},
+		},
+		{
+			name:       "CancelGroup",
+			jobOptions: []JobOption{WithGroup("child")},
+			cancel: func(jobPool *JobPool) (int, error) {
+				return jobPool.CancelGroup("test", "child")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 10)
+
+			// Hold the parent's only routine so the child's job waits in the parent's queue.
+			release := make(chan struct{})
+			releaseOnce := sync.OnceFunc(func() { close(release) })
+			defer releaseOnce()
+
+			blocker, started := blockingJob(release)
+			if err := jobPool.QueueJob("test", blocker, false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+			<-started
+
+			child := jobPool.Child("emails", WithMaxConcurrency(1))
+
+			var ran int32
+			job := funcJob(func(jobRoutine int) {
+				atomic.AddInt32(&ran, 1)
+			})
+
+			if err := child.QueueJob("test", job, false, test.jobOptions...); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			// The child hands the job to the parent through its intake.
+			waitFor(t, 5*time.Second, "the child's job to reach the parent", func() bool {
+				return jobPool.QueuedJobs() == 1
+			})
+
+			if n, err := test.cancel(jobPool); err != nil || n != 1 {
+				t.Fatalf("Cancelled %d jobs with error %v, want 1", n, err)
+			}
+
+			// The child is under its limit again and hands its next jobs to the parent.
+			for i := 0; i < 2; i++ {
+				if err := child.QueueJob("test", job, false); err != nil {
+					t.Fatalf("QueueJob : %s", err)
+				}
+			}
+			releaseOnce()
+
+			waitFor(t, 5*time.Second, "the child's jobs to run", func() bool {
+				return atomic.LoadInt32(&ran) == 2 && child.QueuedJobs() == 0
+			})
+		})
+	}
+}
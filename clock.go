@@ -0,0 +1,83 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"time"
+)
+
+//** TYPES
+
+type (
+	// realClock tells the time with the time package.
+	realClock struct{}
+
+	// realTimer wraps a time.Timer.
+	realTimer struct {
+		timer *time.Timer // The timer being wrapped.
+	}
+)
+
+//** INTERFACES
+
+// Clock tells the pool the time and creates its timers. Tests can provide a fake clock to
+// control the time based features of the pool.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer delivers the time on its channel once it expires.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+//** VARIABLES
+
+var (
+	// RealClock is the Clock used when none is configured.
+	RealClock Clock = realClock{}
+)
+
+//** PRIVATE FUNCTIONS
+
+// clockOf returns the clock in the config or the real clock.
+func clockOf(config Config) Clock {
+	if config.Clock == nil {
+		return RealClock
+	}
+
+	return config.Clock
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Now returns the current time.
+func (realClock realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer creates a timer that expires after the duration.
+func (realClock realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+// C returns the channel the time is delivered on.
+func (realTimer realTimer) C() <-chan time.Time {
+	return realTimer.timer.C
+}
+
+// Stop prevents the timer from firing.
+func (realTimer realTimer) Stop() bool {
+	return realTimer.timer.Stop()
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// clock returns the configured clock or the real clock.
+func (jobPool *JobPool) clock() Clock {
+	return clockOf(jobPool.config)
+}
@@ -0,0 +1,77 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** INTERFACES
+
+// Coalescer is implemented by a job queued with QueueJobUnique that can be merged with the
+// pending job holding its key instead of being refused. Coalesce is given the pending job and
+// the job being queued and returns the job that runs in their place. Returning nil refuses the
+// job being queued with ErrDuplicateJob. Coalesce is called on the queue routine so it must be
+// quick and must not call back into the pool.
+type Coalescer interface {
+	Coalesce(existing Jobber, incoming Jobber) Jobber
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// coalesceUnique merges a Coalescer into the pending job holding its key. The merged job keeps
+// its place in the queue and its enqueue time. It returns the job that was merged into or nil
+// if the job was not merged. It is only called by the queue routine.
+func (jobPool *JobPool) coalesceUnique(queueJob *queueJob) *queueJob {
+	if queueJob.key == "" {
+		return nil
+	}
+
+	coalescer, ok := queueJob.Jobber.(Coalescer)
+	if ok == false {
+		return nil
+	}
+
+	// Only a job still in its queue can be merged into. One that has been dequeued runs with
+	// the job it has.
+	holder, found := jobPool.uniqueJobs[queueJob.key]
+	if found == false || holder.queue == nil || atomic.LoadInt32(&holder.state) != jobPending {
+		return nil
+	}
+
+	var merged Jobber
+	jobPool.callbackSafely("Queue", "Coalesce", func() {
+		merged = coalescer.Coalesce(holder.Jobber, queueJob.Jobber)
+	})
+
+	if merged == nil {
+		return nil
+	}
+
+	// Account for the merged job's size in place of the pending job's.
+	size := jobSize(merged)
+	atomic.AddInt64(&jobPool.gauges.pendingBytes, size-holder.size)
+
+	holder.Jobber = merged
+	holder.size = size
+	jobPool.outstanding.rematch(holder)
+
+	if queueJob.handle != nil {
+		holder.followers = append(holder.followers, queueJob.handle)
+	}
+
+	return holder
+}
+
+// supersedes returns true if the job can take over the key of the holder. A Coalescer queued
+// while the job holding its key is out of the queue, running or waiting to be retried, is
+// queued on its own so its work isn't lost. It is only called by the queue routine.
+func (queueJob *queueJob) supersedes(holder *queueJob) bool {
+	if _, ok := queueJob.Jobber.(Coalescer); ok == false {
+		return false
+	}
+
+	return holder.queue == nil
+}
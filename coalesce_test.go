@@ -0,0 +1,199 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// sumJob is a job that carries values and can be merged with another sumJob.
+	sumJob struct {
+		values   []int     // The values the job hands to its recorder.
+		recorder *recorder // Records the values of every run.
+	}
+
+	// recorder keeps the values each sumJob ran with, in the order the jobs ran.
+	recorder struct {
+		runs  [][]int    // The values of each run.
+		mutex sync.Mutex // Protects runs.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestCoalescePending merges duplicates into a pending job and proves the merged job runs once
+// with every value, in the pending job's place in the queue, and the handles of every
+// submission are done once it finishes.
+func TestCoalescePending(t *testing.T) {
+	jobPool := newTestPool(t, 1, 10)
+
+	// Hold the only routine so the jobs wait in the queue.
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	recorder := &recorder{}
+
+	// Each submission is admitted before the next so the jobs hold their places in order.
+	var handles []*JobHandle
+	submit := func(value int) {
+		t.Helper()
+
+		handle := jobPool.QueueJobUniqueAsync("test", "account", &sumJob{values: []int{value}, recorder: recorder}, false)
+		if err := handle.Wait(); err != nil {
+			t.Fatalf("Submission %d : %s", value, err)
+		}
+		handles = append(handles, handle)
+	}
+
+	submit(1)
+	if err := jobPool.QueueJob("test", &sumJob{values: []int{100}, recorder: recorder}, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	submit(2)
+	submit(3)
+
+	if queued := jobPool.QueuedJobs(); queued != 2 {
+		t.Fatalf("QueuedJobs[%d], want the merged job and the other job", queued)
+	}
+
+	releaseOnce()
+
+	for i, handle := range handles {
+		select {
+		case <-handle.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the handle of submission %d", i)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "both jobs to run", func() bool {
+		return len(recorder.snapshot()) == 2
+	})
+
+	if runs, want := recorder.snapshot(), [][]int{{1, 2, 3}, {100}}; reflect.DeepEqual(runs, want) == false {
+		t.Fatalf("Runs[%v], want %v", runs, want)
+	}
+}
+
+// TestCoalesceRacesDequeue merges a duplicate just as an idle routine dequeues the job holding
+// its key, and proves every value runs exactly once whichever side wins and every handle is
+// done. Run it with -race.
+func TestCoalesceRacesDequeue(t *testing.T) {
+	trials := 2000
+	if testing.Short() == true {
+		trials = 200
+	}
+
+	jobPool := newTestPool(t, 1, 10)
+	recorder := &recorder{}
+
+	// Each pair is queued on an idle routine, which dequeues the first job as the second
+	// arrives.
+	var want []int
+	for trial := 0; trial < trials; trial++ {
+		key := fmt.Sprintf("account-%d", trial)
+
+		if err := jobPool.QueueJobUnique("test", key, &sumJob{values: []int{2 * trial}, recorder: recorder}, false); err != nil {
+			t.Fatalf("Trial %d : QueueJobUnique : %s", trial, err)
+		}
+
+		handle := jobPool.QueueJobUniqueAsync("test", key, &sumJob{values: []int{2*trial + 1}, recorder: recorder}, false)
+		want = append(want, 2*trial, 2*trial+1)
+
+		select {
+		case <-handle.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Trial %d : Timed out waiting for the handle in state %s", trial, handle.State())
+		}
+
+		if err := handle.Err(); err != nil {
+			t.Fatalf("Trial %d : QueueJobUniqueAsync : %s", trial, err)
+		}
+
+		waitFor(t, 5*time.Second, "the trial's values to run", func() bool {
+			return recorder.values() == len(want)
+		})
+	}
+
+	runs := recorder.snapshot()
+	t.Logf("Runs[%d] Merged[%d]", len(runs), len(want)-len(runs))
+
+	var ran []int
+	for _, values := range runs {
+		ran = append(ran, values...)
+	}
+	sort.Ints(ran)
+
+	if reflect.DeepEqual(ran, want) == false {
+		t.Fatalf("Ran %d values, want each of the %d values once", len(ran), len(want))
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob records the job's values.
+func (sumJob *sumJob) RunJob(jobRoutine int) {
+	sumJob.recorder.mutex.Lock()
+	defer sumJob.recorder.mutex.Unlock()
+
+	sumJob.recorder.runs = append(sumJob.recorder.runs, sumJob.values)
+}
+
+// Coalesce returns a job carrying the values of both jobs.
+func (sumJob *sumJob) Coalesce(existing Jobber, incoming Jobber) Jobber {
+	return mergeSums(existing, incoming)
+}
+
+//** PRIVATE FUNCTIONS
+
+// mergeSums returns a sumJob carrying the values of the existing job followed by the values of
+// the incoming job.
+func mergeSums(existing Jobber, incoming Jobber) Jobber {
+	existingJob, incomingJob := existing.(*sumJob), incoming.(*sumJob)
+	values := append([]int{}, existingJob.values...)
+
+	return &sumJob{
+		values:   append(values, incomingJob.values...),
+		recorder: existingJob.recorder,
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// values returns the number of values run so far.
+func (recorder *recorder) values() int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	var values int
+	for _, run := range recorder.runs {
+		values += len(run)
+	}
+
+	return values
+}
+
+// snapshot returns the values of the runs so far.
+func (recorder *recorder) snapshot() [][]int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return append([][]int{}, recorder.runs...)
+}
@@ -0,0 +1,153 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+)
+
+//** TYPES
+
+type (
+	// concurrencyLimit caps the number of jobs running at the same time below the number of job
+	// routines without stopping any routine.
+	concurrencyLimit struct {
+		limit     int        // The max number of jobs running at the same time. Zero is no limit.
+		executing int        // The number of job routines holding a permit.
+		closed    bool       // Set once the pool is shutting down and the limit is lifted.
+		mutex     sync.Mutex // Protects the counts.
+		cond      *sync.Cond // Signals the job routines waiting for a permit.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// SetConcurrencyLimit limits the number of jobs that run at the same time without changing the
+// number of job routines. Routines over the limit wait before they dequeue, so the jobs stay in
+// the queues. Zero or less removes the limit and a limit above the number of job routines has no
+// effect. The limit can be changed at any time and a lower limit lets the running jobs finish.
+// Setting the limit ends a ramp up in progress.
+func (jobPool *JobPool) SetConcurrencyLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	jobPool.rampMutex.Lock()
+	defer jobPool.rampMutex.Unlock()
+
+	jobPool.stopRamp()
+	jobPool.ramp.userLimit = limit
+	jobPool.concurrency.setLimit(limit)
+}
+
+// ConcurrencyLimit returns the limit set with SetConcurrencyLimit. Zero is no limit.
+func (jobPool *JobPool) ConcurrencyLimit() int {
+	limit, _ := jobPool.concurrency.counts()
+	return limit
+}
+
+//** PRIVATE FUNCTIONS
+
+// newConcurrencyLimit creates a limit that doesn't limit anything.
+func newConcurrencyLimit() *concurrencyLimit {
+	concurrencyLimit := concurrencyLimit{}
+	concurrencyLimit.cond = sync.NewCond(&concurrencyLimit.mutex)
+
+	return &concurrencyLimit
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// acquire blocks until the routine can run a job under the limit.
+func (concurrencyLimit *concurrencyLimit) acquire() {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	for concurrencyLimit.full() == true {
+		concurrencyLimit.cond.Wait()
+	}
+
+	concurrencyLimit.executing++
+}
+
+// acquireNow takes a permit without blocking for a routine holding a prefetched job. It returns
+// false if acquire would have to wait.
+func (concurrencyLimit *concurrencyLimit) acquireNow() bool {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	if concurrencyLimit.full() == true {
+		return false
+	}
+
+	concurrencyLimit.executing++
+	return true
+}
+
+// limited reports if a limit is set, in which case the job routines don't prefetch.
+func (concurrencyLimit *concurrencyLimit) limited() bool {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	return concurrencyLimit.closed == false && concurrencyLimit.limit > 0
+}
+
+// tryAcquire takes a permit without blocking for a job run by its submitter. It returns false
+// if the permits held have reached the limit or the number of job routines.
+func (concurrencyLimit *concurrencyLimit) tryAcquire(routines int) bool {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	if concurrencyLimit.closed == true || concurrencyLimit.executing >= routines {
+		return false
+	}
+
+	if concurrencyLimit.limit > 0 && concurrencyLimit.executing >= concurrencyLimit.limit {
+		return false
+	}
+
+	concurrencyLimit.executing++
+	return true
+}
+
+// release gives back the permit taken by acquire.
+func (concurrencyLimit *concurrencyLimit) release() {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	concurrencyLimit.executing--
+	concurrencyLimit.cond.Signal()
+}
+
+// setLimit changes the limit and wakes the routines a higher limit lets through.
+func (concurrencyLimit *concurrencyLimit) setLimit(limit int) {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	concurrencyLimit.limit = limit
+	concurrencyLimit.cond.Broadcast()
+}
+
+// close lifts the limit so the waiting routines can see the pool is shutting down.
+func (concurrencyLimit *concurrencyLimit) close() {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	concurrencyLimit.closed = true
+	concurrencyLimit.cond.Broadcast()
+}
+
+// full reports if the permits held have reached the limit. The mutex must be held.
+func (concurrencyLimit *concurrencyLimit) full() bool {
+	return concurrencyLimit.closed == false && concurrencyLimit.limit > 0 && concurrencyLimit.executing >= concurrencyLimit.limit
+}
+
+// counts returns the limit and the number of routines holding a permit.
+func (concurrencyLimit *concurrencyLimit) counts() (limit int, executing int) {
+	concurrencyLimit.mutex.Lock()
+	defer concurrencyLimit.mutex.Unlock()
+
+	return concurrencyLimit.limit, concurrencyLimit.executing
+}
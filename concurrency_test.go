@@ -0,0 +1,160 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestConcurrencyLimitActiveRoutines proves the job routines held back by the concurrency
+// limit are not counted as active.
+func TestConcurrencyLimitActiveRoutines(t *testing.T) {
+	jobPool := newTestPool(t, 4, 10)
+	jobPool.SetConcurrencyLimit(1)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	job, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", job, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	// Wake the other routines with jobs of their own so they wait on the limit.
+	for i := 0; i < 3; i++ {
+		if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	// Give the other routines time to reach the limit.
+	time.Sleep(50 * time.Millisecond)
+
+	if active := jobPool.ActiveRoutines(); active != 1 {
+		t.Fatalf("ActiveRoutines is %d under a limit of 1, want 1", active)
+	}
+}
+
+// TestConcurrencyLimitPrefetch proves a concurrency limit caps the jobs running at the same time
+// when the routines prefetch, and the jobs waiting on the limit stay in the queues rather than
+// being prefetched by the running routines.
+func TestConcurrencyLimitPrefetch(t *testing.T) {
+	jobPool := newTestPool(t, 4, 100, WithPrefetch())
+	jobPool.SetConcurrencyLimit(2)
+
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	var running, peak, ran int32
+	job := funcJob(func(jobRoutine int) {
+		now := atomic.AddInt32(&running, 1)
+		for {
+			was := atomic.LoadInt32(&peak)
+			if now <= was || atomic.CompareAndSwapInt32(&peak, was, now) == true {
+				break
+			}
+		}
+
+		<-release
+		time.Sleep(time.Millisecond)
+
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&ran, 1)
+	})
+
+	const jobs = 40
+	for i := 0; i < jobs; i++ {
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "the limit to be reached", func() bool {
+		return atomic.LoadInt32(&running) == 2
+	})
+
+	// Give the routines time to prefetch if they were going to.
+	time.Sleep(50 * time.Millisecond)
+
+	if queued := jobPool.QueuedJobs(); queued != jobs-2 {
+		t.Fatalf("QueuedJobs[%d] with 2 jobs running, want %d", queued, jobs-2)
+	}
+
+	releaseOnce()
+
+	waitFor(t, 5*time.Second, "the jobs to run", func() bool {
+		return atomic.LoadInt32(&ran) == jobs
+	})
+
+	if got := atomic.LoadInt32(&peak); got > 2 {
+		t.Fatalf("%d jobs ran at the same time under a limit of 2", got)
+	}
+}
+
+// TestConcurrencyLimitGivesBackPrefetched sets a limit after a routine has prefetched its next
+// job and proves the routine, once the limit leaves it no room, gives the prefetched job back to
+// the queue instead of holding it while it waits.
+func TestConcurrencyLimitGivesBackPrefetched(t *testing.T) {
+	jobPool := newTestPool(t, 2, 10, WithPrefetch())
+
+	// The held job keeps one routine and its permit for the whole test.
+	releaseHeld := make(chan struct{})
+	defer close(releaseHeld)
+
+	held, heldStarted := blockingJob(releaseHeld)
+	if err := jobPool.QueueJob("test", held, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-heldStarted
+
+	// Hold the other routine so the blocker and the jobs after it are queued before it
+	// dequeues the blocker and prefetches the next job.
+	releaseGate := make(chan struct{})
+	releaseGateOnce := sync.OnceFunc(func() { close(releaseGate) })
+	defer releaseGateOnce()
+
+	gate, gateStarted := blockingJob(releaseGate)
+	if err := jobPool.QueueJob("test", gate, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-gateStarted
+
+	releaseBlocker := make(chan struct{})
+	releaseBlockerOnce := sync.OnceFunc(func() { close(releaseBlocker) })
+	defer releaseBlockerOnce()
+
+	blocker, blockerStarted := blockingJob(releaseBlocker)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	releaseGateOnce()
+	<-blockerStarted
+
+	waitFor(t, 5*time.Second, "the routine to prefetch", func() bool {
+		return jobPool.QueuedJobs() == 3
+	})
+
+	// The held job keeps the only permit so the routine has no room for its prefetched job.
+	jobPool.SetConcurrencyLimit(1)
+	releaseBlockerOnce()
+
+	waitFor(t, 5*time.Second, "the prefetched job to be given back", func() bool {
+		return jobPool.QueuedJobs() == 4
+	})
+}
@@ -0,0 +1,856 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// Config holds every setting used to create a JobPool.
+	Config struct {
+		Name               string                   // The name of the pool.
+		Manager            *Manager                 // The manager the pool registers with. When nil the default manager is used.
+		Unmanaged          bool                     // If the pool is not registered with any manager.
+		Clock              Clock                    // Tells the time and creates the timers for delays. When nil the real clock is used.
+		Logger             Logger                   // Receives the pool's internal messages. When nil the standard logger is used.
+		LogLevel           LogLevel                 // The lowest level of internal message that is written.
+		SilencePanics      bool                     // If panics are held to LogLevel instead of always being written.
+		PanicHandler       func(PanicInfo)          // Receives a report for every recovered panic.
+		MaxStackSize       int                      // The largest stack trace captured for a panic. Zero picks a default.
+		StackAllRoutines   bool                     // If panic reports capture the stacks of every goroutine.
+		PanicPolicy        PanicPolicy              // What happens after a panic has been written and reported.
+		AbortFunc          func()                   // Called by the Abort panic policy. When nil the process exits.
+		QuarantinePanics   int                      // The number of panics within QuarantineWindow that quarantines a job type. Zero disables quarantine.
+		QuarantineWindow   time.Duration            // The interval the panics of a job type are counted over.
+		OnQuarantine       func(jobType string)     // Called when a job type is quarantined.
+		MaxRequeues        int                      // The number of times a job that panicked is placed back in the queue. Zero disables requeues.
+		RequeueDelay       time.Duration            // How long to wait before a job that panicked is placed back in the queue.
+		RequeueFront       bool                     // If a job that panicked is placed at the front of its queue instead of the back.
+		MaxRetries         int                      // The number of times a job that returned an error is placed back in the queue. Zero disables retries.
+		RetryDelay         time.Duration            // How long to wait before a job that returned an error is placed back in the queue.
+		RetryBudget        int                      // The number of retries allowed per RetryBudgetEvery across the pool. Zero is unlimited.
+		RetryBudgetEvery   time.Duration            // The interval over which the retry budget refills.
+		RetryPriorityBoost float64                  // The largest share of dequeues that may go to retries placed in the priority queue. Zero disables the boost.
+		Backoff            BackoffStrategy          // Decides the delay before each retry or requeue in place of RetryDelay and RequeueDelay.
+		ErrorClassifier    func(error) Retryability // Decides if a failed job may be retried. DefaultErrorClassifier when nil.
+		DeadLetter         func(DeadLetter)         // Receives the jobs that have failed for good.
+		OnReleaseError     func(Jobber, error)      // Receives the errors of releasing the jobs that never ran. When nil the errors are logged.
+		MissedRunPolicy    MissedRunPolicy          // What cron schedules do about the firings they miss.
+		Routines           int                      // The number of job routines that process jobs concurrently.
+		QueueCapacity      int32                    // The max number of jobs we can store in the queue.
+		OverflowPolicy     OverflowPolicy           // What QueueJob does when the queue is at capacity.
+		AsyncIntake        int                      // The size of the intake buffer used by QueueJobAsync. Zero picks a default.
+		QueueBuffer        int                      // The size of the buffer in front of the queue routine for QueueJob. Zero is unbuffered.
+		DequeueBuffer      int                      // The size of the buffer in front of the queue routine for job routines asking for a job. Zero is unbuffered.
+		Prefetch           bool                     // If each job routine asks for its next job while it runs the current one.
+		StrictFIFO         bool                     // If the settings must keep normal jobs running in the order they were admitted.
+		DrainJobEstimate   time.Duration            // How long a job is expected to run, used by DrainWithDeadline to stop dequeuing in time.
+		StuckWorkerGrace   time.Duration            // How long DrainWithDeadline waits for the job routines before abandoning the ones stuck in a job. Zero waits for them.
+		PriorityFreshness  PriorityFreshness        // If a job routine holding a batch gives normal jobs back when a priority job arrives.
+		RejectionHandler   RejectionHandler         // Handler called for jobs that could not be admitted.
+		HighWatermark      int32                    // The queue depth that fires OnHighWatermark. Zero disables watermarks.
+		LowWatermark       int32                    // The queue depth below which OnLowWatermark fires.
+		OnHighWatermark    func()                   // Called when the queue depth reaches HighWatermark.
+		OnLowWatermark     func()                   // Called when the queue depth falls back under LowWatermark.
+		OnQueueEmpty       func()                   // Called once no jobs are pending.
+		OnQueueNonEmpty    func()                   // Called once a job is pending after none were.
+		QueueEmptyDebounce time.Duration            // How long the queue must stay empty or non empty before its callback is called.
+		RampStep           int                      // The number of jobs the concurrency limit rises by each RampInterval during a ramp up. Zero disables the ramp.
+		RampInterval       time.Duration            // How long the concurrency limit holds at each step of a ramp up.
+		DedupCooldown      time.Duration            // How long after a unique job completes a job with the same key is refused. Zero disables the cool-down.
+		DedupCooldownKeys  int                      // The most keys remembered for the cool-down, forgetting the least recently completed first. Zero uses 10000.
+		SharedBackend      Backend                  // The backend the pool shares with other pools in place of its own queue, see WithSharedBackend.
+		FairQueuing        bool                     // If the tenants' jobs are interleaved rather than served in order.
+		TenantWeights      map[string]int           // The number of consecutive jobs each tenant is served per turn. The default is 1.
+		MaxQueueBytes      int64                    // The largest total SizeBytes of pending jobs. Zero disables the budget.
+		MemorySoftLimit    int64                    // The heap size above which normal jobs are refused. Zero disables the memory guard.
+		MemoryLowLimit     int64                    // The heap size under which the memory guard releases. Zero is nine tenths of MemorySoftLimit.
+		TenantCapacity     int32                    // The max number of pending jobs a single tenant can hold. Zero is unlimited.
+		ContinuationBudget int32                    // The number of jobs queued with Continue that can be admitted over QueueCapacity.
+		TagReservations    map[string]int           // The number of job routines guaranteed to each tag, see WithTagReservations.
+		LockOSThread       bool                     // If job routines lock their OS thread while running a job.
+		PinWorkers         bool                     // If job routines lock their OS thread for their whole life.
+		RuntimeTrace       bool                     // If each job is wrapped in a runtime/trace task while a trace is collected.
+		HistorySize        int                      // The number of recent jobs kept for History. Zero keeps no history.
+		ErrorHistorySize   int                      // The number of recent job errors kept for RecentErrors. Zero keeps none.
+		MaxJobTypes        int                      // The number of job types given their own counters in Stats. Zero picks a default.
+		StatsInterval      time.Duration            // How often the stats reporter emits a Stats snapshot. Zero disables the reporter.
+		StatsReporter      func(Stats)              // Receives each snapshot. When nil the snapshot is written to stdout.
+		StatsSkipIdle      bool                     // If snapshots are skipped while the pool is idle.
+		DepthSampling      bool                     // If the queue depth is sampled for DepthSummary and DepthSamples.
+		DepthInterval      time.Duration            // How often the queue depth is sampled. Zero samples every 100ms.
+		DepthRetention     time.Duration            // How long the depth samples are kept. Zero keeps an hour.
+		IntegrityChecks    bool                     // If every admitted job is checked to leave the pool accounted for exactly once.
+		Validator          func(jober Jobber) error // Rejects malformed jobs when they are queued.
+		PriorityFunc       PriorityFunc             // Decides the priority of each job queued in place of the priority asked for.
+		FaultInjector      FaultInjector            // Called at the admission, dequeue and run injection points in tests. Nil leaves them inert.
+
+		MaxAcceptableQueueLatency time.Duration                                             // The wait in queue above which OnQueueLatency is called. Zero disables the check.
+		SlowMessageThreshold      time.Duration                                             // The time the queue routine takes on a message above which a warning is logged. Zero disables the warning.
+		OnQueueLatency            func(jobType string, priority bool, waited time.Duration) // Called for each job that waited longer than MaxAcceptableQueueLatency.
+		OnMemoryGuard             func(guarded bool, heapBytes int64)                       // Called when the memory guard engages or releases.
+		OnEvicted                 func(jober Jobber, waited time.Duration)                  // Called for each job evicted by the DropOldest overflow policy.
+		OnIntegrityViolation      func(integrityViolation IntegrityViolation)               // Called for each violation found by the integrity checks.
+	}
+
+	// ConfigError describes a setting of a Config that Validate rejected.
+	ConfigError struct {
+		Field   string // The setting that was rejected.
+		Message string // What is wrong with the setting.
+	}
+
+	// Option configures a JobPool when it is created.
+	Option func(config *Config)
+
+	// JobOption configures a single job when it is queued.
+	JobOption func(queueJob *queueJob)
+
+	// cloneSuffixes hands out the numeric suffixes of the names given by CloneConfig.
+	cloneSuffixes struct {
+		last  map[string]int // The last suffix handed out for each name without its suffix.
+		mutex sync.Mutex     // Protects the suffixes.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// defaultQueueCapacity is the capacity of the queue returned by Defaults.
+	defaultQueueCapacity = 1024
+
+	// defaultAsyncIntake is the largest intake buffer created when AsyncIntake isn't set.
+	defaultAsyncIntake = 1024
+
+	// defaultMaxJobTypes is the number of job types given their own counters when MaxJobTypes isn't set.
+	defaultMaxJobTypes = 64
+
+	// defaultMaxStackSize is the largest stack trace captured when MaxStackSize isn't set.
+	defaultMaxStackSize = 1 << 20
+)
+
+//** VARIABLES
+
+var (
+	// clonedNames holds the suffixes handed out to the clones of every pool in the process.
+	clonedNames = cloneSuffixes{
+		last: make(map[string]int),
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// Defaults returns a Config with a job routine per CPU and a queue that holds 1024 jobs. Every
+// other setting is off. The Config can be changed before it is given to NewFromConfig.
+func Defaults() Config {
+	return Config{
+		Routines:      runtime.GOMAXPROCS(0),
+		QueueCapacity: defaultQueueCapacity,
+	}
+}
+
+// WithAsyncIntake sets the size of the buffer QueueJobAsync places jobs into.
+func WithAsyncIntake(buffer int) Option {
+	return func(config *Config) {
+		config.AsyncIntake = buffer
+	}
+}
+
+// WithBackoff sets the strategy that decides how long to wait before a job is retried or
+// requeued. It replaces the fixed delays given to WithRetry and WithRequeueOnPanic.
+func WithBackoff(strategy BackoffStrategy) Option {
+	return func(config *Config) {
+		config.Backoff = strategy
+	}
+}
+
+// WithClock sets the clock used for delays, retries and other timed work. It lets tests drive
+// the pool with a fake clock.
+func WithClock(clock Clock) Option {
+	return func(config *Config) {
+		config.Clock = clock
+	}
+}
+
+// WithControlBuffers sets the size of the buffers in front of the queue routine for QueueJob and
+// for job routines asking for their next job. With buffers a burst of submitters or job routines
+// doesn't have to meet the queue routine one at a time. Each QueueJob still waits for its own
+// outcome and capacity is still enforced by the queue routine.
+func WithControlBuffers(queueBuffer int, dequeueBuffer int) Option {
+	return func(config *Config) {
+		config.QueueBuffer = queueBuffer
+		config.DequeueBuffer = dequeueBuffer
+	}
+}
+
+// WithDeadLetter sets the handler that receives the jobs that have failed for good, such as a
+// job that panicked more times than WithRequeueOnPanic allows.
+func WithDeadLetter(deadLetter func(DeadLetter)) Option {
+	return func(config *Config) {
+		config.DeadLetter = deadLetter
+	}
+}
+
+// WithDedupCooldown has QueueJobUnique refuse a key for the window after its job completed, as
+// well as while the job is pending or running. At most maxKeys keys are remembered and the least
+// recently completed key is forgotten first. Zero keys remembers 10000.
+func WithDedupCooldown(window time.Duration, maxKeys int) Option {
+	return func(config *Config) {
+		config.DedupCooldown = window
+		config.DedupCooldownKeys = maxKeys
+	}
+}
+
+// WithDrainJobEstimate sets how long a job is expected to run. DrainWithDeadline stops handing out
+// jobs once less than the estimate is left before its deadline.
+func WithDrainJobEstimate(estimate time.Duration) Option {
+	return func(config *Config) {
+		config.DrainJobEstimate = estimate
+	}
+}
+
+// WithErrorClassifier sets the function that decides if a failed job is Retryable, Permanent or
+// Fatal. It is called on the worker routine and a panic in it is treated as Retryable.
+func WithErrorClassifier(classifier func(error) Retryability) Option {
+	return func(config *Config) {
+		config.ErrorClassifier = classifier
+	}
+}
+
+// WithErrorHistory keeps the last size jobs that returned an error or panicked. The records are
+// returned by RecentErrors and LastError.
+func WithErrorHistory(size int) Option {
+	return func(config *Config) {
+		config.ErrorHistorySize = size
+	}
+}
+
+// WithFairQueuing gives each tenant its own queues and serves the tenants in turn. A tenant
+// listed in weights is served that many jobs per turn, every other tenant is served one. The
+// weights are copied, so changing the map afterwards doesn't affect the pool.
+func WithFairQueuing(weights map[string]int) Option {
+	return func(config *Config) {
+		config.FairQueuing = true
+		config.TenantWeights = copyCounts(weights)
+	}
+}
+
+// WithHistory keeps a record of the last size jobs that completed, failed or were rejected. The
+// records are returned by History and included in the Stats snapshot.
+func WithHistory(size int) Option {
+	return func(config *Config) {
+		config.HistorySize = size
+	}
+}
+
+// WithLockOSThread makes each job routine lock its OS thread while it runs a job, for jobs that
+// call into C libraries needing thread affinity. With pinWorkers each routine also keeps its
+// thread for its whole life so thread local state survives from one job to the next. A pinned
+// routine unlocks its thread when it exits at shutdown. Single jobs can ask for a locked thread
+// by implementing ThreadAffinity.
+func WithLockOSThread(pinWorkers bool) Option {
+	return func(config *Config) {
+		config.LockOSThread = true
+		config.PinWorkers = pinWorkers
+	}
+}
+
+// WithLogger sets the logger that receives the pool's internal messages.
+func WithLogger(logger Logger) Option {
+	return func(config *Config) {
+		config.Logger = logger
+	}
+}
+
+// WithLogLevel sets the lowest level of internal message that is written. Panics are always
+// written unless silencePanics is true, in which case they are held to the level like any
+// other LogError message.
+func WithLogLevel(logLevel LogLevel, silencePanics bool) Option {
+	return func(config *Config) {
+		config.LogLevel = logLevel
+		config.SilencePanics = silencePanics
+	}
+}
+
+// WithManager registers the pool with the manager instead of the default manager.
+func WithManager(manager *Manager) Option {
+	return func(config *Config) {
+		config.Manager = manager
+		config.Unmanaged = false
+	}
+}
+
+// WithMaxQueueBytes limits the total SizeBytes of the pending jobs that implement Sizer. A job
+// that would take the queue over the budget is rejected with ErrQueueBytesExceeded, independent
+// of the count based capacity.
+func WithMaxQueueBytes(maxBytes int64) Option {
+	return func(config *Config) {
+		config.MaxQueueBytes = maxBytes
+	}
+}
+
+// WithMaxJobTypes sets the number of job types given their own counters in Stats. The counters
+// for any further types are combined under OtherJobTypes.
+func WithMaxJobTypes(maxJobTypes int) Option {
+	return func(config *Config) {
+		config.MaxJobTypes = maxJobTypes
+	}
+}
+
+// WithMissedRunPolicy sets what cron schedules do about firings missed because the process was
+// asleep or the job could not be queued.
+func WithMissedRunPolicy(policy MissedRunPolicy) Option {
+	return func(config *Config) {
+		config.MissedRunPolicy = policy
+	}
+}
+
+// WithName sets the name of the pool.
+func WithName(name string) Option {
+	return func(config *Config) {
+		config.Name = name
+	}
+}
+
+// WithRampUp has the pool ramp up when it is created and whenever RampUp is called. The
+// concurrency limit starts at one job and rises by step every interval until every job routine
+// can run or the limit set with SetConcurrencyLimit is reached.
+func WithRampUp(step int, interval time.Duration) Option {
+	return func(config *Config) {
+		config.RampStep = step
+		config.RampInterval = interval
+	}
+}
+
+// WithRejectionHandler sets the handler that is called for every job the pool could not admit.
+func WithRejectionHandler(rejectionHandler RejectionHandler) Option {
+	return func(config *Config) {
+		config.RejectionHandler = rejectionHandler
+	}
+}
+
+// WithWatermarks sets callbacks for the queue depth. onHigh is called when the number of queued
+// jobs reaches high and onLow is called once it falls back under low. Nothing fires again until
+// the opposite watermark is crossed so the callbacks don't oscillate with every job.
+func WithWatermarks(high int32, low int32, onHigh func(), onLow func()) Option {
+	return func(config *Config) {
+		config.HighWatermark = high
+		config.LowWatermark = low
+		config.OnHighWatermark = onHigh
+		config.OnLowWatermark = onLow
+	}
+}
+
+// WithPrefetch makes each job routine ask for its next job while it runs the current one, so the
+// next job is ready without a round trip to the queue routine. A prefetched job that is cancelled
+// before it is picked up is dropped and one held at shutdown is placed back at the front of its
+// queue. The routines don't prefetch while a concurrency limit is set, see SetConcurrencyLimit.
+func WithPrefetch() Option {
+	return func(config *Config) {
+		config.Prefetch = true
+	}
+}
+
+// WithPriorityFreshness sets what a job routine holding a batch of jobs, such as a job prefetched
+// with WithPrefetch, does when a priority job arrives. StrictPriority gives the rest of the normal jobs back so the priority job runs next,
+// RelaxedPriority, the default, finishes the batch first.
+func WithPriorityFreshness(freshness PriorityFreshness) Option {
+	return func(config *Config) {
+		config.PriorityFreshness = freshness
+	}
+}
+
+// WithQueueEmpty sets callbacks for the queue becoming empty and non empty. onEmpty is called
+// once the last pending job is dequeued or removed and onNonEmpty once a job is queued while
+// none are pending. A state must hold for debounce before its callback is called, so a queue
+// that keeps flapping between empty and one job doesn't report every transition.
+func WithQueueEmpty(onEmpty func(), onNonEmpty func(), debounce time.Duration) Option {
+	return func(config *Config) {
+		config.OnQueueEmpty = onEmpty
+		config.OnQueueNonEmpty = onNonEmpty
+		config.QueueEmptyDebounce = debounce
+	}
+}
+
+// WithoutManager keeps the pool from registering with the default manager.
+func WithoutManager() Option {
+	return func(config *Config) {
+		config.Manager = nil
+		config.Unmanaged = true
+	}
+}
+
+// WithStrictFIFO declares that the pool must run its normal jobs in the order they were
+// admitted, as a pool with a single job routine does. Validate and NewChecked reject it together
+// with settings that reorder jobs: more than one routine, fair queuing, retries and requeues.
+func WithStrictFIFO() Option {
+	return func(config *Config) {
+		config.StrictFIFO = true
+	}
+}
+
+// WithTenantCapacity sets the maximum number of pending jobs a single tenant can hold. Jobs
+// over the quota are rejected with ErrTenantQuotaExceeded without using any of the queue's capacity.
+func WithTenantCapacity(tenantCapacity int32) Option {
+	return func(config *Config) {
+		config.TenantCapacity = tenantCapacity
+	}
+}
+
+// WithValidator sets a check every job is put through when it is queued. A job the validator
+// returns an error for is rejected at the call site with ErrInvalidJob and that error.
+func WithValidator(validator func(jober Jobber) error) Option {
+	return func(config *Config) {
+		config.Validator = validator
+	}
+}
+
+// WithGroup adds the job to the named group.
+func WithGroup(group string) JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.group = group
+	}
+}
+
+// WithMetadata attaches the key/value pairs to the job. The pairs are copied and show up in
+// the queue dump, the worker stats, the history, the error history, panic reports and the job's
+// log messages, and a ContextJobber reads them from its JobMeta. Calling it more than once merges
+// the pairs.
+func WithMetadata(metadata map[string]string) JobOption {
+	return func(queueJob *queueJob) {
+		if queueJob.metadata == nil {
+			queueJob.metadata = make(map[string]string, len(metadata))
+		}
+
+		for key, value := range metadata {
+			queueJob.metadata[key] = value
+		}
+	}
+}
+
+// WithOverflowPolicy sets what QueueJob does when the queue is at capacity. With DropOldest the
+// normal job that has waited the longest is evicted to make room, its handle reports ErrEvicted
+// and onEvicted, when not nil, is called with the job and how long it waited.
+func WithOverflowPolicy(policy OverflowPolicy, onEvicted func(jober Jobber, waited time.Duration)) Option {
+	return func(config *Config) {
+		config.OverflowPolicy = policy
+		config.OnEvicted = onEvicted
+	}
+}
+
+// WithPanicHandler sets the handler that receives a report for every recovered panic.
+func WithPanicHandler(panicHandler func(PanicInfo)) Option {
+	return func(config *Config) {
+		config.PanicHandler = panicHandler
+	}
+}
+
+// WithPanicPolicy sets what happens after a panic has been written and reported. With Abort
+// the abort function is called, or the process exits when abort is nil.
+func WithPanicPolicy(panicPolicy PanicPolicy, abort func()) Option {
+	return func(config *Config) {
+		config.PanicPolicy = panicPolicy
+		config.AbortFunc = abort
+	}
+}
+
+// WithQueueLatencyAlert calls onQueueLatency for every job that waited in queue longer than
+// maxAcceptableQueueLatency before a job routine started it. The callback is run on its own
+// routine. Jobs that waited too long are also counted in Stats.
+func WithQueueLatencyAlert(maxAcceptableQueueLatency time.Duration, onQueueLatency func(jobType string, priority bool, waited time.Duration)) Option {
+	return func(config *Config) {
+		config.MaxAcceptableQueueLatency = maxAcceptableQueueLatency
+		config.OnQueueLatency = onQueueLatency
+	}
+}
+
+// WithRequeueOnPanic places a job that panicked back in its original queue after delay, up to
+// maxRequeues times. The job keeps its slot so the capacity check is skipped. With front the job
+// is placed at the front of its queue instead of the back. A job that panics again after the
+// last requeue goes to the dead letter handler.
+func WithRequeueOnPanic(maxRequeues int, delay time.Duration, front bool) Option {
+	return func(config *Config) {
+		config.MaxRequeues = maxRequeues
+		config.RequeueDelay = delay
+		config.RequeueFront = front
+	}
+}
+
+// WithRetry places a job that returned an error back in its original queue after delay, up to
+// maxRetries times, the same way WithRequeueOnPanic handles a panic. Only jobs that implement
+// ErrorJobber or ContextJobber can return an error. A job that fails again after the last retry
+// goes to the dead letter handler.
+func WithRetry(maxRetries int, delay time.Duration) Option {
+	return func(config *Config) {
+		config.MaxRetries = maxRetries
+		config.RetryDelay = delay
+	}
+}
+
+// WithRetryPriorityBoost places retried and requeued jobs in the priority queue whatever their
+// original priority. At most maxShare of the dequeues, between 0 and 1, go to boosted retries
+// while other jobs are waiting so a storm of retries can't starve fresh work.
+func WithRetryPriorityBoost(maxShare float64) Option {
+	return func(config *Config) {
+		config.RetryPriorityBoost = maxShare
+	}
+}
+
+// WithRetryBudget limits retries and requeues across the whole pool to retries per interval.
+// When the budget is used up a job that would be retried goes to the dead letter handler with
+// ErrRetryBudgetExhausted. The budget refills steadily over the interval.
+func WithRetryBudget(retries int, interval time.Duration) Option {
+	return func(config *Config) {
+		config.RetryBudget = retries
+		config.RetryBudgetEvery = interval
+	}
+}
+
+// WithRuntimeTrace wraps each job in a runtime/trace task named by the job, logs its wait in
+// queue and marks its execution as a region, so go tool trace shows where the time went. It
+// costs nothing while no trace is being collected.
+func WithRuntimeTrace() Option {
+	return func(config *Config) {
+		config.RuntimeTrace = true
+	}
+}
+
+// WithSharedBackend has the pool share the backend with pools in other processes. QueueJob puts
+// jobs without options in the backend instead of the pool's queue, so the backend owns the
+// capacity, and the pool takes a job from the backend whenever it has a job routine to spare.
+func WithSharedBackend(backend Backend) Option {
+	return func(config *Config) {
+		config.SharedBackend = backend
+	}
+}
+
+// WithStackCapture sets the largest stack trace captured for a panic and whether the stacks of
+// every goroutine are captured, which helps when debugging deadlocks.
+func WithStackCapture(maxStackSize int, allRoutines bool) Option {
+	return func(config *Config) {
+		config.MaxStackSize = maxStackSize
+		config.StackAllRoutines = allRoutines
+	}
+}
+
+// WithStatsInterval starts a routine that emits a Stats snapshot every interval until the pool
+// is shut down. Each snapshot is passed to reporter, or written to stdout when reporter is nil.
+// With skipIdle no snapshot is emitted while the pool has no queued or running jobs and
+// nothing has completed since the last snapshot.
+func WithStatsInterval(interval time.Duration, reporter func(Stats), skipIdle bool) Option {
+	return func(config *Config) {
+		config.StatsInterval = interval
+		config.StatsReporter = reporter
+		config.StatsSkipIdle = skipIdle
+	}
+}
+
+// WithTraceID sets the trace ID the job's logger tags its messages with.
+func WithTraceID(traceID string) JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.traceID = traceID
+	}
+}
+
+// WithTenant sets the tenant the job is queued for.
+func WithTenant(tenant string) JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.tenant = tenant
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Error implements the error interface.
+func (configError *ConfigError) Error() string {
+	return fmt.Sprintf("Invalid Config : Field[%s] : %s", configError.Field, configError.Message)
+}
+
+// Validate checks the settings for values that are out of range or contradict each other. Every
+// problem found is returned as a ConfigError, joined into a single error.
+func (config Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, a ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if config.Routines <= 0 {
+		invalid("Routines", "Must Be Positive : Routines[%d]", config.Routines)
+	}
+
+	if config.QueueCapacity <= 0 {
+		invalid("QueueCapacity", "Must Be Positive : QueueCapacity[%d]", config.QueueCapacity)
+	}
+
+	if config.AsyncIntake < 0 || config.QueueBuffer < 0 || config.DequeueBuffer < 0 {
+		invalid("AsyncIntake", "Buffers Can't Be Negative : AsyncIntake[%d] QueueBuffer[%d] DequeueBuffer[%d]", config.AsyncIntake, config.QueueBuffer, config.DequeueBuffer)
+	}
+
+	if config.HighWatermark < 0 || config.LowWatermark < 0 {
+		invalid("HighWatermark", "Watermarks Can't Be Negative : HighWatermark[%d] LowWatermark[%d]", config.HighWatermark, config.LowWatermark)
+	}
+
+	if config.HighWatermark > 0 && config.LowWatermark >= config.HighWatermark {
+		invalid("LowWatermark", "Must Be Below HighWatermark : LowWatermark[%d] HighWatermark[%d]", config.LowWatermark, config.HighWatermark)
+	}
+
+	if config.QueueCapacity > 0 && config.HighWatermark > config.QueueCapacity {
+		invalid("HighWatermark", "Can't Be Reached Above QueueCapacity : HighWatermark[%d] QueueCapacity[%d]", config.HighWatermark, config.QueueCapacity)
+	}
+
+	if config.HighWatermark == 0 && (config.OnHighWatermark != nil || config.OnLowWatermark != nil) {
+		invalid("HighWatermark", "Must Be Set For The Watermark Callbacks")
+	}
+
+	if config.QueueCapacity > 0 && config.TenantCapacity > config.QueueCapacity {
+		invalid("TenantCapacity", "Can't Exceed QueueCapacity : TenantCapacity[%d] QueueCapacity[%d]", config.TenantCapacity, config.QueueCapacity)
+	}
+
+	if len(config.TenantWeights) > 0 && config.FairQueuing == false {
+		invalid("TenantWeights", "Requires FairQueuing")
+	}
+
+	for tenant, weight := range config.TenantWeights {
+		if weight < 0 {
+			invalid("TenantWeights", "Can't Be Negative : Tenant[%s] Weight[%d]", tenant, weight)
+		}
+	}
+
+	var reserved int
+	for tag, routines := range config.TagReservations {
+		if routines < 0 {
+			invalid("TagReservations", "Can't Be Negative : Tag[%s] Routines[%d]", tag, routines)
+		}
+		reserved += routines
+	}
+
+	if config.Routines > 0 && reserved > config.Routines {
+		invalid("TagReservations", "Can't Exceed Routines : Reserved[%d] Routines[%d]", reserved, config.Routines)
+	}
+
+	if config.MaxRetries < 0 || config.MaxRequeues < 0 {
+		invalid("MaxRetries", "Can't Be Negative : MaxRetries[%d] MaxRequeues[%d]", config.MaxRetries, config.MaxRequeues)
+	}
+
+	if config.RetryDelay < 0 || config.RequeueDelay < 0 {
+		invalid("RetryDelay", "Can't Be Negative : RetryDelay[%v] RequeueDelay[%v]", config.RetryDelay, config.RequeueDelay)
+	}
+
+	if config.QuarantinePanics < 0 {
+		invalid("QuarantinePanics", "Can't Be Negative : QuarantinePanics[%d]", config.QuarantinePanics)
+	}
+
+	if config.QuarantinePanics > 0 && config.QuarantineWindow <= 0 {
+		invalid("QuarantineWindow", "Must Be Positive With QuarantinePanics : QuarantineWindow[%v]", config.QuarantineWindow)
+	}
+
+	if config.RetryBudget > 0 && config.RetryBudgetEvery <= 0 {
+		invalid("RetryBudgetEvery", "Must Be Positive With A RetryBudget : RetryBudgetEvery[%v]", config.RetryBudgetEvery)
+	}
+
+	if config.RetryPriorityBoost < 0 || config.RetryPriorityBoost > 1 {
+		invalid("RetryPriorityBoost", "Must Be Between 0 And 1 : RetryPriorityBoost[%v]", config.RetryPriorityBoost)
+	}
+
+	if config.PinWorkers == true && config.LockOSThread == false {
+		invalid("PinWorkers", "Requires LockOSThread")
+	}
+
+	if config.ContinuationBudget < 0 {
+		invalid("ContinuationBudget", "Can't Be Negative : ContinuationBudget[%d]", config.ContinuationBudget)
+	}
+
+	if config.MaxQueueBytes < 0 {
+		invalid("MaxQueueBytes", "Can't Be Negative : MaxQueueBytes[%d]", config.MaxQueueBytes)
+	}
+
+	if config.MemorySoftLimit < 0 || config.MemoryLowLimit < 0 {
+		invalid("MemorySoftLimit", "Can't Be Negative : MemorySoftLimit[%d] MemoryLowLimit[%d]", config.MemorySoftLimit, config.MemoryLowLimit)
+	}
+
+	if config.MemoryLowLimit > 0 && config.MemoryLowLimit >= config.MemorySoftLimit {
+		invalid("MemoryLowLimit", "Must Be Below MemorySoftLimit : MemoryLowLimit[%d] MemorySoftLimit[%d]", config.MemoryLowLimit, config.MemorySoftLimit)
+	}
+
+	if config.MemorySoftLimit == 0 && config.OnMemoryGuard != nil {
+		invalid("MemorySoftLimit", "Must Be Set For OnMemoryGuard")
+	}
+
+	if config.HistorySize < 0 || config.ErrorHistorySize < 0 {
+		invalid("HistorySize", "Can't Be Negative : HistorySize[%d] ErrorHistorySize[%d]", config.HistorySize, config.ErrorHistorySize)
+	}
+
+	if config.StatsReporter != nil && config.StatsInterval <= 0 {
+		invalid("StatsInterval", "Must Be Positive With A StatsReporter : StatsInterval[%v]", config.StatsInterval)
+	}
+
+	if config.DepthInterval < 0 || config.DepthRetention < 0 {
+		invalid("DepthInterval", "Can't Be Negative : DepthInterval[%v] DepthRetention[%v]", config.DepthInterval, config.DepthRetention)
+	}
+
+	if config.SlowMessageThreshold < 0 {
+		invalid("SlowMessageThreshold", "Can't Be Negative : SlowMessageThreshold[%v]", config.SlowMessageThreshold)
+	}
+
+	if config.OnQueueLatency != nil && config.MaxAcceptableQueueLatency <= 0 {
+		invalid("MaxAcceptableQueueLatency", "Must Be Positive With OnQueueLatency : MaxAcceptableQueueLatency[%v]", config.MaxAcceptableQueueLatency)
+	}
+
+	if config.DrainJobEstimate < 0 {
+		invalid("DrainJobEstimate", "Can't Be Negative : DrainJobEstimate[%v]", config.DrainJobEstimate)
+	}
+
+	if config.OnIntegrityViolation != nil && config.IntegrityChecks == false {
+		invalid("IntegrityChecks", "Must Be Set With OnIntegrityViolation")
+	}
+
+	if config.StuckWorkerGrace < 0 {
+		invalid("StuckWorkerGrace", "Can't Be Negative : StuckWorkerGrace[%v]", config.StuckWorkerGrace)
+	}
+
+	if config.RampStep < 0 {
+		invalid("RampStep", "Can't Be Negative : RampStep[%d]", config.RampStep)
+	}
+
+	if config.RampStep > 0 && config.RampInterval <= 0 {
+		invalid("RampInterval", "Must Be Positive With RampStep : RampInterval[%v]", config.RampInterval)
+	}
+
+	if config.DedupCooldown < 0 {
+		invalid("DedupCooldown", "Can't Be Negative : DedupCooldown[%v]", config.DedupCooldown)
+	}
+
+	if config.DedupCooldownKeys < 0 {
+		invalid("DedupCooldownKeys", "Can't Be Negative : DedupCooldownKeys[%d]", config.DedupCooldownKeys)
+	}
+
+	if config.QueueEmptyDebounce < 0 {
+		invalid("QueueEmptyDebounce", "Can't Be Negative : QueueEmptyDebounce[%v]", config.QueueEmptyDebounce)
+	}
+
+	if config.PriorityFreshness != RelaxedPriority && config.PriorityFreshness != StrictPriority {
+		invalid("PriorityFreshness", "Unknown Value : PriorityFreshness[%d]", config.PriorityFreshness)
+	}
+
+	if config.OverflowPolicy < RejectNew || config.OverflowPolicy > CallerRuns {
+		invalid("OverflowPolicy", "Unknown Value : OverflowPolicy[%d]", config.OverflowPolicy)
+	}
+
+	if config.OnEvicted != nil && config.OverflowPolicy != DropOldest {
+		invalid("OnEvicted", "Requires The DropOldest OverflowPolicy")
+	}
+
+	if config.StrictFIFO == true {
+		if config.Routines > 1 {
+			invalid("StrictFIFO", "Requires A Single Routine : Routines[%d]", config.Routines)
+		}
+
+		if config.FairQueuing == true {
+			invalid("StrictFIFO", "Can't Be Used With FairQueuing")
+		}
+
+		if config.MaxRetries > 0 || config.MaxRequeues > 0 || config.RetryPriorityBoost > 0 {
+			invalid("StrictFIFO", "Can't Be Used With Retries Or Requeues : MaxRetries[%d] MaxRequeues[%d]", config.MaxRetries, config.MaxRequeues)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CloneConfig returns the configuration of the pool so an identical pool can be created with
+// NewFromConfig. The maps are copied, so the clone can be changed without touching the pool. The
+// name is given a numeric suffix no other clone in the process has been given, so the pools can
+// be told apart: cloning "orders" twice gives "orders-1" and "orders-2", and cloning "orders-1"
+// then gives "orders-3".
+func (jobPool *JobPool) CloneConfig() Config {
+	config := jobPool.config
+	config.Name = clonedNames.next(config.Name)
+	config.TenantWeights = copyCounts(config.TenantWeights)
+	config.TagReservations = copyCounts(config.TagReservations)
+
+	return config
+}
+
+//** PRIVATE FUNCTIONS
+
+// intakeBuffer returns the size of the intake buffer for the configuration.
+func intakeBuffer(config Config) int {
+	if config.AsyncIntake > 0 {
+		return config.AsyncIntake
+	}
+
+	if config.QueueCapacity < defaultAsyncIntake {
+		return int(config.QueueCapacity)
+	}
+
+	return defaultAsyncIntake
+}
+
+// cloneName returns the name with its numeric suffix incremented.
+func cloneName(name string) string {
+	if name == "" {
+		return name
+	}
+
+	base, suffix := splitCloneName(name)
+	return base + "-" + strconv.Itoa(suffix+1)
+}
+
+// splitCloneName splits the numeric suffix from the name. A name without one has suffix zero.
+func splitCloneName(name string) (string, int) {
+	if index := strings.LastIndex(name, "-"); index != -1 {
+		if suffix, err := strconv.Atoi(name[index+1:]); err == nil && suffix >= 0 {
+			return name[:index], suffix
+		}
+	}
+
+	return name, 0
+}
+
+// copyCounts returns a copy of the map, or nil for an empty one.
+func copyCounts(counts map[string]int) map[string]int {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	copied := make(map[string]int, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+
+	return copied
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// next returns the name with a numeric suffix above the name's own and above every suffix
+// handed out before for the same name.
+func (cloneSuffixes *cloneSuffixes) next(name string) string {
+	if name == "" {
+		return name
+	}
+
+	base, suffix := splitCloneName(name)
+
+	cloneSuffixes.mutex.Lock()
+	defer cloneSuffixes.mutex.Unlock()
+
+	if last := cloneSuffixes.last[base]; last > suffix {
+		suffix = last
+	}
+
+	suffix++
+	cloneSuffixes.last[base] = suffix
+
+	return base + "-" + strconv.Itoa(suffix)
+}
@@ -0,0 +1,289 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestCloneConfig proves a clone can be changed without touching the pool it was cloned from,
+// creates a pool with the same settings and is named apart from every other clone.
+func TestCloneConfig(t *testing.T) {
+	weights := map[string]int{"gold": 3, "silver": 2}
+	reservations := map[string]int{"emails": 1}
+
+	jobPool := newTestPool(t, 4, 10, WithName("clone-test"), WithFairQueuing(weights), WithTagReservations(reservations))
+
+	// The options copied the caller's maps.
+	weights["gold"] = 100
+	reservations["emails"] = 100
+
+	want := map[string]int{"gold": 3, "silver": 2}
+	if reflect.DeepEqual(jobPool.config.TenantWeights, want) == false {
+		t.Fatalf("TenantWeights after changing the caller's map are %v, want %v", jobPool.config.TenantWeights, want)
+	}
+
+	if jobPool.config.TagReservations["emails"] != 1 {
+		t.Fatalf("TagReservations after changing the caller's map are %v, want emails 1", jobPool.config.TagReservations)
+	}
+
+	config := jobPool.CloneConfig()
+	if reflect.DeepEqual(config.TenantWeights, want) == false {
+		t.Fatalf("Cloned TenantWeights are %v, want %v", config.TenantWeights, want)
+	}
+
+	// The clone's maps are not the pool's.
+	config.TenantWeights["gold"] = 7
+	config.TagReservations["emails"] = 2
+
+	if jobPool.config.TenantWeights["gold"] != 3 || jobPool.config.TagReservations["emails"] != 1 {
+		t.Fatalf("Changing the clone changed the pool : TenantWeights %v TagReservations %v", jobPool.config.TenantWeights, jobPool.config.TagReservations)
+	}
+
+	// The clone round trips through NewFromConfig.
+	cloned := NewFromConfig(config)
+	t.Cleanup(func() {
+		cloned.Shutdown("test")
+	})
+
+	roundTrip := cloned.CloneConfig()
+	if roundTrip.Routines != 4 || roundTrip.QueueCapacity != 10 || roundTrip.FairQueuing == false {
+		t.Fatalf("Round trip lost settings : Routines[%d] QueueCapacity[%d] FairQueuing[%v]", roundTrip.Routines, roundTrip.QueueCapacity, roundTrip.FairQueuing)
+	}
+
+	if reflect.DeepEqual(roundTrip.TenantWeights, config.TenantWeights) == false || reflect.DeepEqual(roundTrip.TagReservations, config.TagReservations) == false {
+		t.Fatalf("Round trip maps are %v %v, want %v %v", roundTrip.TenantWeights, roundTrip.TagReservations, config.TenantWeights, config.TagReservations)
+	}
+}
+
+// TestCloneConfigNames proves clones of the same pool, and clones of a clone, never share a
+// name.
+func TestCloneConfigNames(t *testing.T) {
+	jobPool := newTestPool(t, 1, 10, WithName("clone-names"))
+
+	names := map[string]bool{jobPool.config.Name: true}
+	add := func(name string) {
+		t.Helper()
+
+		if names[name] == true {
+			t.Fatalf("Clone name %q was handed out twice", name)
+		}
+		names[name] = true
+	}
+
+	first := jobPool.CloneConfig()
+	add(first.Name)
+	add(jobPool.CloneConfig().Name)
+
+	if base, suffix := splitCloneName(first.Name); base != "clone-names" || suffix < 1 {
+		t.Fatalf("Clone is named %q, want a numbered %q", first.Name, "clone-names")
+	}
+
+	cloned := newTestPool(t, 1, 10, WithName(first.Name))
+	add(cloned.CloneConfig().Name)
+	add(jobPool.CloneConfig().Name)
+
+	unnamed := newTestPool(t, 1, 10)
+	if name := unnamed.CloneConfig().Name; name != "" {
+		t.Fatalf("Clone of an unnamed pool is named %q, want no name", name)
+	}
+}
+
+// TestCloneConfigBehavior creates a pool from a clone whose maps were changed and proves each
+// pool serves its tenants and reserves routines for its tags by its own settings, so changing
+// the clone left the original pool untouched.
+func TestCloneConfigBehavior(t *testing.T) {
+	jobPool := newTestPool(t, 1, 20,
+		WithFairQueuing(map[string]int{"gold": 3, "silver": 1}),
+		WithTagReservations(map[string]int{"emails": 1}),
+	)
+
+	config := jobPool.CloneConfig()
+	config.TenantWeights["gold"] = 1
+	config.TenantWeights["silver"] = 3
+	delete(config.TagReservations, "emails")
+	config.TagReservations["reports"] = 1
+
+	cloned := NewFromConfig(config)
+	t.Cleanup(func() {
+		cloned.Shutdown("test")
+	})
+
+	tests := []struct {
+		name    string
+		jobPool *JobPool
+		order   []string
+		tags    map[string]TagStats
+	}{
+		{"Original", jobPool, []string{"gold", "gold", "gold", "silver", "gold", "silver", "silver", "silver"}, map[string]TagStats{"emails": {Reserved: 1}}},
+		{"Clone", cloned, []string{"gold", "silver", "silver", "silver", "gold", "silver", "gold", "gold"}, map[string]TagStats{"reports": {Reserved: 1}}},
+	}
+
+	for _, test := range tests {
+		if order := tenantOrder(t, test.jobPool); reflect.DeepEqual(order, test.order) == false {
+			t.Fatalf("%s : Served %v, want %v", test.name, order, test.order)
+		}
+
+		if tags := test.jobPool.Stats().Tags; reflect.DeepEqual(tags, test.tags) == false {
+			t.Fatalf("%s : Tags[%v], want %v", test.name, tags, test.tags)
+		}
+	}
+}
+
+// TestValidate proves each rule of Validate rejects its setting, and only its setting, and a
+// valid Config passes.
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		field  string
+	}{
+		{"Valid", func(config *Config) {}, ""},
+		{"Routines", func(config *Config) { config.Routines = 0 }, "Routines"},
+		{"QueueCapacity", func(config *Config) { config.QueueCapacity = 0 }, "QueueCapacity"},
+		{"Buffers", func(config *Config) { config.QueueBuffer = -1 }, "AsyncIntake"},
+		{"NegativeWatermark", func(config *Config) { config.LowWatermark = -1 }, "HighWatermark"},
+		{"LowWatermark", func(config *Config) { config.HighWatermark, config.LowWatermark = 10, 10 }, "LowWatermark"},
+		{"HighWatermarkAboveCapacity", func(config *Config) { config.HighWatermark = config.QueueCapacity + 1 }, "HighWatermark"},
+		{"WatermarkCallbacks", func(config *Config) { config.OnHighWatermark = func() {} }, "HighWatermark"},
+		{"TenantCapacity", func(config *Config) { config.TenantCapacity = config.QueueCapacity + 1 }, "TenantCapacity"},
+		{"TenantWeightsWithoutFairQueuing", func(config *Config) { config.TenantWeights = map[string]int{"a": 1} }, "TenantWeights"},
+		{"NegativeTenantWeight", func(config *Config) {
+			config.FairQueuing = true
+			config.TenantWeights = map[string]int{"a": -1}
+		}, "TenantWeights"},
+		{"NegativeTagReservation", func(config *Config) { config.TagReservations = map[string]int{"a": -1} }, "TagReservations"},
+		{"TagReservationsOverRoutines", func(config *Config) { config.TagReservations = map[string]int{"a": config.Routines + 1} }, "TagReservations"},
+		{"MaxRetries", func(config *Config) { config.MaxRequeues = -1 }, "MaxRetries"},
+		{"RetryDelay", func(config *Config) { config.RetryDelay = -time.Second }, "RetryDelay"},
+		{"QuarantinePanics", func(config *Config) { config.QuarantinePanics = -1 }, "QuarantinePanics"},
+		{"QuarantineWindow", func(config *Config) { config.QuarantinePanics = 3 }, "QuarantineWindow"},
+		{"RetryBudgetEvery", func(config *Config) { config.RetryBudget = 10 }, "RetryBudgetEvery"},
+		{"RetryPriorityBoost", func(config *Config) { config.RetryPriorityBoost = 1.5 }, "RetryPriorityBoost"},
+		{"PinWorkers", func(config *Config) { config.PinWorkers = true }, "PinWorkers"},
+		{"ContinuationBudget", func(config *Config) { config.ContinuationBudget = -1 }, "ContinuationBudget"},
+		{"MaxQueueBytes", func(config *Config) { config.MaxQueueBytes = -1 }, "MaxQueueBytes"},
+		{"NegativeMemoryLimit", func(config *Config) { config.MemorySoftLimit = -1 }, "MemorySoftLimit"},
+		{"MemoryLowLimit", func(config *Config) { config.MemorySoftLimit, config.MemoryLowLimit = 100, 100 }, "MemoryLowLimit"},
+		{"OnMemoryGuard", func(config *Config) { config.OnMemoryGuard = func(bool, int64) {} }, "MemorySoftLimit"},
+		{"HistorySize", func(config *Config) { config.ErrorHistorySize = -1 }, "HistorySize"},
+		{"StatsInterval", func(config *Config) { config.StatsReporter = func(Stats) {} }, "StatsInterval"},
+		{"DepthInterval", func(config *Config) { config.DepthRetention = -time.Second }, "DepthInterval"},
+		{"SlowMessageThreshold", func(config *Config) { config.SlowMessageThreshold = -time.Second }, "SlowMessageThreshold"},
+		{"MaxAcceptableQueueLatency", func(config *Config) { config.OnQueueLatency = func(string, bool, time.Duration) {} }, "MaxAcceptableQueueLatency"},
+		{"DrainJobEstimate", func(config *Config) { config.DrainJobEstimate = -time.Second }, "DrainJobEstimate"},
+		{"IntegrityChecks", func(config *Config) { config.OnIntegrityViolation = func(IntegrityViolation) {} }, "IntegrityChecks"},
+		{"StuckWorkerGrace", func(config *Config) { config.StuckWorkerGrace = -time.Second }, "StuckWorkerGrace"},
+		{"RampStep", func(config *Config) { config.RampStep = -1 }, "RampStep"},
+		{"RampInterval", func(config *Config) { config.RampStep = 1 }, "RampInterval"},
+		{"DedupCooldown", func(config *Config) { config.DedupCooldown = -time.Second }, "DedupCooldown"},
+		{"DedupCooldownKeys", func(config *Config) { config.DedupCooldownKeys = -1 }, "DedupCooldownKeys"},
+		{"QueueEmptyDebounce", func(config *Config) { config.QueueEmptyDebounce = -time.Second }, "QueueEmptyDebounce"},
+		{"PriorityFreshness", func(config *Config) { config.PriorityFreshness = PriorityFreshness(99) }, "PriorityFreshness"},
+		{"OverflowPolicy", func(config *Config) { config.OverflowPolicy = OverflowPolicy(99) }, "OverflowPolicy"},
+		{"OnEvicted", func(config *Config) { config.OnEvicted = func(Jobber, time.Duration) {} }, "OnEvicted"},
+		{"StrictFIFORoutines", func(config *Config) {
+			config.StrictFIFO = true
+			config.Routines = 2
+		}, "StrictFIFO"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Defaults()
+			config.Routines = 4
+			test.change(&config)
+
+			err := config.Validate()
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("Validate : %s", err)
+				}
+				return
+			}
+
+			var fields []string
+			for _, err := range configErrors(err) {
+				var configError *ConfigError
+				if errors.As(err, &configError) == false {
+					t.Fatalf("Validate returned %T, want a *ConfigError", err)
+				}
+				fields = append(fields, configError.Field)
+			}
+
+			if reflect.DeepEqual(fields, []string{test.field}) == false {
+				t.Fatalf("Validate rejected %v, want [%s] : %v", fields, test.field, err)
+			}
+		})
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// configErrors splits the errors joined by Validate.
+func configErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok == true {
+		return joined.Unwrap()
+	}
+
+	return []error{err}
+}
+
+// tenantOrder queues four jobs for gold and then four for silver while the pool's only routine
+// is held, and returns the tenants in the order their jobs ran.
+func tenantOrder(t *testing.T, jobPool *JobPool) []string {
+	t.Helper()
+
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	var mutex sync.Mutex
+	var order []string
+	for _, tenant := range []string{"gold", "silver"} {
+		tenant := tenant
+		for i := 0; i < 4; i++ {
+			job := funcJob(func(jobRoutine int) {
+				mutex.Lock()
+				order = append(order, tenant)
+				mutex.Unlock()
+			})
+
+			if err := jobPool.QueueJob("test", job, false, WithTenant(tenant)); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+		}
+	}
+
+	releaseOnce()
+
+	waitFor(t, 5*time.Second, "the tenants' jobs to run", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return len(order) == 8
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return order
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//** CONSTANTS
+
+const (
+	// consumeMinWait is the first wait of a feeder for room in the queue.
+	consumeMinWait = time.Millisecond
+
+	// consumeMaxWait is the longest a feeder waits before checking for room in the queue again.
+	consumeMaxWait = 50 * time.Millisecond
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Consume queues every job received from the channel until the channel is closed, the context
+// is done or the pool is shut down. When the queue is full Consume stops reading from the
+// channel until there is room, so the sender is held back instead of jobs being dropped. Any
+// number of Consume calls can feed the pool at once. A shared backend that is failing holds the
+// sender back the same way. It returns nil once the channel is closed.
+func (jobPool *JobPool) Consume(ctx context.Context, src <-chan Jobber, priority bool, options ...JobOption) error {
+	for {
+		var jober Jobber
+		var open bool
+
+		select {
+		case jober, open = <-src:
+			if open == false {
+				return nil
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if err := jobPool.feed(ctx, jober, priority, options); err != nil {
+			return err
+		}
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// offerJob queues a job that feed offers again while the queue is full.
+func (jobPool *JobPool) offerJob(jober Jobber, priority bool, options []JobOption) (err error) {
+	defer jobPool.catchPanic(&err, "Consume", "QueueJob")
+
+	return jobPool.queueJober("Consume", jober, priority, true, options)
+}
+
+// feed queues one job for Consume, waiting while the queue or the job's tenant is full.
+func (jobPool *JobPool) feed(ctx context.Context, jober Jobber, priority bool, options []JobOption) error {
+	wait := consumeMinWait
+
+	for {
+		// Only offer the job when there is room so a full queue is rarely reported as a rejection.
+		// A shared backend owns the capacity so the job is always offered.
+		if jobPool.config.SharedBackend != nil || atomic.LoadInt32(&jobPool.gauges.reservedSlots) < jobPool.config.QueueCapacity {
+			err := jobPool.offerJob(jober, priority, options)
+			switch {
+			case err == nil:
+				return nil
+
+			case errors.Is(err, ErrPoolClosed):
+				return err
+
+			case errors.Is(err, ErrPoolAtCapacity), errors.Is(err, ErrTenantQuotaExceeded), errors.Is(err, ErrBackendUnavailable):
+
+			default:
+				// The job was refused for good and the rejection handler has been told.
+				return nil
+			}
+		}
+
+		if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+			return ErrPoolClosed
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if wait *= 2; wait > consumeMaxWait {
+			wait = consumeMaxWait
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// continuer is stored in the context of a running ContextJobber so it can queue its follow up
+	// work with Continue.
+	continuer struct {
+		jobPool *JobPool  // The pool running the job.
+		parent  *queueJob // The job that is running.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrNoRunningJob is returned by Continue for a context that was not passed to a running job.
+	ErrNoRunningJob = errors.New("No Running Job In Context")
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithContinuationBudget lets jobs queued with Continue be admitted while the queue is at
+// capacity, taking the queue up to budget jobs over its capacity. Without a budget a
+// continuation only goes ahead of the submitters waiting in QueueJobWait.
+func WithContinuationBudget(budget int32) Option {
+	return func(config *Config) {
+		config.ContinuationBudget = budget
+	}
+}
+
+// Continue queues the next phase of the work a running ContextJobber is doing, using the context
+// the pool passed to RunJobContext. Where QueueJob would refuse the job because the queue is at
+// capacity, the continuation is admitted ahead of any waiting submitter and can take the queue
+// over its capacity by up to the ContinuationBudget, so a workflow isn't stalled half done by a
+// full queue. The other checks a job is admitted under still apply.
+//
+// The continuation inherits the tenant, tag, group, trace ID and metadata of the running job,
+// which the options can override. Joining the group before the running job completes keeps the
+// group from being done between the phases. A continuation is queued in the pool's own queues
+// even when the pool has a shared backend.
+func Continue(ctx context.Context, jober Jobber, priority bool, options ...JobOption) error {
+	continuer, ok := ctx.Value(continueKey).(*continuer)
+	if ok == false {
+		return ErrNoRunningJob
+	}
+
+	return continuer.jobPool.queueContinuation(continuer.parent, jober, priority, options)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// queueContinuation queues a job for Continue with the settings of the running job it follows.
+func (jobPool *JobPool) queueContinuation(parent *queueJob, jober Jobber, priority bool, options []JobOption) error {
+	inherited := []JobOption{
+		func(queueJob *queueJob) {
+			queueJob.tenant = parent.tenant
+			queueJob.tag = parent.tag
+			queueJob.group = parent.group
+			queueJob.traceID = parent.traceID
+			queueJob.continuation = true
+		},
+		WithMetadata(parent.metadata),
+	}
+
+	err := jobPool.queueJober("Continue", jober, priority, false, append(inherited, options...))
+	if err == nil {
+		atomic.AddInt64(&jobPool.continuations, 1)
+	}
+
+	return err
+}
+
+// claimContinuationSlot takes a slot for a continuation from the queue's capacity and the
+// continuation budget, ahead of any waiting submitter. It returns false if both are used up.
+func (jobPool *JobPool) claimContinuationSlot() bool {
+	return jobPool.claimSlotWithin(jobPool.config.QueueCapacity + jobPool.config.ContinuationBudget)
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// phaseJob is a phase of a workflow that fills the queue to capacity and then continues with
+// the next phase until the last one.
+type phaseJob struct {
+	phase   int          // The phase the job runs.
+	last    int          // The last phase of the workflow.
+	jobPool *JobPool     // The pool the queue is filled in.
+	results chan<- error // Receives the error of each Continue and nil once the last phase ran.
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestContinueAtCapacity runs a workflow of three continuations on a pool whose queue each phase
+// fills to capacity before continuing, and proves the whole chain completes with a continuation
+// budget and stalls at the first phase without one.
+func TestContinueAtCapacity(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget int32
+		want   []error
+	}{
+		{"Budget", 1, []error{nil, nil, nil, nil}},
+		{"NoBudget", 0, []error{ErrPoolAtCapacity}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 4, WithContinuationBudget(test.budget))
+
+			results := make(chan error, 4)
+			job := &phaseJob{last: 3, jobPool: jobPool, results: results}
+			if err := jobPool.QueueJob("test", job, false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			for i, want := range test.want {
+				select {
+				case err := <-results:
+					if errors.Is(err, want) == false {
+						t.Fatalf("Phase %d : Continue returned %v, want %v", i, err, want)
+					}
+
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Timed out waiting for phase %d", i)
+				}
+			}
+
+			continuations := int64(len(test.want) - 1)
+			if got := jobPool.Stats().Continuations; got != continuations {
+				t.Fatalf("Continuations[%d], want %d", got, continuations)
+			}
+		})
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob runs the phase without a context to continue from.
+func (phaseJob *phaseJob) RunJob(jobRoutine int) {
+	phaseJob.RunJobContext(context.Background(), jobRoutine)
+}
+
+// RunJobContext fills the queue to capacity and continues with the next phase, or reports the
+// workflow is done on the last phase.
+func (phaseJob *phaseJob) RunJobContext(ctx context.Context, jobRoutine int) error {
+	if phaseJob.phase == phaseJob.last {
+		phaseJob.results <- nil
+		return nil
+	}
+
+	for {
+		err := phaseJob.jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false)
+		if errors.Is(err, ErrPoolAtCapacity) == true {
+			break
+		}
+
+		if err != nil {
+			phaseJob.results <- err
+			return err
+		}
+	}
+
+	next := *phaseJob
+	next.phase++
+
+	err := Continue(ctx, &next, false)
+	phaseJob.results <- err
+
+	return err
+}
@@ -0,0 +1,549 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// MissedRunPolicy decides what a cron schedule does about firings it missed because the
+	// process was asleep or the queue was full.
+	MissedRunPolicy int
+
+	// CronSyntaxError describes a cron spec that could not be parsed.
+	CronSyntaxError struct {
+		Spec     string // The spec that was parsed.
+		Position int    // The offset in the spec where the problem was found.
+		Message  string // What is wrong with the spec.
+	}
+
+	// Schedule queues a job each time its cron spec fires.
+	Schedule struct {
+		jobPool    *JobPool    // The pool the job is queued in.
+		spec       string      // The cron spec the schedule was created from.
+		cronSpec   *cronSpec   // The parsed cron spec.
+		jober      Jobber      // The job queued on each firing.
+		priority   bool        // If the job is queued as a priority job.
+		next       time.Time   // When the schedule fires next.
+		timerEntry *timerEntry // The scheduler entry for the next firing.
+		missed     int         // The number of firings that did not queue the job.
+		catchUp    bool        // If a missed firing is run along with the next firing.
+		stopped    bool        // If the schedule has been stopped.
+		mutex      sync.Mutex  // Protects the schedule and serializes Stop with a firing.
+	}
+
+	// ScheduleState describes a cron schedule so it can be saved and restored after a restart.
+	ScheduleState struct {
+		Spec     string    `json:"spec"`     // The cron spec of the schedule.
+		JobType  string    `json:"job_type"` // The registered name of the job queued by the schedule.
+		Priority bool      `json:"priority"` // If the job is queued as a priority job.
+		Next     time.Time `json:"next"`     // When the schedule fires next.
+		Missed   int       `json:"missed"`   // The number of firings that did not queue the job.
+	}
+
+	// cronSpec holds the values each field of a cron spec matches as bit sets.
+	cronSpec struct {
+		minutes     uint64 // Bits 0 to 59.
+		hours       uint64 // Bits 0 to 23.
+		daysOfMonth uint64 // Bits 1 to 31.
+		months      uint64 // Bits 1 to 12.
+		daysOfWeek  uint64 // Bits 0 to 6 where 0 is Sunday.
+		anyDay      bool   // If either day field is a star, in which case both must match.
+	}
+
+	// cronField describes the allowed values of one field of a cron spec.
+	cronField struct {
+		name string // The name of the field used in errors.
+		min  int    // The smallest value allowed.
+		max  int    // The largest value allowed.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// SkipMissedRuns counts missed firings and waits for the next one.
+	SkipMissedRuns MissedRunPolicy = iota
+
+	// CatchUpOnce counts missed firings and queues the job once to make up for them.
+	CatchUpOnce
+)
+
+//** VARIABLES
+
+var (
+	// cronFields describes the five fields of a cron spec in order.
+	cronFields = []cronField{
+		{name: "Minute", min: 0, max: 59},
+		{name: "Hour", min: 0, max: 23},
+		{name: "Day Of Month", min: 1, max: 31},
+		{name: "Month", min: 1, max: 12},
+		{name: "Day Of Week", min: 0, max: 7},
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// ScheduleCron queues the job each time the five field cron spec fires. The fields are minute,
+// hour, day of month, month and day of week and accept stars, numbers, ranges, steps and lists.
+// The spec is evaluated against the pool's clock and the job is queued through QueueJob, so a
+// full queue or tenant quota causes a missed firing that is handled by the MissedRunPolicy. A
+// firing is never run by the scheduler under CallerRuns; the queue having no room is a miss.
+func (jobPool *JobPool) ScheduleCron(spec string, jober Jobber, priority bool) (*Schedule, error) {
+	cronSpec, err := parseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := Schedule{
+		jobPool:  jobPool,
+		spec:     spec,
+		cronSpec: cronSpec,
+		jober:    jober,
+		priority: priority,
+	}
+
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	if schedule.scheduleNext(jobPool.clock().Now()) == false {
+		return nil, ErrPoolClosed
+	}
+
+	jobPool.addSchedule(&schedule)
+
+	jobPool.writeLogf(LogDebug, "Cron", "ScheduleCron", "Scheduled : Spec[%s] Next[%v]", spec, schedule.next)
+	return &schedule, nil
+}
+
+// ScheduleStates returns the state of every running cron schedule so it can be saved and given
+// to RestoreSchedules after a restart.
+func (jobPool *JobPool) ScheduleStates() []ScheduleState {
+	jobPool.scheduleMutex.Lock()
+	schedules := make([]*Schedule, 0, len(jobPool.schedules))
+	for schedule := range jobPool.schedules {
+		schedules = append(schedules, schedule)
+	}
+	jobPool.scheduleMutex.Unlock()
+
+	scheduleStates := make([]ScheduleState, 0, len(schedules))
+	for _, schedule := range schedules {
+		if scheduleState, running := schedule.state(); running == true {
+			scheduleStates = append(scheduleStates, scheduleState)
+		}
+	}
+
+	return scheduleStates
+}
+
+// RestoreSchedules recreates the cron schedules saved by ScheduleStates. The factory returns a
+// new job for the saved job type. When the factory is nil the types registered with
+// RegisterJobType are used. A schedule whose next firing is past due fires immediately
+// and the firings it slept through are handled by the MissedRunPolicy. A state matching a
+// running schedule is skipped, so restoring the same states twice creates each schedule once.
+func (jobPool *JobPool) RestoreSchedules(scheduleStates []ScheduleState, factory func(jobType string) Jobber) (schedules []*Schedule, err error) {
+	defer jobPool.catchPanic(&err, "Cron", "RestoreSchedules")
+
+	for _, scheduleState := range scheduleStates {
+		cronSpec, err := parseCron(scheduleState.Spec)
+		if err != nil {
+			return schedules, err
+		}
+
+		var jober Jobber
+		if factory != nil {
+			jober = factory(scheduleState.JobType)
+		} else if jober, err = NewJob(scheduleState.JobType); err != nil {
+			return schedules, err
+		}
+
+		if jober == nil {
+			return schedules, &UnknownJobTypeError{Name: scheduleState.JobType}
+		}
+
+		schedule := Schedule{
+			jobPool:  jobPool,
+			spec:     scheduleState.Spec,
+			cronSpec: cronSpec,
+			jober:    jober,
+			priority: scheduleState.Priority,
+			missed:   scheduleState.Missed,
+		}
+
+		if jobPool.hasSchedule(schedule.key()) == true {
+			continue
+		}
+
+		schedule.mutex.Lock()
+		if scheduleState.Next.IsZero() == true {
+			schedule.scheduleNext(jobPool.clock().Now())
+		} else {
+			schedule.next = scheduleState.Next
+			schedule.timerEntry = jobPool.scheduler.scheduleAt(schedule.next, schedule.fire)
+		}
+		schedule.mutex.Unlock()
+
+		if schedule.timerEntry == nil {
+			return schedules, ErrPoolClosed
+		}
+
+		jobPool.addSchedule(&schedule)
+		schedules = append(schedules, &schedule)
+	}
+
+	return schedules, nil
+}
+
+// Spec returns the cron spec the schedule was created from.
+func (schedule *Schedule) Spec() string {
+	return schedule.spec
+}
+
+// Next returns when the schedule fires next. It returns the zero time once stopped.
+func (schedule *Schedule) Next() time.Time {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	if schedule.stopped == true {
+		return time.Time{}
+	}
+
+	return schedule.next
+}
+
+// Missed returns the number of firings that did not queue the job.
+func (schedule *Schedule) Missed() int {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	return schedule.missed
+}
+
+// Stop cancels the schedule. No firing starts once Stop returns, though a firing already
+// queueing its job may still queue it.
+func (schedule *Schedule) Stop() {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	schedule.stopped = true
+	schedule.jobPool.scheduler.cancel(schedule.timerEntry)
+	schedule.timerEntry = nil
+	schedule.jobPool.removeSchedule(schedule)
+}
+
+// Error implements the error interface.
+func (cronSyntaxError *CronSyntaxError) Error() string {
+	return fmt.Sprintf("Invalid Cron Spec : Spec[%s] Position[%d] : %s", cronSyntaxError.Spec, cronSyntaxError.Position, cronSyntaxError.Message)
+}
+
+//** PRIVATE FUNCTIONS
+
+// parseCron parses a five field cron spec.
+func parseCron(spec string) (*cronSpec, error) {
+	var values [5]uint64
+	var stars [5]bool
+
+	field := 0
+	for position := 0; position < len(spec); {
+		if spec[position] == ' ' || spec[position] == '\t' {
+			position++
+			continue
+		}
+
+		end := position
+		for end < len(spec) && spec[end] != ' ' && spec[end] != '\t' {
+			end++
+		}
+
+		if field == len(cronFields) {
+			return nil, &CronSyntaxError{Spec: spec, Position: position, Message: "Too Many Fields"}
+		}
+
+		bits, err := parseCronField(spec, position, spec[position:end], cronFields[field])
+		if err != nil {
+			return nil, err
+		}
+
+		values[field] = bits
+		stars[field] = spec[position:end] == "*"
+
+		field++
+		position = end
+	}
+
+	if field < len(cronFields) {
+		return nil, &CronSyntaxError{Spec: spec, Position: len(spec), Message: fmt.Sprintf("Missing %s Field", cronFields[field].name)}
+	}
+
+	// Seven is another name for Sunday.
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+
+	return &cronSpec{
+		minutes:     values[0],
+		hours:       values[1],
+		daysOfMonth: values[2],
+		months:      values[3],
+		daysOfWeek:  values[4] &^ (1 << 7),
+		anyDay:      stars[2] || stars[4],
+	}, nil
+}
+
+// parseCronField parses one field of a cron spec into the set of values it matches. The offset
+// is the field's position in the spec and is used for errors.
+func parseCronField(spec string, offset int, text string, cronField cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(text, ",") {
+		syntaxError := func(message string) error {
+			return &CronSyntaxError{Spec: spec, Position: offset, Message: fmt.Sprintf("%s : %s", cronField.name, message)}
+		}
+
+		if part == "" {
+			return 0, syntaxError("Empty Value")
+		}
+
+		rangeText, step := part, 1
+		if slash := strings.IndexByte(part, '/'); slash >= 0 {
+			rangeText = part[:slash]
+
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step <= 0 {
+				return 0, &CronSyntaxError{Spec: spec, Position: offset + slash + 1, Message: fmt.Sprintf("%s : Invalid Step %q", cronField.name, part[slash+1:])}
+			}
+		}
+
+		low, high := cronField.min, cronField.max
+		if rangeText != "*" {
+			var err error
+			lowText, highText := rangeText, ""
+			if dash := strings.IndexByte(rangeText, '-'); dash >= 0 {
+				lowText, highText = rangeText[:dash], rangeText[dash+1:]
+			}
+
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return 0, syntaxError(fmt.Sprintf("Invalid Value %q", lowText))
+			}
+
+			high = low
+			if highText != "" {
+				if high, err = strconv.Atoi(highText); err != nil {
+					return 0, &CronSyntaxError{Spec: spec, Position: offset + len(lowText) + 1, Message: fmt.Sprintf("%s : Invalid Value %q", cronField.name, highText)}
+				}
+			} else if step > 1 {
+				// A single value with a step runs from the value to the end of the field.
+				high = cronField.max
+			}
+
+			if low < cronField.min || high > cronField.max || low > high {
+				return 0, syntaxError(fmt.Sprintf("Range %q Outside %d-%d", rangeText, cronField.min, cronField.max))
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+
+		offset += len(part) + 1
+	}
+
+	return bits, nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// matchesDay returns true if the date matches the day fields. When both day fields are
+// restricted a date matching either is accepted, as cron does.
+func (cronSpec *cronSpec) matchesDay(t time.Time) bool {
+	dayOfMonth := cronSpec.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := cronSpec.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if cronSpec.anyDay == true {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first time after t the spec fires. It returns the zero time if the spec
+// does not fire in the next five years.
+func (cronSpec *cronSpec) next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case cronSpec.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+
+		case cronSpec.matchesDay(t) == false:
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+
+		case cronSpec.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+
+		case cronSpec.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// addSchedule records a running schedule.
+func (jobPool *JobPool) addSchedule(schedule *Schedule) {
+	jobPool.scheduleMutex.Lock()
+	defer jobPool.scheduleMutex.Unlock()
+
+	jobPool.schedules[schedule] = struct{}{}
+}
+
+// removeSchedule forgets a stopped schedule.
+func (jobPool *JobPool) removeSchedule(schedule *Schedule) {
+	jobPool.scheduleMutex.Lock()
+	defer jobPool.scheduleMutex.Unlock()
+
+	delete(jobPool.schedules, schedule)
+}
+
+// hasSchedule returns true if a running schedule has the key.
+func (jobPool *JobPool) hasSchedule(key string) bool {
+	jobPool.scheduleMutex.Lock()
+	defer jobPool.scheduleMutex.Unlock()
+
+	for schedule := range jobPool.schedules {
+		if schedule.key() == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// key identifies the schedule by its spec, job type and priority.
+func (schedule *Schedule) key() string {
+	return fmt.Sprintf("%s|%s|%t", schedule.spec, JobTypeName(schedule.jober), schedule.priority)
+}
+
+// state returns the schedule's state and false if it has stopped.
+func (schedule *Schedule) state() (ScheduleState, bool) {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	scheduleState := ScheduleState{
+		Spec:     schedule.spec,
+		JobType:  JobTypeName(schedule.jober),
+		Priority: schedule.priority,
+		Next:     schedule.next,
+		Missed:   schedule.missed,
+	}
+
+	return scheduleState, schedule.stopped == false
+}
+
+// scheduleNext registers the next firing after t with the scheduler. It returns false if there
+// is no next firing. The mutex must be held.
+func (schedule *Schedule) scheduleNext(t time.Time) bool {
+	schedule.next = schedule.cronSpec.next(t)
+	if schedule.next.IsZero() == true {
+		return false
+	}
+
+	schedule.timerEntry = schedule.jobPool.scheduler.scheduleAt(schedule.next, schedule.fire)
+	return schedule.timerEntry != nil
+}
+
+// fire registers the next firing and queues the job. The job is queued outside the mutex so a
+// slow admission can't hold up the schedule, and never runs on the scheduler under CallerRuns.
+func (schedule *Schedule) fire() {
+	runs := schedule.due()
+
+	for run := 0; run < runs; run++ {
+		err := schedule.jobPool.QueueJob("Cron", schedule.jober, schedule.priority, schedule.jobOptions()...)
+		if err != nil {
+			schedule.miss(err)
+			return
+		}
+	}
+}
+
+// due registers the next firing and returns the number of times the job is to be queued by
+// this one. Firings that were slept through are counted as missed.
+func (schedule *Schedule) due() int {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	if schedule.stopped == true {
+		return 0
+	}
+
+	jobPool := schedule.jobPool
+	now := jobPool.clock().Now()
+
+	// Count the firings that should have happened between this one and now.
+	slept := 0
+	for next := schedule.cronSpec.next(schedule.next); next.IsZero() == false && next.After(now) == false; next = schedule.cronSpec.next(next) {
+		slept++
+	}
+
+	runs := 1
+	if slept > 0 || schedule.catchUp == true {
+		schedule.missed += slept
+		schedule.catchUp = false
+
+		if jobPool.config.MissedRunPolicy == CatchUpOnce {
+			runs++
+		}
+	}
+
+	if schedule.scheduleNext(now) == false {
+		schedule.stopped = true
+		jobPool.removeSchedule(schedule)
+	}
+
+	return runs
+}
+
+// miss records a firing that did not queue the job. The schedule stops once the pool is closed.
+func (schedule *Schedule) miss(err error) {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	jobPool := schedule.jobPool
+	if errors.Is(err, ErrPoolClosed) == true {
+		schedule.stopped = true
+		jobPool.scheduler.cancel(schedule.timerEntry)
+		schedule.timerEntry = nil
+		jobPool.removeSchedule(schedule)
+		return
+	}
+
+	jobPool.writeLogf(LogError, "Cron", "fire", "Missed : Spec[%s] : %s", schedule.spec, err)
+	schedule.missed++
+	schedule.catchUp = true
+}
+
+// jobOptions returns the options the job is queued with. Under CallerRuns the job is kept from
+// running on the scheduler, unless a shared backend takes the job instead of the queue.
+func (schedule *Schedule) jobOptions() []JobOption {
+	config := schedule.jobPool.config
+	if config.OverflowPolicy != CallerRuns || config.SharedBackend != nil {
+		return nil
+	}
+
+	return []JobOption{withoutCallerRuns()}
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestCronFireCallerRuns proves a cron firing the queue has no room for under CallerRuns is
+// missed rather than run on the scheduler, and that the schedule stays usable while it fires.
+func TestCronFireCallerRuns(t *testing.T) {
+	jobPool := newTestPool(t, 1, 1, WithOverflowPolicy(CallerRuns, nil))
+
+	// Saturate the pool: one job runs and one waits in the queue.
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	var ran int32
+	schedule, err := jobPool.ScheduleCron("* * * * *", funcJob(func(jobRoutine int) {
+		atomic.AddInt32(&ran, 1)
+	}), false)
+	if err != nil {
+		t.Fatalf("ScheduleCron : %s", err)
+	}
+	defer schedule.Stop()
+
+	// Fire the schedule as the scheduler would.
+	fired := make(chan struct{})
+	go func() {
+		schedule.fire()
+		close(fired)
+	}()
+
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the firing, the job ran on the scheduler")
+	}
+
+	if got := atomic.LoadInt32(&ran); got != 0 {
+		t.Fatalf("Job ran %d times on the scheduler, want 0", got)
+	}
+
+	if missed := schedule.Missed(); missed != 1 {
+		t.Fatalf("Missed is %d, want 1", missed)
+	}
+
+	if schedule.Next().IsZero() == true {
+		t.Fatal("The next firing was not registered")
+	}
+}
+
+// TestRestoreSchedules saves a cron schedule, restores it twice on a new pool and proves it
+// fires at its saved time on the new pool, and that a schedule restored past due fires at once.
+func TestRestoreSchedules(t *testing.T) {
+	clock := newFakeClock()
+	clock.now = time.Date(2026, time.March, 2, 10, 1, 30, 0, time.UTC)
+
+	saved := newTestPool(t, 1, 10, WithClock(clock))
+	if _, err := saved.ScheduleCron("*/5 * * * *", funcJob(func(jobRoutine int) {}), true); err != nil {
+		t.Fatalf("ScheduleCron : %s", err)
+	}
+
+	scheduleStates := saved.ScheduleStates()
+	saved.Shutdown("test")
+
+	fireAt := time.Date(2026, time.March, 2, 10, 5, 0, 0, time.UTC)
+	if len(scheduleStates) != 1 || scheduleStates[0].Next.Equal(fireAt) == false || scheduleStates[0].Priority == false {
+		t.Fatalf("ScheduleStates : %+v", scheduleStates)
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		fired   int32
+	}{
+		{"OnTime", 0, 0},
+		{"PastDue", 7 * time.Minute, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			clock.now = time.Date(2026, time.March, 2, 10, 1, 30, 0, time.UTC).Add(test.advance)
+
+			jobPool := newTestPool(t, 1, 10, WithClock(clock))
+
+			var ran int32
+			factory := func(jobType string) Jobber {
+				return funcJob(func(jobRoutine int) {
+					atomic.AddInt32(&ran, 1)
+				})
+			}
+
+			schedules, err := jobPool.RestoreSchedules(scheduleStates, factory)
+			if err != nil || len(schedules) != 1 {
+				t.Fatalf("RestoreSchedules : Schedules[%d] : %v", len(schedules), err)
+			}
+
+			// Restoring the same states again creates nothing.
+			if again, err := jobPool.RestoreSchedules(scheduleStates, factory); err != nil || len(again) != 0 {
+				t.Fatalf("RestoreSchedules Again : Schedules[%d] : %v", len(again), err)
+			}
+
+			if restored := jobPool.ScheduleStates(); len(restored) != 1 {
+				t.Fatalf("ScheduleStates[%+v], want one schedule", restored)
+			}
+
+			waitFor(t, 5*time.Second, "the past due firings", func() bool {
+				return atomic.LoadInt32(&ran) == test.fired
+			})
+
+			// The schedule waits for its saved time and then moves on to the next one.
+			waitFor(t, 5*time.Second, "the scheduler's timer", func() bool {
+				return clock.Waiting() == 1
+			})
+
+			next := schedules[0].Next()
+			clock.Advance(next.Sub(clock.Now()) - time.Second)
+			time.Sleep(10 * time.Millisecond)
+
+			if got := atomic.LoadInt32(&ran); got != test.fired {
+				t.Fatalf("Job ran %d times before its firing, want %d", got, test.fired)
+			}
+
+			clock.Advance(time.Second)
+
+			waitFor(t, 5*time.Second, "the restored schedule to fire", func() bool {
+				return atomic.LoadInt32(&ran) == test.fired+1
+			})
+
+			if after := schedules[0].Next(); after.Equal(next.Add(5*time.Minute)) == false {
+				t.Fatalf("Next[%v], want %v", after, next.Add(5*time.Minute))
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+//** CONSTANTS
+
+const (
+	// drainPollInterval is how often DrainWithDeadline checks if the pool has finished its work.
+	drainPollInterval = 5 * time.Millisecond
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// DrainWithDeadline stops admitting jobs and lets the job routines keep working through the
+// queues while the context's deadline allows. Once the time left is less than the configured
+// DrainJobEstimate no new job is handed out, so the running jobs can finish in time. When the
+// context is done the running ContextJobbers are cancelled. The pool is then shut down and the
+// report counts every job completed since the drain started and the jobs left in the queues.
+// Without a deadline the queues are drained completely unless the context is cancelled. The
+// jobs parked by a quarantine are not waited for and are abandoned. With WithAbandonStuckWorkers
+// the job routines whose job has not returned after the grace period are abandoned and the pool
+// is reported shut down without them.
+func (jobPool *JobPool) DrainWithDeadline(ctx context.Context) (report ShutdownReport, err error) {
+	defer jobPool.catchPanic(&err, "DrainWithDeadline", "DrainWithDeadline")
+
+	// Stop accepting new jobs.
+	if atomic.CompareAndSwapInt32(&jobPool.shutdown, 0, 1) == false {
+		return report, ErrPoolClosed
+	}
+
+	completedJobs := atomic.LoadInt32(&jobPool.completedJobs)
+
+	if deadline, ok := ctx.Deadline(); ok == true {
+		cutoff := deadline.Add(-jobPool.config.DrainJobEstimate)
+		atomic.StoreInt64(&jobPool.drainCutoff, cutoff.UnixNano())
+
+		jobPool.writeLogf(LogInfo, "DrainWithDeadline", "DrainWithDeadline", "Started : Dequeue Cutoff[%v]", cutoff)
+	}
+
+	jobPool.waitForDrain(ctx)
+
+	report, err = jobPool.tearDown("DrainWithDeadline", jobPool.config.StuckWorkerGrace)
+	report.CompletedJobs = atomic.LoadInt32(&jobPool.completedJobs) - completedJobs
+
+	return report, err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// waitForDrain blocks until the pool has drained or the context is done, in which case the
+// running ContextJobbers are cancelled.
+func (jobPool *JobPool) waitForDrain(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for jobPool.drained() == false {
+		select {
+		case <-ctx.Done():
+			jobPool.writeLogf(LogInfo, "DrainWithDeadline", "waitForDrain", "Deadline Reached : %v", ctx.Err())
+			jobPool.cancelRunningJobs()
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// pastDrainCutoff returns true once a drain has stopped handing out jobs.
+func (jobPool *JobPool) pastDrainCutoff() bool {
+	cutoff := atomic.LoadInt64(&jobPool.drainCutoff)
+	return cutoff != 0 && time.Now().UnixNano() >= cutoff
+}
+
+// drained returns true once no job is running or prefetched and either the queues are empty or
+// the drain has stopped handing out jobs. The jobs parked by a quarantine keep their slots but
+// can't run until their type is released, so they are left to be abandoned by the shutdown.
+func (jobPool *JobPool) drained() bool {
+	jobPool.prefetchMutex.Lock()
+	prefetchedJobs := len(jobPool.prefetchedJobs)
+	jobPool.prefetchMutex.Unlock()
+
+	if prefetchedJobs > 0 {
+		return false
+	}
+
+	for _, workerState := range jobPool.workerStates() {
+		if atomic.LoadInt32(&workerState.busy) == 1 {
+			return false
+		}
+	}
+
+	if jobPool.pastDrainCutoff() == true {
+		return true
+	}
+
+	// Read the slots first so a job released from quarantine in between is still counted.
+	reservedSlots := atomic.LoadInt32(&jobPool.gauges.reservedSlots)
+	return reservedSlots-atomic.LoadInt32(&jobPool.parkedJobs) <= 0
+}
@@ -0,0 +1,189 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// DumpOptions controls what DumpQueue writes.
+	DumpOptions struct {
+		Limit int  // The most jobs listed for each queue. Zero lists every job.
+		JSON  bool // If the dump is written as JSON instead of text.
+	}
+
+	// QueueDump is a snapshot of the pending and running jobs written by DumpQueue.
+	QueueDump struct {
+		TakenAt       time.Time    `json:"taken_at"`       // When the snapshot was taken.
+		PriorityTotal int          `json:"priority_total"` // The number of jobs in the priority queue.
+		NormalTotal   int          `json:"normal_total"`   // The number of jobs in the normal queue.
+		Priority      []DumpedJob  `json:"priority"`       // The jobs in the priority queue up to the limit.
+		Normal        []DumpedJob  `json:"normal"`         // The jobs in the normal queue up to the limit.
+		Running       []WorkerStat `json:"running"`        // The job routines running a job.
+	}
+
+	// DumpedJob describes a pending job in a QueueDump.
+	DumpedJob struct {
+		Index      int               `json:"index"`              // The job's place in its queue, counted across tenants.
+		ID         uint64            `json:"id"`                 // The number given to the job when it was first queued.
+		Name       string            `json:"name"`               // The name of the job or its type.
+		Priority   bool              `json:"priority"`           // If the job was queued as a priority job.
+		Tenant     string            `json:"tenant,omitempty"`   // The tenant the job is queued for.
+		Group      string            `json:"group,omitempty"`    // The group the job belongs to.
+		TraceID    string            `json:"trace_id,omitempty"` // The trace ID of the job.
+		Metadata   map[string]string `json:"metadata,omitempty"` // The key/value pairs attached with WithMetadata.
+		Attempts   int               `json:"attempts"`           // The number of times the job has been started.
+		EnqueuedAt time.Time         `json:"enqueued_at"`        // When the job was placed in the queue.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// DumpQueue writes a listing of the pending jobs in both queues and the jobs being run, for use
+// when debugging an incident. The queues are read inside the queue routine so the listing is
+// consistent. Set a Limit when the queues may be very large.
+func (jobPool *JobPool) DumpQueue(w io.Writer, dumpOptions DumpOptions) (err error) {
+	defer jobPool.catchPanic(&err, "DumpQueue", "DumpQueue")
+
+	queueDump, err := jobPool.snapshotQueues(dumpOptions.Limit)
+	if err != nil {
+		return err
+	}
+
+	for _, workerStat := range jobPool.WorkerStats() {
+		if workerStat.Running == true {
+			queueDump.Running = append(queueDump.Running, workerStat)
+		}
+	}
+
+	if dumpOptions.JSON == true {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(queueDump)
+	}
+
+	return queueDump.write(w)
+}
+
+// String returns the job as a single line of the text dump.
+func (dumpedJob DumpedJob) String() string {
+	line := fmt.Sprintf("%6d ID[%d] Name[%s] Priority[%t] Attempts[%d] Enqueued[%s]", dumpedJob.Index, dumpedJob.ID, dumpedJob.Name, dumpedJob.Priority, dumpedJob.Attempts, dumpedJob.EnqueuedAt.Format(time.RFC3339Nano))
+
+	if dumpedJob.Tenant != "" {
+		line += fmt.Sprintf(" Tenant[%s]", dumpedJob.Tenant)
+	}
+
+	if dumpedJob.Group != "" {
+		line += fmt.Sprintf(" Group[%s]", dumpedJob.Group)
+	}
+
+	if dumpedJob.TraceID != "" {
+		line += fmt.Sprintf(" Trace[%s]", dumpedJob.TraceID)
+	}
+
+	return line + metadataTag(dumpedJob.Metadata)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// snapshotQueues copies the pending jobs inside the queue routine.
+func (jobPool *JobPool) snapshotQueues(limit int) (QueueDump, error) {
+	var queueDump QueueDump
+
+	err := jobPool.runInQueue(func() {
+		queueDump.TakenAt = time.Now()
+
+		for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+			queueDump.Priority, queueDump.PriorityTotal = dumpJobs(queueDump.Priority, queueDump.PriorityTotal, tenantQueue.priorityJobQueue, limit)
+			queueDump.Normal, queueDump.NormalTotal = dumpJobs(queueDump.Normal, queueDump.NormalTotal, tenantQueue.normalJobQueue, limit)
+		}
+	})
+
+	return queueDump, err
+}
+
+// write writes the dump as text.
+func (queueDump *QueueDump) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Queue Dump : %s\n", queueDump.TakenAt.Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+
+	queues := []struct {
+		name  string
+		total int
+		jobs  []DumpedJob
+	}{
+		{"Priority", queueDump.PriorityTotal, queueDump.Priority},
+		{"Normal", queueDump.NormalTotal, queueDump.Normal},
+	}
+
+	for _, queue := range queues {
+		if _, err := fmt.Fprintf(w, "%s Queue : Jobs[%d]\n", queue.name, queue.total); err != nil {
+			return err
+		}
+
+		for _, dumpedJob := range queue.jobs {
+			if _, err := fmt.Fprintf(w, "  %s\n", dumpedJob); err != nil {
+				return err
+			}
+		}
+
+		if more := queue.total - len(queue.jobs); more > 0 {
+			if _, err := fmt.Fprintf(w, "  ... %d More\n", more); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Running : Routines[%d]\n", len(queueDump.Running)); err != nil {
+		return err
+	}
+
+	for _, workerStat := range queueDump.Running {
+		if _, err := fmt.Fprintf(w, "  Routine[%d] ID[%d] Job[%s] Started[%s] Running[%v]%s\n", workerStat.Routine, workerStat.JobID, workerStat.Job, workerStat.LastJobStarted.Format(time.RFC3339Nano), queueDump.TakenAt.Sub(workerStat.LastJobStarted), metadataTag(workerStat.Metadata)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//** PRIVATE FUNCTIONS
+
+// dumpJobs adds the jobs in the queue to the dump until the limit is reached and returns the
+// dumped jobs and the number of jobs counted so far. It is only called by the queue routine.
+func dumpJobs(dumpedJobs []DumpedJob, total int, queue *list.List, limit int) ([]DumpedJob, int) {
+	index := total
+	for element := queue.Front(); element != nil; element = element.Next() {
+		if limit > 0 && len(dumpedJobs) >= limit {
+			break
+		}
+
+		queueJob := element.Value.(*queueJob)
+		dumpedJobs = append(dumpedJobs, DumpedJob{
+			Index:      index,
+			ID:         queueJob.id,
+			Name:       queueJob.name,
+			Priority:   queueJob.priority,
+			Tenant:     queueJob.tenant,
+			Group:      queueJob.group,
+			TraceID:    queueJob.traceID,
+			Metadata:   queueJob.metadata,
+			Attempts:   queueJob.attempts,
+			EnqueuedAt: queueJob.enqueuedAt,
+		})
+
+		index++
+	}
+
+	return dumpedJobs, total + queue.Len()
+}
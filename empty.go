@@ -0,0 +1,100 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// queueEmptyState tracks the queue becoming empty and non empty for OnQueueEmpty and
+	// OnQueueNonEmpty.
+	queueEmptyState struct {
+		empty     int32      // Set to 1 while no jobs are pending. Only written by the queue routine.
+		changedAt int64      // When the queue last became empty or non empty in Unix nanoseconds.
+		scheduled int32      // Set to 1 while a report is waiting on the scheduler.
+		reported  bool       // If empty was the last state given to the callbacks.
+		mutex     sync.Mutex // Keeps the callbacks in order.
+	}
+)
+
+//** PRIVATE FUNCTIONS
+
+// newQueueEmptyState creates the state for an empty queue or returns nil when there are no
+// callbacks.
+func newQueueEmptyState(config Config) *queueEmptyState {
+	if config.OnQueueEmpty == nil && config.OnQueueNonEmpty == nil {
+		return nil
+	}
+
+	return &queueEmptyState{
+		empty:    1,
+		reported: true,
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// checkQueueEmpty notes the queue becoming empty or non empty. It is only called by the queue
+// routine and the callbacks are run through the scheduler so they can't block the queue.
+func (jobPool *JobPool) checkQueueEmpty() {
+	queueEmptyState := jobPool.queueEmpty
+	if queueEmptyState == nil {
+		return
+	}
+
+	var empty int32
+	if atomic.LoadInt32(&jobPool.gauges.queuedJobs) == 0 {
+		empty = 1
+	}
+
+	if atomic.LoadInt32(&queueEmptyState.empty) == empty {
+		return
+	}
+
+	atomic.StoreInt32(&queueEmptyState.empty, empty)
+	atomic.StoreInt64(&queueEmptyState.changedAt, jobPool.clock().Now().UnixNano())
+
+	if atomic.CompareAndSwapInt32(&queueEmptyState.scheduled, 0, 1) == true {
+		jobPool.scheduler.schedule(jobPool.config.QueueEmptyDebounce, jobPool.reportQueueEmpty)
+	}
+}
+
+// reportQueueEmpty calls the callback for the state of the queue once it has held for the
+// debounce period. Nothing is called if the queue flapped back to the state last reported.
+func (jobPool *JobPool) reportQueueEmpty() {
+	queueEmptyState := jobPool.queueEmpty
+
+	// Wait again if the state changed since the report was scheduled.
+	changedAt := time.Unix(0, atomic.LoadInt64(&queueEmptyState.changedAt))
+	if held := jobPool.clock().Now().Sub(changedAt); held < jobPool.config.QueueEmptyDebounce {
+		jobPool.scheduler.schedule(jobPool.config.QueueEmptyDebounce-held, jobPool.reportQueueEmpty)
+		return
+	}
+
+	atomic.StoreInt32(&queueEmptyState.scheduled, 0)
+
+	queueEmptyState.mutex.Lock()
+	defer queueEmptyState.mutex.Unlock()
+
+	empty := atomic.LoadInt32(&queueEmptyState.empty) == 1
+	if empty == queueEmptyState.reported {
+		return
+	}
+
+	queueEmptyState.reported = empty
+
+	switch {
+	case empty == true && jobPool.config.OnQueueEmpty != nil:
+		jobPool.callbackSafely("Queue", "OnQueueEmpty", jobPool.config.OnQueueEmpty)
+
+	case empty == false && jobPool.config.OnQueueNonEmpty != nil:
+		jobPool.callbackSafely("Queue", "OnQueueNonEmpty", jobPool.config.OnQueueNonEmpty)
+	}
+}
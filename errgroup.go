@@ -0,0 +1,126 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// Group runs functions on the pool's job routines with the semantics of errgroup.Group. The
+	// first function to return an error cancels the group's context and removes the functions
+	// that have not started from the queue.
+	Group struct {
+		jobPool *JobPool        // The pool the functions run on.
+		ctx     context.Context // Cancelled by the first error or once Wait returns.
+		cancel  func()          // Cancels the context.
+		name    string          // The pool group the functions are queued in.
+		feeding sync.WaitGroup  // Counts the Go calls still queueing their function.
+		failed  int32           // Set to 1 once a function has failed.
+		err     error           // The first error returned by a function.
+		errOnce sync.Once       // Records the first error.
+	}
+
+	// groupJob runs one function of a Group.
+	groupJob struct {
+		group *Group                          // The group the function belongs to.
+		fn    func(ctx context.Context) error // The function to run.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrGroupPanicked is the error Wait returns when a function of a Group panics.
+	ErrGroupPanicked = errors.New("Group Function Panicked")
+
+	// groupSequence names the pool group of each Group.
+	groupSequence uint64
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Group returns a Group whose functions run on the pool's job routines, subject to its capacity
+// and priority, with a context derived from ctx that is cancelled by the first error or once
+// Wait returns.
+func (jobPool *JobPool) Group(ctx context.Context) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+
+	group := Group{
+		jobPool: jobPool,
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    fmt.Sprintf("Group %d", atomic.AddUint64(&groupSequence, 1)),
+	}
+
+	return &group
+}
+
+// Go queues the function to run on the pool. It waits while the queue is full rather than
+// failing. A function that can't be queued because the pool is shut down or the context is
+// cancelled fails the group with that error.
+func (group *Group) Go(fn func(ctx context.Context) error) {
+	group.feeding.Add(1)
+	defer group.feeding.Done()
+
+	groupJob := groupJob{
+		group: group,
+		fn:    fn,
+	}
+
+	err := group.jobPool.feed(group.ctx, &groupJob, false, []JobOption{WithGroup(group.name)})
+	if err != nil {
+		group.fail(err)
+	}
+}
+
+// Wait blocks until every function queued by Go has returned or been removed from the queue and
+// returns the first error.
+func (group *Group) Wait() error {
+	group.feeding.Wait()
+	group.jobPool.WaitGroupDone(context.Background(), group.name)
+	group.cancel()
+
+	return group.err
+}
+
+// RunJob runs the function unless the group has already failed. A function that panics fails
+// the group with ErrGroupPanicked before the pool recovers the panic.
+func (groupJob *groupJob) RunJob(jobRoutine int) {
+	group := groupJob.group
+
+	if atomic.LoadInt32(&group.failed) == 1 {
+		return
+	}
+
+	err := ErrGroupPanicked
+	defer func() {
+		if err != nil {
+			group.fail(err)
+		}
+	}()
+
+	err = groupJob.fn(group.ctx)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// fail records the first error, cancels the context and removes the functions that have not
+// started.
+func (group *Group) fail(err error) {
+	group.errOnce.Do(func() {
+		group.err = err
+		atomic.StoreInt32(&group.failed, 1)
+		group.cancel()
+
+		go group.jobPool.CancelGroup("Group", group.name)
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// OverflowPolicy decides what QueueJob does when the queue is at capacity.
+	OverflowPolicy int
+)
+
+//** CONSTANTS
+
+const (
+	// RejectNew rejects the new job with ErrPoolAtCapacity.
+	RejectNew OverflowPolicy = iota
+
+	// DropOldest evicts the normal job that has waited the longest to make room for the new job.
+	// The new job is rejected as with RejectNew when only priority jobs are pending.
+	DropOldest
+
+	// CallerRuns runs the new job on the routine that called QueueJob, which slows the submitter
+	// down to the pace of the pool. The job is run through the same wrapper as a job routine with
+	// CallerRoutine as its routine. Gangs are rejected as with RejectNew.
+	CallerRuns
+)
+
+//** VARIABLES
+
+var (
+	// ErrEvicted is reported by the JobHandle of a job that was admitted and later evicted from
+	// the queue by the DropOldest overflow policy.
+	ErrEvicted = errors.New("Job Evicted")
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// evictOldest removes the normal job that has waited the longest and releases everything it
+// held. It returns false if there is no normal job to evict. It is only called by the queue
+// routine.
+func (jobPool *JobPool) evictOldest() bool {
+	var oldest *queueJob
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		element := tenantQueue.normalJobQueue.Front()
+		if element == nil {
+			continue
+		}
+
+		if queueJob := element.Value.(*queueJob); oldest == nil || queueJob.enqueuedAt.Before(oldest.enqueuedAt) {
+			oldest = queueJob
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	if jobPool.removeQueuedJob(oldest, DispositionEvicted) == false {
+		return false
+	}
+
+	jobPool.finishGroupJob(oldest)
+	atomic.AddInt64(&jobPool.evictions, 1)
+
+	oldest.handle.evict()
+	for _, follower := range oldest.followers {
+		follower.evict()
+	}
+
+	if onEvicted := jobPool.config.OnEvicted; onEvicted != nil {
+		jober := oldest.Jobber
+		waited := time.Since(oldest.enqueuedAt)
+
+		go jobPool.callbackSafely("Queue", "OnEvicted", func() {
+			onEvicted(jober, waited)
+		})
+	}
+
+	return true
+}
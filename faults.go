@@ -0,0 +1,64 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+//** INTERFACES
+
+// FaultInjector is called by the pool at its injection points so tests can make a real pool
+// misbehave on demand, see the jobpooltest package. A pool without one skips the injection
+// points.
+type FaultInjector interface {
+	// BeforeAdmit is called on the submitting routine before a job is queued and can block to
+	// delay the admission. A non-nil error refuses the job with that error.
+	BeforeAdmit(jobType string, jober Jobber) error
+
+	// BeforeDequeue is called on a job routine before it asks the queue routine for a job and
+	// can block to freeze dequeues. closed is closed once the pool starts shutting down.
+	BeforeDequeue(closed <-chan struct{})
+
+	// BeforeRun is called on the job routine right before the job runs. A panic raised here is
+	// recovered, reported and retried as the job's panic.
+	BeforeRun(jobType string, jober Jobber)
+}
+
+//** PUBLIC FUNCTIONS
+
+// WithFaultInjector sets the FaultInjector the pool calls at its admission, dequeue and run
+// injection points. It is meant for tests.
+func WithFaultInjector(faultInjector FaultInjector) Option {
+	return func(config *Config) {
+		config.FaultInjector = faultInjector
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// admitFault returns the error the FaultInjector refuses a submission with.
+func (jobPool *JobPool) admitFault(jober Jobber) error {
+	if jobPool.config.FaultInjector == nil {
+		return nil
+	}
+
+	return jobPool.config.FaultInjector.BeforeAdmit(jobName(jober), jober)
+}
+
+// dequeueFault lets the FaultInjector hold a job routine before it asks for a job.
+func (jobPool *JobPool) dequeueFault() {
+	if jobPool.config.FaultInjector == nil {
+		return
+	}
+
+	jobPool.config.FaultInjector.BeforeDequeue(jobPool.shutdownStatsChannel)
+}
+
+// runFault lets the FaultInjector act on a job about to run. It is called where the job's
+// panics are recovered.
+func (jobPool *JobPool) runFault(queueJob *queueJob) {
+	if jobPool.config.FaultInjector == nil {
+		return
+	}
+
+	jobPool.config.FaultInjector.BeforeRun(queueJob.name, queueJob.Jobber)
+}
@@ -0,0 +1,135 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestStrictFIFO checks a single job routine runs normal jobs in the order they were admitted
+// and that the sequence numbers follow that order, with and without prefetching.
+func TestStrictFIFO(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "Dequeue"},
+		{name: "Prefetch", options: []Option{WithPrefetch()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool, err := NewChecked(1, 1000, append([]Option{WithLogger(NopLogger), WithoutManager(), WithStrictFIFO()}, test.options...)...)
+			if err != nil {
+				t.Fatalf("NewChecked : %v", err)
+			}
+			defer jobPool.Shutdown("test")
+
+			// Hold the job routine so every job is queued before the first one runs.
+			release := make(chan struct{})
+			blocker, started := blockingJob(release)
+			if err := jobPool.QueueJob("test", blocker, false); err != nil {
+				t.Fatalf("QueueJob : %v", err)
+			}
+			<-started
+
+			var mutex sync.Mutex
+			var order []int
+
+			const jobs = 500
+			var sequence uint64
+			handles := make([]*JobHandle, jobs)
+			for i := range handles {
+				i := i
+				handles[i] = jobPool.QueueJobAsync("test", funcJob(func(jobRoutine int) {
+					mutex.Lock()
+					order = append(order, i)
+					mutex.Unlock()
+				}), false)
+
+				if err := handles[i].WaitAdmitted(context.Background()); err != nil {
+					t.Fatalf("Job %d : QueueJobAsync : %v", i, err)
+				}
+
+				if handles[i].Sequence() <= sequence {
+					t.Fatalf("Job %d : Sequence[%d] After Sequence[%d]", i, handles[i].Sequence(), sequence)
+				}
+				sequence = handles[i].Sequence()
+			}
+
+			close(release)
+
+			for i, handle := range handles {
+				select {
+				case <-handle.Done():
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Job %d did not run", i)
+				}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			for i := range order {
+				if order[i] != i {
+					t.Fatalf("Position %d : Ran Job %d", i, order[i])
+				}
+			}
+		})
+	}
+}
+
+// TestValidateStrictFIFO checks Validate rejects WithStrictFIFO together with the settings that
+// reorder jobs.
+func TestValidateStrictFIFO(t *testing.T) {
+	tests := []struct {
+		name     string
+		routines int
+		options  []Option
+		invalid  bool
+	}{
+		{name: "SingleRoutine", routines: 1},
+		{name: "Prefetch", routines: 1, options: []Option{WithPrefetch()}},
+		{name: "Routines", routines: 2, invalid: true},
+		{name: "FairQueuing", routines: 1, options: []Option{WithFairQueuing(nil)}, invalid: true},
+		{name: "Retry", routines: 1, options: []Option{WithRetry(3, time.Millisecond)}, invalid: true},
+		{name: "RequeueOnPanic", routines: 1, options: []Option{WithRequeueOnPanic(3, time.Millisecond, false)}, invalid: true},
+		{name: "RetryPriorityBoost", routines: 1, options: []Option{WithRetryPriorityBoost(0.5)}, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Defaults()
+			config.Routines = test.routines
+			config.QueueCapacity = 10
+			for _, option := range append([]Option{WithStrictFIFO()}, test.options...) {
+				option(&config)
+			}
+
+			err := config.Validate()
+			if test.invalid == false {
+				if err != nil {
+					t.Fatalf("Validate : %v", err)
+				}
+				return
+			}
+
+			var configError *ConfigError
+			if errors.As(err, &configError) == false {
+				t.Fatalf("Validate : Expected A ConfigError : %v", err)
+			}
+
+			if configError.Field != "StrictFIFO" {
+				t.Fatalf("Validate : Field[%s] : %v", configError.Field, err)
+			}
+		})
+	}
+}
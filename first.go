@@ -0,0 +1,138 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// firstJob runs one task of First.
+	firstJob[T any] struct {
+		ctx     context.Context                      // Cancelled once First returns.
+		task    func(ctx context.Context) (T, error) // The task to run.
+		results chan<- mapResult[T]                  // Receives the result.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrTaskPanicked is the error First records for a task that panics.
+	ErrTaskPanicked = errors.New("Task Panicked")
+
+	// ErrNoTasks is returned by First when it is given no tasks.
+	ErrNoTasks = errors.New("No Tasks")
+
+	// firstSequence names the group of each call to First.
+	firstSequence uint64
+)
+
+//** PUBLIC FUNCTIONS
+
+// First runs the tasks on the pool's job routines, for example the same request sent to several
+// backends, and returns the value of the first task to succeed. The first success cancels the
+// context given to the tasks, so the running tasks can stop, and removes the tasks that have not
+// started from the queue. Tasks are queued as the pool has room. If every task fails the errors
+// are joined in the order the tasks failed. If ctx is done first ctx.Err() is returned.
+func First[T any](ctx context.Context, jobPool *JobPool, tasks []func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(tasks) == 0 {
+		return zero, ErrNoTasks
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	group := fmt.Sprintf("First %d", atomic.AddUint64(&firstSequence, 1))
+	results := make(chan mapResult[T])
+	fed := make(chan feedResult, 1)
+
+	// Queue the tasks on their own routine so results can be taken while the queue is full.
+	go func() {
+		var feedResult feedResult
+		for _, task := range tasks {
+			firstJob := firstJob[T]{
+				ctx:     ctx,
+				task:    task,
+				results: results,
+			}
+
+			if feedResult.err = jobPool.feed(ctx, &firstJob, false, []JobOption{WithGroup(group)}); feedResult.err != nil {
+				break
+			}
+
+			feedResult.queued++
+		}
+
+		fed <- feedResult
+	}()
+
+	// Stop the tasks that have not started.
+	abandon := func() {
+		cancel()
+		jobPool.CancelGroup("First", group)
+	}
+
+	var errs []error
+	received, queued := 0, -1
+
+	for queued < 0 || received < queued {
+		select {
+		case mapResult := <-results:
+			received++
+
+			if mapResult.err == nil {
+				abandon()
+				return mapResult.value, nil
+			}
+
+			errs = append(errs, mapResult.err)
+
+		case feedResult := <-fed:
+			// The tasks that could not be queued fail, the ones queued can still succeed.
+			if feedResult.err != nil {
+				errs = append(errs, feedResult.err)
+			}
+
+			queued = feedResult.queued
+
+		case <-ctx.Done():
+			abandon()
+			return zero, ctx.Err()
+		}
+	}
+
+	return zero, errors.Join(errs...)
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob runs the task and hands the result to First. A task that panics is reported to First
+// as ErrTaskPanicked before the pool recovers the panic.
+func (firstJob *firstJob[T]) RunJob(jobRoutine int) {
+	mapResult := mapResult[T]{
+		err: ErrTaskPanicked,
+	}
+
+	defer func() {
+		select {
+		case firstJob.results <- mapResult:
+		case <-firstJob.ctx.Done():
+		}
+	}()
+
+	if err := firstJob.ctx.Err(); err != nil {
+		mapResult.err = err
+		return
+	}
+
+	mapResult.value, mapResult.err = firstJob.task(firstJob.ctx)
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// PriorityFreshness decides what a job routine holding a batch of jobs does when a priority
+	// job arrives before the batch is finished.
+	PriorityFreshness int
+)
+
+//** CONSTANTS
+
+const (
+	// RelaxedPriority finishes the batch before the priority job is picked up.
+	RelaxedPriority PriorityFreshness = iota
+
+	// StrictPriority checks for pending priority jobs before each job of the batch and gives the
+	// rest of the normal jobs back so the priority job runs first.
+	StrictPriority
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// PriorityPending returns true if a priority job is waiting in the queues. It reads a counter
+// kept by the queue routine, so it is cheap enough to call between the items of a long running
+// job that wants to yield to priority work.
+func (jobPool *JobPool) PriorityPending() bool {
+	return atomic.LoadInt32(&jobPool.gauges.priorityJobs) > 0
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// countPriorityJobs adjusts the number of jobs waiting in the priority queues. It is only called
+// by the queue routine.
+func (jobPool *JobPool) countPriorityJobs(delta int) {
+	if delta != 0 {
+		atomic.AddInt32(&jobPool.gauges.priorityJobs, int32(delta))
+	}
+}
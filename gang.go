@@ -0,0 +1,195 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//** TYPES
+
+type (
+	// GangTooLargeError is returned when a GangJobber needs more job routines than the pool has.
+	GangTooLargeError struct {
+		GangSize int // The number of job routines the job needs.
+		Routines int // The number of job routines in the pool.
+	}
+
+	// workerSlots hands out the job routines to jobs in the order they ask. A job holds one slot
+	// and a gang holds a slot for each routine it needs.
+	workerSlots struct {
+		available int        // The number of slots not held by a job.
+		total     int        // The number of slots, one for each job routine that has not been retired.
+		waiters   *list.List // The slotWaiters in the order they asked.
+		mutex     sync.Mutex // Protects the slots.
+	}
+
+	// slotWaiter is a job routine waiting for slots.
+	slotWaiter struct {
+		slots    int           // The number of slots needed.
+		stranded bool          // Set when the routines were retired until the slots could never be held.
+		ready    chan struct{} // Closed once the slots are held or the waiter is stranded.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrGangTooLarge is wrapped by every GangTooLargeError.
+	ErrGangTooLarge = errors.New("Gang Too Large")
+)
+
+//** INTERFACES
+
+// GangJobber is implemented by jobs that need several job routines at the same time. The job
+// runs on one routine once GangSize routines are free and the others stay idle until it ends.
+type GangJobber interface {
+	Jobber
+	GangSize() int
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Error implements the error interface.
+func (gangTooLargeError *GangTooLargeError) Error() string {
+	return fmt.Sprintf("%s : GangSize[%d] Routines[%d]", ErrGangTooLarge, gangTooLargeError.GangSize, gangTooLargeError.Routines)
+}
+
+// Unwrap returns ErrGangTooLarge so errors.Is matches the sentinel.
+func (gangTooLargeError *GangTooLargeError) Unwrap() error {
+	return ErrGangTooLarge
+}
+
+//** PRIVATE FUNCTIONS
+
+// newWorkerSlots creates a slot for each job routine.
+func newWorkerSlots(routines int) *workerSlots {
+	return &workerSlots{
+		available: routines,
+		total:     routines,
+		waiters:   list.New(),
+	}
+}
+
+// jobGangSize returns the number of job routines the job needs.
+func jobGangSize(jober Jobber) int {
+	if gangJobber, ok := jober.(GangJobber); ok == true {
+		return gangJobber.GangSize()
+	}
+
+	return 1
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// checkGang returns a GangTooLargeError for a gang that could never run.
+func (jobPool *JobPool) checkGang(gangSize int) error {
+	if routines := jobPool.liveRoutines(); gangSize > routines {
+		return &GangTooLargeError{GangSize: gangSize, Routines: routines}
+	}
+
+	return nil
+}
+
+// dropStrandedGang cancels a gang that was dequeued but can't run because routines were retired
+// until too few are left. It is called by the job routine holding the job.
+func (jobPool *JobPool) dropStrandedGang(queueJob *queueJob, jobRoutine int) {
+	jobPool.finishTag(queueJob)
+
+	if jobPool.cancelJob(jobPool.workerName(jobRoutine), queueJob, jobClaimed, DispositionCancelled) == false {
+		return
+	}
+
+	jobPool.finishGroupJob(queueJob)
+
+	if queueJob.child != nil {
+		queueJob.child.dropped(jobPool.workerName(jobRoutine), queueJob)
+	}
+
+	err := &GangTooLargeError{GangSize: queueJob.gangSize, Routines: jobPool.liveRoutines()}
+	jobPool.writeLogf(LogError, jobPool.workerName(jobRoutine), "dropStrandedGang", "ERROR : %s : ID[%d]", err, queueJob.id)
+}
+
+// acquire blocks until the slots are held. Slots are handed out in the order they are asked for
+// so a gang can't be starved by single jobs. It returns false without holding any slots if
+// the job routines left after retirements are too few to ever provide them.
+func (workerSlots *workerSlots) acquire(slots int) bool {
+	workerSlots.mutex.Lock()
+
+	if slots > workerSlots.total {
+		workerSlots.mutex.Unlock()
+		return false
+	}
+
+	if workerSlots.waiters.Len() == 0 && workerSlots.available >= slots {
+		workerSlots.available -= slots
+		workerSlots.mutex.Unlock()
+		return true
+	}
+
+	slotWaiter := slotWaiter{
+		slots: slots,
+		ready: make(chan struct{}),
+	}
+
+	workerSlots.waiters.PushBack(&slotWaiter)
+	workerSlots.mutex.Unlock()
+
+	<-slotWaiter.ready
+	return slotWaiter.stranded == false
+}
+
+// add gives the pool the slot of a new job routine.
+func (workerSlots *workerSlots) add(slots int) {
+	workerSlots.mutex.Lock()
+	workerSlots.total += slots
+	workerSlots.mutex.Unlock()
+
+	workerSlots.release(slots)
+}
+
+// remove takes the slot of a retired job routine away. A routine holding the slot hands it
+// back first, so the count can fall below zero until it does. The waiters that now need more
+// slots than are left are stranded and the ones behind them are served.
+func (workerSlots *workerSlots) remove(slots int) {
+	workerSlots.mutex.Lock()
+	workerSlots.available -= slots
+	workerSlots.total -= slots
+
+	for element := workerSlots.waiters.Front(); element != nil; {
+		next := element.Next()
+		if slotWaiter := element.Value.(*slotWaiter); slotWaiter.slots > workerSlots.total {
+			slotWaiter.stranded = true
+			workerSlots.waiters.Remove(element)
+			close(slotWaiter.ready)
+		}
+		element = next
+	}
+	workerSlots.mutex.Unlock()
+
+	workerSlots.release(0)
+}
+
+// release gives the slots back and hands them to the waiters in order.
+func (workerSlots *workerSlots) release(slots int) {
+	workerSlots.mutex.Lock()
+	defer workerSlots.mutex.Unlock()
+
+	workerSlots.available += slots
+
+	for front := workerSlots.waiters.Front(); front != nil; front = workerSlots.waiters.Front() {
+		slotWaiter := front.Value.(*slotWaiter)
+		if workerSlots.available < slotWaiter.slots {
+			return
+		}
+
+		workerSlots.available -= slotWaiter.slots
+		workerSlots.waiters.Remove(front)
+		close(slotWaiter.ready)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+//** TYPES
+
+type (
+	// gauges holds the counters read on every admission decision, such as by QueuedJobs and
+	// PercentFull. Each counter sits on its own cache line so the queue routine and the job
+	// routines updating one don't slow down the readers of another, and each read is a single
+	// atomic load that allocates nothing. Stats takes the heavier snapshot of the whole pool.
+	gauges struct {
+		pendingBytes   int64                   // The total size of the pending jobs. First so it is 64-bit aligned.
+		_              [cacheLineSize - 8]byte // Keeps pendingBytes on its own cache line.
+		queuedJobs     int32                   // The number of pending jobs in queue.
+		_              [cacheLineSize - 4]byte // Keeps queuedJobs on its own cache line.
+		priorityJobs   int32                   // The number of pending jobs in the priority queues.
+		_              [cacheLineSize - 4]byte // Keeps priorityJobs on its own cache line.
+		reservedSlots  int32                   // The number of slots held by queued jobs and jobs in the intake buffer.
+		_              [cacheLineSize - 4]byte // Keeps reservedSlots on its own cache line.
+		activeRoutines int32                   // The number of routines active.
+		_              [cacheLineSize - 4]byte // Keeps activeRoutines on its own cache line.
+		queueBacklog   int32                   // The number of messages sent to the queue routine it has not picked up.
+		_              [cacheLineSize - 4]byte // Keeps queueBacklog on its own cache line.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// cacheLineSize is the size of a cache line on the common 64-bit processors.
+	cacheLineSize = 64
+)
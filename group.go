@@ -0,0 +1,166 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+)
+
+//** TYPES
+
+type (
+	// jobGroup tracks the jobs of a named group that have not completed.
+	jobGroup struct {
+		outstanding int                    // The number of jobs that have not completed or been cancelled.
+		pending     map[*queueJob]struct{} // The jobs that are still in the queues.
+		done        chan struct{}          // Closed once there are no outstanding jobs.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// CancelGroup removes every pending job in the group from the queues and returns the number of
+// jobs cancelled. Jobs of the group that are already running are not affected.
+func (jobPool *JobPool) CancelGroup(goRoutine string, group string) (n int, err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "CancelGroup")
+
+	err = jobPool.runInQueue(func() {
+		var cancelled []*queueJob
+
+		jobPool.groupMutex.Lock()
+		if jobGroup, found := jobPool.groups[group]; found == true {
+			cancelled = make([]*queueJob, 0, len(jobGroup.pending))
+			for queueJob := range jobGroup.pending {
+				cancelled = append(cancelled, queueJob)
+			}
+		}
+		jobPool.groupMutex.Unlock()
+
+		for _, queueJob := range cancelled {
+			if jobPool.removeQueuedJob(queueJob, DispositionCancelled) == false {
+				continue
+			}
+
+			jobPool.finishGroupJob(queueJob)
+			n++
+		}
+	})
+
+	return n, err
+}
+
+// WaitGroupDone blocks until every job added to the group has completed or been cancelled, or
+// the context is done. It returns immediately if the group has no outstanding jobs.
+func (jobPool *JobPool) WaitGroupDone(ctx context.Context, group string) error {
+	jobPool.groupMutex.Lock()
+	jobGroup, found := jobPool.groups[group]
+	jobPool.groupMutex.Unlock()
+
+	if found == false {
+		return nil
+	}
+
+	select {
+	case <-jobGroup.done:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// hasGroups returns true if any group has outstanding jobs.
+func (jobPool *JobPool) hasGroups() bool {
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	return len(jobPool.groups) > 0
+}
+
+// admitGroupJob adds an admitted job to its group.
+func (jobPool *JobPool) admitGroupJob(job *queueJob) {
+	if job.group == "" {
+		return
+	}
+
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	group, found := jobPool.groups[job.group]
+	if found == false {
+		group = &jobGroup{
+			pending: make(map[*queueJob]struct{}),
+			done:    make(chan struct{}),
+		}
+		jobPool.groups[job.group] = group
+	}
+
+	group.outstanding++
+	group.pending[job] = struct{}{}
+}
+
+// dequeueGroupJob records that a job of the group has left the queues.
+func (jobPool *JobPool) dequeueGroupJob(queueJob *queueJob) {
+	if queueJob.group == "" {
+		return
+	}
+
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	if jobGroup, found := jobPool.groups[queueJob.group]; found == true {
+		delete(jobGroup.pending, queueJob)
+	}
+}
+
+// restoreGroupJob records that a job of the group has been placed back in the queues without
+// having started.
+func (jobPool *JobPool) restoreGroupJob(queueJob *queueJob) {
+	if queueJob.group == "" {
+		return
+	}
+
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	if jobGroup, found := jobPool.groups[queueJob.group]; found == true {
+		jobGroup.pending[queueJob] = struct{}{}
+	}
+}
+
+// finishGroupJob records that a job of the group has completed or been cancelled. The group is
+// forgotten once it has no outstanding jobs.
+func (jobPool *JobPool) finishGroupJob(queueJob *queueJob) {
+	if queueJob.group == "" {
+		return
+	}
+
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	jobGroup, found := jobPool.groups[queueJob.group]
+	if found == false {
+		return
+	}
+
+	jobGroup.outstanding--
+	if jobGroup.outstanding == 0 {
+		delete(jobPool.groups, queueJob.group)
+		close(jobGroup.done)
+	}
+}
+
+// releaseGroups releases every routine waiting on a group during shutdown.
+func (jobPool *JobPool) releaseGroups() {
+	jobPool.groupMutex.Lock()
+	defer jobPool.groupMutex.Unlock()
+
+	for group, jobGroup := range jobPool.groups {
+		delete(jobPool.groups, group)
+		close(jobGroup.done)
+	}
+}
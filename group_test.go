@@ -0,0 +1,79 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// flakyJob is a job that fails or panics its first attempts and then succeeds.
+type flakyJob struct {
+	failures int32 // The number of attempts that fail.
+	panics   bool  // If the failing attempts panic instead of returning an error.
+	attempts int32 // The number of attempts made.
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob runs the job without reporting its error.
+func (flakyJob *flakyJob) RunJob(jobRoutine int) {
+	flakyJob.RunJobError(jobRoutine)
+}
+
+// RunJobError fails the first attempts and succeeds after them.
+func (flakyJob *flakyJob) RunJobError(jobRoutine int) error {
+	if atomic.AddInt32(&flakyJob.attempts, 1) > flakyJob.failures {
+		return nil
+	}
+
+	if flakyJob.panics == true {
+		panic("Job Panicked")
+	}
+
+	return errors.New("Job Failed")
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestGroupRetriedJob proves a group job that is retried or requeued is counted in its group
+// once, so WaitGroupDone returns once the job succeeds.
+func TestGroupRetriedJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		panics  bool
+	}{
+		{"Retry", []Option{WithRetry(3, time.Millisecond)}, false},
+		{"RequeueOnPanic", []Option{WithRequeueOnPanic(3, time.Millisecond, false)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 2, 10, test.options...)
+
+			job := &flakyJob{failures: 2, panics: test.panics}
+			if err := jobPool.QueueJob("test", job, false, WithGroup("batch")); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := jobPool.WaitGroupDone(ctx, "batch"); err != nil {
+				t.Fatalf("WaitGroupDone : %s", err)
+			}
+
+			if attempts := atomic.LoadInt32(&job.attempts); attempts != 3 {
+				t.Fatalf("Job ran %d times, want 3", attempts)
+			}
+		})
+	}
+}
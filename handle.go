@@ -0,0 +1,286 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// JobState is where a job submitted with QueueJobAsync is in its life.
+	JobState int32
+
+	// JobHandle reports the outcome of an asynchronous submission.
+	JobHandle struct {
+		admitted  chan struct{} // Closed once the job has been admitted or rejected.
+		err       error         // The reason the job was rejected or nil if it was admitted.
+		admission AdmissionInfo // Where the job was placed when it was admitted.
+		evicted   int32         // Set to 1 once the admitted job has been evicted from the queue.
+		state     int32         // The JobState of the job.
+		done      chan struct{} // Closed once the job has finished running, been cancelled or been rejected.
+		finished  int32         // Set to 1 once done is closed.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// StateSubmitted is the state of a job waiting in the intake buffer for the queue routine.
+	StateSubmitted JobState = iota
+
+	// StateAdmitted is the state of a job the queue routine has placed in its queue. A job that
+	// is retried returns to this state.
+	StateAdmitted
+
+	// StateRejected is the state of a job the pool could not admit. Err reports the reason.
+	StateRejected
+
+	// StateRunning is the state of a job a routine is running.
+	StateRunning
+
+	// StateDone is the state of a job that finished running, whatever its outcome.
+	StateDone
+
+	// StateCancelled is the state of an admitted job that was removed before it started, by a
+	// cancel, an eviction or the pool shutting down.
+	StateCancelled
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobAsync takes a slot in the queue and places the job in the intake buffer without waiting
+// for the queue routine. The job is rejected immediately if the queue is at capacity, otherwise
+// the handle reports once the queue routine has placed the job in its queue.
+func (jobPool *JobPool) QueueJobAsync(goRoutine string, jober Jobber, priority bool, options ...JobOption) (handle *JobHandle) {
+	handle = &JobHandle{
+		admitted: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	var err error
+	defer func() {
+		if err != nil {
+			handle.resolve(err)
+		}
+	}()
+	defer jobPool.catchPanic(&err, goRoutine, "QueueJobAsync")
+
+	// Jobs can't be queued once the pool is shutting down.
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		handle.resolve(jobPool.reject(goRoutine, jober, ErrPoolClosed))
+		return handle
+	}
+
+	// A nil or malformed job is rejected at the call site rather than on a job routine.
+	if err := jobPool.checkJob(jober); err != nil {
+		handle.resolve(jobPool.reject(goRoutine, jober, err))
+		return handle
+	}
+
+	// A FaultInjector can delay or refuse the job.
+	if err := jobPool.admitFault(jober); err != nil {
+		handle.resolve(jobPool.reject(goRoutine, jober, err))
+		return handle
+	}
+
+	// A gang larger than the pool could never run.
+	gangSize := jobGangSize(jober)
+	if err := jobPool.checkGang(gangSize); err != nil {
+		handle.resolve(jobPool.reject(goRoutine, jober, err))
+		return handle
+	}
+
+	// Create the job object to queue.
+	job := queueJob{
+		Jobber:   jober,
+		name:     jobName(jober),
+		size:     jobSize(jober),
+		gangSize: gangSize,
+		priority: jobPool.admitPriority(jober, priority),
+		handle:   handle,
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	// If the queue is at capacity don't add it.
+	if jobPool.reserveSlot() == false {
+		handle.resolve(jobPool.rejectJob(goRoutine, &job, ErrPoolAtCapacity))
+		return handle
+	}
+
+	// Queue the job
+	if err := jobPool.submitIntake(&job); err != nil {
+		jobPool.releaseSlots(1)
+		handle.resolve(jobPool.reject(goRoutine, jober, err))
+	}
+
+	return handle
+}
+
+// Admitted returns a channel that is closed once the job has been admitted or rejected.
+func (handle *JobHandle) Admitted() <-chan struct{} {
+	return handle.admitted
+}
+
+// Wait blocks until the job has been admitted or rejected and returns the reason it was rejected.
+// It returns ErrEvicted if the job was admitted and has since been evicted.
+func (handle *JobHandle) Wait() error {
+	<-handle.admitted
+	return handle.outcome()
+}
+
+// Err returns the reason the job was rejected or ErrEvicted if it was evicted after it was
+// admitted. It returns nil while the job is still waiting to be admitted.
+func (handle *JobHandle) Err() error {
+	select {
+	case <-handle.admitted:
+		return handle.outcome()
+	default:
+		return nil
+	}
+}
+
+// Sequence returns the number the pool gave the job when it was admitted. Numbers increase in
+// the order jobs are placed in the queues and are the IDs accepted by Cancel and reported in
+// JobMeta. It returns 0 while the job is waiting to be admitted or if it was rejected.
+func (handle *JobHandle) Sequence() uint64 {
+	select {
+	case <-handle.admitted:
+		return handle.admission.ID
+	default:
+		return 0
+	}
+}
+
+// Admission returns where the job was placed when it was admitted. It returns the zero value
+// while the job is waiting to be admitted or if it was rejected.
+func (handle *JobHandle) Admission() AdmissionInfo {
+	select {
+	case <-handle.admitted:
+		return handle.admission
+	default:
+		return AdmissionInfo{}
+	}
+}
+
+// WaitAdmitted blocks until the job has been admitted or rejected, or ctx is done, and returns
+// the reason it was rejected or ctx.Err().
+func (handle *JobHandle) WaitAdmitted(ctx context.Context) error {
+	select {
+	case <-handle.admitted:
+		return handle.outcome()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the job has finished running, been cancelled or
+// been rejected. A job that is retried is only done once its last attempt has finished.
+func (handle *JobHandle) Done() <-chan struct{} {
+	return handle.done
+}
+
+// State returns where the job is in its life without blocking.
+func (handle *JobHandle) State() JobState {
+	return JobState(atomic.LoadInt32(&handle.state))
+}
+
+// String returns the name of the state.
+func (jobState JobState) String() string {
+	switch jobState {
+	case StateSubmitted:
+		return "Submitted"
+	case StateAdmitted:
+		return "Admitted"
+	case StateRejected:
+		return "Rejected"
+	case StateRunning:
+		return "Running"
+	case StateDone:
+		return "Done"
+	case StateCancelled:
+		return "Cancelled"
+	default:
+		return "Unknown"
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// setState records where the job is in its life.
+func (handle *JobHandle) setState(jobState JobState) {
+	if handle == nil {
+		return
+	}
+
+	atomic.StoreInt32(&handle.state, int32(jobState))
+
+	switch jobState {
+	case StateDone, StateCancelled, StateRejected:
+		if atomic.CompareAndSwapInt32(&handle.finished, 0, 1) == true {
+			close(handle.done)
+		}
+	}
+}
+
+// outcome returns the result of the submission once the job has been admitted or rejected.
+func (handle *JobHandle) outcome() error {
+	if atomic.LoadInt32(&handle.evicted) == 1 {
+		return ErrEvicted
+	}
+
+	return handle.err
+}
+
+// evict records that the admitted job was evicted from the queue.
+func (handle *JobHandle) evict() {
+	if handle == nil {
+		return
+	}
+
+	atomic.StoreInt32(&handle.evicted, 1)
+}
+
+// admit records where the job was placed and releases any waiters.
+func (handle *JobHandle) admit(admission AdmissionInfo) {
+	if handle == nil {
+		return
+	}
+
+	// A requeued job has already been admitted once.
+	select {
+	case <-handle.admitted:
+		return
+	default:
+	}
+
+	handle.admission = admission
+	handle.setState(StateAdmitted)
+	close(handle.admitted)
+}
+
+// resolve records the outcome of the submission and releases any waiters.
+func (handle *JobHandle) resolve(err error) {
+	if handle == nil {
+		return
+	}
+
+	// A requeued job has already been admitted once.
+	select {
+	case <-handle.admitted:
+		return
+	default:
+	}
+
+	handle.err = err
+	handle.setState(StateRejected)
+	close(handle.admitted)
+}
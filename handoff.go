@@ -0,0 +1,157 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+)
+
+//** VARIABLES
+
+var (
+	// ErrJobCancelled is returned by QueueJobHandoff for a job cancelled before it started, such
+	// as by Cancel, CancelPending or CancelGroup.
+	ErrJobCancelled = errors.New("Job Cancelled")
+
+	// ErrJobRejected is returned by QueueJobHandoff for a job refused after it was admitted, such
+	// as a job whose type was quarantined before it started.
+	ErrJobRejected = errors.New("Job Rejected After Admission")
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobHandoff queues a job and blocks until a job routine has started running it, so the
+// caller knows the pool had room for it right now. If ctx expires first the job is withdrawn
+// from the queue and ctx.Err() is returned. A job that was already dequeued can't be withdrawn
+// and still runs, in which case nil is returned once it starts. A job that leaves the pool
+// another way before it starts returns ErrJobCancelled, ErrEvicted or ErrJobRejected, and
+// ErrPoolClosed is returned if the pool shuts down before the job starts.
+func (jobPool *JobPool) QueueJobHandoff(ctx context.Context, jober Jobber, priority bool, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, "QueueJobHandoff", "QueueJobHandoff")
+
+	var job *queueJob
+	started := make(chan struct{})
+	ended := make(chan struct{})
+
+	options = append(options[:len(options):len(options)], func(queueJob *queueJob) {
+		queueJob.started = started
+		queueJob.ended = ended
+		job = queueJob
+	})
+
+	if err = jobPool.QueueJob("QueueJobHandoff", jober, priority, options...); err != nil {
+		return err
+	}
+
+	select {
+	case <-started:
+		return nil
+
+	case <-ended:
+		return job.handoffResult()
+
+	case <-jobPool.shutdownStatsChannel:
+		// Jobs still in the queues are abandoned by the shutdown.
+		select {
+		case <-started:
+			return nil
+		default:
+			return ErrPoolClosed
+		}
+
+	case <-ctx.Done():
+		return jobPool.withdrawJob(ctx, job)
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// handoffError returns the error QueueJobHandoff reports for a job that left the pool with the
+// disposition before it started.
+func handoffError(disposition Disposition) error {
+	switch disposition {
+	case DispositionEvicted:
+		return ErrEvicted
+	case DispositionRejected:
+		return ErrJobRejected
+	case DispositionAbandoned:
+		return ErrPoolClosed
+	default:
+		return ErrJobCancelled
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// withdrawJob takes a job waiting for handoff out of the queue and returns ctx.Err(). A job that
+// was already dequeued can't be withdrawn, in which case it waits for the job to start or leave
+// the pool. A job still in the queues once the pool has shut down is abandoned and counts as
+// withdrawn.
+func (jobPool *JobPool) withdrawJob(ctx context.Context, job *queueJob) error {
+	var withdrawn bool
+	err := jobPool.runInQueue(func() {
+		// Once started the job may have been queued again as a retry which is not withdrawn.
+		select {
+		case <-job.started:
+			return
+		default:
+		}
+
+		if jobPool.removeQueuedJob(job, DispositionRejected) == false {
+			return
+		}
+
+		jobPool.finishGroupJob(job)
+		withdrawn = true
+	})
+
+	if err != nil {
+		select {
+		case <-job.started:
+			return nil
+		default:
+			return ctx.Err()
+		}
+	}
+
+	if withdrawn == true {
+		return ctx.Err()
+	}
+
+	select {
+	case <-job.started:
+		return nil
+	case <-job.ended:
+		return job.handoffResult()
+	}
+}
+
+// handoffResult returns what QueueJobHandoff reports once the job has left the pool. A job
+// that started before it was cancelled as a retry was handed off.
+func (queueJob *queueJob) handoffResult() error {
+	select {
+	case <-queueJob.started:
+		return nil
+	default:
+		return handoffError(queueJob.endedAs)
+	}
+}
+
+// endHandoff tells a submitter waiting for the handoff that the job was cancelled with the
+// disposition. It is called once when the job is disposed.
+func (queueJob *queueJob) endHandoff(state int32, disposition Disposition) {
+	if state != jobCancelled || queueJob.ended == nil {
+		return
+	}
+
+	queueJob.endedAs = disposition
+	close(queueJob.ended)
+}
+
+// waitsForHandoff reports if the job's submitter is blocked until a job routine starts it.
+func (queueJob *queueJob) waitsForHandoff() bool {
+	return queueJob.ended != nil && queueJob.attempts == 0
+}
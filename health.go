@@ -0,0 +1,69 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//** VARIABLES
+
+var (
+	// ErrQueueRoutineUnresponsive is returned by Healthy when the queue routine doesn't answer
+	// the probe before the context is done.
+	ErrQueueRoutineUnresponsive = errors.New("Queue Routine Unresponsive")
+
+	// ErrJobsNotRunning is returned by Healthy when jobs are queued but no job routine is active.
+	ErrJobsNotRunning = errors.New("Jobs Queued With No Active Routines")
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Healthy probes the pool end to end. It sends a ping through the queue routine and reports
+// ErrQueueRoutineUnresponsive if the ping isn't answered before the context is done, for example
+// because the queue routine is stuck in a user callback. It also reports ErrJobsNotRunning when
+// jobs are waiting in the queues and no job routine is working on them.
+func (jobPool *JobPool) Healthy(ctx context.Context) (err error) {
+	defer jobPool.catchPanic(&err, "Healthy", "Healthy")
+
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return ErrPoolClosed
+	}
+
+	// The result channel is buffered so the queue routine never blocks on a probe
+	// that has given up.
+	ping := queueTask{
+		task:          func() {},
+		resultChannel: make(chan struct{}, 1),
+	}
+
+	if jobPool.enterQueue() == false {
+		return ErrPoolClosed
+	}
+	defer jobPool.exitQueue()
+
+	jobPool.countBacklog(1)
+	select {
+	case jobPool.taskChannel <- &ping:
+	case <-ctx.Done():
+		jobPool.countBacklog(-1)
+		return fmt.Errorf("%w : %v", ErrQueueRoutineUnresponsive, ctx.Err())
+	}
+
+	select {
+	case <-ping.resultChannel:
+	case <-ctx.Done():
+		return fmt.Errorf("%w : %v", ErrQueueRoutineUnresponsive, ctx.Err())
+	}
+
+	if atomic.LoadInt32(&jobPool.gauges.queuedJobs) > 0 && atomic.LoadInt32(&jobPool.gauges.activeRoutines) == 0 {
+		return ErrJobsNotRunning
+	}
+
+	return nil
+}
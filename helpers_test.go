@@ -0,0 +1,163 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// funcJob lets an ordinary function be queued as a job.
+	funcJob func(jobRoutine int)
+
+	// fakeClock is a Clock whose time only moves when the test advances it.
+	fakeClock struct {
+		now    time.Time    // The time the clock reports.
+		timers []*fakeTimer // The timers that haven't fired or been stopped.
+		mutex  sync.Mutex   // Protects the clock.
+	}
+
+	// fakeTimer is a timer created by a fakeClock.
+	fakeTimer struct {
+		clock  *fakeClock     // The clock the timer belongs to.
+		fireAt time.Time      // When the timer fires.
+		c      chan time.Time // Receives the time once the timer fires.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob calls the function.
+func (funcJob funcJob) RunJob(jobRoutine int) {
+	funcJob(jobRoutine)
+}
+
+// Now returns the clock's time.
+func (fakeClock *fakeClock) Now() time.Time {
+	fakeClock.mutex.Lock()
+	defer fakeClock.mutex.Unlock()
+
+	return fakeClock.now
+}
+
+// NewTimer creates a timer that fires once the clock has been advanced by the duration.
+func (fakeClock *fakeClock) NewTimer(d time.Duration) Timer {
+	fakeClock.mutex.Lock()
+	defer fakeClock.mutex.Unlock()
+
+	fakeTimer := &fakeTimer{
+		clock:  fakeClock,
+		fireAt: fakeClock.now.Add(d),
+		c:      make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		fakeTimer.c <- fakeClock.now
+		return fakeTimer
+	}
+
+	fakeClock.timers = append(fakeClock.timers, fakeTimer)
+	return fakeTimer
+}
+
+// Advance moves the clock forward and fires the timers that have come due.
+func (fakeClock *fakeClock) Advance(d time.Duration) {
+	fakeClock.mutex.Lock()
+	defer fakeClock.mutex.Unlock()
+
+	fakeClock.now = fakeClock.now.Add(d)
+
+	timers := fakeClock.timers[:0]
+	for _, fakeTimer := range fakeClock.timers {
+		if fakeTimer.fireAt.After(fakeClock.now) == true {
+			timers = append(timers, fakeTimer)
+			continue
+		}
+
+		fakeTimer.c <- fakeClock.now
+	}
+
+	fakeClock.timers = timers
+}
+
+// Waiting returns the number of timers that haven't fired or been stopped.
+func (fakeClock *fakeClock) Waiting() int {
+	fakeClock.mutex.Lock()
+	defer fakeClock.mutex.Unlock()
+
+	return len(fakeClock.timers)
+}
+
+// C returns the channel the time is delivered on.
+func (fakeTimer *fakeTimer) C() <-chan time.Time {
+	return fakeTimer.c
+}
+
+// Stop prevents the timer from firing. It returns false if the timer already fired.
+func (fakeTimer *fakeTimer) Stop() bool {
+	fakeClock := fakeTimer.clock
+
+	fakeClock.mutex.Lock()
+	defer fakeClock.mutex.Unlock()
+
+	for i, pending := range fakeClock.timers {
+		if pending == fakeTimer {
+			fakeClock.timers = append(fakeClock.timers[:i], fakeClock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+//** PRIVATE FUNCTIONS
+
+// newFakeClock creates a fake clock that starts at the current time.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+// newTestPool creates a pool that logs nothing and is shut down once the test is over.
+func newTestPool(t *testing.T, numberOfRoutines int, queueCapacity int32, options ...Option) *JobPool {
+	t.Helper()
+
+	options = append([]Option{WithLogger(NopLogger), WithoutManager()}, options...)
+	jobPool := New(numberOfRoutines, queueCapacity, options...)
+
+	t.Cleanup(func() {
+		jobPool.Shutdown("test")
+	})
+
+	return jobPool
+}
+
+// blockingJob returns a job that blocks its job routine until release is closed, and a
+// channel closed once the job has started.
+func blockingJob(release chan struct{}) (funcJob, chan struct{}) {
+	started := make(chan struct{})
+
+	return funcJob(func(jobRoutine int) {
+		close(started)
+		<-release
+	}), started
+}
+
+// waitFor polls the condition until it holds or the timeout passes.
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for condition() == false {
+		if time.Now().After(deadline) == true {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
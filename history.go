@@ -0,0 +1,154 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// JobOutcome describes how a job left the pool.
+	JobOutcome string
+
+	// JobRecord describes a job that completed, failed or was rejected.
+	JobRecord struct {
+		Type       string            `json:"type"`               // The name of the job or its type.
+		Priority   bool              `json:"priority"`           // If the job was queued as a priority job.
+		EnqueuedAt time.Time         `json:"enqueued_at"`        // When the job was placed in the queue.
+		StartedAt  time.Time         `json:"started_at"`         // When a job routine started the job.
+		EndedAt    time.Time         `json:"ended_at"`           // When the job finished or was rejected.
+		Outcome    JobOutcome        `json:"outcome"`            // How the job left the pool.
+		Error      string            `json:"error,omitempty"`    // The error the job failed with or the reason it was rejected.
+		Metadata   map[string]string `json:"metadata,omitempty"` // The key/value pairs attached with WithMetadata.
+	}
+
+	// jobHistory is a ring of the most recent job records.
+	jobHistory struct {
+		records []JobRecord // The ring of records.
+		next    int         // Where the next record is written.
+		full    bool        // If the ring has wrapped around.
+		mutex   sync.Mutex  // Protects the ring.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// OutcomeCompleted is recorded for a job that returned without an error.
+	OutcomeCompleted JobOutcome = "completed"
+
+	// OutcomeFailed is recorded for a job that returned an error.
+	OutcomeFailed JobOutcome = "failed"
+
+	// OutcomePanicked is recorded for a job that panicked.
+	OutcomePanicked JobOutcome = "panicked"
+
+	// OutcomeRetried is recorded for a failed job that was placed back in the queue.
+	OutcomeRetried JobOutcome = "retried"
+
+	// OutcomeRejected is recorded for a job that was not accepted by the queue.
+	OutcomeRejected JobOutcome = "rejected"
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// History returns a copy of the most recent job records, oldest first. It returns nil unless
+// the pool was created with WithHistory.
+func (jobPool *JobPool) History() []JobRecord {
+	return jobPool.history.snapshot()
+}
+
+//** PRIVATE FUNCTIONS
+
+// newJobHistory creates a ring holding size records. It returns nil, meaning no history is
+// kept, when size is not positive.
+func newJobHistory(size int) *jobHistory {
+	if size <= 0 {
+		return nil
+	}
+
+	return &jobHistory{
+		records: make([]JobRecord, size),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// add writes a record over the oldest one once the ring is full.
+func (jobHistory *jobHistory) add(jobRecord JobRecord) {
+	if jobHistory == nil {
+		return
+	}
+
+	jobHistory.mutex.Lock()
+	defer jobHistory.mutex.Unlock()
+
+	jobHistory.records[jobHistory.next] = jobRecord
+
+	jobHistory.next++
+	if jobHistory.next == len(jobHistory.records) {
+		jobHistory.next = 0
+		jobHistory.full = true
+	}
+}
+
+// snapshot returns a copy of the records, oldest first.
+func (jobHistory *jobHistory) snapshot() []JobRecord {
+	if jobHistory == nil {
+		return nil
+	}
+
+	jobHistory.mutex.Lock()
+	defer jobHistory.mutex.Unlock()
+
+	if jobHistory.full == false {
+		return append([]JobRecord(nil), jobHistory.records[:jobHistory.next]...)
+	}
+
+	records := make([]JobRecord, 0, len(jobHistory.records))
+	records = append(records, jobHistory.records[jobHistory.next:]...)
+	return append(records, jobHistory.records[:jobHistory.next]...)
+}
+
+// jobRecord describes a job that ran. The record is built before the job can be retried, after
+// which the job belongs to the queue again.
+func (jobPool *JobPool) jobRecord(queueJob *queueJob, started time.Time, ended time.Time, err error) JobRecord {
+	jobRecord := JobRecord{
+		Type:       queueJob.name,
+		Priority:   queueJob.priority,
+		EnqueuedAt: queueJob.enqueuedAt,
+		StartedAt:  started,
+		EndedAt:    ended,
+		Metadata:   queueJob.metadata,
+	}
+
+	if err != nil {
+		jobRecord.Error = err.Error()
+	}
+
+	return jobRecord
+}
+
+// recordRejection adds a rejected job to the history.
+func (jobPool *JobPool) recordRejection(name string, reason error) {
+	if jobPool.history == nil {
+		return
+	}
+
+	jobRecord := JobRecord{
+		Type:    name,
+		EndedAt: time.Now(),
+		Outcome: OutcomeRejected,
+	}
+
+	if reason != nil {
+		jobRecord.Error = reason.Error()
+	}
+
+	jobPool.history.add(jobRecord)
+}
@@ -0,0 +1,179 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// injectCapacity decides how jobs placed in the queues together account for capacity.
+	injectCapacity int
+)
+
+//** CONSTANTS
+
+const (
+	// injectReserve takes a slot for every job or refuses them all untouched when the queue can't
+	// hold them.
+	injectReserve injectCapacity = iota
+
+	// injectHeld is for jobs that already hold their slots, such as retries.
+	injectHeld
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RequeueAll places the jobs in the queue with a single request to the queue routine, for
+// example to re-drive the jobs handed to the dead letter handler. The jobs are queued in order
+// without live traffic in between. Either every job gets a slot or none are queued and
+// ErrPoolAtCapacity is returned, in which case the jobs are not released so the caller can try
+// again. Jobs refused by the byte budget are passed to the rejection handler and their errors
+// are joined.
+func (jobPool *JobPool) RequeueAll(jobs []Jobber, priority bool) (err error) {
+	defer jobPool.catchPanic(&err, "RequeueAll", "RequeueAll")
+
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return ErrPoolClosed
+	}
+
+	queueJobs := make([]*queueJob, len(jobs))
+	for i, jober := range jobs {
+		if queueJobs[i], err = jobPool.newQueueJob(jober, priority, nil); err != nil {
+			return jobPool.reject("RequeueAll", jober, err)
+		}
+	}
+
+	return jobPool.injectJobs("RequeueAll", queueJobs, false, injectReserve)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// newQueueJob creates the job object to queue. It returns an error for a nil or malformed job
+// and for a gang that could never run.
+func (jobPool *JobPool) newQueueJob(jober Jobber, priority bool, options []JobOption) (*queueJob, error) {
+	if err := jobPool.checkJob(jober); err != nil {
+		return nil, err
+	}
+
+	gangSize := jobGangSize(jober)
+	if err := jobPool.checkGang(gangSize); err != nil {
+		return nil, err
+	}
+
+	job := queueJob{
+		Jobber:   jober,
+		name:     jobName(jober),
+		size:     jobSize(jober),
+		gangSize: gangSize,
+		priority: jobPool.admitPriority(jober, priority),
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	return &job, nil
+}
+
+// injectJobs places the jobs in their queues with one request to the queue routine. With front
+// the jobs are placed ahead of the pending jobs, still in the order given. New jobs are checked
+// against their tenant's quota and the byte budget, each job refused is passed to the rejection
+// handler and the errors are joined. Jobs that already hold their slots are not checked. When
+// the queue can't hold every job none are queued and the jobs are passed to the rejection
+// handler without being released. The handler is called once the queue routine is done.
+func (jobPool *JobPool) injectJobs(goRoutine string, queueJobs []*queueJob, front bool, capacity injectCapacity) error {
+	var full error
+	var refused []*queueJob
+	var errs []error
+
+	err := jobPool.runInQueue(func() {
+		switch capacity {
+		case injectReserve:
+			if jobPool.reserveSlots(len(queueJobs)) == false {
+				full = jobPool.rejection(ErrPoolAtCapacity, nil, nil)
+				return
+			}
+		}
+
+		// Jobs placed at the front go in reverse so they keep their order.
+		for i := range queueJobs {
+			queueJob := queueJobs[i]
+			if front == true {
+				queueJob = queueJobs[len(queueJobs)-1-i]
+			}
+
+			if capacity != injectHeld {
+				var reason error
+				switch {
+				case jobPool.barred(queueJob) == true:
+					reason = ErrBarrier
+				case jobPool.tenantAtCapacity(queueJob.tenant) == true:
+					reason = ErrTenantQuotaExceeded
+				case jobPool.bytesAtCapacity(queueJob) == true:
+					reason = ErrQueueBytesExceeded
+				case jobPool.memoryRefused(queueJob) == true:
+					reason = ErrMemoryGuarded
+				}
+
+				if reason != nil {
+					jobPool.releaseSlots(1)
+					refused = append(refused, queueJob)
+					errs = append(errs, jobPool.rejection(reason, queueJob.Jobber, queueJob))
+					continue
+				}
+			}
+
+			queueJob.front = front
+			jobPool.pushJob(queueJob)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// The jobs are left untouched so the caller can offer them again.
+	if full != nil {
+		for _, queueJob := range queueJobs {
+			jobPool.notifyRejected(goRoutine, queueJob.Jobber, full)
+		}
+		return full
+	}
+
+	for i, queueJob := range refused {
+		jobPool.reject(goRoutine, queueJob.Jobber, errs[i])
+	}
+
+	return errors.Join(errs...)
+}
+
+// injectJob places a single job in its queue through injectJobs.
+func (jobPool *JobPool) injectJob(goRoutine string, job *queueJob, front bool, capacity injectCapacity) error {
+	return jobPool.injectJobs(goRoutine, []*queueJob{job}, front, capacity)
+}
+
+// reserveSlots takes the slots for every job or none of them. It returns false if the queue
+// can't hold them all or submitters are waiting for a slot.
+func (jobPool *JobPool) reserveSlots(slots int) bool {
+	if atomic.LoadInt32(&jobPool.waitingSubmitters) > 0 {
+		return false
+	}
+
+	for {
+		reservedSlots := atomic.LoadInt32(&jobPool.gauges.reservedSlots)
+		if reservedSlots+int32(slots) > jobPool.config.QueueCapacity {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&jobPool.gauges.reservedSlots, reservedSlots, reservedSlots+int32(slots)) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// closerJob counts the times it ran and was closed.
+type closerJob struct {
+	runs   int32 // The number of times the job ran.
+	closed int32 // The number of times the job was closed.
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob counts the run.
+func (closerJob *closerJob) RunJob(jobRoutine int) {
+	atomic.AddInt32(&closerJob.runs, 1)
+}
+
+// Close counts the close.
+func (closerJob *closerJob) Close() error {
+	atomic.AddInt32(&closerJob.closed, 1)
+	return nil
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestRequeueAllAtCapacity proves RequeueAll refuses every job untouched when the queue can't
+// hold them all, so the same jobs can be requeued once there is room.
+func TestRequeueAllAtCapacity(t *testing.T) {
+	var rejected int32
+	jobPool := newTestPool(t, 1, 2, WithRejectionHandler(RejectionHandlerFunc(func(jober Jobber, reason error) {
+		atomic.AddInt32(&rejected, 1)
+	})))
+
+	// Hold the only routine and one of the two slots.
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	jobs := []Jobber{&closerJob{}, &closerJob{}}
+	if err := jobPool.RequeueAll(jobs, false); errors.Is(err, ErrPoolAtCapacity) == false {
+		t.Fatalf("RequeueAll returned %v, want %v", err, ErrPoolAtCapacity)
+	}
+
+	if queued := jobPool.QueuedJobs(); queued != 1 {
+		t.Fatalf("QueuedJobs is %d, want 1", queued)
+	}
+
+	for i, jober := range jobs {
+		if closed := atomic.LoadInt32(&jober.(*closerJob).closed); closed != 0 {
+			t.Fatalf("Job %d was closed %d times, want 0", i, closed)
+		}
+	}
+
+	if got := atomic.LoadInt32(&rejected); got != 2 {
+		t.Fatalf("Rejection handler was called %d times, want 2", got)
+	}
+
+	// Once there is room the same jobs are queued and run.
+	releaseOnce()
+	waitFor(t, 5*time.Second, "the queue to empty", func() bool {
+		return jobPool.QueuedJobs() == 0
+	})
+
+	if err := jobPool.RequeueAll(jobs, false); err != nil {
+		t.Fatalf("RequeueAll : %s", err)
+	}
+
+	waitFor(t, 5*time.Second, "the requeued jobs to run", func() bool {
+		return atomic.LoadInt32(&jobs[0].(*closerJob).runs) == 1 && atomic.LoadInt32(&jobs[1].(*closerJob).runs) == 1
+	})
+}
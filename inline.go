@@ -0,0 +1,129 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithInlineIfIdle runs a job queued with QueueJob on the submitting routine, skipping the
+// queues, when nothing is pending and a job routine is free under the concurrency limit. This
+// saves the round trip through the queue routine for jobs that run in microseconds. The job goes
+// through the same wrapper as a job routine with CallerRoutine as its routine and holds a permit
+// of the concurrency limit while it runs. A job is queued as usual when the pool is busy, when
+// a barrier is up, when it is a gang or unique job, or when the pool has tag reservations, so it
+// never passes a job queued before it.
+func WithInlineIfIdle() JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.inlineIfIdle = true
+	}
+}
+
+// WithConcurrencyPermit makes RunInline wait for a permit of the concurrency limit before it
+// runs the job and hold it while the job runs, so the job counts against SetConcurrencyLimit as
+// one run by a job routine would.
+func WithConcurrencyPermit() JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.permit = true
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunInline runs a job on the calling routine right now, without touching the queues, through
+// the same wrapper the job routines use. The job is checked by the validator and quarantine, a
+// ContextJobber gets a context derived from ctx carrying its JobMeta and logger, a panic is
+// recovered and reported to the PanicHandler under the PanicPolicy, and the run is counted in
+// Stats, the error history and the history like any other. The job's error, or the panic as an
+// error, is returned to the caller instead of being retried or dead lettered. The job does not
+// count against the concurrency limit unless WithConcurrencyPermit is passed, and a gang does
+// not hold any job routines.
+func (jobPool *JobPool) RunInline(ctx context.Context, jober Jobber, options ...JobOption) error {
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return ErrPoolClosed
+	}
+
+	if err := jobPool.checkJob(jober); err != nil {
+		return err
+	}
+
+	job := queueJob{
+		Jobber: jober,
+		name:   jobName(jober),
+		size:   jobSize(jober),
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	if job.permit == true {
+		jobPool.concurrency.acquire()
+		defer jobPool.concurrency.release()
+	}
+
+	started := time.Now()
+	job.enqueuedAt = started
+	job.firstEnqueuedAt = started
+	job.id = atomic.AddUint64(&jobPool.jobSequence, 1)
+	job.setState(jobRunning)
+
+	jobRecord, panicked, err := jobPool.performJob(ctx, &job, CallerRoutine, nil, started)
+	jobPool.history.add(jobRecord)
+
+	job.setState(jobDone)
+	if panicked == false {
+		atomic.AddInt32(&jobPool.completedJobs, 1)
+	}
+
+	return err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// runInlineIfIdle runs a job asked to run inline on the submitting routine if the pool is idle.
+// It returns false if the job has to be queued.
+func (jobPool *JobPool) runInlineIfIdle(queueJob *queueJob) bool {
+	if queueJob.inlineIfIdle == false || queueJob.gangSize > 1 || queueJob.key != "" || jobPool.tags != nil {
+		return false
+	}
+
+	// Jobs pending, in the intake buffer or waiting for a barrier are ahead of this one.
+	if atomic.LoadInt32(&jobPool.gauges.reservedSlots) > 0 || atomic.LoadInt32(&jobPool.raisedBarriers) > 0 || atomic.LoadInt32(&jobPool.outstanding.barrier) > 0 {
+		return false
+	}
+
+	if jobPool.concurrency.tryAcquire(jobPool.liveRoutines()) == false {
+		return false
+	}
+	defer jobPool.concurrency.release()
+
+	queueJob.setState(jobClaimed)
+	queueJob.enqueuedAt = time.Now()
+	queueJob.firstEnqueuedAt = queueJob.enqueuedAt
+	queueJob.id = atomic.AddUint64(&jobPool.jobSequence, 1)
+
+	jobPool.outstanding.admit(queueJob)
+	jobPool.trackJob(queueJob)
+	jobPool.admitGroupJob(queueJob)
+	jobPool.dequeueGroupJob(queueJob)
+
+	if queueJob.admissionInfo != nil {
+		*queueJob.admissionInfo = AdmissionInfo{
+			ID:        queueJob.id,
+			CallerRan: true,
+		}
+	}
+
+	atomic.AddInt64(&jobPool.inlineRuns, 1)
+
+	jobPool.runJob(queueJob, CallerRoutine)
+	return true
+}
@@ -0,0 +1,140 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// behaviorJob succeeds, fails or panics as told and records the JobMeta it was run with.
+	behaviorJob struct {
+		behavior string  // What the job does: succeed, fail or panic.
+		meta     JobMeta // The JobMeta found in the job's context.
+		logger   bool    // If the job's context carried a logger.
+	}
+
+	// runObservation is what a run of a job looked like from the outside.
+	runObservation struct {
+		Processed     int64             // The runs counted for the job type.
+		Errors        int64             // The errors counted for the job type.
+		Panics        int64             // The panics counted for the job type.
+		Outcome       JobOutcome        // The outcome recorded in the history.
+		Failed        bool              // If the history recorded an error.
+		Metadata      map[string]string // The metadata recorded in the history.
+		Reported      int               // The panics handed to the PanicHandler.
+		ReportedJob   string            // The job named in the panic report.
+		ReportedMeta  map[string]string // The metadata in the panic report.
+		Attempt       int               // The attempt the job saw in its JobMeta.
+		ContextMeta   map[string]string // The metadata the job saw in its JobMeta.
+		ContextHasLog bool              // If the job's context carried a logger.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Name names the job after its behavior.
+func (behaviorJob *behaviorJob) Name() string {
+	return "behavior-" + behaviorJob.behavior
+}
+
+// RunJob is never called since the job is a ContextJobber.
+func (behaviorJob *behaviorJob) RunJob(jobRoutine int) {}
+
+// RunJobContext records the JobMeta and behaves as told.
+func (behaviorJob *behaviorJob) RunJobContext(ctx context.Context, jobRoutine int) error {
+	behaviorJob.meta = MetaFromContext(ctx)
+	behaviorJob.logger = LoggerFromContext(ctx) != nil
+
+	switch behaviorJob.behavior {
+	case "fail":
+		return errors.New("Job Failed")
+	case "panic":
+		panic("Job Panicked")
+	default:
+		return nil
+	}
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestRunInlineMatchesJobRoutine runs the same cases through RunInline and through a job
+// routine and checks the stats, history, panic reports and job context come out the same.
+func TestRunInlineMatchesJobRoutine(t *testing.T) {
+	runners := map[string]func(t *testing.T, jobPool *JobPool, job *behaviorJob, metadata map[string]string){
+		"RunInline": func(t *testing.T, jobPool *JobPool, job *behaviorJob, metadata map[string]string) {
+			err := jobPool.RunInline(context.Background(), job, WithMetadata(metadata))
+			if (err != nil) != (job.behavior != "succeed") {
+				t.Fatalf("RunInline : %v", err)
+			}
+		},
+		"JobRoutine": func(t *testing.T, jobPool *JobPool, job *behaviorJob, metadata map[string]string) {
+			if err := jobPool.QueueJob("test", job, false, WithMetadata(metadata)); err != nil {
+				t.Fatalf("QueueJob : %v", err)
+			}
+
+			waitFor(t, 5*time.Second, "the job to run", func() bool {
+				return len(jobPool.Stats().History) == 1
+			})
+		},
+	}
+
+	for _, behavior := range []string{"succeed", "fail", "panic"} {
+		t.Run(behavior, func(t *testing.T) {
+			observations := make(map[string]runObservation)
+			for name, run := range runners {
+				var mutex sync.Mutex
+				var reports []PanicInfo
+				jobPool := newTestPool(t, 1, 10, WithHistory(10), WithPanicHandler(func(panicInfo PanicInfo) {
+					mutex.Lock()
+					reports = append(reports, panicInfo)
+					mutex.Unlock()
+				}))
+
+				job := &behaviorJob{behavior: behavior}
+				run(t, jobPool, job, map[string]string{"key": "value"})
+
+				stats := jobPool.Stats()
+				jobTypeStats := stats.JobTypes[job.Name()]
+				observation := runObservation{
+					Processed:     jobTypeStats.Processed,
+					Errors:        jobTypeStats.Errors,
+					Panics:        jobTypeStats.Panics,
+					Outcome:       stats.History[0].Outcome,
+					Failed:        stats.History[0].Error != "",
+					Metadata:      stats.History[0].Metadata,
+					Attempt:       job.meta.Attempt,
+					ContextMeta:   job.meta.Metadata,
+					ContextHasLog: job.logger,
+				}
+
+				mutex.Lock()
+				observation.Reported = len(reports)
+				if len(reports) > 0 {
+					observation.ReportedJob = reports[0].JobName
+					observation.ReportedMeta = reports[0].Metadata
+				}
+				mutex.Unlock()
+
+				if observation.Processed != 1 {
+					t.Fatalf("%s : Processed[%d]", name, observation.Processed)
+				}
+
+				observations[name] = observation
+			}
+
+			if reflect.DeepEqual(observations["RunInline"], observations["JobRoutine"]) == false {
+				t.Fatalf("RunInline and a job routine differ :\nRunInline  %+v\nJobRoutine %+v", observations["RunInline"], observations["JobRoutine"])
+			}
+		})
+	}
+}
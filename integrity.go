@@ -0,0 +1,296 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// Disposition names how a job admitted to the pool left it, see WithIntegrityChecks.
+	Disposition int32
+
+	// ViolationKind names what the integrity checks found wrong with a job.
+	ViolationKind int
+
+	// IntegrityViolation describes a job the integrity checks found unaccounted for or accounted
+	// for twice.
+	IntegrityViolation struct {
+		Kind        ViolationKind // What is wrong.
+		Sequence    uint64        // The sequence number the job was given when it was admitted.
+		JobID       uint64        // The ID of the job.
+		Job         string        // The name of the job.
+		State       JobState      // Where the job was in its life when the violation was found.
+		Recorded    Disposition   // The disposition recorded for the job first. DispositionNone for a gap.
+		Disposition Disposition   // The disposition recorded for the job again. DispositionNone for a gap.
+	}
+
+	// integrityChecker hands every admitted job a sequence number and holds the jobs whose
+	// sequence has not been accounted for yet.
+	integrityChecker struct {
+		sequence uint64                                      // The last sequence number handed out.
+		pending  map[uint64]*queueJob                        // The admitted jobs not accounted for yet by sequence number.
+		violated func(integrityViolation IntegrityViolation) // Reports a violation.
+		mutex    sync.Mutex                                  // Protects the sequence and the pending jobs.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// DispositionNone is a job that has not been accounted for.
+	DispositionNone Disposition = iota
+
+	// DispositionCompleted is a job that ran and was not retried, whether it succeeded or not.
+	DispositionCompleted
+
+	// DispositionRejected is a job refused after it was admitted, such as a job withdrawn by a
+	// QueueJobContext whose context was done before the job started.
+	DispositionRejected
+
+	// DispositionEvicted is a job evicted by the DropOldest overflow policy.
+	DispositionEvicted
+
+	// DispositionCancelled is a job cancelled before it started, such as by Cancel,
+	// CancelPending, CancelGroup or a transaction of UpdatePending.
+	DispositionCancelled
+
+	// DispositionAbandoned is a job left pending, waiting to be retried or running on an
+	// abandoned job routine when the pool was shut down.
+	DispositionAbandoned
+
+	// DispositionTransferred is a job moved to another pool by TransferPending.
+	DispositionTransferred
+)
+
+const (
+	// ViolationGap is a job that left the pool, or was still in it once the pool was shut down,
+	// without being accounted for.
+	ViolationGap ViolationKind = iota
+
+	// ViolationDouble is a job accounted for a second time.
+	ViolationDouble
+)
+
+//** VARIABLES
+
+var (
+	// dispositionNames holds the name of each disposition.
+	dispositionNames = map[Disposition]string{
+		DispositionNone:        "None",
+		DispositionCompleted:   "Completed",
+		DispositionRejected:    "Rejected",
+		DispositionEvicted:     "Evicted",
+		DispositionCancelled:   "Cancelled",
+		DispositionAbandoned:   "Abandoned",
+		DispositionTransferred: "Transferred",
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithIntegrityChecks is meant for staging. Every job admitted to the pool is given a sequence
+// number and every way a job leaves the pool accounts for its sequence exactly once: completed,
+// rejected after admission, evicted, cancelled, abandoned at shutdown or transferred. A job that
+// leaves without being accounted for, is accounted for twice or is still unaccounted for once
+// the pool is shut down is logged as an error and passed to onViolation, which can be nil. The
+// callback is run on its own routine. The checks take a lock on every admission and every exit.
+func WithIntegrityChecks(onViolation func(integrityViolation IntegrityViolation)) Option {
+	return func(config *Config) {
+		config.IntegrityChecks = true
+		config.OnIntegrityViolation = onViolation
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// String returns the name of the disposition.
+func (disposition Disposition) String() string {
+	if name, found := dispositionNames[disposition]; found == true {
+		return name
+	}
+
+	return "Unknown"
+}
+
+// String returns the name of the kind of violation.
+func (violationKind ViolationKind) String() string {
+	switch violationKind {
+	case ViolationGap:
+		return "Gap"
+	case ViolationDouble:
+		return "Double"
+	default:
+		return "Unknown"
+	}
+}
+
+// String describes the violation for logs.
+func (integrityViolation IntegrityViolation) String() string {
+	return fmt.Sprintf("Kind[%s] Sequence[%d] ID[%d] Job[%s] State[%s] Recorded[%s] Disposition[%s]", integrityViolation.Kind, integrityViolation.Sequence, integrityViolation.JobID, integrityViolation.Job, integrityViolation.State, integrityViolation.Recorded, integrityViolation.Disposition)
+}
+
+//** PRIVATE FUNCTIONS
+
+// newIntegrityChecker creates the checker or returns nil if the pool has no integrity checks.
+func newIntegrityChecker(config Config) *integrityChecker {
+	if config.IntegrityChecks == false {
+		return nil
+	}
+
+	return &integrityChecker{
+		pending: make(map[uint64]*queueJob),
+	}
+}
+
+// newIntegrityViolation describes a violation found for the job.
+func newIntegrityViolation(violationKind ViolationKind, queueJob *queueJob, recorded Disposition, disposition Disposition) IntegrityViolation {
+	return IntegrityViolation{
+		Kind:        violationKind,
+		Sequence:    queueJob.sequence,
+		JobID:       queueJob.id,
+		Job:         queueJob.name,
+		State:       handleState(atomic.LoadInt32(&queueJob.state)),
+		Recorded:    recorded,
+		Disposition: disposition,
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// trackJob gives a job admitted to the pool its sequence number. It is only called by the queue
+// routine.
+func (jobPool *JobPool) trackJob(queueJob *queueJob) {
+	if jobPool.integrity == nil {
+		return
+	}
+
+	jobPool.integrity.admit(queueJob)
+}
+
+// checkIntegrity runs once the pool is shut down. A job still running on an abandoned job
+// routine is accounted for as abandoned and every job not accounted for that is not running
+// is a gap.
+func (jobPool *JobPool) checkIntegrity(abandonedWorkers bool) {
+	if jobPool.integrity == nil {
+		return
+	}
+
+	for _, queueJob := range jobPool.integrity.unaccounted() {
+		// A running job left after the job routines are down was run by its submitter and is
+		// accounted for once it returns, unless job routines were abandoned with their jobs.
+		if atomic.LoadInt32(&queueJob.state) == jobRunning {
+			if abandonedWorkers == true {
+				queueJob.dispose(jobRunning, DispositionAbandoned)
+			}
+			continue
+		}
+
+		jobPool.integrity.violated(newIntegrityViolation(ViolationGap, queueJob, DispositionNone, DispositionNone))
+	}
+}
+
+// reportViolation logs a violation and hands it to the OnIntegrityViolation callback.
+func (jobPool *JobPool) reportViolation(integrityViolation IntegrityViolation) {
+	atomic.AddInt64(&jobPool.integrityViolations, 1)
+
+	jobPool.writeLogf(LogError, "Integrity", "reportViolation", "ERROR : Integrity Violation : %s", integrityViolation)
+
+	if jobPool.config.OnIntegrityViolation == nil {
+		return
+	}
+
+	go jobPool.callbackSafely("Integrity", "OnIntegrityViolation", func() {
+		jobPool.config.OnIntegrityViolation(integrityViolation)
+	})
+}
+
+// dispose moves the job from a state to the state it ends in with the disposition and accounts
+// for it. It returns false if the job was not in the from state, in which case another path has
+// already won the job.
+func (queueJob *queueJob) dispose(from int32, disposition Disposition) bool {
+	state := jobCancelled
+	if disposition == DispositionCompleted {
+		state = jobDone
+	}
+
+	if atomic.CompareAndSwapInt32(&queueJob.state, from, state) == false {
+		return false
+	}
+
+	queueJob.account(disposition)
+	queueJob.reportState(state)
+	queueJob.endHandoff(state, disposition)
+	return true
+}
+
+// account records how the job left the pool. A job that leaves the pool without its state
+// changing, such as a transferred job, is accounted for directly.
+func (queueJob *queueJob) account(disposition Disposition) {
+	if queueJob.integrity == nil {
+		return
+	}
+
+	queueJob.integrity.account(queueJob, disposition)
+}
+
+// checkAccounted reports a gap for a job that ended without being accounted for.
+func (queueJob *queueJob) checkAccounted() {
+	if queueJob.integrity == nil || atomic.LoadInt32(&queueJob.disposition) != int32(DispositionNone) {
+		return
+	}
+
+	queueJob.integrity.violated(newIntegrityViolation(ViolationGap, queueJob, DispositionNone, DispositionNone))
+}
+
+// admit gives the job the next sequence number and holds it until it is accounted for. A job
+// transferred from another pool starts over.
+func (integrityChecker *integrityChecker) admit(queueJob *queueJob) {
+	integrityChecker.mutex.Lock()
+	defer integrityChecker.mutex.Unlock()
+
+	integrityChecker.sequence++
+
+	queueJob.integrity = integrityChecker
+	queueJob.sequence = integrityChecker.sequence
+	atomic.StoreInt32(&queueJob.disposition, int32(DispositionNone))
+
+	integrityChecker.pending[queueJob.sequence] = queueJob
+}
+
+// account records the disposition of the job and reports a job accounted for a second time.
+func (integrityChecker *integrityChecker) account(queueJob *queueJob, disposition Disposition) {
+	if atomic.CompareAndSwapInt32(&queueJob.disposition, int32(DispositionNone), int32(disposition)) == false {
+		recorded := Disposition(atomic.LoadInt32(&queueJob.disposition))
+		integrityChecker.violated(newIntegrityViolation(ViolationDouble, queueJob, recorded, disposition))
+		return
+	}
+
+	integrityChecker.mutex.Lock()
+	delete(integrityChecker.pending, queueJob.sequence)
+	integrityChecker.mutex.Unlock()
+}
+
+// unaccounted returns the jobs not accounted for in the order they were admitted.
+func (integrityChecker *integrityChecker) unaccounted() []*queueJob {
+	integrityChecker.mutex.Lock()
+	defer integrityChecker.mutex.Unlock()
+
+	queueJobs := make([]*queueJob, 0, len(integrityChecker.pending))
+	for _, queueJob := range integrityChecker.pending {
+		queueJobs = append(queueJobs, queueJob)
+	}
+
+	sort.Slice(queueJobs, func(i, j int) bool {
+		return queueJobs[i].sequence < queueJobs[j].sequence
+	})
+
+	return queueJobs
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// ErrorRecord describes a job that returned an error or panicked.
+	ErrorRecord struct {
+		Time     time.Time         `json:"time"`               // When the job returned or panicked.
+		JobID    uint64            `json:"job_id"`             // The number given to the job when it was first queued.
+		Job      string            `json:"job"`                // The name of the job or its type.
+		TraceID  string            `json:"trace_id,omitempty"` // The trace ID of the job.
+		Attempt  int               `json:"attempt"`            // The attempt that failed. The first run of a job is attempt 1.
+		Panicked bool              `json:"panicked"`           // If the job panicked rather than returning an error.
+		Error    string            `json:"error"`              // The error or panic message.
+		Metadata map[string]string `json:"metadata,omitempty"` // The key/value pairs attached to the job with WithMetadata.
+		Err      error             `json:"-"`                  // The error the job returned or the recovered panic.
+	}
+
+	// errorRing is a ring of the most recent error records.
+	errorRing struct {
+		records []ErrorRecord // The ring of records.
+		next    int           // Where the next record is written.
+		full    bool          // If the ring has wrapped around.
+		mutex   sync.Mutex    // Protects the ring.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// LastError returns the error of the most recent job that failed or panicked. It returns nil
+// if no job has failed or the pool was not created with WithErrorHistory.
+func (jobPool *JobPool) LastError() error {
+	errorRecords := jobPool.RecentErrors(1)
+	if len(errorRecords) == 0 {
+		return nil
+	}
+
+	return errorRecords[0].Err
+}
+
+// RecentErrors returns a copy of up to n of the most recent error records, oldest first. It
+// returns nil unless the pool was created with WithErrorHistory.
+func (jobPool *JobPool) RecentErrors(n int) []ErrorRecord {
+	return jobPool.errors.snapshot(n)
+}
+
+//** PRIVATE FUNCTIONS
+
+// newErrorRing creates a ring holding size records. It returns nil, meaning no errors are kept,
+// when size is not positive.
+func newErrorRing(size int) *errorRing {
+	if size <= 0 {
+		return nil
+	}
+
+	return &errorRing{
+		records: make([]ErrorRecord, size),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// recordError adds a job that failed or panicked to the ring.
+func (jobPool *JobPool) recordError(queueJob *queueJob, ended time.Time, err error, panicked bool) {
+	if jobPool.errors == nil || err == nil {
+		return
+	}
+
+	jobPool.errors.add(ErrorRecord{
+		Time:     ended,
+		JobID:    queueJob.id,
+		Job:      queueJob.name,
+		TraceID:  queueJob.traceID,
+		Attempt:  queueJob.attempts,
+		Panicked: panicked,
+		Metadata: queueJob.metadata,
+		Error:    err.Error(),
+		Err:      err,
+	})
+}
+
+// add writes a record over the oldest one once the ring is full.
+func (errorRing *errorRing) add(errorRecord ErrorRecord) {
+	errorRing.mutex.Lock()
+	defer errorRing.mutex.Unlock()
+
+	errorRing.records[errorRing.next] = errorRecord
+
+	errorRing.next++
+	if errorRing.next == len(errorRing.records) {
+		errorRing.next = 0
+		errorRing.full = true
+	}
+}
+
+// snapshot returns a copy of up to n of the most recent records, oldest first.
+func (errorRing *errorRing) snapshot(n int) []ErrorRecord {
+	if errorRing == nil || n <= 0 {
+		return nil
+	}
+
+	errorRing.mutex.Lock()
+	defer errorRing.mutex.Unlock()
+
+	held := errorRing.next
+	if errorRing.full == true {
+		held = len(errorRing.records)
+	}
+
+	if n > held {
+		n = held
+	}
+
+	// Copy the last n records in the order they were written.
+	errorRecords := make([]ErrorRecord, n)
+	for index := range errorRecords {
+		slot := (errorRing.next - n + index + len(errorRing.records)) % len(errorRing.records)
+		errorRecords[index] = errorRing.records[slot]
+	}
+
+	return errorRecords
+}
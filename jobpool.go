@@ -19,6 +19,74 @@ The following is a list of parameters for creating a JobPool:
 	numberOfRoutines: Sets the number of job routines that are allowed to process jobs concurrently
 	queueCapacity:    Sets the maximum number of pending job objects that can be in queue
 
+The following is a list of options that can be passed to New:
+
+	WithAbandonStuckWorkers: Sets how long DrainWithDeadline waits for stuck job routines before abandoning them
+	WithAsyncIntake:         Sets the size of the buffer used by QueueJobAsync
+	WithBackoff:             Sets the strategy that decides the delay before each retry
+	WithClock:               Sets the clock used for delays and other timed work
+	WithContinuationBudget:  Lets jobs queued with Continue take the queue over its capacity
+	WithControlBuffers:      Sets the buffers in front of the queue routine for submitters and job routines
+	WithDeadLetter:          Sets the handler that receives the jobs that have failed for good
+	WithDedupCooldown:       Refuses a key queued with QueueJobUnique for a window after its job completed
+	WithDepthSampler:        Samples the queue depth into a ring for DepthSummary and DepthSamples
+	WithDrainJobEstimate:    Sets how long before its deadline DrainWithDeadline stops handing out jobs
+	WithErrorClassifier:     Decides if a failed job is retried, dead lettered or fails its group
+	WithErrorHistory:        Keeps the most recent job errors and panics for RecentErrors and LastError
+	WithFairQueuing:         Interleaves the jobs of different tenants instead of serving the queue strictly in order
+	WithFaultInjector:       Injects faults at the admission, dequeue and run points for tests
+	WithHistory:             Keeps a record of the most recent jobs
+	WithIntegrityChecks:     Checks every admitted job leaves the pool accounted for exactly once
+	WithLockOSThread:        Locks the OS thread of a job routine while it runs jobs
+	WithLogLevel:            Sets the lowest level of internal message that is written
+	WithLogger:              Sets the logger that receives the pool's internal messages
+	WithManager:             Registers the pool with a manager other than the default manager
+	WithMaxJobTypes:         Sets the number of job types given their own counters in Stats
+	WithMaxQueueBytes:       Limits the total size in bytes of the pending jobs
+	WithMemoryGuard:         Refuses normal jobs while the heap is over a soft limit
+	WithMissedRunPolicy:     Decides what cron schedules do about missed firings
+	WithName:                Sets the name of the pool
+	WithOverflowPolicy:      Sets whether a full queue rejects the new job, evicts the oldest normal job or runs the new job in the caller
+	WithPanicHandler:        Sets the handler that receives a report for every recovered panic
+	WithPanicPolicy:         Sets whether a panic is recovered, raised again or aborts the process
+	WithPrefetch:            Has each job routine ask for its next job while it runs the current one
+	WithPriorityFreshness:   Sets whether a batch of jobs yields to a priority job that arrives
+	WithPriorityFunc:        Sets the function that decides the priority of each job in place of the priority asked for
+	WithQuarantine:          Quarantines a job type that keeps panicking until Unquarantine is called
+	WithQueueEmpty:          Sets callbacks for the queue becoming empty and non empty
+	WithQueueLatencyAlert:   Reports jobs that waited in queue longer than a threshold
+	WithRampUp:              Raises the concurrency limit step by step after the pool starts
+	WithRejectionHandler:    Sets a handler that is called for every job the pool could not admit
+	WithReleaseErrorHandler: Sets the handler that receives the errors of releasing jobs that never ran
+	WithRequeueOnPanic:      Places a job that panicked back in its queue a limited number of times
+	WithRetry:               Places a job that returned an error back in its queue a limited number of times
+	WithRetryBudget:         Limits the number of retries across the pool over an interval
+	WithRetryPriorityBoost:  Places retries in the priority queue with a cap on their share of dequeues
+	WithRuntimeTrace:        Wraps each job in a runtime/trace task while a trace is collected
+	WithSharedBackend:       Shares a durable backend with pools in other processes in place of the pool's own queue
+	WithSlowMessageWarning:  Logs a warning when the queue routine takes too long on a single message
+	WithStackCapture:        Sets the size and scope of the stack traces captured for panics
+	WithStatsInterval:       Emits a Stats snapshot on an interval until the pool is shut down
+	WithStrictFIFO:          Requires settings that run normal jobs in the order they were admitted
+	WithTagReservations:     Guarantees each tag a minimum number of job routines
+	WithTenantCapacity:      Sets the maximum number of pending jobs a single tenant can hold
+	WithValidator:           Rejects malformed jobs when they are queued
+	WithWatermarks:          Sets callbacks for when the queue rises above and falls back under a depth
+	WithoutManager:          Keeps the pool from registering with the default manager
+
+DrainWithDeadline stops admissions and keeps the job routines working until the time left before the context's deadline
+drops under the DrainJobEstimate, then shuts the pool down and reports what completed and what was abandoned.
+With WithAbandonStuckWorkers it stops waiting for job routines whose job has not returned after a grace period and
+reports each one with its job and stack in ShutdownReport.AbandonedWorkers, so one job blocked forever can't hang the exit.
+
+Every pool registers with DefaultManager unless it is created with WithManager or WithoutManager. A Manager combines the
+Stats of its pools and shuts them down together with ShutdownAll, stopping upstream pools declared with DependsOn first.
+
+The same settings are captured by the Config type. Defaults returns a starting Config and Validate reports
+settings that are out of range or contradict each other. NewFromConfig creates a pool from a Config and
+CloneConfig returns the Config of an existing pool so a second pool with identical settings can be
+created, for example to drain one pool while a replacement takes over.
+
 JobPool Management
 
 Go routines are used to manage and process all the jobs. A single Queue routine provides the safe queuing of work.
@@ -30,6 +98,124 @@ subbmitted to the queue. The job routines keep track of the number of active job
 The QueueJob method is used to queue a job into one of the two queues. This call will block until the Queue routine reports back
 success or failure that the job is in queue.
 
+The QueueJobAsync method takes a slot in the queue and places the job in a buffered intake channel without waiting for the Queue
+routine. The returned JobHandle reports when the job has been admitted and its State follows the job from Submitted
+through Admitted and Running to Done, or to Rejected or Cancelled. Jobs waiting in the intake buffer count against the
+capacity of the queue, so both methods enforce the same limit. Consume feeds the pool from a channel and stops reading
+from it while the queue is full.
+
+Both methods accept JobOption values. WithTenant names the tenant a job is queued for. With the WithFairQueuing option each
+tenant has its own priority and normal queue and the Queue routine serves the tenants in turn, so one tenant with thousands
+of pending jobs can't hold up the others. Priority jobs are still served first within their tenant. The WithTenantCapacity option limits the number of pending jobs
+any one tenant can hold, independent of fair queuing and below the capacity of the queue.
+
+The WithMemoryGuard option is a safety valve for job types that don't implement Sizer. A routine samples the heap and while
+it is over the soft limit new normal jobs are refused with ErrMemoryGuarded and priority jobs are still admitted. The guard
+releases once the heap falls under the low limit, so it doesn't flap, and Stats reports its state.
+
+A job that implements Releaser, or io.Closer, is released once when it is dropped without running: rejected, evicted,
+cancelled, purged, abandoned at shutdown or handed to the dead letter handler. Errors go to the handler set with
+WithReleaseErrorHandler or are logged. A submitter that offers a job again after the queue was full queues it with
+WithRetainOnReject so it is not released.
+
+The WithPriorityFunc option decides the priority of each job as it is queued in place of the priority the submitter asked
+for, so a class of jobs can be made priority without changing the call sites. SetPriorityFunc replaces or removes it while
+the pool runs. The priority a job was queued with is reported by its AdmissionInfo and the overrides are counted in Stats.
+
+QueueJobWait blocks while the queue is full instead of rejecting the job. The waiting submitters are admitted one per freed
+slot in the order they arrived and other submissions are refused while any of them wait, so none can cut in front. A job
+that calls QueueJobWait from its job routine never waits, as all the routines could end up waiting on each other, and is
+refused with ErrWouldDeadlock once the continuation budget is used up.
+
+A running ContextJobber queues the next phase of its work with Continue and the context it was given. A continuation goes
+ahead of the waiting submitters and, with the WithContinuationBudget option, is admitted even while the queue is at capacity
+up to the budget, so a workflow isn't left half done because the queue filled up between its phases.
+
+Every rejection is a *RejectError, which the submitter and the RejectionHandler both receive. It unwraps to the exported
+sentinel for the reason, so errors.Is(err, ErrPoolAtCapacity) still works, and it implements Rejection: Reason names the
+cause and Details holds the state of the pool that caused it, such as the queue depth against its capacity or the
+tenant's pending jobs against its quota.
+
+WithTag tags a job and the WithTagReservations option guarantees each tag a minimum number of job routines. A job
+routine is not handed a job that would leave too few routines for a tag with pending jobs to reach its minimum, while a
+reservation the tag isn't using is lent out and reclaimed as the borrowed routines finish their jobs.
+
+RunInline runs a job on the calling routine through the same wrapper as the job routines without touching the queues, for
+a tool that shares its job types with a service. Panics are recovered and reported, the run is counted in Stats and the
+histories, and the job's error is returned instead of being retried. WithConcurrencyPermit makes it count against the
+concurrency limit.
+
+WithTraceID sets the trace ID carried by the logger a LoggerJobber receives or a ContextJobber finds with LoggerFromContext.
+WithMetadata attaches key/value pairs that are carried the same way and show up in the queue dump, the worker stats, the
+history, the error history and panic reports. A ContextJobber reads them from the JobMeta returned by MetaFromContext.
+
+WithGroup adds a job to a named group. Jobs can be added to a group over time. CancelGroup removes the group's pending jobs
+from the queues and WaitGroupDone blocks until every job added to the group has completed or been cancelled. A group is
+forgotten once it has no outstanding jobs.
+
+Every QueueJob waits while the Queue routine handles a message, so Stats.QueueLoop reports how long the Queue routine took
+on each type of message over the last minute, its max and p99, and the backlog of messages it has not picked up. The
+WithSlowMessageWarning option logs every message that takes longer than a threshold, such as a large purge.
+
+The WithDepthSampler option samples the queue depth and the running jobs on an interval into a fixed ring, so bursts that
+drain between scrapes aren't missed. DepthSummary returns the lowest, highest and average over a window, such as the peak
+depth over the last hour, and DepthSamples returns the samples themselves.
+
+The WithFaultInjector option hands a FaultInjector the pool's admission, dequeue and run injection points, which are inert
+without one. The jobpooltest package uses them to refuse or delay submissions, make a type of job panic and freeze dequeues
+on a real pool from test code.
+
+The WithIntegrityChecks option, meant for staging, gives every admitted job a sequence number and checks that each one is
+accounted for exactly once as completed, rejected after admission, evicted, cancelled, abandoned at shutdown or
+transferred. A job that leaves the pool unaccounted for, is accounted for twice or is still unaccounted for once the pool
+is shut down is logged as an error and passed to the callback.
+
+UpdatePending runs a function as a transaction over the pending jobs for tools that reorder work by rules the pool can't
+know. The PendingTx lists the jobs and removes, reprioritizes or moves them to the front of their queue. Nothing is queued
+or dequeued until the function returns, its changes are applied together, and a function that overruns its context is
+abandoned without changing anything.
+
+WaitForNone blocks until no job picked by a predicate is pending, running or waiting to be retried, for example before a
+migration that must not race a type of job. It is woken by the jobs finishing rather than by polling. Matching jobs
+admitted during the wait extend it unless WithBarrier is passed, which refuses them with ErrBarrier until the wait ends.
+
+QueueBarrier places a barrier between the jobs admitted before it and after it. The later jobs, priority jobs included,
+wait until every earlier job has completed or been cancelled, so one pool can run phased work such as loading every
+document before indexing any of them.
+
+Child creates a view of the pool with its own concurrency and pending limits, set with WithMaxConcurrency and WithMaxPending.
+Jobs queued through a child run on the parent's job routines and use the parent's queue capacity. Shutting down a child only
+cancels the child's jobs that have not started. Shutting down the parent shuts down every child.
+
+RetireWorker takes a single job routine out of rotation once it finishes its current job, leaving the others running, and
+AddWorker starts a replacement under a new ID. WorkerStats marks the routines that were retired.
+
+SetConcurrencyLimit caps the jobs the whole pool runs at the same time without stopping any job routine, for example to
+throttle while a downstream service is degraded, and can be raised or removed again at any time. WithRampUp starts the
+limit at one job and raises it by a step each interval until every job routine is in use, so a cold downstream service
+is not hit by a burst. RampUp starts the ramp again, FinishRamp ends it early and setting a limit by hand stops it.
+
+ScheduleCron queues a job each time a five field cron spec fires, measured against the pool's clock. Firings that could not
+queue the job or were slept through are counted by the Schedule and handled by the MissedRunPolicy. ScheduleStates and RestoreSchedules carry the schedules across a restart.
+
+QueueJobUnique queues a job under a key and returns ErrDuplicateJob while a job with the same key is pending or running.
+With WithDedupCooldown the key is also refused with ErrRecentlyProcessed for a window after its job completed, so a
+webhook delivered again just after it was handled is not handled twice. A job that implements Coalescer is merged into
+the pending job holding its key instead, keeping that job's place in the queue, and QueueJobUniqueAsync returns a handle
+whose Done channel is closed once the merged job finishes.
+
+RegisterJobType names a job type so its jobs can be serialized with MarshalJob and queued again later with Replay.
+
+FeedBackend queues the jobs of a Backend, such as the jobpoolsqlite package or the in memory MemoryBackend, in the pool.
+Each job is handed out under a lease that the pool extends while it holds the job, acknowledges once the job is done
+and releases if the job is cancelled. A job whose lease expires because its process died is handed out again and reads
+its Deliveries from its JobMeta.
+
+WithSharedBackend lets pools in different processes share one Backend. QueueJob puts each job without options in the
+backend, which owns the capacity, and every pool takes a job from it whenever it has a job routine to spare, so the jobs
+are split between the pools. QueuedJobs reports the depth of the backend while Stats also reports the jobs pending in the
+pool itself. A failing backend pauses the pool, which asks again after a longer wait each time.
+
 Example Use Of JobPool
 
 The following shows a simple test application
@@ -100,11 +286,17 @@ package jobpool
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 //** TYPES
@@ -112,30 +304,200 @@ import (
 type (
 	// queueJob is a control structure for queuing jobs.
 	queueJob struct {
-		Jobber                   // The object to execute the job routine against.
-		priority      bool       // If the job needs to be placed on the priority queue.
-		resultChannel chan error // Used to inform the queue operaion is complete.
+		Jobber                            // The object to execute the job routine against.
+		name            string            // The name of the job from Namer or its type, worked out once when it is queued.
+		id              uint64            // The number given to the job when it is first queued.
+		traceID         string            // The trace ID the job's logger tags its messages with.
+		metadata        map[string]string // The key/value pairs attached with WithMetadata.
+		size            int64             // The SizeBytes of the job from Sizer, worked out once when it is queued.
+		gangSize        int               // The number of job routines the job needs at the same time.
+		priority        bool              // If the job needs to be placed on the priority queue.
+		tenant          string            // The tenant the job is queued for.
+		tag             string            // The tag the job counts against for a reservation, see WithTag.
+		tagDispatched   bool              // Set while the job holds a job routine counted against its tag.
+		group           string            // The group the job belongs to.
+		key             string            // The key the job was queued under with QueueJobUnique.
+		outstanding     *outstandingJobs  // The in-flight registry holding the job once it is admitted.
+		integrity       *integrityChecker // The integrity checker holding the job once it is admitted, see WithIntegrityChecks.
+		sequence        uint64            // The sequence number given to the job by the integrity checker.
+		disposition     int32             // How the job left the pool, see Disposition. Set once with compare and swap.
+		child           *ChildPool        // The child pool the job was queued through.
+		enqueuedAt      time.Time         // When the job was placed in the queue.
+		attempts        int               // The number of times the job has been started.
+		deliveries      int               // The number of times a backend has handed the job out, see FeedBackend.
+		lastError       error             // Why the previous attempt failed.
+		firstEnqueuedAt time.Time         // When the job was first placed in the queue.
+		front           bool              // If the job is placed at the front of its queue.
+		callerRuns      bool              // If the job is run by its submitter when the queue is at capacity.
+		inlineIfIdle    bool              // If the job is run by its submitter when the pool is idle.
+		waitForSlot     bool              // If the submitter waits for a slot when the queue is at capacity.
+		ticket          uint64            // The job's turn among the submitters waiting for a slot.
+		slotWaiter      *list.Element     // The job's place among the submitters waiting for a slot. Only used by the queue routine.
+		retained        bool              // If the submitter keeps the job when it is refused because the queue is full.
+		continuation    bool              // If the job was queued with Continue and can use the continuation budget.
+		reentrant       bool              // If the job was queued by one of the pool's job routines, which must not wait for a slot.
+		permit          bool              // If RunInline holds a permit of the concurrency limit while the job runs.
+		released        int32             // Set to 1 once the job has been released because it will never run.
+		boosted         bool              // If a retry is placed in the priority queue until it is dequeued.
+		started         chan struct{}     // Closed once a job routine starts the job when the submitter waits for the handoff.
+		ended           chan struct{}     // Closed once the job is cancelled when the submitter waits for the handoff.
+		endedAs         Disposition       // How the job was cancelled, set before ended is closed.
+		resultChannel   chan error        // Used to inform the queue operaion is complete.
+		admission       int32             // Decides between the queue routine admitting the job and its submitter withdrawing it.
+		state           int32             // Where the job is in its life, see jobPending. Moved on with compare and swap.
+		handle          *JobHandle        // Used to inform an asynchronous submitter the queue operation is complete.
+		followers       []*JobHandle      // The handles of the asynchronous submissions coalesced into the job.
+		admissionInfo   *AdmissionInfo    // Receives where the job was placed when the submitter asked for it.
+		tenantQueue     *tenantQueue      // The tenant queues the job is in while pending.
+		queue           *list.List        // The list the job is in while pending.
+		element         *list.Element     // The job's element in the list while pending.
 	}
 
 	// dequeueJob is a control structure for dequeuing jobs.
 	dequeueJob struct {
 		ResultChannel chan *queueJob // Used to return the queued job to be processed.
+		prefetch      bool           // If the job is held for a job routine still running its current job.
+	}
+
+	// cancelPending is a control structure for emptying the queues.
+	cancelPending struct {
+		priority      bool              // If the priority queue should be emptied.
+		normal        bool              // If the normal queue should be emptied.
+		resultChannel chan []*list.List // Used to return the detached queues.
+	}
+
+	// queueTask is a control structure for running a function inside the queue routine.
+	queueTask struct {
+		task          func()        // The function to run with access to the queues.
+		resultChannel chan struct{} // Used to inform the function has run.
 	}
 
 	// JobPool maintains queues and Go routines for processing jobs.
 	JobPool struct {
-		priorityJobQueue     *list.List       // The priority job queue.
-		normalJobQueue       *list.List       // The normal job queue.
-		queueChannel         chan *queueJob   // Channel allows the thread safe placement of jobs into the queue.
-		dequeueChannel       chan *dequeueJob // Channel allows the thread safe removal of jobs from the queue.
-		shutdownQueueChannel chan string      // Channel used to shutdown the queue routine.
-		jobChannel           chan string      // Channel to signal to a job routine to process a job.
-		shutdownJobChannel   chan struct{}    // Channel used to shutdown the job routines.
-		shutdownWaitGroup    sync.WaitGroup   // The WaitGroup for shutting down existing routines.
-		queuedJobs           int32            // The number of pending jobs in queued.
-		activeRoutines       int32            // The number of routines active.
-		queueCapacity        int32            // The max number of jobs we can store in the queue.
+		defaultQueue         *tenantQueue                  // The queues for jobs without a tenant, or every job without fair queuing.
+		tenantQueues         map[string]*tenantQueue       // The queues for each tenant with fair queuing.
+		activeTenants        *list.List                    // The round robin of tenant queues with pending jobs.
+		tenantJobs           map[string]int32              // The number of pending jobs for each tenant.
+		tenantMutex          sync.Mutex                    // Protects tenantJobs.
+		queueChannel         chan *queueJob                // Channel allows the thread safe placement of jobs into the queue.
+		intakeChannel        chan *queueJob                // Buffered channel for jobs that already hold a slot in the queue.
+		dequeueChannel       chan *dequeueJob              // Channel allows the thread safe removal of jobs from the queue.
+		cancelChannel        chan *cancelPending           // Channel allows the thread safe emptying of the queues.
+		taskChannel          chan *queueTask               // Channel allows functions to be run safely against the queues.
+		queueMutex           sync.RWMutex                  // Held for reading by each request with the queue routine and for writing to close it.
+		queueClosed          bool                          // Set once the queue routine no longer takes requests. Protected by queueMutex.
+		groups               map[string]*jobGroup          // The groups with jobs that have not completed.
+		uniqueJobs           map[string]*queueJob          // The pending or running job holding each key queued with QueueJobUnique. Only used by the queue routine.
+		cooldown             *cooldown                     // The keys of unique jobs that completed within the DedupCooldown or nil. Only used by the queue routine.
+		outstanding          *outstandingJobs              // The jobs admitted to the pool that are not done or cancelled, see WaitForNone.
+		barriers             []*barrier                    // The barriers not yet lifted in the order they were queued. Only used by the queue routine.
+		raisedBarriers       int32                         // The number of barriers not yet lifted, read by jobs asking to run inline.
+		heldWakeUps          int                           // The wake ups spent by job routines that found only jobs held back by a barrier. Only used by the queue routine.
+		quarantine           *quarantine                   // The job types taken out of rotation for panicking too often and their parked jobs.
+		parkedJobs           int32                         // The number of jobs parked by a quarantine.
+		tags                 *tagReservations              // The job routines guaranteed to each tag or nil when there are no reservations.
+		depthSampler         *depthSampler                 // The queue depth samples or nil when the pool has no depth sampler.
+		integrity            *integrityChecker             // The admitted jobs not yet accounted for or nil when the pool has no integrity checks.
+		sharedCancel         context.CancelFunc            // Stops the feeding of jobs from the shared backend or nil.
+		groupMutex           sync.Mutex                    // Protects groups.
+		children             []*ChildPool                  // The child pools created from the pool.
+		jobTypes             map[string]*JobTypeStats      // The counters for each type of job.
+		jobTypeMutex         sync.Mutex                    // Protects jobTypes.
+		retryBudget          *retryBudget                  // Limits the number of retries across the pool.
+		scheduledRetries     map[*queueJob]*timerEntry     // The retries waiting on their delay. Nil once Shutdown has abandoned them.
+		runningJobs          map[uint64]context.CancelFunc // Cancels the context of each running ContextJobber by job ID.
+		runningMutex         sync.Mutex                    // Protects runningJobs.
+		prefetchedJobs       map[*queueJob]struct{}        // The jobs prefetched by job routines that have not been picked up.
+		prefetchMutex        sync.Mutex                    // Protects prefetchedJobs.
+		retryMutex           sync.Mutex                    // Protects the scheduled retries.
+		scheduler            *scheduler                    // Runs the timed work of the pool such as delayed retries.
+		schedules            map[*Schedule]struct{}        // The cron schedules that are running.
+		scheduleMutex        sync.Mutex                    // Protects the schedules.
+		history              *jobHistory                   // The most recent jobs or nil when no history is kept.
+		errors               *errorRing                    // The most recent job errors or nil when none are kept.
+		waitTimes            *waitTimes                    // The time jobs waited in queue over the last minute.
+		loopTimes            *loopTimes                    // The time the queue routine took to handle its messages over the last minute.
+		queueEmpty           *queueEmptyState              // Reports the queue becoming empty and non empty or nil without callbacks.
+		jobSequence          uint64                        // The ID given to the last job queued or run inline.
+		boostCredit          float64                       // The share of dequeues earned by boosted retries. Only used by the queue routine.
+		childMutex           sync.Mutex                    // Protects children.
+		shutdownQueueChannel chan string                   // Channel used to shutdown the queue routine.
+		wakeUps              *wakeUps                      // Counts the jobs the job routines can dequeue.
+		concurrency          *concurrencyLimit             // Caps the jobs running at the same time, see SetConcurrencyLimit.
+		ramp                 rampState                     // The ramp up in progress.
+		rampMutex            sync.Mutex                    // Protects the ramp.
+		shutdownStatsChannel chan struct{}                 // Channel used to shutdown the stats reporter.
+		shutdownDone         chan struct{}                 // Closed once the pool has been torn down.
+		shutdownWaitGroup    sync.WaitGroup                // The WaitGroup for shutting down existing routines.
+		gauges               *gauges                       // The counters read on every admission decision.
+		slotWaiters          *list.List                    // The jobs of the submitters waiting for a slot in ticket order. Only used by the queue routine.
+		slotTickets          uint64                        // The ticket given to the last submitter to wait for a slot. Only used by the queue routine.
+		waitingSubmitters    int32                         // The number of submitters waiting for a slot.
+		slotFreed            chan struct{}                 // Tells the queue routine a slot was freed while submitters wait.
+		completedJobs        int32                         // The number of jobs that have run to completion in the life of the pool.
+		completedBaseline    int32                         // The completed jobs at the last ResetStats, which Stats counts from.
+		enqueuedJobs         int64                         // The number of jobs placed in the queues, counting requeues.
+		dequeuedJobs         int64                         // The number of jobs taken from the queues by the job routines.
+		workers              []*workerState                // The counters for each job routine, retired routines included. Protected by workerMutex.
+		workerMutex          sync.RWMutex                  // Protects workers and workersClosed.
+		workersClosed        bool                          // Set once Shutdown has stopped routines being added.
+		routines             int32                         // The number of job routines that have not been retired.
+		workerSlots          *workerSlots                  // Hands the job routines to jobs so a gang can keep routines idle.
+		aboveHighWatermark   int32                         // Set to 1 while the queue is above the high watermark.
+		queueLatencyAlerts   int64                         // The number of jobs that waited longer than MaxAcceptableQueueLatency.
+		evictions            int64                         // The number of jobs evicted by the DropOldest overflow policy.
+		callerRuns           int64                         // The number of jobs run by their submitter under the CallerRuns overflow policy.
+		inlineRuns           int64                         // The number of jobs run by their submitter with WithInlineIfIdle.
+		continuations        int64                         // The number of jobs queued by running jobs with Continue.
+		integrityViolations  int64                         // The number of violations found by the integrity checks.
+		memoryGuarded        int32                         // Set to 1 while the heap is over the memory guard's soft limit.
+		heapBytes            int64                         // The heap size the memory guard last sampled.
+		priorityOverrides    int64                         // The number of jobs the PriorityFunc queued with a different priority than asked for.
+		priorityFunc         PriorityFunc                  // Decides the priority of each job queued. Nil keeps the priority asked for.
+		priorityMutex        sync.RWMutex                  // Protects priorityFunc.
+		sharedDepth          int64                         // The number of jobs waiting in the shared backend as last measured.
+		backendUnavailable   int32                         // Set to 1 while the shared backend is failing.
+		resetAt              int64                         // When the cumulative counters were last reset in Unix nanoseconds.
+		shutdown             int32                         // Set to 1 once Shutdown has been called.
+		tornDown             int32                         // Set to 1 by the first call to ShutdownWithReport.
+		cancelOnStart        int32                         // Set to 1 by ShutdownNow so jobs starting during the shutdown are cancelled.
+		drainCutoff          int64                         // When a drain stops handing out jobs in Unix nanoseconds or zero.
+		config               Config                        // The configuration the pool was created with.
+		manager              *Manager                      // The manager the pool is registered with or nil.
+		managerMutex         sync.Mutex                    // Protects manager.
+	}
+
+	// ShutdownReport describes what happened to the work in the pool during Shutdown.
+	ShutdownReport struct {
+		CompletedJobs         int32             // The number of jobs that completed while the pool was shutting down.
+		AbandonedPriorityJobs int               // The number of jobs left in the priority queue.
+		AbandonedNormalJobs   int               // The number of jobs left in the normal queue.
+		WaitDuration          time.Duration     // How long it took for the job routines to finish.
+		RunningRoutines       []int             // The job routines that were still running a job when told to stop.
+		AbandonedRetries      int               // The number of retries that were waiting on their delay.
+		AbandonedWorkers      []AbandonedWorker // The job routines left running a job that did not return, see WithAbandonStuckWorkers.
 	}
+
+	// RejectionHandlerFunc allows an ordinary function to be used as a RejectionHandler.
+	RejectionHandlerFunc func(jober Jobber, reason error)
+)
+
+//** VARIABLES
+
+var (
+	// ErrPoolAtCapacity is returned when a job is queued while the queue is full.
+	ErrPoolAtCapacity = errors.New("Job Pool At Capacity")
+
+	// ErrTenantQuotaExceeded is returned when a job is queued for a tenant that already holds
+	// the maximum number of pending jobs.
+	ErrTenantQuotaExceeded = errors.New("Tenant Quota Exceeded")
+
+	// ErrQueueBytesExceeded is returned when adding the job would take the pending jobs over the
+	// byte budget set with WithMaxQueueBytes.
+	ErrQueueBytesExceeded = errors.New("Queue Bytes Exceeded")
+
+	// ErrPoolClosed is returned when a job is queued after Shutdown has been called.
+	ErrPoolClosed = errors.New("Job Pool Closed")
 )
 
 //** INTERFACES
@@ -145,6 +507,52 @@ type Jobber interface {
 	RunJob(jobRoutine int)
 }
 
+// ErrorJobber is an interface that is implemented by jobs that report failure. The pool calls
+// RunJobError instead of RunJob for jobs that implement it.
+type ErrorJobber interface {
+	Jobber
+	RunJobError(jobRoutine int) error
+}
+
+// ContextJobber is an interface that is implemented by jobs that accept a context. The pool calls
+// RunJobContext instead of RunJob for jobs that implement it. The context carries the job's
+// JobMeta, see MetaFromContext.
+type ContextJobber interface {
+	Jobber
+	RunJobContext(ctx context.Context, jobRoutine int) error
+}
+
+// LoggerJobber is an interface that is implemented by jobs that write to the pool's logger. The
+// logger tags every message with the pool name, job routine, job ID and trace ID.
+type LoggerJobber interface {
+	Jobber
+	RunJobLogger(logger Logger, jobRoutine int) error
+}
+
+// Sizer is an optional interface that reports the memory a job holds while it is pending. The
+// sizes are counted against the budget set with WithMaxQueueBytes.
+type Sizer interface {
+	SizeBytes() int64
+}
+
+// Namer is an optional interface that gives a job a human readable name. The name is used in
+// panic reports, logs, stats, history and worker snapshots in place of the job's type.
+type Namer interface {
+	Name() string
+}
+
+// RejectionHandler is an interface that is implemented to handle jobs the pool could not admit.
+// Reject is called from the submitting routine, never from the queue routine, and any panic
+// it raises is recovered. The reason is always returned to the caller as well.
+type RejectionHandler interface {
+	Reject(jober Jobber, reason error)
+}
+
+// Reject calls f(jober, reason).
+func (f RejectionHandlerFunc) Reject(jober Jobber, reason error) {
+	f(jober, reason)
+}
+
 //** INIT FUNCTION
 
 // init is called when the system is inited.
@@ -156,23 +564,103 @@ func init() {
 //** PUBLIC FUNCTIONS
 
 // New creates a new JobPool.
-func New(numberOfRoutines int, queueCapacity int32) (jobPool *JobPool) {
+func New(numberOfRoutines int, queueCapacity int32, options ...Option) (jobPool *JobPool) {
+	config := Config{
+		Routines:      numberOfRoutines,
+		QueueCapacity: queueCapacity,
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&config)
+	}
+
+	return NewFromConfig(config)
+}
+
+// NewChecked creates a new JobPool like New but first checks the settings with Validate and
+// returns the error instead of creating a pool that can't work as configured.
+func NewChecked(numberOfRoutines int, queueCapacity int32, options ...Option) (*JobPool, error) {
+	config := Config{
+		Routines:      numberOfRoutines,
+		QueueCapacity: queueCapacity,
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&config)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewFromConfig(config), nil
+}
+
+// NewFromConfig creates a new JobPool from a fully populated Config. Start from Defaults and
+// check the Config with Validate before the pool is created.
+func NewFromConfig(config Config) (jobPool *JobPool) {
+	numberOfRoutines := config.Routines
+
 	// Create the job queue.
 	jobPool = &JobPool{
-		priorityJobQueue:     list.New(),
-		normalJobQueue:       list.New(),
-		queueChannel:         make(chan *queueJob),
-		dequeueChannel:       make(chan *dequeueJob),
+		defaultQueue:         newTenantQueue(""),
+		tenantQueues:         make(map[string]*tenantQueue),
+		activeTenants:        list.New(),
+		tenantJobs:           make(map[string]int32),
+		queueChannel:         make(chan *queueJob, config.QueueBuffer),
+		intakeChannel:        make(chan *queueJob, intakeBuffer(config)),
+		dequeueChannel:       make(chan *dequeueJob, config.DequeueBuffer),
+		cancelChannel:        make(chan *cancelPending),
+		taskChannel:          make(chan *queueTask),
+		slotWaiters:          list.New(),
+		slotFreed:            make(chan struct{}, 1),
+		groups:               make(map[string]*jobGroup),
+		uniqueJobs:           make(map[string]*queueJob),
+		cooldown:             newCooldown(config),
+		outstanding:          newOutstandingJobs(),
+		integrity:            newIntegrityChecker(config),
+		quarantine:           newQuarantine(),
+		tags:                 newTagReservations(config),
+		depthSampler:         newDepthSampler(config),
+		jobTypes:             make(map[string]*JobTypeStats),
 		shutdownQueueChannel: make(chan string),
-		jobChannel:           make(chan string, queueCapacity),
-		shutdownJobChannel:   make(chan struct{}),
-		queuedJobs:           0,
-		activeRoutines:       0,
-		queueCapacity:        queueCapacity,
+		wakeUps:              newWakeUps(),
+		concurrency:          newConcurrencyLimit(),
+		shutdownStatsChannel: make(chan struct{}),
+		shutdownDone:         make(chan struct{}),
+		gauges:               &gauges{},
+		routines:             int32(numberOfRoutines),
+		workers:              make([]*workerState, numberOfRoutines),
+		workerSlots:          newWorkerSlots(numberOfRoutines),
+		scheduledRetries:     make(map[*queueJob]*timerEntry),
+		runningJobs:          make(map[uint64]context.CancelFunc),
+		prefetchedJobs:       make(map[*queueJob]struct{}),
+		schedules:            make(map[*Schedule]struct{}),
+		scheduler:            newScheduler(clockOf(config)),
+		history:              newJobHistory(config.HistorySize),
+		errors:               newErrorRing(config.ErrorHistorySize),
+		waitTimes:            newWaitTimes(),
+		loopTimes:            &loopTimes{},
+		queueEmpty:           newQueueEmptyState(config),
+		retryBudget:          newRetryBudget(config.RetryBudget, config.RetryBudgetEvery, clockOf(config).Now()),
+		resetAt:              time.Now().UnixNano(),
+		priorityFunc:         config.PriorityFunc,
+		config:               config,
+	}
+
+	// Report the violations found by the integrity checks.
+	if jobPool.integrity != nil {
+		jobPool.integrity.violated = jobPool.reportViolation
 	}
 
 	// Launch the job routines to process work.
 	for jobRoutine := 0; jobRoutine < numberOfRoutines; jobRoutine++ {
+		jobPool.workers[jobRoutine] = &workerState{
+			name: jobPool.routineName(jobRoutine),
+		}
+
 		// Add the routine to the wait group.
 		jobPool.shutdownWaitGroup.Add(1)
 
@@ -183,6 +671,38 @@ func New(numberOfRoutines int, queueCapacity int32) (jobPool *JobPool) {
 	// Start the queue routine to capture and provide jobs.
 	go jobPool.queueRoutine()
 
+	// Start the stats reporter.
+	if config.StatsInterval > 0 {
+		go jobPool.statsRoutine()
+	}
+
+	// Start sampling the queue depth.
+	if config.DepthSampling == true {
+		go jobPool.depthSamplerRoutine()
+	}
+
+	// Start sampling the heap for the memory guard.
+	if config.MemorySoftLimit > 0 {
+		go jobPool.memoryGuardRoutine()
+	}
+
+	// Start with a ramp up when one is configured.
+	jobPool.RampUp()
+
+	// Start feeding the jobs of the shared backend.
+	if config.SharedBackend != nil {
+		jobPool.startSharing()
+	}
+
+	// Register the pool so it can be watched and shut down with the other pools.
+	switch {
+	case config.Manager != nil:
+		config.Manager.register(jobPool)
+
+	case config.Unmanaged == false:
+		defaultManager.register(jobPool)
+	}
+
 	return jobPool
 }
 
@@ -190,107 +710,353 @@ func New(numberOfRoutines int, queueCapacity int32) (jobPool *JobPool) {
 
 // Shutdown will release resources and shutdown all processing.
 func (jobPool *JobPool) Shutdown(goRoutine string) (err error) {
-	defer catchPanic(&err, goRoutine, "Shutdown")
+	_, err = jobPool.ShutdownWithReport(goRoutine)
+	return err
+}
 
-	writeStdout(goRoutine, "Shutdown", "Started")
-	writeStdout(goRoutine, "Shutdown", "Queue Routine")
+// ShutdownWithReport will release resources and shutdown all processing. The report describes
+// the jobs that completed during the shutdown and the jobs that were left in the queues. Only the
+// first call shuts the pool down, any other call waits for it and returns ErrPoolClosed. New jobs
+// are refused first, then the timers are stopped, the queue routine goes down, the job routines
+// finish their jobs and the channels are closed last.
+func (jobPool *JobPool) ShutdownWithReport(goRoutine string) (report ShutdownReport, err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "ShutdownWithReport")
 
-	jobPool.shutdownQueueChannel <- "Shutdown"
-	<-jobPool.shutdownQueueChannel
+	return jobPool.tearDown(goRoutine, 0)
+}
 
-	close(jobPool.shutdownQueueChannel)
-	close(jobPool.queueChannel)
-	close(jobPool.dequeueChannel)
+// QueueJob queues a job to be processed.
+func (jobPool *JobPool) QueueJob(goRoutine string, jober Jobber, priority bool, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "QueueJob")
 
-	writeStdout(goRoutine, "Shutdown", "Shutting Down Job Routines")
+	return jobPool.queueJober(goRoutine, jober, priority, false, options)
+}
 
-	// Close the channel to shut things down
-	close(jobPool.shutdownJobChannel)
-	jobPool.shutdownWaitGroup.Wait()
+// CancelPending empties the priority and/or normal queue and returns the number of jobs cancelled.
+// Passing false for both priorityOnly and normalOnly empties both queues. Jobs already running are
+// not affected. If cancelled is not nil it is called for each cancelled job once the queues have
+// been released, in the order the jobs were queued.
+func (jobPool *JobPool) CancelPending(goRoutine string, priorityOnly bool, normalOnly bool, cancelled func(jober Jobber)) (n int, err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "CancelPending")
 
-	close(jobPool.jobChannel)
+	if priorityOnly == true && normalOnly == true {
+		return 0, fmt.Errorf("Invalid Queue Selection")
+	}
 
-	writeStdout(goRoutine, "Shutdown", "Completed")
-	return err
+	// Create the cancel object to queue.
+	request := cancelPending{
+		priority:      normalOnly == false,
+		normal:        priorityOnly == false,
+		resultChannel: make(chan []*list.List),
+	}
+
+	defer close(request.resultChannel)
+
+	if jobPool.enterQueue() == false {
+		return 0, ErrPoolClosed
+	}
+
+	// Empty the queues.
+	jobPool.countBacklog(1)
+	jobPool.cancelChannel <- &request
+	queues := <-request.resultChannel
+	jobPool.exitQueue()
+
+	// Walk the detached queues outside of the queue routine.
+	for _, queue := range queues {
+		n += queue.Len()
+
+		for element := queue.Front(); element != nil; element = element.Next() {
+			queueJob := element.Value.(*queueJob)
+			jobPool.cancelJob(goRoutine, queueJob, jobPending, DispositionCancelled)
+
+			if cancelled != nil {
+				cancelled(queueJob.Jobber)
+			}
+		}
+	}
+
+	return n, err
 }
 
-// QueueJob queues a job to be processed.
-func (jobPool *JobPool) QueueJob(goRoutine string, jober Jobber, priority bool) (err error) {
-	defer catchPanic(&err, goRoutine, "QueueJob")
+// QueuedJobs will return the number of jobs items in queue. A pool with a shared backend returns
+// the number of jobs waiting in the backend as last measured, see WithSharedBackend. It is a
+// single atomic load that allocates nothing, so it can be called on every incoming request.
+func (jobPool *JobPool) QueuedJobs() int32 {
+	if jobPool.config.SharedBackend != nil {
+		return jobPool.sharedQueuedJobs()
+	}
 
-	// Create the job object to queue.
+	return atomic.LoadInt32(&jobPool.gauges.queuedJobs)
+}
+
+// Capacity returns the maximum number of jobs the queues can hold.
+func (jobPool *JobPool) Capacity() int32 {
+	return jobPool.config.QueueCapacity
+}
+
+// PriorityQueueDepth returns the number of jobs waiting in the priority queues.
+func (jobPool *JobPool) PriorityQueueDepth() int32 {
+	return atomic.LoadInt32(&jobPool.gauges.priorityJobs)
+}
+
+// NormalQueueDepth returns the number of jobs waiting in the normal queues.
+func (jobPool *JobPool) NormalQueueDepth() int32 {
+	// The counters are read one after the other so a job dequeued in between is not counted twice.
+	depth := atomic.LoadInt32(&jobPool.gauges.queuedJobs) - atomic.LoadInt32(&jobPool.gauges.priorityJobs)
+	if depth < 0 {
+		return 0
+	}
+
+	return depth
+}
+
+// PercentFull returns how full the queue is from 0 to 100. Slots held by jobs in the intake
+// buffer and by retries count as used, as they do when a job is admitted. With a byte budget
+// set the fuller of the two limits is returned. The counters are read without a round trip to
+// the queue routine so it is cheap enough to call for every job.
+func (jobPool *JobPool) PercentFull() float64 {
+	// A pool without capacity admits nothing.
+	if jobPool.config.QueueCapacity <= 0 {
+		return 100
+	}
+
+	percent := 100 * float64(atomic.LoadInt32(&jobPool.gauges.reservedSlots)) / float64(jobPool.config.QueueCapacity)
+
+	if jobPool.config.MaxQueueBytes > 0 {
+		if bytesPercent := 100 * float64(atomic.LoadInt64(&jobPool.gauges.pendingBytes)) / float64(jobPool.config.MaxQueueBytes); bytesPercent > percent {
+			percent = bytesPercent
+		}
+	}
+
+	if percent > 100 {
+		return 100
+	}
+
+	return percent
+}
+
+// ActiveRoutines will return the number of routines performing work. Like QueuedJobs it is a
+// single atomic load that allocates nothing.
+func (jobPool *JobPool) ActiveRoutines() int32 {
+	return atomic.LoadInt32(&jobPool.gauges.activeRoutines)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// queueJober queues a job for QueueJob. A submitter that offers the job again when the queue is
+// full passes offeredAgain so the job is not released when it is refused for that reason.
+func (jobPool *JobPool) queueJober(goRoutine string, jober Jobber, priority bool, offeredAgain bool, options []JobOption) (err error) {
+	// Jobs can't be queued once the pool is shutting down.
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return jobPool.rejectOffer(goRoutine, jober, offeredAgain, ErrPoolClosed)
+	}
+
+	// A nil or malformed job is rejected at the call site rather than on a job routine.
+	if err = jobPool.checkJob(jober); err != nil {
+		return jobPool.rejectOffer(goRoutine, jober, offeredAgain, err)
+	}
+
+	// A FaultInjector can delay or refuse the job.
+	if err = jobPool.admitFault(jober); err != nil {
+		return jobPool.rejectOffer(goRoutine, jober, offeredAgain, err)
+	}
+
+	// The PriorityFunc can override the priority asked for.
+	priority = jobPool.admitPriority(jober, priority)
+
+	// A job without options goes to the shared backend, which owns the capacity.
+	if jobPool.config.SharedBackend != nil && len(options) == 0 {
+		return jobPool.putShared(goRoutine, jober, priority, offeredAgain)
+	}
+
+	// A gang larger than the pool could never run.
+	gangSize := jobGangSize(jober)
+	if err = jobPool.checkGang(gangSize); err != nil {
+		return jobPool.rejectOffer(goRoutine, jober, offeredAgain, err)
+	}
+
+	// Create the job object to queue. A gang needs the job routines, so it is never run by its
+	// submitter.
 	job := queueJob{
-		jober,            // Jobber Interface.
-		priority,         // Priority.
-		make(chan error), // Result Channel.
+		Jobber:        jober,
+		name:          jobName(jober),
+		size:          jobSize(jober),
+		gangSize:      gangSize,
+		priority:      priority,
+		callerRuns:    jobPool.config.OverflowPolicy == CallerRuns && gangSize <= 1,
+		resultChannel: make(chan error),
 	}
 
-	defer close(job.resultChannel)
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	if offeredAgain == true {
+		job.retained = true
+	}
+
+	// A job asked to run inline skips the queues when the pool is idle.
+	if jobPool.runInlineIfIdle(&job) == true {
+		return nil
+	}
 
 	// Queue the job
-	jobPool.queueChannel <- &job
-	err = <-job.resultChannel
+	err = jobPool.submitJob(&job)
+
+	// The queue was at capacity so the job runs here.
+	if err == errCallerRuns {
+		jobPool.runInCaller(&job)
+		return nil
+	}
+
+	if err != nil {
+		err = jobPool.rejectJob(goRoutine, &job, err)
+	}
 
 	return err
 }
 
-// QueuedJobs will return the number of jobs items in queue.
-func (jobPool *JobPool) QueuedJobs() int32 {
-	return atomic.AddInt32(&jobPool.queuedJobs, 0)
+// reject hands a job that could not be admitted to the rejection handler and releases it. It
+// returns the reason as a RejectError, which is what the submitter is given.
+func (jobPool *JobPool) reject(goRoutine string, jober Jobber, reason error) error {
+	reason = jobPool.rejection(reason, jober, nil)
+
+	jobPool.notifyRejected(goRoutine, jober, reason)
+	jobPool.releaseJober(goRoutine, jober)
+
+	return reason
 }
 
-// ActiveRoutines will return the number of routines performing work.
-func (jobPool *JobPool) ActiveRoutines() int32 {
-	return atomic.AddInt32(&jobPool.activeRoutines, 0)
+// rejectOffer rejects a submission. A job refused because the queue or its tenant is full or
+// the shared backend is failing is not released when its submitter keeps it to offer again.
+func (jobPool *JobPool) rejectOffer(goRoutine string, jober Jobber, retained bool, reason error) error {
+	reason = jobPool.rejection(reason, jober, nil)
+
+	if retained == true && (errors.Is(reason, ErrPoolAtCapacity) || errors.Is(reason, ErrTenantQuotaExceeded) || errors.Is(reason, ErrBackendUnavailable)) {
+		jobPool.notifyRejected(goRoutine, jober, reason)
+		return reason
+	}
+
+	return jobPool.reject(goRoutine, jober, reason)
 }
 
-//** PRIVATE FUNCTIONS
+// rejectJob rejects a job that was built for queueing, so the RejectError can describe the
+// job's tenant, size and key.
+func (jobPool *JobPool) rejectJob(goRoutine string, queueJob *queueJob, reason error) error {
+	reason = jobPool.rejection(reason, queueJob.Jobber, queueJob)
 
-// catchPanic is used to catch any Panic and log exceptions to Stdout. It will also write the stack trace
-//  err: A reference to the err variable to be returned to the caller. Can be nil.
-func catchPanic(err *error, goRoutine string, functionName string) {
-	if r := recover(); r != nil {
-		// Capture the stack trace.
-		buf := make([]byte, 10000)
-		runtime.Stack(buf, false)
+	return jobPool.rejectOffer(goRoutine, queueJob.Jobber, queueJob.retained, reason)
+}
 
-		writeStdoutf(goRoutine, functionName, "PANIC Defered [%v] : Stack Trace : %v", r, string(buf))
+// notifyRejected hands a job that could not be admitted to the rejection handler without
+// releasing it.
+func (jobPool *JobPool) notifyRejected(goRoutine string, jober Jobber, reason error) {
+	defer jobPool.catchPanic(nil, goRoutine, "notifyRejected")
 
-		if err != nil {
-			*err = fmt.Errorf("%v", r)
-		}
+	name := jobName(jober)
+	jobPool.recordJobType(name, func(jobTypeStats *JobTypeStats) {
+		jobTypeStats.Rejected++
+	})
+	jobPool.recordRejection(name, reason)
+
+	if jobPool.config.RejectionHandler == nil {
+		return
 	}
-}
 
-// writeStdout is used to write a system message directly to stdout.
-func writeStdout(goRoutine string, functionName string, message string) {
-	log.Printf("%s : %s : %s\n", goRoutine, functionName, message)
+	jobPool.config.RejectionHandler.Reject(jober, reason)
 }
 
-// writeStdoutf is used to write a formatted system message directly stdout.
-func writeStdoutf(goRoutine string, functionName string, format string, a ...interface{}) {
-	writeStdout(goRoutine, functionName, fmt.Sprintf(format, a...))
+// callbackSafely runs a user supplied callback within a safe context.
+func (jobPool *JobPool) callbackSafely(goRoutine string, functionName string, callback func()) {
+	defer jobPool.catchPanic(nil, goRoutine, functionName)
+
+	callback()
 }
 
-//** PRIVATE MEMBER FUNCTIONS
+// checkWatermarks fires the watermark callbacks when the queued job count crosses the high
+// watermark from below or falls back under the low watermark. It is only called by the queue
+// routine and the callbacks are run on their own routine so they can't block the queue.
+func (jobPool *JobPool) checkWatermarks() {
+	if jobPool.config.HighWatermark <= 0 {
+		return
+	}
+
+	queuedJobs := atomic.LoadInt32(&jobPool.gauges.queuedJobs)
+
+	if atomic.LoadInt32(&jobPool.aboveHighWatermark) == 0 {
+		if queuedJobs >= jobPool.config.HighWatermark {
+			atomic.StoreInt32(&jobPool.aboveHighWatermark, 1)
+
+			if jobPool.config.OnHighWatermark != nil {
+				go jobPool.callbackSafely("Queue", "OnHighWatermark", jobPool.config.OnHighWatermark)
+			}
+		}
+
+		return
+	}
+
+	if queuedJobs < jobPool.config.LowWatermark {
+		atomic.StoreInt32(&jobPool.aboveHighWatermark, 0)
+
+		if jobPool.config.OnLowWatermark != nil {
+			go jobPool.callbackSafely("Queue", "OnLowWatermark", jobPool.config.OnLowWatermark)
+		}
+	}
+}
 
 // queueRoutine performs the thread safe queue related processing.
 func (jobPool *JobPool) queueRoutine() {
 	for {
 		select {
 		case <-jobPool.shutdownQueueChannel:
-			writeStdout("Queue", "queueRoutine", "Going Down")
+			jobPool.writeLog(LogDebug, "Queue", "queueRoutine", "Going Down")
+			jobPool.queueRoutineCloseIntake()
+			jobPool.queueRoutineCloseWaiters()
 			jobPool.shutdownQueueChannel <- "Down"
 			return
 
 		case queueJob := <-jobPool.queueChannel:
 			// Enqueue the job
+			started := jobPool.takeMessage()
 			jobPool.queueRoutineEnqueue(queueJob)
+			jobPool.recordMessage(loopEnqueue, started)
+			break
+
+		case queueJob := <-jobPool.intakeChannel:
+			// Admit a job that already holds a slot
+			started := jobPool.takeMessage()
+			jobPool.queueRoutineAdmit(queueJob)
+			jobPool.recordMessage(loopAdmit, started)
 			break
 
 		case dequeueJob := <-jobPool.dequeueChannel:
 			// Dequeue a job
+			started := jobPool.takeMessage()
 			jobPool.queueRoutineDequeue(dequeueJob)
+			jobPool.recordMessage(loopDequeue, started)
+			break
+
+		case cancelPending := <-jobPool.cancelChannel:
+			// Empty the requested queues
+			started := jobPool.takeMessage()
+			jobPool.queueRoutineCancel(cancelPending)
+			jobPool.recordMessage(loopCancel, started)
+			break
+
+		case queueTask := <-jobPool.taskChannel:
+			// Run the function against the queues
+			started := jobPool.takeMessage()
+			jobPool.queueRoutineTask(queueTask)
+			jobPool.recordMessage(loopTask, started)
+			break
+
+		case <-jobPool.slotFreed:
+			// Admit the submitters waiting for a slot
+			started := time.Now()
+			jobPool.queueRoutineAdmitWaiters()
+			jobPool.recordMessage(loopSlot, started)
 			break
 		}
 	}
@@ -298,75 +1064,631 @@ func (jobPool *JobPool) queueRoutine() {
 
 // queueRoutineEnqueue places a job on either the normal or priority queue.
 func (jobPool *JobPool) queueRoutineEnqueue(queueJob *queueJob) {
-	defer catchPanic(nil, "Queue", "queueRoutineEnqueue")
+	defer jobPool.catchPanic(nil, "Queue", "queueRoutineEnqueue")
 
-	// If the queue is at capacity don't add it.
-	if atomic.AddInt32(&jobPool.queuedJobs, 0) == jobPool.queueCapacity {
-		queueJob.resultChannel <- fmt.Errorf("Job Pool At Capacity")
+	// The submitter gave up on the job before it was taken.
+	if queueJob.claimAdmission() == false {
 		return
 	}
 
-	if queueJob.priority == true {
-		jobPool.priorityJobQueue.PushBack(queueJob)
-	} else {
-		jobPool.normalJobQueue.PushBack(queueJob)
+	// A unique job that can be merged into the pending job holding its key takes no slot.
+	if holder := jobPool.coalesceUnique(queueJob); holder != nil {
+		if queueJob.admissionInfo != nil {
+			*queueJob.admissionInfo = jobPool.admissionInfo(holder)
+		}
+
+		queueJob.resultChannel <- nil
+		return
 	}
 
-	// Increment the queued work count.
-	atomic.AddInt32(&jobPool.queuedJobs, 1)
+	// If a barrier holds back jobs like it don't add it.
+	if jobPool.barred(queueJob) == true {
+		queueJob.resultChannel <- ErrBarrier
+		return
+	}
+
+	// If the job's key is held by another job or cooling down don't add it.
+	if err := jobPool.checkUnique(queueJob); err != nil {
+		queueJob.resultChannel <- err
+		return
+	}
+
+	// If the tenant is at its quota don't add it.
+	if jobPool.tenantAtCapacity(queueJob.tenant) == true {
+		queueJob.resultChannel <- ErrTenantQuotaExceeded
+		return
+	}
+
+	// If the job would take the queue over its byte budget don't add it.
+	if jobPool.bytesAtCapacity(queueJob) == true {
+		queueJob.resultChannel <- ErrQueueBytesExceeded
+		return
+	}
+
+	// If the heap is over the memory guard only priority jobs are added.
+	if jobPool.memoryRefused(queueJob) == true {
+		queueJob.resultChannel <- ErrMemoryGuarded
+		return
+	}
+
+	// If the queue is at capacity a continuation can use the continuation budget and the
+	// submitter of a job queued with QueueJobWait waits its turn.
+	reserved := jobPool.reserveSlot()
+	if reserved == false && queueJob.continuation == true {
+		reserved = jobPool.claimContinuationSlot()
+	}
+	if reserved == false && queueJob.waitForSlot == true {
+		if queueJob.reentrant == true {
+			queueJob.resultChannel <- ErrWouldDeadlock
+			return
+		}
+
+		jobPool.queueRoutineWaitSlot(queueJob)
+		return
+	}
+
+	// If the queue is at capacity don't add it, unless the oldest normal job can be evicted to
+	// make room or the submitter runs the job itself.
+	if reserved == false {
+		if queueJob.callerRuns == true && len(jobPool.barriers) == 0 {
+			jobPool.admitCallerRun(queueJob)
+			jobPool.holdUnique(queueJob)
+			queueJob.resultChannel <- errCallerRuns
+			return
+		}
+
+		if jobPool.config.OverflowPolicy != DropOldest || jobPool.evictOldest() == false || jobPool.reserveSlot() == false {
+			queueJob.resultChannel <- ErrPoolAtCapacity
+			return
+		}
+	}
+
+	jobPool.pushJob(queueJob)
+	jobPool.holdUnique(queueJob)
+
+	if queueJob.admissionInfo != nil {
+		*queueJob.admissionInfo = jobPool.admissionInfo(queueJob)
+	}
 
 	// Tell the caller the work is queued.
 	queueJob.resultChannel <- nil
+}
+
+// queueRoutineAdmit places a job from the intake buffer on either the normal or priority queue.
+// The job reserved its slot when it was submitted so there is no capacity check.
+func (jobPool *JobPool) queueRoutineAdmit(queueJob *queueJob) {
+	defer jobPool.catchPanic(nil, "Queue", "queueRoutineAdmit")
+
+	// A unique job merged into the pending job holding its key gives the slot back.
+	if holder := jobPool.coalesceUnique(queueJob); holder != nil {
+		jobPool.releaseSlots(1)
+		queueJob.handle.admit(jobPool.admissionInfo(holder))
+		return
+	}
+
+	// If a barrier holds the job back, the job's key is taken, the tenant is at its quota, the
+	// queue at its byte budget or the heap over the memory guard give the slot back.
+	refused := jobPool.checkUnique(queueJob)
+	switch {
+	case refused != nil:
+	case jobPool.barred(queueJob) == true:
+		refused = ErrBarrier
+	case jobPool.tenantAtCapacity(queueJob.tenant) == true:
+		refused = ErrTenantQuotaExceeded
+	case jobPool.bytesAtCapacity(queueJob) == true:
+		refused = ErrQueueBytesExceeded
+	case jobPool.memoryRefused(queueJob) == true:
+		refused = ErrMemoryGuarded
+	}
+
+	if refused != nil {
+		jobPool.releaseSlots(1)
+		refused = jobPool.rejection(refused, queueJob.Jobber, queueJob)
+		queueJob.handle.resolve(refused)
+		go jobPool.rejectOffer("Queue", queueJob.Jobber, queueJob.retained, refused)
 
-	// Tell the job routine to wake up.
-	jobPool.jobChannel <- "Wake Up"
+		if queueJob.child != nil {
+			go queueJob.child.dropped("Queue", queueJob)
+		}
+		return
+	}
+
+	jobPool.pushJob(queueJob)
+	jobPool.holdUnique(queueJob)
+
+	// Tell the submitter the work is queued.
+	queueJob.handle.admit(jobPool.admissionInfo(queueJob))
+}
+
+// queueRoutineCloseIntake rejects the jobs still waiting in the intake buffer during shutdown.
+func (jobPool *JobPool) queueRoutineCloseIntake() {
+	for {
+		select {
+		case queueJob := <-jobPool.intakeChannel:
+			jobPool.countBacklog(-1)
+			jobPool.releaseSlots(1)
+			refused := jobPool.rejection(ErrPoolClosed, queueJob.Jobber, queueJob)
+			queueJob.handle.resolve(refused)
+			go jobPool.reject("Queue", queueJob.Jobber, refused)
+
+			if queueJob.child != nil {
+				go queueJob.child.dropped("Queue", queueJob)
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+// pushJob places a job on either the normal or priority queue.
+func (jobPool *JobPool) pushJob(queueJob *queueJob) {
+	queueJob.enqueuedAt = time.Now()
+	if queueJob.firstEnqueuedAt.IsZero() == true {
+		queueJob.firstEnqueuedAt = queueJob.enqueuedAt
+
+		queueJob.id = atomic.AddUint64(&jobPool.jobSequence, 1)
+
+		jobPool.outstanding.admit(queueJob)
+		jobPool.trackJob(queueJob)
+		jobPool.admitGroupJob(queueJob)
+	}
+
+	// A job whose type was quarantined after it was submitted is parked.
+	if jobPool.parkQuarantined(queueJob) == true {
+		return
+	}
+
+	// A retry or requeue is already counted in its group and is only pending again.
+	jobPool.pushTenantJob(queueJob)
+	jobPool.restoreGroupJob(queueJob)
+	if queueJob.queue == queueJob.tenantQueue.priorityJobQueue {
+		jobPool.countPriorityJobs(1)
+	}
+	atomic.AddInt64(&jobPool.gauges.pendingBytes, queueJob.size)
+
+	// Increment the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, 1)
+	atomic.AddInt64(&jobPool.enqueuedJobs, 1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	// Tell a job routine to wake up.
+	jobPool.wakeUps.post(1)
+}
+
+// reserveSlot takes one of the slots in the queue. It returns false if the queue is at capacity
+// or submitters are waiting for a slot.
+func (jobPool *JobPool) reserveSlot() bool {
+	if atomic.LoadInt32(&jobPool.waitingSubmitters) > 0 {
+		return false
+	}
+
+	return jobPool.claimSlot()
+}
+
+// claimSlot takes one of the slots in the queue ahead of any waiting submitter. It returns false
+// if the queue is at capacity.
+func (jobPool *JobPool) claimSlot() bool {
+	return jobPool.claimSlotWithin(jobPool.config.QueueCapacity)
+}
+
+// claimSlotWithin takes a slot ahead of any waiting submitter while fewer than limit are taken.
+func (jobPool *JobPool) claimSlotWithin(limit int32) bool {
+	for {
+		reservedSlots := atomic.LoadInt32(&jobPool.gauges.reservedSlots)
+		if reservedSlots >= limit {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&jobPool.gauges.reservedSlots, reservedSlots, reservedSlots+1) {
+			return true
+		}
+	}
 }
 
 // queueRoutineDequeue remove a job from the queue.
 func (jobPool *JobPool) queueRoutineDequeue(dequeueJob *dequeueJob) {
-	defer catchPanic(nil, "Queue", "queueRoutineDequeue")
+	defer jobPool.catchPanic(nil, "Queue", "queueRoutineDequeue")
+
+	// A drain running out of time hands out no more jobs.
+	if jobPool.pastDrainCutoff() == true {
+		dequeueJob.ResultChannel <- nil
+		return
+	}
 
-	var nextJob *list.Element
+	seen := jobPool.tags.generation()
 
-	if jobPool.priorityJobQueue.Len() > 0 {
-		nextJob = jobPool.priorityJobQueue.Front()
-		jobPool.priorityJobQueue.Remove(nextJob)
-	} else {
-		nextJob = jobPool.normalJobQueue.Front()
-		jobPool.normalJobQueue.Remove(nextJob)
+	job, tagHeld := jobPool.popTenantJob()
+	if job == nil {
+		// The job this wake up was for has been cancelled or is held back by a barrier or a tag
+		// reservation. The wake up is given back once the barrier is lifted or a job finishes.
+		switch {
+		case tagHeld == true:
+			jobPool.holdTagWakeUp(seen)
+
+		case len(jobPool.barriers) > 0:
+			jobPool.heldWakeUps++
+		}
+
+		dequeueJob.ResultChannel <- nil
+		return
 	}
 
 	// Decrement the queued work count.
-	atomic.AddInt32(&jobPool.queuedJobs, -1)
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, -1)
+	jobPool.releaseSlots(1)
+	atomic.AddInt64(&jobPool.dequeuedJobs, 1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	// A job that left the pending state was won by another path and is never handed out.
+	if job.transition(jobPending, jobClaimed) == false {
+		dequeueJob.ResultChannel <- nil
+		return
+	}
 
-	// Cast the list element back to a Job.
-	job := nextJob.Value.(*queueJob)
+	jobPool.tags.dispatch(job)
+
+	if dequeueJob.prefetch == true {
+		jobPool.holdPrefetched(job)
+	}
 
 	// Give the caller the work to process.
 	dequeueJob.ResultChannel <- job
 }
 
+// queueRoutineCancel detaches the requested queues and replaces them with empty ones.
+func (jobPool *JobPool) queueRoutineCancel(cancelPending *cancelPending) {
+	var queues []*list.List
+	var cancelled int
+
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		if cancelPending.priority == true && tenantQueue.priorityJobQueue.Len() > 0 {
+			queues = append(queues, tenantQueue.priorityJobQueue)
+			cancelled += tenantQueue.priorityJobQueue.Len()
+			jobPool.countPriorityJobs(-tenantQueue.priorityJobQueue.Len())
+			tenantQueue.priorityJobQueue = list.New()
+		}
+
+		if cancelPending.normal == true && tenantQueue.normalJobQueue.Len() > 0 {
+			queues = append(queues, tenantQueue.normalJobQueue)
+			cancelled += tenantQueue.normalJobQueue.Len()
+			tenantQueue.normalJobQueue = list.New()
+		}
+
+		if tenantQueue.len() == 0 {
+			jobPool.retireTenant(tenantQueue)
+		}
+	}
+
+	// Detach the cancelled jobs from their queues so no other path removes them again, and
+	// release what they held. The release is skipped when nothing is tracked per job so emptying
+	// a very large queue stays cheap.
+	release := len(jobPool.tenantJobs) > 0 || jobPool.hasGroups() == true || len(jobPool.uniqueJobs) > 0 || jobPool.tags != nil || atomic.LoadInt64(&jobPool.gauges.pendingBytes) > 0
+	for _, queue := range queues {
+		for element := queue.Front(); element != nil; element = element.Next() {
+			queueJob := element.Value.(*queueJob)
+			queueJob.queue = nil
+			queueJob.element = nil
+
+			if release == true {
+				jobPool.unqueueJob(queueJob)
+				jobPool.finishGroupJob(queueJob)
+				jobPool.releaseUnique(queueJob, false)
+			}
+
+			if queueJob.child != nil {
+				go queueJob.child.dropped("Queue", queueJob)
+			}
+		}
+	}
+
+	// Decrement the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, -int32(cancelled))
+	jobPool.releaseSlots(cancelled)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	jobPool.wakeUps.retract(cancelled)
+
+	// Give the caller the detached queues.
+	cancelPending.resultChannel <- queues
+}
+
+// queueRoutineTask runs a function against the queues.
+func (jobPool *JobPool) queueRoutineTask(queueTask *queueTask) {
+	defer func() {
+		queueTask.resultChannel <- struct{}{}
+	}()
+	defer jobPool.catchPanic(nil, "Queue", "queueRoutineTask")
+
+	queueTask.task()
+}
+
+// runInQueue runs the function inside the queue routine and waits for it to finish. It returns
+// ErrPoolClosed without running the function once the queue routine is shut down.
+func (jobPool *JobPool) runInQueue(task func()) error {
+	if jobPool.enterQueue() == false {
+		return ErrPoolClosed
+	}
+	defer jobPool.exitQueue()
+
+	queueTask := queueTask{
+		task:          task,
+		resultChannel: make(chan struct{}),
+	}
+
+	defer close(queueTask.resultChannel)
+
+	jobPool.countBacklog(1)
+	jobPool.taskChannel <- &queueTask
+	<-queueTask.resultChannel
+
+	return nil
+}
+
+// enterQueue is called before a request is handed to the queue routine. It returns false once
+// the queue routine is shut down, otherwise exitQueue must be called once the queue routine has
+// answered. Shutdown waits for the requests that have entered so no request is sent on a closed
+// channel or left unanswered.
+func (jobPool *JobPool) enterQueue() bool {
+	jobPool.queueMutex.RLock()
+	if jobPool.queueClosed == true {
+		jobPool.queueMutex.RUnlock()
+		return false
+	}
+
+	return true
+}
+
+// exitQueue is called once the queue routine has answered a request.
+func (jobPool *JobPool) exitQueue() {
+	jobPool.queueMutex.RUnlock()
+}
+
+// closeQueue waits for the requests with the queue routine and refuses any new ones.
+func (jobPool *JobPool) closeQueue() {
+	jobPool.queueMutex.Lock()
+	defer jobPool.queueMutex.Unlock()
+
+	jobPool.queueClosed = true
+}
+
+// tearDown shuts the pool down for ShutdownWithReport. With abandonAfter greater than zero the
+// job routines that have not gone down by then are abandoned.
+func (jobPool *JobPool) tearDown(goRoutine string, abandonAfter time.Duration) (report ShutdownReport, err error) {
+	// Only the first call tears the pool down. Later calls wait for it to finish.
+	if atomic.CompareAndSwapInt32(&jobPool.tornDown, 0, 1) == false {
+		<-jobPool.shutdownDone
+		return report, ErrPoolClosed
+	}
+	defer close(jobPool.shutdownDone)
+
+	// Capture the completed count so jobs finishing during teardown can be reported.
+	completedJobs := atomic.LoadInt32(&jobPool.completedJobs)
+
+	jobPool.writeLog(LogInfo, goRoutine, "ShutdownWithReport", "Started")
+
+	// The pool is torn down in order: intake, timers, the queue routine, the job routines and
+	// last the channels. Every request to the queue routine goes through enterQueue, so nothing
+	// can send on a channel once the queue routine is down and no channel is closed while a
+	// routine could still use it.
+
+	// Stop accepting new jobs.
+	atomic.StoreInt32(&jobPool.shutdown, 1)
+	jobPool.stopSharing()
+	jobPool.leaveManager()
+	close(jobPool.shutdownStatsChannel)
+	report.AbandonedPriorityJobs, report.AbandonedNormalJobs = jobPool.closeChildren()
+
+	// Stop the timers so no retry or scheduled job fires into a pool going down.
+	report.AbandonedRetries = jobPool.cancelRetries()
+	jobPool.scheduler.stop()
+
+	jobPool.writeLog(LogDebug, goRoutine, "ShutdownWithReport", "Queue Routine")
+
+	// Let the requests with the queue routine finish before it goes down.
+	jobPool.closeQueue()
+
+	jobPool.shutdownQueueChannel <- "Shutdown"
+	<-jobPool.shutdownQueueChannel
+
+	// The queue routine is down so the queues can be read safely.
+	jobPool.returnPrefetched()
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		report.AbandonedPriorityJobs += tenantQueue.priorityJobQueue.Len()
+		report.AbandonedNormalJobs += tenantQueue.normalJobQueue.Len()
+
+		jobPool.cancelQueuedJobs(goRoutine, tenantQueue.priorityJobQueue, DispositionAbandoned)
+		jobPool.cancelQueuedJobs(goRoutine, tenantQueue.normalJobQueue, DispositionAbandoned)
+
+		dropChildJobs(goRoutine, tenantQueue.priorityJobQueue)
+		dropChildJobs(goRoutine, tenantQueue.normalJobQueue)
+	}
+
+	parkedPriorityJobs, parkedNormalJobs := jobPool.cancelParked()
+	report.AbandonedPriorityJobs += parkedPriorityJobs
+	report.AbandonedNormalJobs += parkedNormalJobs
+
+	// Jobs left in the queues will never complete.
+	jobPool.releaseGroups()
+
+	jobPool.writeLog(LogDebug, goRoutine, "ShutdownWithReport", "Shutting Down Job Routines")
+
+	// Capture the routines that are still running a job.
+	for jobRoutine, workerState := range jobPool.closeWorkers() {
+		if atomic.LoadInt32(&workerState.busy) == 1 {
+			report.RunningRoutines = append(report.RunningRoutines, jobRoutine)
+		}
+	}
+
+	// Wake the job routines so they finish their jobs and go down.
+	waitStarted := time.Now()
+	jobPool.concurrency.close()
+	jobPool.wakeUps.close()
+	report.AbandonedWorkers = jobPool.waitForWorkers(goRoutine, abandonAfter)
+	report.WaitDuration = time.Since(waitStarted)
+
+	// Every job admitted has been accounted for unless the bookkeeping lost one.
+	jobPool.checkIntegrity(len(report.AbandonedWorkers) > 0)
+
+	// Every routine that could use the channels is down. The channels are left open for the
+	// routines that were abandoned.
+	if len(report.AbandonedWorkers) == 0 {
+		jobPool.closeChannels()
+	}
+
+	report.CompletedJobs = atomic.LoadInt32(&jobPool.completedJobs) - completedJobs
+
+	jobPool.writeLogf(LogInfo, goRoutine, "ShutdownWithReport", "Completed : Completed[%d] Abandoned Priority[%d] Normal[%d] Retries[%d]", report.CompletedJobs, report.AbandonedPriorityJobs, report.AbandonedNormalJobs, report.AbandonedRetries)
+	return report, err
+}
+
+// closeChannels closes the channels of the queue routine. It is called last during shutdown once
+// the queue routine and the job routines are down.
+func (jobPool *JobPool) closeChannels() {
+	close(jobPool.shutdownQueueChannel)
+	close(jobPool.queueChannel)
+	close(jobPool.intakeChannel)
+	close(jobPool.dequeueChannel)
+	close(jobPool.cancelChannel)
+	close(jobPool.taskChannel)
+}
+
+// submitJob hands the job to the queue routine and waits for the outcome.
+func (jobPool *JobPool) submitJob(queueJob *queueJob) error {
+	if jobPool.enterQueue() == false {
+		return ErrPoolClosed
+	}
+	defer jobPool.exitQueue()
+
+	defer close(queueJob.resultChannel)
+
+	jobPool.countBacklog(1)
+	jobPool.queueChannel <- queueJob
+	return <-queueJob.resultChannel
+}
+
+// submitIntake places a job that holds a slot in the intake buffer. It returns ErrPoolClosed
+// once the queue routine is shut down, in which case the caller still holds the slot.
+func (jobPool *JobPool) submitIntake(queueJob *queueJob) error {
+	if jobPool.enterQueue() == false {
+		return ErrPoolClosed
+	}
+	defer jobPool.exitQueue()
+
+	jobPool.countBacklog(1)
+	jobPool.intakeChannel <- queueJob
+	return nil
+}
+
+// removeQueuedJob takes a pending job out of its queue and marks it cancelled with the
+// disposition. It returns false without touching the queues if the job is no longer in a queue
+// or another path has already won it. It is only called by the queue routine.
+func (jobPool *JobPool) removeQueuedJob(queueJob *queueJob, disposition Disposition) bool {
+	if queueJob.element == nil || queueJob.dispose(jobPending, disposition) == false {
+		return false
+	}
+
+	// The job is released off the queue routine so a slow Close can't hold up the queues.
+	go jobPool.releaseJob("Queue", queueJob)
+
+	if queueJob.queue == queueJob.tenantQueue.priorityJobQueue {
+		jobPool.countPriorityJobs(-1)
+	}
+
+	queueJob.queue.Remove(queueJob.element)
+	queueJob.queue = nil
+	queueJob.element = nil
+
+	if queueJob.tenantQueue.len() == 0 {
+		jobPool.retireTenant(queueJob.tenantQueue)
+	}
+
+	jobPool.unqueueJob(queueJob)
+	jobPool.releaseUnique(queueJob, false)
+
+	// A child's job gives back its place under the child's limits.
+	if queueJob.child != nil {
+		go queueJob.child.dropped("Queue", queueJob)
+	}
+
+	// Decrement the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, -1)
+	jobPool.releaseSlots(1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	jobPool.wakeUps.retract(1)
+	return true
+}
+
+// unqueueJob releases what a job held while it was pending.
+func (jobPool *JobPool) unqueueJob(queueJob *queueJob) {
+	jobPool.countTenantJob(queueJob.tenant, -1)
+	jobPool.tags.count(queueJob.tag, -1)
+	jobPool.dequeueGroupJob(queueJob)
+	atomic.AddInt64(&jobPool.gauges.pendingBytes, -queueJob.size)
+}
+
+// bytesAtCapacity returns true if queueing the job would take the pending jobs over the byte
+// budget. It is only called by the queue routine.
+func (jobPool *JobPool) bytesAtCapacity(queueJob *queueJob) bool {
+	if jobPool.config.MaxQueueBytes <= 0 || queueJob.size <= 0 {
+		return false
+	}
+
+	return atomic.LoadInt64(&jobPool.gauges.pendingBytes)+queueJob.size > jobPool.config.MaxQueueBytes
+}
+
+// releaseSlots gives back slots in the queue and lets a waiting submitter take them.
+func (jobPool *JobPool) releaseSlots(released int) {
+	atomic.AddInt32(&jobPool.gauges.reservedSlots, -int32(released))
+	jobPool.signalSlotFreed()
+}
+
 // jobRoutine performs the actual processing of jobs.
 func (jobPool *JobPool) jobRoutine(jobRoutine int) {
-	for {
-		select {
-		// Shutdown the job routine.
-		case <-jobPool.shutdownJobChannel:
-			writeStdout(fmt.Sprintf("JobRoutine %d", jobRoutine), "jobRoutine", "Going Down")
-			jobPool.shutdownWaitGroup.Done()
-			return
+	// A pinned routine keeps its OS thread for its whole life and unlocks it before it exits so
+	// the thread is returned to the runtime instead of being destroyed.
+	if jobPool.config.PinWorkers == true {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
 
-		// Perform the work.
-		case <-jobPool.jobChannel:
-			jobPool.doJobSafely(jobRoutine)
-			break
+	// Label the routine so it can be told apart in goroutine profiles.
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("jobpool", jobPool.config.Name, "routine", strconv.Itoa(jobRoutine))))
+
+	// Perform the work until the job routines are told to shut down or this routine is retired.
+	// A routine that prefetched its next job runs it without waiting for another wake up.
+	workerState := jobPool.worker(jobRoutine)
+	atomic.StoreUint64(&workerState.goroutineID, goroutineID())
+
+	for jobPool.wakeUps.wait(&workerState.retired) == true {
+		for prefetched := jobPool.doJobSafely(jobRoutine, nil); prefetched != nil; {
+			prefetched = jobPool.doJobSafely(jobRoutine, prefetched)
 		}
 	}
+
+	// A retired routine takes its slot with it so gangs are sized to the routines left.
+	if atomic.LoadInt32(&workerState.retired) == 1 {
+		jobPool.workerSlots.remove(1)
+	}
+
+	// A routine still holding its goroutine ID at shutdown is stuck, see stuckWorkers.
+	atomic.StoreUint64(&workerState.goroutineID, 0)
+
+	jobPool.writeLog(LogDebug, workerState.name, "jobRoutine", "Going Down")
+	jobPool.shutdownWaitGroup.Done()
 }
 
 // dequeueJob pulls a job from the queue.
 func (jobPool *JobPool) dequeueJob() (job *queueJob, err error) {
-	defer catchPanic(&err, "jobRoutine", "dequeueJob")
+	defer jobPool.catchPanic(&err, "jobRoutine", "dequeueJob")
+
+	// A FaultInjector can hold the routine to freeze dequeues.
+	jobPool.dequeueFault()
 
 	// Create the job object to queue.
 	requestJob := dequeueJob{
@@ -375,28 +1697,284 @@ func (jobPool *JobPool) dequeueJob() (job *queueJob, err error) {
 
 	defer close(requestJob.ResultChannel)
 
+	// The jobs left in the queues are abandoned once the queue routine is shut down.
+	if jobPool.enterQueue() == false {
+		return nil, nil
+	}
+	defer jobPool.exitQueue()
+
 	// Dequeue the job
+	jobPool.countBacklog(1)
 	jobPool.dequeueChannel <- &requestJob
 	job = <-requestJob.ResultChannel
 
 	return job, err
 }
 
-// doJobSafely will executes the job within a safe context.
-func (jobPool *JobPool) doJobSafely(jobRoutine int) {
-	defer catchPanic(nil, "jobRoutine", "doJobSafely")
-	defer atomic.AddInt32(&jobPool.activeRoutines, -1)
+// doJobSafely will executes the job within a safe context. The job is the one prefetched by the
+// previous call when prefetched is set. It returns the request for the routine's next job when
+// one was prefetched while the job ran.
+func (jobPool *JobPool) doJobSafely(jobRoutine int, prefetched *dequeueJob) (next *dequeueJob) {
+	defer jobPool.catchPanic(nil, jobPool.workerName(jobRoutine), "doJobSafely")
+
+	// Wait for room under the concurrency limit before the job leaves the queues. A routine
+	// holding a prefetched job doesn't wait but gives the job back to its queue when there is no
+	// room, so the job isn't held outside the queues while the routine waits.
+	permit := true
+	if prefetched == nil {
+		jobPool.concurrency.acquire()
+	} else {
+		permit = jobPool.concurrency.acquireNow()
+	}
+
+	defer func() {
+		if permit == true {
+			jobPool.concurrency.release()
+		}
+	}()
+
+	// Update the active routine count. A routine held back by the limit is not active.
+	atomic.AddInt32(&jobPool.gauges.activeRoutines, 1)
+	defer atomic.AddInt32(&jobPool.gauges.activeRoutines, -1)
 
-	// Update the active routine count.
-	atomic.AddInt32(&jobPool.activeRoutines, 1)
+	// Hold a slot so routines reserved by a gang stay idle.
+	slots := 1
+	jobPool.workerSlots.acquire(slots)
+	defer func() {
+		jobPool.workerSlots.release(slots)
+	}()
 
 	// Dequeue a job
-	queueJob, err := jobPool.dequeueJob()
+	var queueJob *queueJob
+	var err error
+	switch {
+	case prefetched != nil:
+		queueJob = jobPool.claimPrefetched(prefetched, permit == false)
+
+	default:
+		queueJob, err = jobPool.dequeueJob()
+	}
+
 	if err != nil {
-		writeStdoutf("Queue", "jobpool.JobPool", "doJobSafely", "ERROR : %s", err)
+		jobPool.writeLogf(LogError, "Queue", "doJobSafely", "ERROR : %s", err)
+		return
+	}
+
+	// The job was cancelled before it could be dequeued.
+	if queueJob == nil {
 		return
 	}
 
+	// A prefetched job that couldn't be given back because the pool is shutting down is run
+	// once the limit is lifted.
+	if permit == false {
+		jobPool.concurrency.acquire()
+		permit = true
+	}
+
+	// A gang waits until enough routines are free and keeps them idle while it runs. A gang
+	// left with too few routines by RetireWorker is cancelled.
+	if queueJob.gangSize > 1 {
+		jobPool.workerSlots.release(slots)
+		slots = queueJob.gangSize
+		if jobPool.workerSlots.acquire(slots) == false {
+			slots = 0
+			jobPool.dropStrandedGang(queueJob, jobRoutine)
+			return nil
+		}
+	}
+
+	// Ask for the next job so it is ready once this one is done.
+	next = jobPool.prefetchJob()
+
+	jobPool.runJob(queueJob, jobRoutine)
+	return next
+}
+
+// runJob runs a job and accounts for it in its group and child pool, the worker and job type
+// stats, the history and the error history, and retries it when it fails. Job routines and
+// jobs run by their submitter under the CallerRuns overflow policy both go through it, the
+// latter with CallerRoutine as the routine.
+func (jobPool *JobPool) runJob(queueJob *queueJob, jobRoutine int) {
+	// Hand the routine back to the job's tag reservation once the job is done with it.
+	defer jobPool.finishTag(queueJob)
+
+	// A job that was cancelled after it was claimed never runs.
+	if queueJob.transition(jobClaimed, jobRunning) == false {
+		return
+	}
+
+	// Account for the job in its group and child pool however it finishes, unless it is
+	// placed back in the queue to run again.
+	var requeued bool
+	defer func() {
+		if requeued == true {
+			return
+		}
+
+		jobPool.finishGroupJob(queueJob)
+
+		if queueJob.child != nil {
+			queueJob.child.finished("jobRoutine")
+		}
+	}()
+
+	if queueJob.child != nil {
+		queueJob.child.started(queueJob)
+	}
+
+	// Mark the routine as running a job. A job run by its submitter has no routine to mark.
+	var workerState *workerState
+	if jobRoutine != CallerRoutine {
+		workerState = jobPool.worker(jobRoutine)
+
+		atomic.StoreInt32(&workerState.busy, 1)
+		defer atomic.StoreInt32(&workerState.busy, 0)
+	}
+
 	// Perform the job.
-	queueJob.RunJob(jobRoutine)
+	started := time.Now()
+	jobPool.checkQueueLatency(queueJob, started)
+	jobPool.recordWait(queueJob, started)
+
+	jobRecord, panicked, err := jobPool.performJob(context.Background(), queueJob, jobRoutine, workerState, started)
+
+	switch {
+	case panicked == true:
+		requeued = jobPool.retry(queueJob, err, true)
+
+	case err != nil:
+		requeued = jobPool.retry(queueJob, err, false)
+	}
+
+	if requeued == true {
+		jobRecord.Outcome = OutcomeRetried
+	}
+
+	jobPool.history.add(jobRecord)
+
+	if requeued == true {
+		return
+	}
+
+	// Free the job's key before the job is seen as done.
+	jobPool.finishUnique(queueJob, panicked == false && err == nil)
+
+	queueJob.dispose(jobRunning, DispositionCompleted)
+
+	if panicked == true {
+		return
+	}
+
+	// Update the completed job count.
+	atomic.AddInt32(&jobPool.completedJobs, 1)
+}
+
+// performJob runs the job through the wrapper shared by the job routines, the jobs run by their
+// submitter and RunInline. The job is traced and timed, a panic is recovered and reported, and
+// the outcome is counted against the job's type and in the error history before the record for
+// the history is returned. What happens to a job that failed is left to the caller.
+func (jobPool *JobPool) performJob(ctx context.Context, queueJob *queueJob, jobRoutine int, workerState *workerState, started time.Time) (jobRecord JobRecord, panicked bool, err error) {
+	if workerState != nil {
+		workerState.start(started, queueJob.id, queueJob.name, queueJob.metadata)
+	}
+
+	ctx, endTrace := jobPool.traceJob(ctx, queueJob, started)
+	panicked, err = jobPool.executeJob(ctx, queueJob, jobRoutine)
+	endTrace()
+	ended := time.Now()
+	if workerState != nil {
+		workerState.finish(ended)
+	}
+	jobPool.recordJobType(queueJob.name, func(jobTypeStats *JobTypeStats) {
+		jobTypeStats.record(ended.Sub(started), err, panicked)
+	})
+
+	if jobPool.history != nil {
+		jobRecord = jobPool.jobRecord(queueJob, started, ended, err)
+	}
+
+	// Record the error before the job can be retried.
+	jobPool.recordError(queueJob, ended, err, panicked)
+
+	jobRecord.Outcome = OutcomeCompleted
+	switch {
+	case panicked == true:
+		jobPool.notePanic(queueJob.name, ended)
+		jobRecord.Outcome = OutcomePanicked
+
+	case err != nil:
+		jobRecord.Outcome = OutcomeFailed
+	}
+
+	return jobRecord, panicked, err
+}
+
+// checkQueueLatency counts and reports a job that waited in queue longer than the configured
+// MaxAcceptableQueueLatency. The wait is measured with the monotonic clock.
+func (jobPool *JobPool) checkQueueLatency(queueJob *queueJob, started time.Time) {
+	if jobPool.config.MaxAcceptableQueueLatency <= 0 {
+		return
+	}
+
+	waited := started.Sub(queueJob.enqueuedAt)
+	if waited <= jobPool.config.MaxAcceptableQueueLatency {
+		return
+	}
+
+	atomic.AddInt64(&jobPool.queueLatencyAlerts, 1)
+
+	if onQueueLatency := jobPool.config.OnQueueLatency; onQueueLatency != nil {
+		jobType := queueJob.name
+		priority := queueJob.priority
+
+		go jobPool.callbackSafely("jobRoutine", "OnQueueLatency", func() {
+			onQueueLatency(jobType, priority, waited)
+		})
+	}
+}
+
+// executeJob runs the job and recovers from any panic it raises.
+func (jobPool *JobPool) executeJob(ctx context.Context, queueJob *queueJob, jobRoutine int) (panicked bool, err error) {
+	panicked = true
+	defer jobPool.catchJobPanic(&err, queueJob, jobPool.workerName(jobRoutine), "executeJob")
+
+	if jobPool.tracing() == true {
+		defer trace.StartRegion(ctx, "execute").End()
+	}
+
+	// Locks nest, so a pinned routine stays locked once the job returns.
+	if jobPool.lockThread(queueJob) == true {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	queueJob.attempts++
+
+	// Tell a submitter waiting for the handoff the job has started.
+	if queueJob.attempts == 1 && queueJob.started != nil {
+		close(queueJob.started)
+	}
+
+	// A FaultInjector can make the job panic.
+	jobPool.runFault(queueJob)
+
+	switch jober := queueJob.Jobber.(type) {
+	case ContextJobber:
+		runningCtx, done := jobPool.runningContext(ctx, queueJob)
+		defer done()
+
+		err = jober.RunJobContext(jobPool.jobContext(runningCtx, queueJob, jobRoutine), jobRoutine)
+
+	case LoggerJobber:
+		err = jober.RunJobLogger(jobPool.jobLogger(queueJob, jobRoutine), jobRoutine)
+
+	case ErrorJobber:
+		err = jober.RunJobError(jobRoutine)
+
+	default:
+		jober.RunJob(jobRoutine)
+	}
+
+	return false, err
 }
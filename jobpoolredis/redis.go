@@ -0,0 +1,247 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package jobpoolredis shares one logical job queue between processes through a pair of Redis lists.
+
+Jobs are serialized with the job type registry of the jobpool package, so every job type must be registered with
+jobpool.RegisterJobType in each process. Push places a job on the priority or normal list with LPUSH. Feed pops jobs
+with BRPOP, taking the priority list first, and queues them in the local JobPool where they run on its job routines.
+A popped job Feed can't hand to the pool, because the pool is shut down or the context ends while the pool is at
+capacity, is put back at the head of its list with RPUSH so it keeps its place.
+
+The package does not depend on a Redis driver. The Client interface is small enough to be satisfied by a thin wrapper
+around any driver.
+
+*/
+package jobpoolredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// ConnectionError reports a failed call to Redis.
+	ConnectionError struct {
+		Op  string // The Redis command that failed.
+		Err error  // The error returned by the client.
+	}
+
+	// Queue pushes jobs to and feeds jobs from a pair of Redis lists.
+	Queue struct {
+		client           Client                   // Talks to Redis.
+		priorityKey      string                   // The list holding priority jobs.
+		normalKey        string                   // The list holding normal jobs.
+		popTimeout       time.Duration            // How long a BRPOP waits for a job.
+		backoff          jobpool.BackoffStrategy  // The delay between attempts after a failure.
+		rejectionHandler jobpool.RejectionHandler // Receives the jobs that could not be pushed or queued.
+	}
+
+	// Option changes a setting of a Queue.
+	Option func(*Queue)
+)
+
+//** INTERFACES
+
+// Client is the part of a Redis client the queue needs. BRPop returns a nil value and no error
+// when the timeout expires without a job.
+type Client interface {
+	LPush(ctx context.Context, key string, value []byte) error
+	RPush(ctx context.Context, key string, value []byte) error
+	BRPop(ctx context.Context, timeout time.Duration, keys ...string) (key string, value []byte, err error)
+}
+
+//** VARIABLES
+
+var (
+	// ErrQueueClosed is returned by Feed when the pool has been shut down.
+	ErrQueueClosed = errors.New("Redis Queue Closed")
+)
+
+//** PUBLIC FUNCTIONS
+
+// New creates a queue stored in the lists name:priority and name:normal.
+func New(client Client, name string, options ...Option) *Queue {
+	queue := Queue{
+		client:      client,
+		priorityKey: name + ":priority",
+		normalKey:   name + ":normal",
+		popTimeout:  time.Second,
+		backoff:     jobpool.ExponentialBackoff(50*time.Millisecond, 5*time.Second),
+	}
+
+	for _, option := range options {
+		option(&queue)
+	}
+
+	return &queue
+}
+
+// WithPopTimeout sets how long each BRPOP waits for a job before checking the context again.
+func WithPopTimeout(timeout time.Duration) Option {
+	return func(queue *Queue) {
+		queue.popTimeout = timeout
+	}
+}
+
+// WithBackoff sets the delay between attempts after Redis fails or the pool is at capacity.
+func WithBackoff(strategy jobpool.BackoffStrategy) Option {
+	return func(queue *Queue) {
+		queue.backoff = strategy
+	}
+}
+
+// WithRejectionHandler sets the handler told about jobs that could not be pushed to Redis or
+// queued in the pool. It is only called with a job, so a failed pop or a job that can't be
+// decoded is not reported.
+func WithRejectionHandler(rejectionHandler jobpool.RejectionHandler) Option {
+	return func(queue *Queue) {
+		queue.rejectionHandler = rejectionHandler
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Push serializes the job and places it on the shared queue.
+func (queue *Queue) Push(ctx context.Context, jober jobpool.Jobber, priority bool) error {
+	data, err := jobpool.MarshalJob(jober, priority)
+	if err != nil {
+		queue.reject(jober, err)
+		return err
+	}
+
+	key := queue.normalKey
+	if priority == true {
+		key = queue.priorityKey
+	}
+
+	if err := queue.client.LPush(ctx, key, data); err != nil {
+		err = &ConnectionError{Op: "LPUSH", Err: err}
+		queue.reject(jober, err)
+		return err
+	}
+
+	return nil
+}
+
+// Feed pops jobs from the shared queue and queues them in the pool until the context is done or
+// the pool is shut down. A failed pop is retried with backoff. A job the pool has no room for is
+// held and offered again with backoff, so Feed never pops more jobs than the pool can take.
+func (queue *Queue) Feed(ctx context.Context, jobPool *jobpool.JobPool) error {
+	failures := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, data, err := queue.client.BRPop(ctx, queue.popTimeout, queue.priorityKey, queue.normalKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			failures++
+			if err := queue.wait(ctx, failures); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		failures = 0
+		if data == nil {
+			continue
+		}
+
+		if err := queue.offer(ctx, jobPool, data); err != nil {
+			return err
+		}
+	}
+}
+
+// Error implements the error interface.
+func (connectionError *ConnectionError) Error() string {
+	return fmt.Sprintf("Redis %s Failed : %v", connectionError.Op, connectionError.Err)
+}
+
+// Unwrap returns the error returned by the client.
+func (connectionError *ConnectionError) Unwrap() error {
+	return connectionError.Err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// offer queues a popped job in the pool, waiting while the pool is at capacity. A job that is
+// not queued because the pool is shut down or the context ends is put back in Redis.
+func (queue *Queue) offer(ctx context.Context, jobPool *jobpool.JobPool, data []byte) error {
+	jober, priority, err := jobpool.UnmarshalJob(data)
+	if err != nil {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := jobPool.QueueJob("Redis", jober, priority, jobpool.WithRetainOnReject())
+		switch {
+		case err == nil:
+			return nil
+
+		case errors.Is(err, jobpool.ErrPoolClosed):
+			// Put the job back so another process can run it.
+			queue.restore(jober, priority, data)
+			return ErrQueueClosed
+
+		case errors.Is(err, jobpool.ErrPoolAtCapacity), errors.Is(err, jobpool.ErrTenantQuotaExceeded):
+			if err := queue.wait(ctx, attempt); err != nil {
+				queue.restore(jober, priority, data)
+				return err
+			}
+
+		default:
+			queue.reject(jober, err)
+			return nil
+		}
+	}
+}
+
+// restore puts a popped job back at the head of its list, where BRPOP takes it next. The job is
+// passed to the rejection handler if Redis fails.
+func (queue *Queue) restore(jober jobpool.Jobber, priority bool, data []byte) {
+	key := queue.normalKey
+	if priority == true {
+		key = queue.priorityKey
+	}
+
+	if err := queue.client.RPush(context.Background(), key, data); err != nil {
+		queue.reject(jober, &ConnectionError{Op: "RPUSH", Err: err})
+	}
+}
+
+// wait sleeps for the backoff delay of the attempt or until the context is done.
+func (queue *Queue) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(queue.backoff.NextDelay(attempt, nil))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reject tells the rejection handler about a job that could not be pushed or queued.
+func (queue *Queue) reject(jober jobpool.Jobber, reason error) {
+	if queue.rejectionHandler != nil && jober != nil {
+		queue.rejectionHandler.Reject(jober, reason)
+	}
+}
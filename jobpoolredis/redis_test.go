@@ -0,0 +1,230 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpoolredis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// fakeClient is a Client that keeps its lists in memory.
+	fakeClient struct {
+		lists map[string][]string // The values of each list from the head to the tail.
+		mutex sync.Mutex          // Protects the lists.
+	}
+
+	// noteJob is a registered job that carries a note.
+	noteJob struct {
+		Note string `json:"note"` // Tells the jobs apart.
+	}
+
+	// blockJob holds its job routine until release is closed.
+	blockJob struct {
+		release chan struct{} // Closed to let the job return.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+func init() {
+	jobpool.RegisterJobType("jobpoolredis.noteJob", func() jobpool.Jobber {
+		return &noteJob{}
+	})
+}
+
+// TestFeedRestoresJob proves a job Feed popped but could not queue is put back at the head of
+// its list, where the next BRPOP takes it, when the context ends while the pool is at capacity
+// and when the pool is shut down.
+func TestFeedRestoresJob(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+		pool func(t *testing.T) *jobpool.JobPool
+	}{
+		{
+			name: "ContextDone",
+			want: context.Canceled,
+			pool: func(t *testing.T) *jobpool.JobPool {
+				jobPool := newPool(t)
+
+				// Fill the pool: one job runs and one waits in the queue.
+				release := make(chan struct{})
+				t.Cleanup(func() {
+					close(release)
+				})
+
+				if err := jobPool.QueueJob("test", &blockJob{release: release}, false); err != nil {
+					t.Fatalf("QueueJob : %s", err)
+				}
+
+				waitFor(t, "the first job to start", func() bool {
+					return jobPool.ActiveRoutines() == 1
+				})
+
+				if err := jobPool.QueueJob("test", &blockJob{release: release}, false); err != nil {
+					t.Fatalf("QueueJob : %s", err)
+				}
+
+				return jobPool
+			},
+		},
+		{
+			name: "PoolClosed",
+			want: ErrQueueClosed,
+			pool: func(t *testing.T) *jobpool.JobPool {
+				jobPool := newPool(t)
+				jobPool.Shutdown("test")
+
+				return jobPool
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient()
+			queue := New(client, "jobs", WithPopTimeout(time.Millisecond), WithBackoff(jobpool.ConstantBackoff(time.Millisecond)))
+
+			for _, note := range []string{"first", "second"} {
+				if err := queue.Push(context.Background(), &noteJob{Note: note}, false); err != nil {
+					t.Fatalf("Push : %s", err)
+				}
+			}
+			want := client.list("jobs:normal")
+
+			jobPool := test.pool(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fed := make(chan error, 1)
+			go func() {
+				fed <- queue.Feed(ctx, jobPool)
+			}()
+
+			// A pool at capacity holds Feed with the first job popped until the context ends.
+			if test.want == context.Canceled {
+				waitFor(t, "the first job to be popped", func() bool {
+					return len(client.list("jobs:normal")) == 1
+				})
+				cancel()
+			}
+
+			select {
+			case err := <-fed:
+				if errors.Is(err, test.want) == false {
+					t.Fatalf("Feed returned %v, want %v", err, test.want)
+				}
+
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for Feed to return")
+			}
+
+			if got := client.list("jobs:normal"); reflect.DeepEqual(got, want) == false {
+				t.Fatalf("List after Feed is %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// newFakeClient creates a client with empty lists.
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		lists: make(map[string][]string),
+	}
+}
+
+// newPool creates a pool with one job routine and room for one job that is shut down once the
+// test is over.
+func newPool(t *testing.T) *jobpool.JobPool {
+	jobPool := jobpool.New(1, 1, jobpool.WithLogger(jobpool.NopLogger), jobpool.WithoutManager())
+	t.Cleanup(func() {
+		jobPool.Shutdown("test")
+	})
+
+	return jobPool
+}
+
+// waitFor polls the condition until it holds or five seconds pass.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for condition() == false {
+		if time.Now().After(deadline) == true {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// RunJob does nothing.
+func (noteJob *noteJob) RunJob(jobRoutine int) {
+}
+
+// RunJob waits for the release.
+func (blockJob *blockJob) RunJob(jobRoutine int) {
+	<-blockJob.release
+}
+
+// LPush places the value at the head of the list.
+func (fakeClient *fakeClient) LPush(ctx context.Context, key string, value []byte) error {
+	fakeClient.mutex.Lock()
+	defer fakeClient.mutex.Unlock()
+
+	fakeClient.lists[key] = append([]string{string(value)}, fakeClient.lists[key]...)
+	return nil
+}
+
+// RPush places the value at the tail of the list.
+func (fakeClient *fakeClient) RPush(ctx context.Context, key string, value []byte) error {
+	fakeClient.mutex.Lock()
+	defer fakeClient.mutex.Unlock()
+
+	fakeClient.lists[key] = append(fakeClient.lists[key], string(value))
+	return nil
+}
+
+// BRPop takes the value at the tail of the first list that has one, waiting up to the timeout.
+func (fakeClient *fakeClient) BRPop(ctx context.Context, timeout time.Duration, keys ...string) (string, []byte, error) {
+	fakeClient.mutex.Lock()
+	for _, key := range keys {
+		if values := fakeClient.lists[key]; len(values) > 0 {
+			fakeClient.lists[key] = values[:len(values)-1]
+			fakeClient.mutex.Unlock()
+			return key, []byte(values[len(values)-1]), nil
+		}
+	}
+	fakeClient.mutex.Unlock()
+
+	select {
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+
+	return "", nil, nil
+}
+
+// list returns a copy of the list from the head to the tail.
+func (fakeClient *fakeClient) list(key string) []string {
+	fakeClient.mutex.Lock()
+	defer fakeClient.mutex.Unlock()
+
+	return append([]string(nil), fakeClient.lists[key]...)
+}
@@ -0,0 +1,319 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package jobpoolsqlite keeps the pending jobs of a single process in an embedded SQLite file so they survive a crash.
+
+Every job lives in one row of a single table holding its priority, a sequence that orders the jobs, the payload and
+a status. Jobs are serialized with the job type registry of the jobpool package, so every job type must be registered
+with jobpool.RegisterJobType. Push inserts a pending row. The Queue is a jobpool.Backend: Pop takes the oldest pending
+row, priority rows first, and marks it in flight under a lease. Feed hands the queue to JobPool.FeedBackend, which
+queues each job in the local pool, extends its lease while the pool holds it and deletes the row once the job has
+finished running. A job the pool cancels, for example because it was shut down with the job still in its queue, is
+returned to pending.
+
+Any number of processes can share one database file by creating their pools with jobpool.WithSharedBackend and the
+Queue. QueueJob then inserts the jobs as pending rows and each pool takes a row whenever it has a job routine to spare,
+so the jobs are split between the processes.
+
+A process that dies between taking a row and finishing its job leaves the row in flight. Once its lease expires the
+row is taken again with its delivery count raised, and Open returns such rows to pending straight away, so the jobs
+run again after a restart. A job can therefore run more than once and should be safe to repeat.
+
+The package does not depend on a SQLite driver. Open takes a *sql.DB opened with any driver, such as
+github.com/mattn/go-sqlite3 or modernc.org/sqlite, of SQLite 3.35 or later. SQLite serializes writers, so the database should be opened with a
+busy timeout when more than one routine uses it.
+
+*/
+package jobpoolsqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// DatabaseError reports a failed statement.
+	DatabaseError struct {
+		Op  string // The statement that failed.
+		Err error  // The error returned by the driver.
+	}
+
+	// Queue pushes jobs to and hands jobs out of a SQLite table.
+	Queue struct {
+		db               *sql.DB                  // The database holding the table.
+		table            string                   // The table holding the jobs.
+		lease            time.Duration            // How long a row stays in flight unless its lease is extended.
+		rejectionHandler jobpool.RejectionHandler // Receives the jobs that could not be pushed.
+	}
+
+	// Option changes a setting of a Queue.
+	Option func(*Queue)
+)
+
+//** CONSTANTS
+
+const (
+	// statusPending is the status of a row waiting to be handed out.
+	statusPending = 0
+
+	// statusInFlight is the status of a row handed out under a lease.
+	statusInFlight = 1
+)
+
+//** VARIABLES
+
+var (
+	// ErrInvalidTable is returned by Open when the table name is not a plain identifier.
+	ErrInvalidTable = errors.New("Invalid Table Name")
+
+	// tableName matches the table names Open accepts.
+	tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+//** PUBLIC FUNCTIONS
+
+// Open creates the table when it doesn't exist and returns the rows left in flight by a process
+// that died to pending.
+func Open(ctx context.Context, db *sql.DB, table string, options ...Option) (*Queue, error) {
+	if tableName.MatchString(table) == false {
+		return nil, fmt.Errorf("%w : Table[%s]", ErrInvalidTable, table)
+	}
+
+	queue := Queue{
+		db:    db,
+		table: table,
+		lease: 30 * time.Second,
+	}
+
+	for _, option := range options {
+		option(&queue)
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			sequence     INTEGER PRIMARY KEY AUTOINCREMENT,
+			priority     INTEGER NOT NULL,
+			payload      BLOB    NOT NULL,
+			status       INTEGER NOT NULL DEFAULT %d,
+			lease_id     TEXT    NOT NULL DEFAULT '',
+			leased_until INTEGER NOT NULL DEFAULT 0,
+			deliveries   INTEGER NOT NULL DEFAULT 0
+		)`, table, statusPending),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_pending ON %s (status, priority DESC, sequence)`, table, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_lease ON %s (lease_id)`, table, table),
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, &DatabaseError{Op: "CREATE", Err: err}
+		}
+	}
+
+	if _, err := queue.Recover(ctx); err != nil {
+		return nil, err
+	}
+
+	return &queue, nil
+}
+
+// WithLease sets how long a row stays in flight unless its lease is extended. FeedBackend
+// extends the lease every third of it while the pool holds the job.
+func WithLease(lease time.Duration) Option {
+	return func(queue *Queue) {
+		queue.lease = lease
+	}
+}
+
+// WithRejectionHandler sets the handler told about jobs that could not be pushed to the table.
+func WithRejectionHandler(rejectionHandler jobpool.RejectionHandler) Option {
+	return func(queue *Queue) {
+		queue.rejectionHandler = rejectionHandler
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Push serializes the job and inserts it as a pending row.
+func (queue *Queue) Push(ctx context.Context, jober jobpool.Jobber, priority bool) error {
+	data, err := jobpool.MarshalJob(jober, priority)
+	if err != nil {
+		queue.reject(jober, err)
+		return err
+	}
+
+	if err := queue.Put(ctx, data, priority); err != nil {
+		queue.reject(jober, err)
+		return err
+	}
+
+	return nil
+}
+
+// Feed queues the jobs of the table in the pool with FeedBackend until the context is done or
+// the pool is shut down.
+func (queue *Queue) Feed(ctx context.Context, jobPool *jobpool.JobPool) error {
+	return jobPool.FeedBackend(ctx, queue)
+}
+
+// Put inserts a serialized job as a pending row.
+func (queue *Queue) Put(ctx context.Context, data []byte, priority bool) error {
+	statement := fmt.Sprintf(`INSERT INTO %s (priority, payload, status) VALUES (?, ?, %d)`, queue.table, statusPending)
+	if _, err := queue.db.ExecContext(ctx, statement, priorityValue(priority), data); err != nil {
+		return &DatabaseError{Op: "INSERT", Err: err}
+	}
+
+	return nil
+}
+
+// Pop marks the next pending row, or the oldest row whose lease has expired, in flight under a
+// new lease. It returns nil when no row is waiting.
+func (queue *Queue) Pop(ctx context.Context) (*jobpool.Lease, error) {
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	// A single statement takes the row so pools sharing the file never wait on each other to
+	// turn a read into a write.
+	now := time.Now()
+	statement := fmt.Sprintf(`UPDATE %[1]s SET status = %[3]d, lease_id = ?, leased_until = ?, deliveries = deliveries + 1
+		WHERE sequence = (SELECT sequence FROM %[1]s WHERE status = %[2]d OR (status = %[3]d AND leased_until < ?) ORDER BY priority DESC, sequence LIMIT 1)
+		RETURNING payload, deliveries`, queue.table, statusPending, statusInFlight)
+
+	var lease jobpool.Lease
+
+	err = queue.db.QueryRowContext(ctx, statement, leaseID, now.Add(queue.lease).UnixNano(), now.UnixNano()).Scan(&lease.Data, &lease.Deliveries)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+
+	case err != nil:
+		return nil, &DatabaseError{Op: "UPDATE", Err: err}
+	}
+
+	lease.ID = leaseID
+	lease.TTL = queue.lease
+
+	return &lease, nil
+}
+
+// Extend renews the lease for another lease period.
+func (queue *Queue) Extend(ctx context.Context, lease *jobpool.Lease) error {
+	statement := fmt.Sprintf(`UPDATE %s SET leased_until = ? WHERE lease_id = ? AND status = %d`, queue.table, statusInFlight)
+	return queue.execLease(ctx, "UPDATE", statement, time.Now().Add(queue.lease).UnixNano(), lease.ID)
+}
+
+// Ack deletes the row of the lease.
+func (queue *Queue) Ack(ctx context.Context, lease *jobpool.Lease) error {
+	statement := fmt.Sprintf(`DELETE FROM %s WHERE lease_id = ? AND status = %d`, queue.table, statusInFlight)
+	return queue.execLease(ctx, "DELETE", statement, lease.ID)
+}
+
+// Release returns the row of the lease to pending.
+func (queue *Queue) Release(ctx context.Context, lease *jobpool.Lease) error {
+	statement := fmt.Sprintf(`UPDATE %s SET status = %d, lease_id = '', leased_until = 0 WHERE lease_id = ? AND status = %d`, queue.table, statusPending, statusInFlight)
+	return queue.execLease(ctx, "UPDATE", statement, lease.ID)
+}
+
+// Len returns the number of rows waiting to be handed out, counting the rows whose lease has
+// expired.
+func (queue *Queue) Len(ctx context.Context) (int64, error) {
+	statement := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE status = %d OR (status = %d AND leased_until < ?)`, queue.table, statusPending, statusInFlight)
+
+	var count int64
+	if err := queue.db.QueryRowContext(ctx, statement, time.Now().UnixNano()).Scan(&count); err != nil {
+		return 0, &DatabaseError{Op: "SELECT", Err: err}
+	}
+
+	return count, nil
+}
+
+// Recover returns the rows whose lease has expired to pending and returns how many were
+// returned.
+func (queue *Queue) Recover(ctx context.Context) (int64, error) {
+	statement := fmt.Sprintf(`UPDATE %s SET status = %d, lease_id = '', leased_until = 0 WHERE status = %d AND leased_until < ?`, queue.table, statusPending, statusInFlight)
+
+	result, err := queue.db.ExecContext(ctx, statement, time.Now().UnixNano())
+	if err != nil {
+		return 0, &DatabaseError{Op: "UPDATE", Err: err}
+	}
+
+	recovered, err := result.RowsAffected()
+	if err != nil {
+		return 0, &DatabaseError{Op: "UPDATE", Err: err}
+	}
+
+	return recovered, nil
+}
+
+// Error implements the error interface.
+func (databaseError *DatabaseError) Error() string {
+	return fmt.Sprintf("SQLite %s Failed : %v", databaseError.Op, databaseError.Err)
+}
+
+// Unwrap returns the error returned by the driver.
+func (databaseError *DatabaseError) Unwrap() error {
+	return databaseError.Err
+}
+
+//** PRIVATE FUNCTIONS
+
+// priorityValue returns the value stored in the priority column.
+func priorityValue(priority bool) int {
+	if priority == true {
+		return 1
+	}
+
+	return 0
+}
+
+// newLeaseID returns a random lease ID.
+func newLeaseID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// execLease runs a statement against the row of a lease. It returns jobpool.ErrLeaseLost if the
+// row has been handed out again.
+func (queue *Queue) execLease(ctx context.Context, op string, statement string, args ...interface{}) error {
+	result, err := queue.db.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return &DatabaseError{Op: op, Err: err}
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{Op: op, Err: err}
+	}
+
+	if updated == 0 {
+		return jobpool.ErrLeaseLost
+	}
+
+	return nil
+}
+
+// reject tells the rejection handler about a job that could not be pushed.
+func (queue *Queue) reject(jober jobpool.Jobber, reason error) {
+	if queue.rejectionHandler != nil {
+		queue.rejectionHandler.Reject(jober, reason)
+	}
+}
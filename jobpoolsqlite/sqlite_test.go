@@ -0,0 +1,637 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpoolsqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// fakeDriver is a database/sql driver that keeps each named database in memory and runs the
+	// statements the Queue sends with SQLite's semantics.
+	fakeDriver struct {
+		databases map[string]*fakeDatabase // The databases by name.
+		mutex     sync.Mutex               // Protects databases.
+	}
+
+	// fakeDatabase holds the rows of the jobs table.
+	fakeDatabase struct {
+		rows     []*fakeRow // The rows in the table.
+		sequence int64      // The last sequence given to a row.
+		mutex    sync.Mutex // Serializes the statements like SQLite's single writer.
+	}
+
+	// fakeRow is a row of the jobs table.
+	fakeRow struct {
+		sequence    int64  // The sequence column.
+		priority    int64  // The priority column.
+		payload     []byte // The payload column.
+		status      int64  // The status column.
+		leaseID     string // The lease_id column.
+		leasedUntil int64  // The leased_until column.
+		deliveries  int64  // The deliveries column.
+	}
+
+	// fakeConn is a connection to a fake database.
+	fakeConn struct {
+		database *fakeDatabase // The database the connection uses.
+	}
+
+	// fakeRows returns the rows of a query.
+	fakeRows struct {
+		columns []string         // The names of the columns.
+		values  [][]driver.Value // The rows left to return.
+	}
+
+	// noteJob is a registered job that records the delivery it ran with.
+	noteJob struct {
+		Note  string        `json:"note"`            // Tells the jobs apart.
+		Sleep time.Duration `json:"sleep,omitempty"` // How long the job runs for.
+	}
+
+	// noteDeliveries holds the delivery each run of a note's job saw.
+	noteDeliveries struct {
+		deliveries []int      // The delivery of each run.
+		mutex      sync.Mutex // Protects deliveries.
+	}
+
+	// blockJob runs the function as a job.
+	blockJob func()
+)
+
+//** VARIABLES
+
+var (
+	// drivers is the fake driver registered as "fakesqlite".
+	drivers = &fakeDriver{databases: make(map[string]*fakeDatabase)}
+
+	// deliveries maps each note to the deliveries seen by each run of its job.
+	deliveries sync.Map
+
+	// databases names a new database for each test.
+	databases int64
+
+	// pushes tells apart the notes pushed by each run of a test.
+	pushes int64
+)
+
+//** PUBLIC FUNCTIONS
+
+func init() {
+	sql.Register("fakesqlite", drivers)
+
+	jobpool.RegisterJobType("jobpoolsqlite.noteJob", func() jobpool.Jobber {
+		return &noteJob{}
+	})
+}
+
+// TestFeedCompletesJobs proves Feed runs the pushed jobs and deletes each row once its job has
+// finished.
+func TestFeedCompletesJobs(t *testing.T) {
+	db := openDatabase(t)
+
+	queue, err := Open(context.Background(), db, "jobs")
+	if err != nil {
+		t.Fatalf("Open : %s", err)
+	}
+
+	notes := pushNotes(t, queue, "first", "second", "third")
+
+	jobPool := newPool(t, 2, 10)
+	feed(t, queue, jobPool)
+
+	waitFor(t, "the jobs to run", func() bool {
+		return ran(notes...) == true
+	})
+
+	waitFor(t, "the rows to be deleted", func() bool {
+		return rowCount(t, db) == 0
+	})
+}
+
+// TestCrashRecovery leaves a row in flight as a process that died between taking a job and
+// finishing it would, and proves a restart returns the row to pending once its lease has
+// expired, so the job runs again with its second delivery. A row whose lease is live stays in
+// flight.
+func TestCrashRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		lease     time.Duration
+		recovered bool
+	}{
+		{"LeaseExpired", 10 * time.Millisecond, true},
+		{"LeaseLive", time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openDatabase(t)
+
+			crashed, err := Open(context.Background(), db, "jobs", WithLease(test.lease))
+			if err != nil {
+				t.Fatalf("Open : %s", err)
+			}
+
+			notes := pushNotes(t, crashed, "crashed-"+test.name)
+
+			// The process takes the row and dies before the job finishes.
+			if lease, err := crashed.Pop(context.Background()); err != nil || lease == nil {
+				t.Fatalf("Pop : Lease[%v] : %v", lease, err)
+			}
+
+			time.Sleep(20 * time.Millisecond)
+
+			// The restarted process opens the table.
+			restarted, err := Open(context.Background(), db, "jobs", WithLease(test.lease))
+			if err != nil {
+				t.Fatalf("Open : %s", err)
+			}
+
+			pending, err := restarted.Len(context.Background())
+			if err != nil {
+				t.Fatalf("Len : %s", err)
+			}
+
+			if (pending == 1) != test.recovered {
+				t.Fatalf("Len[%d] after the restart, recovered %v", pending, test.recovered)
+			}
+
+			if test.recovered == false {
+				if lease, err := restarted.Pop(context.Background()); err != nil || lease != nil {
+					t.Fatalf("Pop handed out a row under a live lease : Lease[%v] : %v", lease, err)
+				}
+				return
+			}
+
+			jobPool := newPool(t, 1, 10)
+			feed(t, restarted, jobPool)
+
+			waitFor(t, "the recovered job to run", func() bool {
+				return ran(notes...) == true
+			})
+
+			if got := delivered(notes[0]); len(got) != 1 || got[0] != 2 {
+				t.Fatalf("Deliveries[%v], want [2]", got)
+			}
+
+			waitFor(t, "the row to be deleted", func() bool {
+				return rowCount(t, db) == 0
+			})
+		})
+	}
+}
+
+// TestFeedReleasesCancelledJobs shuts the pool down with jobs from the table still in its
+// queue and proves their rows are returned to pending for the next process.
+func TestFeedReleasesCancelledJobs(t *testing.T) {
+	db := openDatabase(t)
+
+	queue, err := Open(context.Background(), db, "jobs")
+	if err != nil {
+		t.Fatalf("Open : %s", err)
+	}
+
+	// Hold the only routine so the jobs Feed queues wait in the pool.
+	jobPool := newPool(t, 1, 10)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := jobPool.QueueJob("test", blockJob(func() { close(started); <-release }), false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	notes := pushNotes(t, queue, "cancelled-1", "cancelled-2")
+	feed(t, queue, jobPool)
+
+	waitFor(t, "the jobs to be queued in the pool", func() bool {
+		return jobPool.QueuedJobs() == 2
+	})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	jobPool.Shutdown("test")
+
+	waitFor(t, "the rows to return to pending", func() bool {
+		pending, err := queue.Len(context.Background())
+		return err == nil && pending == 2
+	})
+
+	if ran(notes[0]) == true || ran(notes[1]) == true {
+		t.Fatal("A cancelled job ran")
+	}
+}
+
+// TestSharedBackend creates two pools sharing one table, as two processes would, queues jobs
+// through both and proves each job runs exactly once and the jobs are split between the pools.
+func TestSharedBackend(t *testing.T) {
+	db := openDatabase(t)
+
+	queue, err := Open(context.Background(), db, "jobs")
+	if err != nil {
+		t.Fatalf("Open : %s", err)
+	}
+
+	pools := []*jobpool.JobPool{
+		newPool(t, 2, 10, jobpool.WithSharedBackend(queue)),
+		newPool(t, 2, 10, jobpool.WithSharedBackend(queue)),
+	}
+
+	push := atomic.AddInt64(&pushes, 1)
+
+	var notes []string
+	for i := 0; i < 40; i++ {
+		note := fmt.Sprintf("%s/%d/shared-%d", t.Name(), push, i)
+		notes = append(notes, note)
+
+		job := &noteJob{Note: note, Sleep: time.Millisecond}
+		if err := pools[i%len(pools)].QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob %d : %s", i, err)
+		}
+	}
+
+	waitFor(t, "the jobs to run", func() bool {
+		return ran(notes...) == true
+	})
+
+	waitFor(t, "the rows to be deleted", func() bool {
+		return rowCount(t, db) == 0
+	})
+
+	for _, note := range notes {
+		if got := delivered(note); len(got) != 1 || got[0] != 1 {
+			t.Fatalf("%s : Deliveries[%v], want one run on its first delivery", note, got)
+		}
+	}
+
+	var completed []int32
+	waitFor(t, "the pools to count the jobs", func() bool {
+		completed = completed[:0]
+		for _, jobPool := range pools {
+			completed = append(completed, jobPool.Stats().CompletedJobs)
+		}
+
+		return completed[0]+completed[1] == int32(len(notes))
+	})
+
+	if completed[0] == 0 || completed[1] == 0 {
+		t.Fatalf("Completed%v, want the %d jobs split between both pools", completed, len(notes))
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Open returns a connection to the named database, creating it the first time.
+func (fakeDriver *fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDriver.mutex.Lock()
+	defer fakeDriver.mutex.Unlock()
+
+	database, found := fakeDriver.databases[name]
+	if found == false {
+		database = &fakeDatabase{}
+		fakeDriver.databases[name] = database
+	}
+
+	return &fakeConn{database: database}, nil
+}
+
+// Prepare is not supported, the statements are run through ExecContext and QueryContext.
+func (fakeConn *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("Prepare Not Supported")
+}
+
+// Close does nothing.
+func (fakeConn *fakeConn) Close() error {
+	return nil
+}
+
+// Begin is not supported.
+func (fakeConn *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("Transactions Not Supported")
+}
+
+// ExecContext runs a statement that returns no rows.
+func (fakeConn *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	database := fakeConn.database
+
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "CREATE"):
+		return driver.RowsAffected(0), nil
+
+	case strings.HasPrefix(query, "INSERT"):
+		database.sequence++
+		database.rows = append(database.rows, &fakeRow{
+			sequence: database.sequence,
+			priority: args[0].Value.(int64),
+			payload:  append([]byte{}, args[1].Value.([]byte)...),
+			status:   statusPending,
+		})
+		return driver.RowsAffected(1), nil
+
+	case strings.HasPrefix(query, "DELETE"):
+		leaseID := args[0].Value.(string)
+
+		var affected int64
+		rows := database.rows[:0]
+		for _, row := range database.rows {
+			if row.leaseID == leaseID && row.status == statusInFlight {
+				affected++
+				continue
+			}
+			rows = append(rows, row)
+		}
+		database.rows = rows
+		return driver.RowsAffected(affected), nil
+
+	case strings.Contains(query, "SET leased_until = ?"):
+		return database.update(func(row *fakeRow) bool {
+			if row.leaseID != args[1].Value.(string) || row.status != statusInFlight {
+				return false
+			}
+			row.leasedUntil = args[0].Value.(int64)
+			return true
+		}), nil
+
+	case strings.Contains(query, "WHERE lease_id = ?"):
+		return database.update(func(row *fakeRow) bool {
+			if row.leaseID != args[0].Value.(string) || row.status != statusInFlight {
+				return false
+			}
+			row.status, row.leaseID, row.leasedUntil = statusPending, "", 0
+			return true
+		}), nil
+
+	case strings.Contains(query, "AND leased_until < ?"):
+		return database.update(func(row *fakeRow) bool {
+			if row.status != statusInFlight || row.leasedUntil >= args[0].Value.(int64) {
+				return false
+			}
+			row.status, row.leaseID, row.leasedUntil = statusPending, "", 0
+			return true
+		}), nil
+	}
+
+	return nil, fmt.Errorf("Unknown Statement : %s", query)
+}
+
+// QueryContext runs a statement that returns rows.
+func (fakeConn *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	database := fakeConn.database
+
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "SELECT COUNT(*)"):
+		var count int64
+		for _, row := range database.rows {
+			if row.waiting(args[0].Value.(int64)) == true {
+				count++
+			}
+		}
+		return &fakeRows{columns: []string{"count"}, values: [][]driver.Value{{count}}}, nil
+
+	case strings.Contains(query, "RETURNING payload, deliveries"):
+		rows := &fakeRows{columns: []string{"payload", "deliveries"}}
+
+		// The oldest waiting row, priority rows first.
+		var next *fakeRow
+		for _, row := range database.rows {
+			if row.waiting(args[2].Value.(int64)) == false {
+				continue
+			}
+
+			if next == nil || row.priority > next.priority || (row.priority == next.priority && row.sequence < next.sequence) {
+				next = row
+			}
+		}
+
+		if next != nil {
+			next.status = statusInFlight
+			next.leaseID = args[0].Value.(string)
+			next.leasedUntil = args[1].Value.(int64)
+			next.deliveries++
+			rows.values = append(rows.values, []driver.Value{append([]byte{}, next.payload...), next.deliveries})
+		}
+		return rows, nil
+	}
+
+	return nil, fmt.Errorf("Unknown Query : %s", query)
+}
+
+// Columns returns the names of the columns.
+func (fakeRows *fakeRows) Columns() []string {
+	return fakeRows.columns
+}
+
+// Close does nothing.
+func (fakeRows *fakeRows) Close() error {
+	return nil
+}
+
+// Next copies the next row into dest.
+func (fakeRows *fakeRows) Next(dest []driver.Value) error {
+	if len(fakeRows.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, fakeRows.values[0])
+	fakeRows.values = fakeRows.values[1:]
+
+	return nil
+}
+
+// RunJob does nothing, see RunJobContext.
+func (noteJob *noteJob) RunJob(jobRoutine int) {
+}
+
+// RunJobContext records the delivery the job ran with.
+func (noteJob *noteJob) RunJobContext(ctx context.Context, jobRoutine int) error {
+	time.Sleep(noteJob.Sleep)
+
+	value, _ := deliveries.LoadOrStore(noteJob.Note, &noteDeliveries{})
+
+	noteDeliveries := value.(*noteDeliveries)
+	noteDeliveries.mutex.Lock()
+	noteDeliveries.deliveries = append(noteDeliveries.deliveries, jobpool.MetaFromContext(ctx).Deliveries)
+	noteDeliveries.mutex.Unlock()
+
+	return nil
+}
+
+// RunJob calls the function.
+func (blockJob blockJob) RunJob(jobRoutine int) {
+	blockJob()
+}
+
+//** PRIVATE FUNCTIONS
+
+// openDatabase opens a new fake database for the test.
+func openDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("fakesqlite", fmt.Sprintf("test-%d", atomic.AddInt64(&databases, 1)))
+	if err != nil {
+		t.Fatalf("sql.Open : %s", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db
+}
+
+// pushNotes pushes a noteJob for each note, made unique to the run of the test, and returns the
+// notes.
+func pushNotes(t *testing.T, queue *Queue, notes ...string) []string {
+	t.Helper()
+
+	push := atomic.AddInt64(&pushes, 1)
+	for i, note := range notes {
+		notes[i] = fmt.Sprintf("%s/%d/%s", t.Name(), push, note)
+		if err := queue.Push(context.Background(), &noteJob{Note: notes[i]}, false); err != nil {
+			t.Fatalf("Push : %s", err)
+		}
+	}
+
+	return notes
+}
+
+// newPool creates a pool with the options that is shut down once the test is over.
+func newPool(t *testing.T, routines int, capacity int32, options ...jobpool.Option) *jobpool.JobPool {
+	options = append([]jobpool.Option{jobpool.WithLogger(jobpool.NopLogger), jobpool.WithoutManager()}, options...)
+
+	jobPool := jobpool.New(routines, capacity, options...)
+	t.Cleanup(func() {
+		jobPool.Shutdown("test")
+	})
+
+	return jobPool
+}
+
+// feed runs Feed until the test is over.
+func feed(t *testing.T, queue *Queue, jobPool *jobpool.JobPool) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		queue.Feed(ctx, jobPool)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-fed
+	})
+}
+
+// ran returns true if every note's job has run.
+func ran(notes ...string) bool {
+	for _, note := range notes {
+		if len(delivered(note)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// delivered returns the delivery each run of the note's job saw.
+func delivered(note string) []int {
+	value, found := deliveries.Load(note)
+	if found == false {
+		return nil
+	}
+
+	noteDeliveries := value.(*noteDeliveries)
+	noteDeliveries.mutex.Lock()
+	defer noteDeliveries.mutex.Unlock()
+
+	got := append([]int{}, noteDeliveries.deliveries...)
+	sort.Ints(got)
+
+	return got
+}
+
+// rowCount returns the number of rows in the table, pending or in flight.
+func rowCount(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Conn : %s", err)
+	}
+	defer conn.Close()
+
+	var count int
+	conn.Raw(func(driverConn interface{}) error {
+		database := driverConn.(*fakeConn).database
+
+		database.mutex.Lock()
+		count = len(database.rows)
+		database.mutex.Unlock()
+
+		return nil
+	})
+
+	return count
+}
+
+// waitFor polls the condition until it holds or five seconds pass.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for condition() == false {
+		if time.Now().After(deadline) == true {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// update applies the change to each row and returns the number of rows it changed. The mutex
+// must be held.
+func (fakeDatabase *fakeDatabase) update(change func(row *fakeRow) bool) driver.Result {
+	var affected int64
+	for _, row := range fakeDatabase.rows {
+		if change(row) == true {
+			affected++
+		}
+	}
+
+	return driver.RowsAffected(affected)
+}
+
+// waiting returns true if the row can be handed out at now.
+func (fakeRow *fakeRow) waiting(now int64) bool {
+	return fakeRow.status == statusPending || (fakeRow.status == statusInFlight && fakeRow.leasedUntil < now)
+}
@@ -0,0 +1,206 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package jobpooltest injects faults into a real JobPool so code built on the pool can be tested against
+a pool that misbehaves.
+
+Faults is a jobpool.FaultInjector driven from test code. It can refuse the next N submissions with
+jobpool.ErrPoolAtCapacity, delay every admission by a fixed duration, make the jobs of one type panic
+and freeze dequeues until they are thawed. The faults are applied by the pool at its own injection
+points, so the real admission, scheduling, retry and panic handling runs around them. Nothing is
+random: a fault applies from the call that sets it until the call that clears it.
+
+	pool := jobpooltest.NewPool(2, 10)
+	defer pool.Shutdown("test")
+
+	pool.Faults.RejectNext(1)
+	err := pool.QueueJob("test", job, false) // errors.Is(err, jobpool.ErrPoolAtCapacity)
+
+A frozen pool keeps admitting jobs until its queue is at capacity. Shutdown releases the frozen job
+routines, so a test that forgets to thaw does not hang.
+
+*/
+package jobpooltest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/goinggo/jobpool"
+)
+
+//** TYPES
+
+type (
+	// Faults holds the faults the pool is told to inject. The zero value injects nothing.
+	Faults struct {
+		rejectNext int                    // The number of submissions still to refuse.
+		rejectWith error                  // The error the submissions are refused with.
+		admitDelay time.Duration          // How long each admission is held.
+		panics     map[string]interface{} // The value each job type panics with.
+		frozen     chan struct{}          // Closed to thaw the dequeues. Nil while dequeues are not frozen.
+		mutex      sync.Mutex             // Protects the faults.
+	}
+
+	// Pool is a JobPool created with a Faults as its FaultInjector.
+	Pool struct {
+		*jobpool.JobPool         // The pool the faults are injected into.
+		Faults           *Faults // The faults injected into the pool.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// New creates a Faults that injects nothing until told to.
+func New() *Faults {
+	return &Faults{}
+}
+
+// NewPool creates a JobPool with the options given and a Faults injected into it.
+func NewPool(numberOfRoutines int, queueCapacity int32, options ...jobpool.Option) *Pool {
+	faults := New()
+
+	return &Pool{
+		JobPool: jobpool.New(numberOfRoutines, queueCapacity, append(options, faults.Option())...),
+		Faults:  faults,
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Option returns the option that injects the faults into a pool created with jobpool.New.
+func (faults *Faults) Option() jobpool.Option {
+	return jobpool.WithFaultInjector(faults)
+}
+
+// RejectNext refuses the next n submissions with jobpool.ErrPoolAtCapacity, as a pool whose
+// queue is full would.
+func (faults *Faults) RejectNext(n int) {
+	faults.RejectNextWith(n, jobpool.ErrPoolAtCapacity)
+}
+
+// RejectNextWith refuses the next n submissions with err. The pool wraps err in a
+// jobpool.RejectError like any other rejection.
+func (faults *Faults) RejectNextWith(n int, err error) {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	faults.rejectNext = n
+	faults.rejectWith = err
+}
+
+// DelayAdmissions holds every submission for delay before it is queued. A zero delay stops
+// holding them.
+func (faults *Faults) DelayAdmissions(delay time.Duration) {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	faults.admitDelay = delay
+}
+
+// PanicOn makes every job of the type panic with value when it starts to run. The type is the
+// job's Name when it implements jobpool.Namer and its type name otherwise.
+func (faults *Faults) PanicOn(jobType string, value interface{}) {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	if faults.panics == nil {
+		faults.panics = make(map[string]interface{})
+	}
+
+	faults.panics[jobType] = value
+}
+
+// ClearPanic lets the jobs of the type run again.
+func (faults *Faults) ClearPanic(jobType string) {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	delete(faults.panics, jobType)
+}
+
+// FreezeDequeues stops the job routines from taking jobs from the queues until ThawDequeues is
+// called or the pool is shut down. The jobs already running finish.
+func (faults *Faults) FreezeDequeues() {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	if faults.frozen == nil {
+		faults.frozen = make(chan struct{})
+	}
+}
+
+// ThawDequeues lets the job routines take jobs from the queues again.
+func (faults *Faults) ThawDequeues() {
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	if faults.frozen != nil {
+		close(faults.frozen)
+		faults.frozen = nil
+	}
+}
+
+// Reset clears every fault and thaws the dequeues.
+func (faults *Faults) Reset() {
+	faults.ThawDequeues()
+
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	faults.rejectNext = 0
+	faults.rejectWith = nil
+	faults.admitDelay = 0
+	faults.panics = nil
+}
+
+// BeforeAdmit implements jobpool.FaultInjector. It holds the submission for the admission
+// delay and then refuses it while submissions are left to refuse.
+func (faults *Faults) BeforeAdmit(jobType string, jober jobpool.Jobber) error {
+	faults.mutex.Lock()
+	delay := faults.admitDelay
+	faults.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	faults.mutex.Lock()
+	defer faults.mutex.Unlock()
+
+	if faults.rejectNext <= 0 {
+		return nil
+	}
+
+	faults.rejectNext--
+	return faults.rejectWith
+}
+
+// BeforeDequeue implements jobpool.FaultInjector. It blocks while the dequeues are frozen.
+func (faults *Faults) BeforeDequeue(closed <-chan struct{}) {
+	faults.mutex.Lock()
+	frozen := faults.frozen
+	faults.mutex.Unlock()
+
+	if frozen == nil {
+		return
+	}
+
+	select {
+	case <-frozen:
+	case <-closed:
+	}
+}
+
+// BeforeRun implements jobpool.FaultInjector. It panics for a job of a type set by PanicOn.
+func (faults *Faults) BeforeRun(jobType string, jober jobpool.Jobber) {
+	faults.mutex.Lock()
+	value, found := faults.panics[jobType]
+	faults.mutex.Unlock()
+
+	if found == true {
+		panic(value)
+	}
+}
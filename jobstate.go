@@ -0,0 +1,87 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** CONSTANTS
+
+// Every job moves through these states with compare and swap, so of the paths racing for a job
+// only one can win it: it either runs once or is cancelled, never both. A retry moves the job
+// from running back to pending.
+const (
+	// jobPending is the state of a job in the queues or the intake buffer.
+	jobPending int32 = iota
+
+	// jobClaimed is the state of a job taken from the queues for a job routine, or of a job its
+	// submitter is about to run under CallerRuns.
+	jobClaimed
+
+	// jobRunning is the state of a job a routine has started.
+	jobRunning
+
+	// jobDone is the state of a job that finished running and is not being retried.
+	jobDone
+
+	// jobCancelled is the state of a job removed from the pool before it started.
+	jobCancelled
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// transition moves the job from one state to another. It returns false if the job was not in
+// the from state, in which case another path has already won the job.
+func (queueJob *queueJob) transition(from int32, to int32) bool {
+	if atomic.CompareAndSwapInt32(&queueJob.state, from, to) == false {
+		return false
+	}
+
+	queueJob.reportState(to)
+	return true
+}
+
+// setState moves the job to a state whatever state it is in.
+func (queueJob *queueJob) setState(state int32) {
+	atomic.StoreInt32(&queueJob.state, state)
+	queueJob.reportState(state)
+}
+
+// reportState mirrors the job's state onto the handle of its submission and the handles of the
+// submissions coalesced into it. A job that is done or cancelled leaves the in-flight registry
+// and must have been accounted for when the pool has integrity checks.
+func (queueJob *queueJob) reportState(state int32) {
+	if state == jobDone || state == jobCancelled {
+		queueJob.checkAccounted()
+	}
+
+	if queueJob.outstanding != nil && (state == jobDone || state == jobCancelled) {
+		queueJob.outstanding.finish(queueJob)
+	}
+
+	jobState := handleState(state)
+
+	queueJob.handle.setState(jobState)
+	for _, follower := range queueJob.followers {
+		follower.setState(jobState)
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// handleState returns the JobState a job's handle reports for the job's state.
+func handleState(state int32) JobState {
+	switch state {
+	case jobRunning:
+		return StateRunning
+	case jobDone:
+		return StateDone
+	case jobCancelled:
+		return StateCancelled
+	default:
+		return StateAdmitted
+	}
+}
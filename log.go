@@ -0,0 +1,182 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+//** TYPES
+
+type (
+	// LogLevel is the level of an internal message.
+	LogLevel int
+
+	// stdLogger writes to the standard logger.
+	stdLogger struct{}
+
+	// nopLogger discards every message.
+	nopLogger struct{}
+
+	// jobLogger writes a job's messages through the pool's logger tagged with the job's identity.
+	jobLogger struct {
+		jobPool   *JobPool // The pool whose logger and level are used.
+		goRoutine string   // The job routine running the job.
+		tags      string   // The pool name, job ID and trace ID.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// LogDebug is used for the lifecycle of the queue and job routines.
+	LogDebug LogLevel = iota
+
+	// LogInfo is used for shutdown progress and stats reports.
+	LogInfo
+
+	// LogError is used for errors and panics.
+	LogError
+
+	// LogOff silences every message. Panics are still written unless SilencePanics is set.
+	LogOff
+)
+
+//** VARIABLES
+
+var (
+	// NopLogger is a Logger that discards every message.
+	NopLogger Logger = nopLogger{}
+)
+
+//** INTERFACES
+
+// Logger is an interface that is implemented to receive the pool's internal messages.
+// A *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// String returns the name of the level.
+func (logLevel LogLevel) String() string {
+	switch logLevel {
+	case LogDebug:
+		return "DEBUG"
+
+	case LogInfo:
+		return "INFO"
+
+	case LogError:
+		return "ERROR"
+
+	case LogOff:
+		return "OFF"
+	}
+
+	return fmt.Sprintf("LogLevel(%d)", int(logLevel))
+}
+
+// Printf writes the message to the standard logger.
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Printf discards the message.
+func (nopLogger) Printf(format string, v ...interface{}) {
+}
+
+// Printf writes the message at LogInfo tagged with the job's identity.
+func (jobLogger *jobLogger) Printf(format string, v ...interface{}) {
+	jobLogger.jobPool.writeLogf(LogInfo, jobLogger.goRoutine, jobLogger.tags, format, v...)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// logger returns the logger configured for the pool.
+func (jobPool *JobPool) logger() Logger {
+	if jobPool.config.Logger == nil {
+		return stdLogger{}
+	}
+
+	return jobPool.config.Logger
+}
+
+// jobLogger returns a logger tagged for the job running on the job routine.
+func (jobPool *JobPool) jobLogger(queueJob *queueJob, jobRoutine int) Logger {
+	tags := fmt.Sprintf("Pool[%s] Job[%d]", jobPool.config.Name, queueJob.id)
+	if queueJob.traceID != "" {
+		tags += fmt.Sprintf(" Trace[%s]", queueJob.traceID)
+	}
+
+	tags += metadataTag(queueJob.metadata)
+
+	return &jobLogger{
+		jobPool:   jobPool,
+		goRoutine: jobPool.workerName(jobRoutine),
+		tags:      tags,
+	}
+}
+
+// writeLog is used to write a system message to the logger if the level is enabled.
+func (jobPool *JobPool) writeLog(logLevel LogLevel, goRoutine string, functionName string, message string) {
+	if logLevel < jobPool.config.LogLevel {
+		return
+	}
+
+	jobPool.logger().Printf("%s : %s : %s : %s\n", logLevel, goRoutine, functionName, message)
+}
+
+// writeLogf is used to write a formatted system message to the logger if the level is enabled.
+func (jobPool *JobPool) writeLogf(logLevel LogLevel, goRoutine string, functionName string, format string, a ...interface{}) {
+	if logLevel < jobPool.config.LogLevel {
+		return
+	}
+
+	jobPool.writeLog(logLevel, goRoutine, functionName, fmt.Sprintf(format, a...))
+}
+
+// writePanic is used to write a panic report. Panics are written regardless of the log level
+// unless SilencePanics is set.
+func (jobPool *JobPool) writePanic(goRoutine string, functionName string, message string) {
+	if jobPool.config.SilencePanics == true && LogError < jobPool.config.LogLevel {
+		return
+	}
+
+	jobPool.logger().Printf("%s : %s : %s : %s\n", LogError, goRoutine, functionName, message)
+}
+
+//** PRIVATE FUNCTIONS
+
+// formatMetadata returns the key/value pairs as key=value sorted by key and separated by commas.
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + metadata[key]
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// metadataTag returns the key/value pairs as a Meta tag for a log message or an empty string
+// when there are none.
+func metadataTag(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" Meta[%s]", formatMetadata(metadata))
+}
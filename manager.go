@@ -0,0 +1,349 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// Manager holds a set of pools by name so they can be watched and shut down together.
+	Manager struct {
+		pools        map[string]*JobPool // The registered pools by name.
+		dependencies map[string][]string // The pools that must be shut down before each pool.
+		mutex        sync.Mutex          // Protects the maps.
+	}
+
+	// ManagerStats combines the Stats of every pool registered with a Manager.
+	ManagerStats struct {
+		TakenAt        time.Time        `json:"taken_at"`        // When the snapshot was taken.
+		QueuedJobs     int64            `json:"queued_jobs"`     // The number of pending jobs across the pools.
+		ActiveRoutines int64            `json:"active_routines"` // The number of routines active across the pools.
+		CompletedJobs  int64            `json:"completed_jobs"`  // The number of jobs run to completion across the pools.
+		EnqueuedJobs   int64            `json:"enqueued_jobs"`   // The number of jobs placed in the queues across the pools.
+		DequeuedJobs   int64            `json:"dequeued_jobs"`   // The number of jobs taken from the queues across the pools.
+		QueueCapacity  int64            `json:"queue_capacity"`  // The combined capacity of the queues.
+		Pools          map[string]Stats `json:"pools"`           // The snapshot of each pool by name.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrPoolRegistered is returned by Register when the name is taken or the pool is already
+	// registered with a manager.
+	ErrPoolRegistered = errors.New("Pool Already Registered")
+
+	// ErrDependencyCycle is returned by DependsOn when the dependency would make the shutdown
+	// order impossible to satisfy.
+	ErrDependencyCycle = errors.New("Pool Dependency Cycle")
+
+	// defaultManager is the manager pools register with unless told otherwise.
+	defaultManager = NewManager()
+)
+
+//** PUBLIC FUNCTIONS
+
+// NewManager creates an empty manager.
+func NewManager() *Manager {
+	return &Manager{
+		pools:        make(map[string]*JobPool),
+		dependencies: make(map[string][]string),
+	}
+}
+
+// DefaultManager returns the manager every pool registers with unless it is created with
+// WithManager or WithoutManager.
+func DefaultManager() *Manager {
+	return defaultManager
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Register adds the pool to the manager under the name. A pool can only be registered with one
+// manager and is removed from it once the pool is shut down.
+func (manager *Manager) Register(name string, jobPool *JobPool) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if _, found := manager.pools[name]; found == true {
+		return fmt.Errorf("%w : Name[%s]", ErrPoolRegistered, name)
+	}
+
+	if jobPool.setManager(manager) == false {
+		return fmt.Errorf("%w : Name[%s]", ErrPoolRegistered, name)
+	}
+
+	manager.pools[name] = jobPool
+	return nil
+}
+
+// Unregister removes the pool with the name from the manager. The pool keeps running. It
+// returns false if no pool is registered under the name.
+func (manager *Manager) Unregister(name string) bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	jobPool, found := manager.pools[name]
+	if found == false {
+		return false
+	}
+
+	delete(manager.pools, name)
+	jobPool.setManager(nil)
+
+	return true
+}
+
+// Pool returns the pool registered under the name or nil.
+func (manager *Manager) Pool(name string) *JobPool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	return manager.pools[name]
+}
+
+// Names returns the names of the registered pools in order.
+func (manager *Manager) Names() []string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	names := make([]string, 0, len(manager.pools))
+	for name := range manager.pools {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// DependsOn declares that the downstream pool is fed by the upstream pool, so ShutdownAll
+// shuts the upstream pool down first. The pools don't need to be registered yet.
+func (manager *Manager) DependsOn(downstream string, upstream string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if downstream == upstream || manager.dependsOn(upstream, downstream) == true {
+		return fmt.Errorf("%w : Downstream[%s] Upstream[%s]", ErrDependencyCycle, downstream, upstream)
+	}
+
+	manager.dependencies[downstream] = append(manager.dependencies[downstream], upstream)
+	return nil
+}
+
+// AggregateStats returns the Stats of every registered pool and their combined counters.
+func (manager *Manager) AggregateStats() ManagerStats {
+	pools := manager.snapshot()
+
+	managerStats := ManagerStats{
+		TakenAt: time.Now(),
+		Pools:   make(map[string]Stats, len(pools)),
+	}
+
+	for name, jobPool := range pools {
+		stats := jobPool.Stats()
+
+		managerStats.QueuedJobs += int64(stats.QueuedJobs)
+		managerStats.ActiveRoutines += int64(stats.ActiveRoutines)
+		managerStats.CompletedJobs += int64(stats.CompletedJobs)
+		managerStats.EnqueuedJobs += stats.EnqueuedJobs
+		managerStats.DequeuedJobs += stats.DequeuedJobs
+		managerStats.QueueCapacity += int64(stats.QueueCapacity)
+		managerStats.Pools[name] = stats
+	}
+
+	return managerStats
+}
+
+// StatusJSON returns the AggregateStats snapshot encoded as JSON.
+func (manager *Manager) StatusJSON() ([]byte, error) {
+	return json.Marshal(manager.AggregateStats())
+}
+
+// StatusHandler returns an http.Handler that writes the AggregateStats snapshot as JSON.
+func (manager *Manager) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := manager.StatusJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(status)
+	})
+}
+
+// ShutdownAll shuts down every registered pool. Pools are shut down in parallel except where a
+// dependency was declared, in which case the upstream pool finishes its shutdown first. Each
+// pool shuts down as Shutdown does, letting its running jobs complete. If the context is done
+// first the pools already shutting down carry on in the background, the pools waiting on them
+// are left running and the context's error is returned with the name of each pool. The errors
+// of every pool are joined.
+func (manager *Manager) ShutdownAll(ctx context.Context) error {
+	var errs []error
+
+	pools := manager.snapshot()
+	for _, wave := range manager.shutdownWaves(pools) {
+		waveErrs := make([]error, len(wave))
+
+		var waitGroup sync.WaitGroup
+		for i, name := range wave {
+			waitGroup.Add(1)
+			go func(i int, name string, jobPool *JobPool) {
+				defer waitGroup.Done()
+
+				if err := jobPool.Shutdown("ShutdownAll"); err != nil {
+					waveErrs[i] = fmt.Errorf("Pool[%s] : %w", name, err)
+				}
+			}(i, name, pools[name])
+		}
+
+		done := make(chan struct{})
+		go func() {
+			waitGroup.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			errs = append(errs, waveErrs...)
+
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("%w : Pools[%s]", ctx.Err(), strings.Join(wave, ", ")))
+			return errors.Join(errs...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// snapshot returns a copy of the registered pools.
+func (manager *Manager) snapshot() map[string]*JobPool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	pools := make(map[string]*JobPool, len(manager.pools))
+	for name, jobPool := range manager.pools {
+		pools[name] = jobPool
+	}
+
+	return pools
+}
+
+// dependsOn returns true if the downstream pool depends on the upstream pool directly or
+// through other pools. The mutex must be held.
+func (manager *Manager) dependsOn(downstream string, upstream string) bool {
+	for _, dependency := range manager.dependencies[downstream] {
+		if dependency == upstream || manager.dependsOn(dependency, upstream) == true {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shutdownWaves orders the pools into waves. Every pool a pool depends on is in an earlier
+// wave. Dependencies on pools that are not in the set are ignored.
+func (manager *Manager) shutdownWaves(pools map[string]*JobPool) [][]string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	remaining := make(map[string]struct{}, len(pools))
+	for name := range pools {
+		remaining[name] = struct{}{}
+	}
+
+	var waves [][]string
+	for len(remaining) > 0 {
+		var wave []string
+		for name := range remaining {
+			ready := true
+			for _, upstream := range manager.dependencies[name] {
+				if _, pending := remaining[upstream]; pending == true {
+					ready = false
+					break
+				}
+			}
+
+			if ready == true {
+				wave = append(wave, name)
+			}
+		}
+
+		sort.Strings(wave)
+		for _, name := range wave {
+			delete(remaining, name)
+		}
+
+		waves = append(waves, wave)
+	}
+
+	return waves
+}
+
+// register adds a new pool to its manager. A pool with a name that is taken is registered under
+// the next free numbered name, an unnamed pool is registered as "jobpool".
+func (manager *Manager) register(jobPool *JobPool) {
+	name := jobPool.config.Name
+	if name == "" {
+		name = "jobpool"
+	}
+
+	for errors.Is(manager.Register(name, jobPool), ErrPoolRegistered) == true {
+		name = cloneName(name)
+	}
+}
+
+// unregister removes the pool from the manager.
+func (manager *Manager) unregister(jobPool *JobPool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	for name, registered := range manager.pools {
+		if registered == jobPool {
+			delete(manager.pools, name)
+			return
+		}
+	}
+}
+
+// setManager records the manager the pool is registered with. It returns false if the pool is
+// already registered with a manager.
+func (jobPool *JobPool) setManager(manager *Manager) bool {
+	jobPool.managerMutex.Lock()
+	defer jobPool.managerMutex.Unlock()
+
+	if manager != nil && jobPool.manager != nil {
+		return false
+	}
+
+	jobPool.manager = manager
+	return true
+}
+
+// leaveManager removes the pool from the manager it is registered with.
+func (jobPool *JobPool) leaveManager() {
+	jobPool.managerMutex.Lock()
+	manager := jobPool.manager
+	jobPool.manager = nil
+	jobPool.managerMutex.Unlock()
+
+	if manager != nil {
+		manager.unregister(jobPool)
+	}
+}
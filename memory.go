@@ -0,0 +1,127 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+//** CONSTANTS
+
+const (
+	// memoryGuardInterval is how often the memory guard samples the heap.
+	memoryGuardInterval = 100 * time.Millisecond
+
+	// heapObjectsMetric is the runtime metric for the bytes held by heap objects, the same
+	// figure as MemStats.HeapAlloc without stopping the world to read it.
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+)
+
+//** VARIABLES
+
+var (
+	// ErrMemoryGuarded is returned for a normal job refused while the heap is over the soft limit
+	// set with WithMemoryGuard.
+	ErrMemoryGuarded = errors.New("Heap Over Memory Guard")
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithMemoryGuard sets a soft limit on the process heap as a safety valve for job types that
+// don't implement Sizer. A routine samples the heap and once it exceeds softLimit the pool
+// refuses new normal jobs with ErrMemoryGuarded, still admitting priority jobs, until the heap
+// falls under lowLimit. A lowLimit of zero is nine tenths of softLimit. onChange, which can be
+// nil, is called with the new state and the heap size each time the guard engages or releases.
+func WithMemoryGuard(softLimit int64, lowLimit int64, onChange func(guarded bool, heapBytes int64)) Option {
+	return func(config *Config) {
+		config.MemorySoftLimit = softLimit
+		config.MemoryLowLimit = lowLimit
+		config.OnMemoryGuard = onChange
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// MemoryGuarded returns true while the heap is over the soft limit set with WithMemoryGuard and
+// normal jobs are refused.
+func (jobPool *JobPool) MemoryGuarded() bool {
+	return atomic.LoadInt32(&jobPool.memoryGuarded) == 1
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// memoryLowLimit returns the heap size the guard releases under.
+func (jobPool *JobPool) memoryLowLimit() int64 {
+	if jobPool.config.MemoryLowLimit > 0 {
+		return jobPool.config.MemoryLowLimit
+	}
+
+	return jobPool.config.MemorySoftLimit / 10 * 9
+}
+
+// memoryGuardRoutine samples the heap every memoryGuardInterval until the pool is shut down.
+func (jobPool *JobPool) memoryGuardRoutine() {
+	ticker := time.NewTicker(memoryGuardInterval)
+	defer ticker.Stop()
+
+	samples := []metrics.Sample{{Name: heapObjectsMetric}}
+
+	for {
+		select {
+		case <-jobPool.shutdownStatsChannel:
+			jobPool.writeLog(LogDebug, "Memory", "memoryGuardRoutine", "Going Down")
+			return
+
+		case <-ticker.C:
+			metrics.Read(samples)
+			if samples[0].Value.Kind() != metrics.KindUint64 {
+				jobPool.writeLogf(LogError, "Memory", "memoryGuardRoutine", "ERROR : Metric Unsupported : Metric[%s]", heapObjectsMetric)
+				return
+			}
+
+			jobPool.checkMemory(int64(samples[0].Value.Uint64()))
+		}
+	}
+}
+
+// checkMemory engages the guard when the heap exceeds the soft limit and releases it once the
+// heap falls under the low limit. Nothing changes in between so the guard doesn't flap with
+// every collection.
+func (jobPool *JobPool) checkMemory(heapBytes int64) {
+	atomic.StoreInt64(&jobPool.heapBytes, heapBytes)
+
+	var guarded bool
+	switch {
+	case heapBytes > jobPool.config.MemorySoftLimit:
+		if atomic.CompareAndSwapInt32(&jobPool.memoryGuarded, 0, 1) == false {
+			return
+		}
+		guarded = true
+		jobPool.writeLogf(LogInfo, "Memory", "checkMemory", "Guard Engaged : Heap[%d] SoftLimit[%d]", heapBytes, jobPool.config.MemorySoftLimit)
+
+	case heapBytes < jobPool.memoryLowLimit():
+		if atomic.CompareAndSwapInt32(&jobPool.memoryGuarded, 1, 0) == false {
+			return
+		}
+		jobPool.writeLogf(LogInfo, "Memory", "checkMemory", "Guard Released : Heap[%d] LowLimit[%d]", heapBytes, jobPool.memoryLowLimit())
+
+	default:
+		return
+	}
+
+	if jobPool.config.OnMemoryGuard != nil {
+		go jobPool.callbackSafely("Memory", "OnMemoryGuard", func() {
+			jobPool.config.OnMemoryGuard(guarded, heapBytes)
+		})
+	}
+}
+
+// memoryRefused returns true if the job is a normal job and the memory guard is engaged.
+func (jobPool *JobPool) memoryRefused(queueJob *queueJob) bool {
+	return queueJob.priority == false && atomic.LoadInt32(&jobPool.memoryGuarded) == 1
+}
@@ -0,0 +1,255 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// outstandingJobs is the in-flight registry. It holds the jobs admitted to the pool that are
+	// not yet done or cancelled, pending, running or waiting to be retried, and the WaitForNone
+	// callers waiting on them.
+	outstandingJobs struct {
+		jobs    map[*queueJob]struct{} // The jobs that are not done or cancelled.
+		waiters []*noneWaiter          // The callers of WaitForNone.
+		barrier int32                  // The number of waiters holding back matching jobs. Read without the mutex.
+		mutex   sync.Mutex             // Protects the jobs and the waiters.
+	}
+
+	// noneWaiter is a caller of WaitForNone.
+	noneWaiter struct {
+		match   func(queueJob *queueJob) bool // Picks the jobs waited on.
+		barrier bool                          // If matching jobs are refused while the caller waits.
+		jobs    map[*queueJob]struct{}        // The matching jobs that are not done or cancelled.
+		done    chan struct{}                 // Closed once no matching job is left.
+	}
+
+	// WaitOption configures a call to WaitForNone.
+	WaitOption func(noneWaiter *noneWaiter)
+)
+
+//** VARIABLES
+
+var (
+	// ErrBarrier is returned for a job refused because a WaitForNone with WithBarrier is waiting
+	// for jobs like it to be gone.
+	ErrBarrier = errors.New("Job Held Back By Barrier")
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithBarrier has the pool refuse new jobs that match the predicate with ErrBarrier while
+// WaitForNone waits, so the wait can't be extended.
+func WithBarrier() WaitOption {
+	return func(noneWaiter *noneWaiter) {
+		noneWaiter.barrier = true
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// WaitForNone blocks until no job matching the predicate is pending, running or waiting to be
+// retried, or the context is done. Matching jobs admitted during the wait, including jobs that
+// were submitted before the call and were still on their way into the queues, extend it, so a
+// steady stream of matching jobs can keep it waiting. WithBarrier refuses them instead. The
+// predicate is called for the jobs in the pool and for every job admitted during the wait. It
+// must be quick and must not call the pool.
+func (jobPool *JobPool) WaitForNone(ctx context.Context, predicate func(jober Jobber) bool, options ...WaitOption) (err error) {
+	defer jobPool.catchPanic(&err, "WaitForNone", "WaitForNone")
+
+	noneWaiter := newNoneWaiter(func(queueJob *queueJob) bool {
+		return predicate(queueJob.Jobber)
+	})
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(noneWaiter)
+	}
+
+	// The waiter is added by the queue routine so no job is admitted or merged while the
+	// registry is read.
+	err = jobPool.runInQueue(func() {
+		jobPool.outstanding.wait(noneWaiter)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-noneWaiter.done:
+		return nil
+
+	case <-ctx.Done():
+		jobPool.outstanding.forget(noneWaiter)
+		return ctx.Err()
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// newOutstandingJobs creates an empty in-flight registry.
+func newOutstandingJobs() *outstandingJobs {
+	return &outstandingJobs{
+		jobs: make(map[*queueJob]struct{}),
+	}
+}
+
+// newNoneWaiter creates a waiter on the jobs picked by match.
+func newNoneWaiter(match func(queueJob *queueJob) bool) *noneWaiter {
+	return &noneWaiter{
+		match: match,
+		jobs:  make(map[*queueJob]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// barred returns true if a WaitForNone with WithBarrier holds back the job. It is only called by
+// the queue routine.
+func (jobPool *JobPool) barred(queueJob *queueJob) bool {
+	outstanding := jobPool.outstanding
+	if atomic.LoadInt32(&outstanding.barrier) == 0 {
+		return false
+	}
+
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	for _, noneWaiter := range outstanding.waiters {
+		if noneWaiter.barrier == true && noneWaiter.matches(queueJob) == true {
+			return true
+		}
+	}
+
+	return false
+}
+
+// admit adds a job to the registry and to the waiters it matches. It is only called by the
+// queue routine.
+func (outstanding *outstandingJobs) admit(queueJob *queueJob) {
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	queueJob.outstanding = outstanding
+	outstanding.jobs[queueJob] = struct{}{}
+
+	outstanding.match(queueJob)
+}
+
+// rematch adds a job whose Jobber has changed to the waiters it now matches. It is only called by
+// the queue routine.
+func (outstanding *outstandingJobs) rematch(queueJob *queueJob) {
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	outstanding.match(queueJob)
+}
+
+// match adds a job to the waiters it matches. The mutex must be held.
+func (outstanding *outstandingJobs) match(queueJob *queueJob) {
+	for _, noneWaiter := range outstanding.waiters {
+		if noneWaiter.matches(queueJob) == true {
+			noneWaiter.jobs[queueJob] = struct{}{}
+		}
+	}
+}
+
+// finish removes a job that is done or cancelled and releases the waiters left with no
+// matching job.
+func (outstanding *outstandingJobs) finish(queueJob *queueJob) {
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	delete(outstanding.jobs, queueJob)
+
+	for i := 0; i < len(outstanding.waiters); i++ {
+		noneWaiter := outstanding.waiters[i]
+		if _, found := noneWaiter.jobs[queueJob]; found == false {
+			continue
+		}
+
+		delete(noneWaiter.jobs, queueJob)
+		if len(noneWaiter.jobs) == 0 {
+			outstanding.remove(noneWaiter)
+			close(noneWaiter.done)
+			i--
+		}
+	}
+}
+
+// wait adds a waiter holding the matching jobs in the registry. A waiter with no matching job
+// is released at once. It is only called by the queue routine.
+func (outstanding *outstandingJobs) wait(noneWaiter *noneWaiter) {
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	for queueJob := range outstanding.jobs {
+		if noneWaiter.matches(queueJob) == true {
+			noneWaiter.jobs[queueJob] = struct{}{}
+		}
+	}
+
+	if len(noneWaiter.jobs) == 0 {
+		close(noneWaiter.done)
+		return
+	}
+
+	outstanding.waiters = append(outstanding.waiters, noneWaiter)
+	if noneWaiter.barrier == true {
+		atomic.AddInt32(&outstanding.barrier, 1)
+	}
+}
+
+// forget removes a waiter whose context is done.
+func (outstanding *outstandingJobs) forget(noneWaiter *noneWaiter) {
+	outstanding.mutex.Lock()
+	defer outstanding.mutex.Unlock()
+
+	outstanding.remove(noneWaiter)
+}
+
+// remove takes a waiter out of the registry if it is still there. The mutex must be held.
+func (outstanding *outstandingJobs) remove(noneWaiter *noneWaiter) {
+	for i, waiter := range outstanding.waiters {
+		if waiter != noneWaiter {
+			continue
+		}
+
+		outstanding.waiters = append(outstanding.waiters[:i], outstanding.waiters[i+1:]...)
+		if noneWaiter.barrier == true {
+			atomic.AddInt32(&outstanding.barrier, -1)
+		}
+		return
+	}
+}
+
+// matches returns true if the waiter picks the job. A predicate that panics picks no job.
+func (noneWaiter *noneWaiter) matches(queueJob *queueJob) (matched bool) {
+	defer func() {
+		if recover() != nil {
+			matched = false
+		}
+	}()
+
+	return noneWaiter.match(queueJob)
+}
+
+// finished returns true once no job the waiter picks is left.
+func (noneWaiter *noneWaiter) finished() bool {
+	select {
+	case <-noneWaiter.done:
+		return true
+	default:
+		return false
+	}
+}
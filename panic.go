@@ -0,0 +1,180 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// PanicPolicy decides what happens after a panic has been written and reported.
+	PanicPolicy int
+
+	// PanicInfo describes a recovered panic.
+	PanicInfo struct {
+		Value        interface{}       // The value passed to panic.
+		Jobber       Jobber            // The job that panicked or nil if the panic was not raised by a job.
+		JobName      string            // The name of the job that panicked.
+		Metadata     map[string]string // The key/value pairs attached to the job with WithMetadata.
+		Pool         string            // The name of the pool that recovered the panic.
+		GoRoutine    string            // The routine that recovered the panic.
+		FunctionName string            // The function that recovered the panic.
+		Stack        string            // The stack trace captured when the panic was recovered.
+		Time         time.Time         // When the panic was recovered.
+	}
+
+	// repanicked wraps a panic raised again by the Repanic policy so the recoveries further up
+	// the same routine pass it on instead of reporting it a second time.
+	repanicked struct {
+		value interface{} // The value passed to the original panic.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// Recover continues processing after a panic. This is the default.
+	Recover PanicPolicy = iota
+
+	// Repanic raises the panic again so the process dies with the original stack. The panic is
+	// written, reported and raised again once however many of the pool's frames it crosses.
+	Repanic
+
+	// Abort calls the configured abort function, which exits the process by default.
+	Abort
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Error returns the value of the original panic so the process dies with it.
+func (repanicked repanicked) Error() string {
+	return fmt.Sprintf("%v", repanicked.value)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// catchPanic is used to catch any Panic and log exceptions. It will also write the stack trace.
+//
+//	err: A reference to the err variable to be returned to the caller. Can be nil.
+func (jobPool *JobPool) catchPanic(err *error, goRoutine string, functionName string) {
+	if r := recover(); r != nil {
+		jobPool.handlePanic(r, nil, err, goRoutine, functionName)
+	}
+}
+
+// catchJobPanic is used to catch a Panic raised by a job. The job is included in the report.
+//
+//	err: A reference to the err variable to be returned to the caller. Can be nil.
+func (jobPool *JobPool) catchJobPanic(err *error, queueJob *queueJob, goRoutine string, functionName string) {
+	if r := recover(); r != nil {
+		jobPool.handlePanic(r, queueJob, err, goRoutine, functionName)
+	}
+}
+
+// handlePanic writes and reports a recovered panic. The stack trace is only captured when it
+// will be written or handed to the panic handler.
+func (jobPool *JobPool) handlePanic(r interface{}, queueJob *queueJob, err *error, goRoutine string, functionName string) {
+	// A panic the Repanic policy raised again has already been written and reported.
+	if repanicked, ok := r.(repanicked); ok == true {
+		panic(repanicked)
+	}
+
+	if err != nil {
+		*err = fmt.Errorf("%v", r)
+	}
+
+	// Apply the panic policy once the panic has been written and reported.
+	defer jobPool.applyPanicPolicy(r)
+
+	writePanic := jobPool.logger() != NopLogger && (jobPool.config.SilencePanics == false || LogError >= jobPool.config.LogLevel)
+	if writePanic == false && jobPool.config.PanicHandler == nil {
+		return
+	}
+
+	panicInfo := PanicInfo{
+		Value:        r,
+		Pool:         jobPool.config.Name,
+		GoRoutine:    goRoutine,
+		FunctionName: functionName,
+		Stack:        string(jobPool.captureStack()),
+		Time:         time.Now(),
+	}
+
+	if queueJob != nil {
+		panicInfo.Jobber = queueJob.Jobber
+		panicInfo.JobName = queueJob.name
+		panicInfo.Metadata = queueJob.metadata
+	}
+
+	if writePanic == true {
+		if queueJob != nil {
+			jobPool.writePanic(goRoutine, functionName, fmt.Sprintf("PANIC Defered [%v] : Job[%s]%s : Stack Trace : %v", r, panicInfo.JobName, metadataTag(panicInfo.Metadata), panicInfo.Stack))
+		} else {
+			jobPool.writePanic(goRoutine, functionName, fmt.Sprintf("PANIC Defered [%v] : Stack Trace : %v", r, panicInfo.Stack))
+		}
+	}
+
+	if jobPool.config.PanicHandler != nil {
+		jobPool.reportPanic(panicInfo)
+	}
+}
+
+// applyPanicPolicy raises the panic again or aborts when the panic policy asks for it.
+func (jobPool *JobPool) applyPanicPolicy(r interface{}) {
+	switch jobPool.config.PanicPolicy {
+	case Repanic:
+		panic(repanicked{value: r})
+
+	case Abort:
+		if jobPool.config.AbortFunc != nil {
+			jobPool.config.AbortFunc()
+			return
+		}
+
+		os.Exit(2)
+	}
+}
+
+// reportPanic hands a panic report to the panic handler. A panic raised by the handler is
+// written but not reported again.
+func (jobPool *JobPool) reportPanic(panicInfo PanicInfo) {
+	defer func() {
+		if r := recover(); r != nil {
+			jobPool.writePanic(panicInfo.GoRoutine, "PanicHandler", fmt.Sprintf("PANIC Defered [%v]", r))
+		}
+	}()
+
+	jobPool.config.PanicHandler(panicInfo)
+}
+
+// captureStack returns the stack trace of the current goroutine, or of every goroutine when
+// StackAllRoutines is set. The buffer is doubled until the trace fits or MaxStackSize is reached.
+func (jobPool *JobPool) captureStack() []byte {
+	maxStackSize := jobPool.config.MaxStackSize
+	if maxStackSize <= 0 {
+		maxStackSize = defaultMaxStackSize
+	}
+
+	size := 4096
+	for {
+		if size > maxStackSize {
+			size = maxStackSize
+		}
+
+		buf := make([]byte, size)
+		n := runtime.Stack(buf, jobPool.config.StackAllRoutines)
+
+		if n < size || size == maxStackSize {
+			return buf[:n]
+		}
+
+		size *= 2
+	}
+}
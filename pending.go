@@ -0,0 +1,297 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// PendingJob describes a pending job seen by a PendingTx.
+	PendingJob struct {
+		ID         uint64            // The number given to the job when it was first queued.
+		Jobber     Jobber            // The job. It must not be changed.
+		Name       string            // The name of the job or its type.
+		Priority   bool              // If the job is in the priority queue.
+		Tenant     string            // The tenant the job is queued for.
+		Group      string            // The group the job belongs to.
+		Key        string            // The key the job was queued under with QueueJobUnique.
+		Metadata   map[string]string // The key/value pairs attached with WithMetadata.
+		Attempts   int               // The number of times the job has been started.
+		EnqueuedAt time.Time         // When the job was placed in the queue.
+	}
+
+	// pendingTx is the PendingTx handed to an UpdatePending function. It reads the queues while
+	// the queue routine waits on the transaction and records the changes to apply at the end.
+	pendingTx struct {
+		jobPool *JobPool             // The pool the transaction runs against.
+		jobs    []PendingJob         // The pending jobs in order, read on first use.
+		index   map[uint64]*queueJob // The pending jobs by ID, read on first use.
+		removed map[uint64]struct{}  // The jobs the transaction removes.
+		changes []func()             // The changes to apply in order once the transaction commits.
+		closed  bool                 // Set once the transaction has committed or timed out.
+		mutex   sync.Mutex           // Keeps the queue routine from ending the transaction during a call.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// defaultPendingTxTimeout bounds a transaction whose context has no deadline.
+	defaultPendingTxTimeout = time.Second
+)
+
+//** VARIABLES
+
+var (
+	// ErrTxClosed is returned by a PendingTx used after its transaction has ended.
+	ErrTxClosed = errors.New("Pending Transaction Closed")
+)
+
+//** INTERFACES
+
+// PendingTx inspects and changes the pending jobs inside UpdatePending. Iterate sees the queues
+// as they were when the transaction began. The changes are applied together, in the order they
+// were made, once the UpdatePending function returns nil. The change methods return false if no
+// pending job has the ID or the transaction has already removed it.
+type PendingTx interface {
+	Iterate(fn func(pendingJob PendingJob) bool) error
+	Remove(id uint64) (bool, error)
+	Reprioritize(id uint64, priority bool) (bool, error)
+	MoveToFront(id uint64) (bool, error)
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// UpdatePending runs the function as a transaction over the pending jobs. The queue routine
+// waits on the transaction, so no job is queued or dequeued until it ends and the jobs it sees
+// are the jobs it changes. If the function returns an error nothing is changed and the error is
+// returned. The transaction is bounded by ctx, or by one second when ctx has no deadline. Once
+// it is done the queue routine carries on without applying the changes, the PendingTx returns
+// ErrTxClosed and ctx's error is returned. The function must not queue jobs or call anything
+// else that waits on the queue routine as it would hold until the transaction times out.
+func (jobPool *JobPool) UpdatePending(ctx context.Context, update func(tx PendingTx) error) (err error) {
+	defer jobPool.catchPanic(&err, "UpdatePending", "UpdatePending")
+
+	if _, found := ctx.Deadline(); found == false {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPendingTxTimeout)
+		defer cancel()
+	}
+
+	pendingTx := pendingTx{
+		jobPool: jobPool,
+		removed: make(map[uint64]struct{}),
+	}
+
+	// The function runs on its own routine so it can be abandoned once ctx is done.
+	updated := make(chan error, 1)
+
+	queueErr := jobPool.runInQueue(func() {
+		go func() {
+			var updateErr error
+			defer func() {
+				updated <- updateErr
+			}()
+			defer jobPool.catchPanic(&updateErr, "UpdatePending", "update")
+
+			updateErr = update(&pendingTx)
+		}()
+
+		select {
+		case err = <-updated:
+			pendingTx.commit(err == nil)
+
+		case <-ctx.Done():
+			pendingTx.commit(false)
+			err = ctx.Err()
+		}
+	})
+
+	if queueErr != nil {
+		return queueErr
+	}
+
+	return err
+}
+
+// Iterate calls fn for each pending job, priority jobs first, until fn returns false.
+func (pendingTx *pendingTx) Iterate(fn func(pendingJob PendingJob) bool) error {
+	jobs, err := pendingTx.pendingJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, pendingJob := range jobs {
+		if fn(pendingJob) == false {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Remove cancels the pending job with the ID.
+func (pendingTx *pendingTx) Remove(id uint64) (bool, error) {
+	return pendingTx.change(id, func(queueJob *queueJob) {
+		pendingTx.removed[id] = struct{}{}
+		pendingTx.changes = append(pendingTx.changes, func() {
+			pendingTx.jobPool.removePendingJob(queueJob)
+		})
+	})
+}
+
+// Reprioritize moves the pending job with the ID to the back of the priority or normal queue.
+// A job already in the queue keeps its place.
+func (pendingTx *pendingTx) Reprioritize(id uint64, priority bool) (bool, error) {
+	return pendingTx.change(id, func(queueJob *queueJob) {
+		pendingTx.changes = append(pendingTx.changes, func() {
+			pendingTx.jobPool.reprioritizeQueuedJob(queueJob, priority)
+		})
+	})
+}
+
+// MoveToFront moves the pending job with the ID to the front of its queue.
+func (pendingTx *pendingTx) MoveToFront(id uint64) (bool, error) {
+	return pendingTx.change(id, func(queueJob *queueJob) {
+		pendingTx.changes = append(pendingTx.changes, func() {
+			queueJob.queue.MoveToFront(queueJob.element)
+		})
+	})
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// pendingJobs returns the pending jobs, reading the queues on first use.
+func (pendingTx *pendingTx) pendingJobs() ([]PendingJob, error) {
+	pendingTx.mutex.Lock()
+	defer pendingTx.mutex.Unlock()
+
+	if pendingTx.closed == true {
+		return nil, ErrTxClosed
+	}
+
+	pendingTx.read()
+	return pendingTx.jobs, nil
+}
+
+// change records a change to the pending job with the ID. It returns false if there is no such
+// job or it has been removed.
+func (pendingTx *pendingTx) change(id uint64, record func(queueJob *queueJob)) (bool, error) {
+	pendingTx.mutex.Lock()
+	defer pendingTx.mutex.Unlock()
+
+	if pendingTx.closed == true {
+		return false, ErrTxClosed
+	}
+
+	pendingTx.read()
+
+	queueJob, found := pendingTx.index[id]
+	if found == false {
+		return false, nil
+	}
+
+	if _, removed := pendingTx.removed[id]; removed == true {
+		return false, nil
+	}
+
+	record(queueJob)
+	return true, nil
+}
+
+// read copies the pending jobs the first time it is called. The queue routine is waiting on
+// the transaction so the queues can't change while they are read. The mutex must be held.
+func (pendingTx *pendingTx) read() {
+	if pendingTx.index != nil {
+		return
+	}
+
+	pendingTx.index = make(map[uint64]*queueJob)
+
+	tenantQueues := pendingTx.jobPool.tenantQueuesSnapshot()
+	for _, priority := range []bool{true, false} {
+		for _, tenantQueue := range tenantQueues {
+			queue := tenantQueue.normalJobQueue
+			if priority == true {
+				queue = tenantQueue.priorityJobQueue
+			}
+
+			for element := queue.Front(); element != nil; element = element.Next() {
+				queueJob := element.Value.(*queueJob)
+
+				pendingTx.index[queueJob.id] = queueJob
+				pendingTx.jobs = append(pendingTx.jobs, PendingJob{
+					ID:         queueJob.id,
+					Jobber:     queueJob.Jobber,
+					Name:       queueJob.name,
+					Priority:   priority,
+					Tenant:     queueJob.tenant,
+					Group:      queueJob.group,
+					Key:        queueJob.key,
+					Metadata:   queueJob.metadata,
+					Attempts:   queueJob.attempts,
+					EnqueuedAt: queueJob.enqueuedAt,
+				})
+			}
+		}
+	}
+}
+
+// commit ends the transaction and applies the changes when apply is set. It is only called by
+// the queue routine.
+func (pendingTx *pendingTx) commit(apply bool) {
+	pendingTx.mutex.Lock()
+	defer pendingTx.mutex.Unlock()
+
+	pendingTx.closed = true
+
+	if apply == false {
+		return
+	}
+
+	for _, change := range pendingTx.changes {
+		change()
+	}
+}
+
+// removePendingJob cancels a pending job and releases what it held. It is only called by the
+// queue routine.
+func (jobPool *JobPool) removePendingJob(queueJob *queueJob) {
+	if jobPool.removeQueuedJob(queueJob, DispositionCancelled) == false {
+		return
+	}
+
+	jobPool.finishGroupJob(queueJob)
+}
+
+// reprioritizeQueuedJob moves a pending job to the back of the priority or normal queue of its
+// tenant. It is only called by the queue routine.
+func (jobPool *JobPool) reprioritizeQueuedJob(queueJob *queueJob, priority bool) {
+	inPriority := queueJob.queue == queueJob.tenantQueue.priorityJobQueue
+
+	queueJob.priority = priority
+	queueJob.boosted = false
+
+	if inPriority == priority {
+		return
+	}
+
+	queueJob.queue.Remove(queueJob.element)
+	queueJob.front = false
+	queueJob.tenantQueue.push(queueJob)
+
+	switch priority {
+	case true:
+		jobPool.countPriorityJobs(1)
+	default:
+		jobPool.countPriorityJobs(-1)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+//** PUBLIC FUNCTIONS
+
+// NewPoolWithContext creates a new JobPool like New that is tied to the context. When the
+// context is done the pool is shut down as if ShutdownNow had been called. Shutting the pool
+// down explicitly before then releases the context.
+func NewPoolWithContext(ctx context.Context, numberOfRoutines int, queueCapacity int32, options ...Option) *JobPool {
+	jobPool := New(numberOfRoutines, queueCapacity, options...)
+
+	go jobPool.watchContext(ctx)
+
+	return jobPool
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// ShutdownNow stops admitting jobs, cancels the context of every running ContextJobber and any
+// job that starts before the queues are closed, and shuts the pool down. The job routines exit
+// once their current job returns and the pending jobs are reported as abandoned.
+func (jobPool *JobPool) ShutdownNow(goRoutine string) (report ShutdownReport, err error) {
+	atomic.StoreInt32(&jobPool.shutdown, 1)
+
+	// Jobs that start from here on are cancelled as they start.
+	atomic.StoreInt32(&jobPool.cancelOnStart, 1)
+	jobPool.cancelRunningJobs()
+
+	return jobPool.ShutdownWithReport(goRoutine)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// watchContext shuts the pool down once the context is done. It returns without doing anything
+// if the pool is shut down first.
+func (jobPool *JobPool) watchContext(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		jobPool.writeLogf(LogInfo, "Context", "watchContext", "Context Done : %v", ctx.Err())
+		jobPool.ShutdownNow("Context")
+
+	case <-jobPool.shutdownStatsChannel:
+	}
+}
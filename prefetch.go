@@ -0,0 +1,157 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// prefetchJob asks the queue routine for the routine's next job while the current job runs. It
+// returns nil if prefetch is off, a concurrency limit is set or no job is waiting to be claimed.
+// Under a limit the routine waits for a permit before it dequeues so the jobs stay in the queues.
+func (jobPool *JobPool) prefetchJob() *dequeueJob {
+	if jobPool.config.Prefetch == false || jobPool.concurrency.limited() == true || jobPool.wakeUps.tryWait() == false {
+		return nil
+	}
+
+	// The result channel is buffered so the queue routine never waits on the job routine.
+	requestJob := dequeueJob{
+		ResultChannel: make(chan *queueJob, 1),
+		prefetch:      true,
+	}
+
+	go jobPool.requestPrefetch(&requestJob)
+
+	return &requestJob
+}
+
+// requestPrefetch hands the prefetch request to the queue routine.
+func (jobPool *JobPool) requestPrefetch(requestJob *dequeueJob) {
+	defer jobPool.catchPanic(nil, "jobRoutine", "requestPrefetch")
+
+	// A FaultInjector can hold the routine to freeze dequeues.
+	jobPool.dequeueFault()
+
+	if jobPool.enterQueue() == false {
+		requestJob.ResultChannel <- nil
+		return
+	}
+	defer jobPool.exitQueue()
+
+	jobPool.countBacklog(1)
+	jobPool.dequeueChannel <- requestJob
+}
+
+// holdPrefetched records a job taken from the queues for a job routine that is still running its
+// current job. It is only called by the queue routine.
+func (jobPool *JobPool) holdPrefetched(queueJob *queueJob) {
+	jobPool.prefetchMutex.Lock()
+	defer jobPool.prefetchMutex.Unlock()
+
+	jobPool.prefetchedJobs[queueJob] = struct{}{}
+}
+
+// claimPrefetched waits for the prefetched job and takes it for the job routine. It returns nil
+// if there was no job or the job was cancelled or returned to its queue before it was picked up.
+// The job is given back to the front of its queue once a drain has stopped handing out jobs, and
+// with StrictPriority a normal job is given back when a priority job is waiting so the routine
+// can dequeue the priority job instead. It is also given back when noPermit is set because a
+// concurrency limit set after the job was prefetched leaves the routine no room to run it.
+func (jobPool *JobPool) claimPrefetched(requestJob *dequeueJob, noPermit bool) *queueJob {
+	queueJob := <-requestJob.ResultChannel
+	if queueJob == nil {
+		return nil
+	}
+
+	jobPool.prefetchMutex.Lock()
+	_, held := jobPool.prefetchedJobs[queueJob]
+	delete(jobPool.prefetchedJobs, queueJob)
+	jobPool.prefetchMutex.Unlock()
+
+	if held == false {
+		return nil
+	}
+
+	giveBack := noPermit || jobPool.pastDrainCutoff()
+	if jobPool.config.PriorityFreshness == StrictPriority && queueJob.priority == false && jobPool.PriorityPending() == true {
+		giveBack = true
+	}
+
+	if giveBack == true {
+		err := jobPool.runInQueue(func() {
+			jobPool.restoreJob(queueJob)
+		})
+
+		// The pool is shutting down so the job is run rather than lost.
+		if err == nil {
+			return nil
+		}
+	}
+
+	return queueJob
+}
+
+// takePrefetched removes the prefetched job with the ID before a job routine picks it up. It
+// returns nil if no prefetched job has the ID.
+func (jobPool *JobPool) takePrefetched(id uint64) *queueJob {
+	jobPool.prefetchMutex.Lock()
+	defer jobPool.prefetchMutex.Unlock()
+
+	for queueJob := range jobPool.prefetchedJobs {
+		if queueJob.id == id && queueJob.dispose(jobClaimed, DispositionCancelled) == true {
+			delete(jobPool.prefetchedJobs, queueJob)
+			jobPool.finishTag(queueJob)
+			return queueJob
+		}
+	}
+
+	return nil
+}
+
+// returnPrefetched places every job that was prefetched but not picked up back at the front of
+// its queue during shutdown. It is called once the queue routine is down.
+func (jobPool *JobPool) returnPrefetched() {
+	jobPool.prefetchMutex.Lock()
+	prefetchedJobs := jobPool.prefetchedJobs
+	jobPool.prefetchedJobs = make(map[*queueJob]struct{})
+	jobPool.prefetchMutex.Unlock()
+
+	for queueJob := range prefetchedJobs {
+		jobPool.restoreJob(queueJob)
+	}
+}
+
+// restoreJob places a job that was taken from the queues but never started back at the front of
+// its queue. The job keeps its ID and place in its group.
+func (jobPool *JobPool) restoreJob(queueJob *queueJob) {
+	queueJob.setState(jobPending)
+	jobPool.finishTag(queueJob)
+
+	tenantQueue := jobPool.tenantQueue(queueJob.tenant)
+	tenantQueue.pushFront(queueJob)
+
+	if tenantQueue.turn == nil {
+		tenantQueue.turn = jobPool.activeTenants.PushBack(tenantQueue)
+	}
+
+	if queueJob.queue == tenantQueue.priorityJobQueue {
+		jobPool.countPriorityJobs(1)
+	}
+
+	jobPool.countTenantJob(queueJob.tenant, 1)
+	jobPool.tags.count(queueJob.tag, 1)
+	jobPool.restoreGroupJob(queueJob)
+	atomic.AddInt64(&jobPool.gauges.pendingBytes, queueJob.size)
+
+	// Increment the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, 1)
+	atomic.AddInt32(&jobPool.gauges.reservedSlots, 1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	jobPool.wakeUps.post(1)
+}
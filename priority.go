@@ -0,0 +1,67 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// PriorityFunc decides the priority of a job when it is queued. It receives the priority the
+	// submitter asked for and returns the priority the job is queued with, so a whole class of
+	// jobs can be made priority, or normal, without changing the call sites. Returning requested
+	// keeps the submitter's choice.
+	PriorityFunc func(jober Jobber, requested bool) bool
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithPriorityFunc sets the function that decides the priority of each job when it is queued in
+// place of the priority the submitter asked for. It can be replaced or removed while the pool
+// runs with SetPriorityFunc.
+func WithPriorityFunc(priorityFunc PriorityFunc) Option {
+	return func(config *Config) {
+		config.PriorityFunc = priorityFunc
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// SetPriorityFunc replaces the function that decides the priority of each job, for example from
+// an admin endpoint. Nil removes it so jobs are queued with the priority their submitter asked
+// for again. Jobs already queued keep their priority.
+func (jobPool *JobPool) SetPriorityFunc(priorityFunc PriorityFunc) {
+	jobPool.priorityMutex.Lock()
+	defer jobPool.priorityMutex.Unlock()
+
+	jobPool.priorityFunc = priorityFunc
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// admitPriority returns the priority a job is queued with. The PriorityFunc is called on the
+// submitting routine and one that panics leaves the priority asked for.
+func (jobPool *JobPool) admitPriority(jober Jobber, requested bool) bool {
+	jobPool.priorityMutex.RLock()
+	priorityFunc := jobPool.priorityFunc
+	jobPool.priorityMutex.RUnlock()
+
+	if priorityFunc == nil {
+		return requested
+	}
+
+	priority := requested
+	jobPool.callbackSafely("Queue", "PriorityFunc", func() {
+		priority = priorityFunc(jober, requested)
+	})
+
+	if priority != requested {
+		atomic.AddInt64(&jobPool.priorityOverrides, 1)
+	}
+
+	return priority
+}
@@ -0,0 +1,171 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// classJob is a job of a class a PriorityFunc can pick out that records the order jobs ran in.
+	classJob struct {
+		class string    // The class of the job.
+		order *runOrder // Records the job once it has run.
+	}
+
+	// runOrder keeps the classes of the jobs in the order they ran.
+	runOrder struct {
+		classes []string   // The class of each job that ran.
+		mutex   sync.Mutex // Protects classes.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestPriorityFunc queues a job behind a normal job with a PriorityFunc in place and proves the
+// priority the function decides wins over the one the caller asked for. It is reported in the
+// handle's admission, counted as an override and decides whether the job runs first.
+func TestPriorityFunc(t *testing.T) {
+	promoteReports := func(jober Jobber, requested bool) bool {
+		return requested || jober.(*classJob).class == "report"
+	}
+
+	demoteAll := func(jober Jobber, requested bool) bool {
+		return false
+	}
+
+	keepRequested := func(jober Jobber, requested bool) bool {
+		return requested
+	}
+
+	tests := []struct {
+		name         string
+		priorityFunc PriorityFunc
+		requested    bool
+		want         bool
+		overrides    int64
+	}{
+		{"Promotes", promoteReports, false, true, 1},
+		{"Demotes", demoteAll, true, false, 1},
+		{"KeepsRequested", keepRequested, true, true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 10, WithPriorityFunc(test.priorityFunc))
+
+			// Hold the only routine so the jobs wait in the queue.
+			release := make(chan struct{})
+			releaseOnce := sync.OnceFunc(func() { close(release) })
+			defer releaseOnce()
+
+			blocker, started := blockingJob(release)
+			if err := jobPool.QueueJob("test", blocker, false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+			<-started
+
+			order := &runOrder{}
+			if err := jobPool.QueueJob("test", &classJob{class: "other", order: order}, false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			handle := jobPool.QueueJobAsync("test", &classJob{class: "report", order: order}, test.requested)
+			if err := handle.WaitAdmitted(context.Background()); err != nil {
+				t.Fatalf("QueueJobAsync : %s", err)
+			}
+
+			if priority := handle.Admission().Priority; priority != test.want {
+				t.Fatalf("Admission Priority[%v] asked for %v, want %v", priority, test.requested, test.want)
+			}
+
+			if overrides := jobPool.Stats().PriorityOverrides; overrides != test.overrides {
+				t.Fatalf("PriorityOverrides[%d], want %d", overrides, test.overrides)
+			}
+
+			releaseOnce()
+
+			waitFor(t, 5*time.Second, "both jobs to run", func() bool {
+				return len(order.snapshot()) == 2
+			})
+
+			want := []string{"other", "report"}
+			if test.want == true {
+				want = []string{"report", "other"}
+			}
+
+			if classes := order.snapshot(); reflect.DeepEqual(classes, want) == false {
+				t.Fatalf("Ran %v, want %v", classes, want)
+			}
+		})
+	}
+}
+
+// TestSetPriorityFunc replaces the PriorityFunc while the pool runs and proves removing it
+// restores the priority each caller asks for and a new function takes effect on the next job.
+func TestSetPriorityFunc(t *testing.T) {
+	jobPool := newTestPool(t, 1, 10, WithPriorityFunc(func(jober Jobber, requested bool) bool {
+		return true
+	}))
+
+	steps := []struct {
+		name         string
+		set          bool
+		priorityFunc PriorityFunc
+		requested    bool
+		want         bool
+		overrides    int64
+	}{
+		{"Promoted", false, nil, false, true, 1},
+		{"RemovedNormal", true, nil, false, false, 1},
+		{"RemovedPriority", false, nil, true, true, 1},
+		{"Demoted", true, func(jober Jobber, requested bool) bool { return false }, true, false, 2},
+	}
+
+	for _, step := range steps {
+		if step.set == true {
+			jobPool.SetPriorityFunc(step.priorityFunc)
+		}
+
+		handle := jobPool.QueueJobAsync("test", &classJob{class: "report", order: &runOrder{}}, step.requested)
+		if err := handle.Wait(); err != nil {
+			t.Fatalf("%s : QueueJobAsync : %s", step.name, err)
+		}
+
+		if priority := handle.Admission().Priority; priority != step.want {
+			t.Fatalf("%s : Admission Priority[%v] asked for %v, want %v", step.name, priority, step.requested, step.want)
+		}
+
+		if overrides := jobPool.Stats().PriorityOverrides; overrides != step.overrides {
+			t.Fatalf("%s : PriorityOverrides[%d], want %d", step.name, overrides, step.overrides)
+		}
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob records the job's class.
+func (classJob *classJob) RunJob(jobRoutine int) {
+	classJob.order.mutex.Lock()
+	defer classJob.order.mutex.Unlock()
+
+	classJob.order.classes = append(classJob.order.classes, classJob.class)
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// snapshot returns the classes of the jobs that have run so far.
+func (runOrder *runOrder) snapshot() []string {
+	runOrder.mutex.Lock()
+	defer runOrder.mutex.Unlock()
+
+	return append([]string{}, runOrder.classes...)
+}
@@ -0,0 +1,350 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// quarantine tracks the panics of each job type and the types taken out of rotation.
+	quarantine struct {
+		panics      map[string][]time.Time // The recent panics of each job type inside the window.
+		quarantined map[string]struct{}    // The job types in quarantine.
+		parked      map[string]*list.List  // The pending jobs of each quarantined type in the order they were admitted. Only used by the queue routine.
+		mutex       sync.Mutex             // Protects panics and quarantined.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrQuarantined is returned for a job whose type has been quarantined after panicking too
+	// often, see WithQuarantine.
+	ErrQuarantined = errors.New("Job Type Quarantined")
+
+	// ErrNotQuarantined is returned by Unquarantine for a job type that is not in quarantine.
+	ErrNotQuarantined = errors.New("Job Type Not Quarantined")
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithQuarantine takes a job type out of rotation once its jobs have panicked panics times
+// within window. The pending jobs of the type are parked, new jobs of the type are rejected with
+// ErrQuarantined and onQuarantine, which can be nil, is called with the type's name. A pending
+// job queued with QueueJobHandoff is refused rather than parked. The other job types keep
+// running. Unquarantine puts the type back in rotation.
+func WithQuarantine(panics int, window time.Duration, onQuarantine func(jobType string)) Option {
+	return func(config *Config) {
+		config.QuarantinePanics = panics
+		config.QuarantineWindow = window
+		config.OnQuarantine = onQuarantine
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Unquarantine puts a quarantined job type back in rotation and returns the number of parked
+// jobs released. The parked jobs are placed at the back of their queues in the order they were
+// admitted. The type's panic count starts over.
+func (jobPool *JobPool) Unquarantine(jobType string) (released int, err error) {
+	defer jobPool.catchPanic(&err, "Unquarantine", "Unquarantine")
+
+	if jobPool.quarantine.release(jobType) == false {
+		return 0, fmt.Errorf("%w : JobType[%s]", ErrNotQuarantined, jobType)
+	}
+
+	err = jobPool.runInQueue(func() {
+		released = jobPool.queueRoutineUnpark(jobType)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	jobPool.writeLogf(LogInfo, "Unquarantine", "Unquarantine", "Released : JobType[%s] Jobs[%d]", jobType, released)
+	return released, nil
+}
+
+// Quarantined returns the names of the job types in quarantine in sorted order.
+func (jobPool *JobPool) Quarantined() []string {
+	return jobPool.quarantine.types()
+}
+
+//** PRIVATE FUNCTIONS
+
+// newQuarantine creates a quarantine with no job types in it.
+func newQuarantine() *quarantine {
+	return &quarantine{
+		panics:      make(map[string][]time.Time),
+		quarantined: make(map[string]struct{}),
+		parked:      make(map[string]*list.List),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// checkQuarantine returns ErrQuarantined for a job whose type is in quarantine.
+func (jobPool *JobPool) checkQuarantine(jober Jobber) error {
+	if jobType := jobName(jober); jobPool.quarantine.holds(jobType) == true {
+		return fmt.Errorf("%w : JobType[%s]", ErrQuarantined, jobType)
+	}
+
+	return nil
+}
+
+// notePanic counts a panic raised by a job and quarantines the job's type once it has panicked
+// too often inside the window.
+func (jobPool *JobPool) notePanic(jobType string, panicked time.Time) {
+	if jobPool.config.QuarantinePanics <= 0 {
+		return
+	}
+
+	if jobPool.quarantine.record(jobType, panicked, jobPool.config.QuarantinePanics, jobPool.config.QuarantineWindow) == false {
+		return
+	}
+
+	var parked int
+	jobPool.runInQueue(func() {
+		parked = jobPool.queueRoutinePark(jobType)
+	})
+
+	jobPool.writeLogf(LogError, "Quarantine", "notePanic", "ERROR : Quarantined : JobType[%s] Panics[%d] Window[%v] Parked[%d]", jobType, jobPool.config.QuarantinePanics, jobPool.config.QuarantineWindow, parked)
+
+	if onQuarantine := jobPool.config.OnQuarantine; onQuarantine != nil {
+		go jobPool.callbackSafely("Quarantine", "OnQuarantine", func() {
+			onQuarantine(jobType)
+		})
+	}
+}
+
+// queueRoutinePark takes the pending jobs of a quarantined type out of the queues and parks them.
+// It returns the number of jobs parked. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutinePark(jobType string) int {
+	var queueJobs []*queueJob
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		for _, queue := range []*list.List{tenantQueue.priorityJobQueue, tenantQueue.normalJobQueue} {
+			for element := queue.Front(); element != nil; element = element.Next() {
+				if queueJob := element.Value.(*queueJob); queueJob.name == jobType {
+					queueJobs = append(queueJobs, queueJob)
+				}
+			}
+		}
+	}
+
+	// Park the jobs in the order they were admitted so they are released in that order.
+	sort.Slice(queueJobs, func(i int, j int) bool {
+		return queueJobs[i].id < queueJobs[j].id
+	})
+
+	for _, queueJob := range queueJobs {
+		// A job whose submitter waits for the handoff can't be held back, so it is refused.
+		if queueJob.waitsForHandoff() == true {
+			if jobPool.removeQueuedJob(queueJob, DispositionRejected) == true {
+				jobPool.finishGroupJob(queueJob)
+			}
+			continue
+		}
+
+		jobPool.unqueueParked(queueJob)
+		jobPool.park(queueJob)
+	}
+
+	return len(queueJobs)
+}
+
+// queueRoutineUnpark places the parked jobs of a job type back at the back of their queues and
+// returns the number released. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineUnpark(jobType string) int {
+	parked, found := jobPool.quarantine.parked[jobType]
+	if found == false {
+		return 0
+	}
+
+	delete(jobPool.quarantine.parked, jobType)
+	atomic.AddInt32(&jobPool.parkedJobs, -int32(parked.Len()))
+
+	for element := parked.Front(); element != nil; element = element.Next() {
+		queueJob := element.Value.(*queueJob)
+		queueJob.front = false
+
+		jobPool.pushTenantJob(queueJob)
+		jobPool.restoreGroupJob(queueJob)
+		if queueJob.queue == queueJob.tenantQueue.priorityJobQueue {
+			jobPool.countPriorityJobs(1)
+		}
+		atomic.AddInt64(&jobPool.gauges.pendingBytes, queueJob.size)
+
+		// Increment the queued work count. The job kept its slot while it was parked.
+		atomic.AddInt32(&jobPool.gauges.queuedJobs, 1)
+		jobPool.checkWatermarks()
+		jobPool.checkQueueEmpty()
+
+		jobPool.wakeUps.post(1)
+	}
+
+	return parked.Len()
+}
+
+// parkQuarantined parks a job of a quarantined type instead of queueing it, as happens to a
+// retry or a job in the intake buffer when its type is quarantined. A job waiting for the
+// handoff is refused instead. It returns false if the job's type is not in quarantine. It is only called by the queue routine.
+func (jobPool *JobPool) parkQuarantined(queueJob *queueJob) bool {
+	if jobPool.quarantine.holds(queueJob.name) == false {
+		return false
+	}
+
+	// A job whose submitter waits for the handoff can't be held back, so it is refused and its
+	// slot given up.
+	if queueJob.waitsForHandoff() == true {
+		queueJob.dispose(jobPending, DispositionRejected)
+		go jobPool.releaseJob("Queue", queueJob)
+		jobPool.releaseSlots(1)
+		jobPool.finishGroupJob(queueJob)
+		return true
+	}
+
+	jobPool.dequeueGroupJob(queueJob)
+	jobPool.park(queueJob)
+
+	return true
+}
+
+// park adds a job to the parked jobs of its type. The job stays pending and keeps its slot in
+// the queue. It is only called by the queue routine.
+func (jobPool *JobPool) park(queueJob *queueJob) {
+	parked, found := jobPool.quarantine.parked[queueJob.name]
+	if found == false {
+		parked = list.New()
+		jobPool.quarantine.parked[queueJob.name] = parked
+	}
+
+	parked.PushBack(queueJob)
+	atomic.AddInt32(&jobPool.parkedJobs, 1)
+}
+
+// unqueueParked takes a pending job out of its queue to be parked. Unlike removeQueuedJob the
+// job stays pending, keeps its slot and keeps its unique key. It is only called by the queue
+// routine.
+func (jobPool *JobPool) unqueueParked(queueJob *queueJob) {
+	if queueJob.queue == queueJob.tenantQueue.priorityJobQueue {
+		jobPool.countPriorityJobs(-1)
+	}
+
+	queueJob.queue.Remove(queueJob.element)
+	queueJob.queue = nil
+	queueJob.element = nil
+
+	if queueJob.tenantQueue.len() == 0 {
+		jobPool.retireTenant(queueJob.tenantQueue)
+	}
+
+	jobPool.unqueueJob(queueJob)
+
+	// Decrement the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, -1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	jobPool.wakeUps.retract(1)
+}
+
+// cancelParked marks every parked job cancelled during shutdown and returns the number of
+// priority and normal jobs abandoned. It is called once the queue routine is down.
+func (jobPool *JobPool) cancelParked() (priorityJobs int, normalJobs int) {
+	for jobType, parked := range jobPool.quarantine.parked {
+		for element := parked.Front(); element != nil; element = element.Next() {
+			queueJob := element.Value.(*queueJob)
+			if queueJob.priority == true {
+				priorityJobs++
+			} else {
+				normalJobs++
+			}
+
+			jobPool.cancelJob("Shutdown", queueJob, jobPending, DispositionAbandoned)
+
+			if queueJob.child != nil {
+				queueJob.child.dropped("Shutdown", queueJob)
+			}
+		}
+
+		delete(jobPool.quarantine.parked, jobType)
+	}
+
+	atomic.StoreInt32(&jobPool.parkedJobs, 0)
+	return priorityJobs, normalJobs
+}
+
+// record adds a panic to the job type's count. It returns true if the panic puts the type in
+// quarantine.
+func (quarantine *quarantine) record(jobType string, panicked time.Time, limit int, window time.Duration) bool {
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	if _, found := quarantine.quarantined[jobType]; found == true {
+		return false
+	}
+
+	// Forget the panics that have left the window.
+	panics := quarantine.panics[jobType]
+	for len(panics) > 0 && panicked.Sub(panics[0]) > window {
+		panics = panics[1:]
+	}
+	panics = append(panics, panicked)
+
+	if len(panics) < limit {
+		quarantine.panics[jobType] = panics
+		return false
+	}
+
+	delete(quarantine.panics, jobType)
+	quarantine.quarantined[jobType] = struct{}{}
+	return true
+}
+
+// release takes the job type out of quarantine. It returns false if the type is not in
+// quarantine.
+func (quarantine *quarantine) release(jobType string) bool {
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	if _, found := quarantine.quarantined[jobType]; found == false {
+		return false
+	}
+
+	delete(quarantine.quarantined, jobType)
+	return true
+}
+
+// holds returns true if the job type is in quarantine.
+func (quarantine *quarantine) holds(jobType string) bool {
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	_, found := quarantine.quarantined[jobType]
+	return found
+}
+
+// types returns the job types in quarantine in sorted order.
+func (quarantine *quarantine) types() []string {
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	jobTypes := make([]string, 0, len(quarantine.quarantined))
+	for jobType := range quarantine.quarantined {
+		jobTypes = append(jobTypes, jobType)
+	}
+
+	sort.Strings(jobTypes)
+	return jobTypes
+}
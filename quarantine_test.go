@@ -0,0 +1,204 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// typedJob is a job of a named type that panics or records its label when it runs.
+type typedJob struct {
+	jobType string       // The name of the job's type.
+	label   string       // What the job records when it runs.
+	panics  bool         // If the job panics instead.
+	record  func(string) // Records the label.
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Name returns the job's type.
+func (typedJob *typedJob) Name() string {
+	return typedJob.jobType
+}
+
+// RunJob panics or records the label.
+func (typedJob *typedJob) RunJob(jobRoutine int) {
+	if typedJob.panics == true {
+		panic("Job Panicked")
+	}
+
+	typedJob.record(typedJob.label)
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestQuarantine checks a job type is quarantined once it panics too often: its pending jobs
+// are parked, new jobs of the type are rejected with ErrQuarantined, the callback is called and
+// the other types keep running. Unquarantine releases the parked jobs in the order they were
+// admitted.
+func TestQuarantine(t *testing.T) {
+	quarantined := make(chan string, 1)
+	jobPool := newTestPool(t, 1, 20, WithQuarantine(2, time.Minute, func(jobType string) {
+		quarantined <- jobType
+	}))
+
+	var mutex sync.Mutex
+	var order []string
+	record := func(label string) {
+		mutex.Lock()
+		order = append(order, label)
+		mutex.Unlock()
+	}
+	ran := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), order...)
+	}
+
+	// Hold the job routine so every job is queued before the first one runs.
+	release := make(chan struct{})
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+	<-started
+
+	jobs := []*typedJob{
+		{jobType: "bad", panics: true},
+		{jobType: "bad", panics: true},
+		{jobType: "bad", label: "bad-1", record: record},
+		{jobType: "good", label: "good-1", record: record},
+		{jobType: "bad", label: "bad-2", record: record},
+		{jobType: "good", label: "good-2", record: record},
+		{jobType: "bad", label: "bad-3", record: record},
+	}
+	for _, job := range jobs {
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob : %v", err)
+		}
+	}
+
+	close(release)
+
+	// Entry: the second panic quarantines the type and parks its pending jobs.
+	select {
+	case jobType := <-quarantined:
+		if jobType != "bad" {
+			t.Fatalf("OnQuarantine : JobType[%s]", jobType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OnQuarantine was not called")
+	}
+
+	waitFor(t, 5*time.Second, "the other type to run", func() bool {
+		return len(ran()) == 2
+	})
+
+	if got, want := ran(), []string{"good-1", "good-2"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("Ran %v : Want %v", got, want)
+	}
+
+	if got := jobPool.Quarantined(); reflect.DeepEqual(got, []string{"bad"}) == false {
+		t.Fatalf("Quarantined : %v", got)
+	}
+
+	if parked := jobPool.Stats().ParkedJobs; parked != 3 {
+		t.Fatalf("ParkedJobs[%d] : Expected 3", parked)
+	}
+
+	// Rejection: new jobs of the type are refused, other types are not.
+	err := jobPool.QueueJob("test", &typedJob{jobType: "bad", label: "bad-4", record: record}, false)
+	if errors.Is(err, ErrQuarantined) == false {
+		t.Fatalf("QueueJob : Expected ErrQuarantined : %v", err)
+	}
+
+	var rejection Rejection
+	if errors.As(err, &rejection) == false || rejection.Reason() != RejectQuarantined {
+		t.Fatalf("QueueJob : Expected a RejectQuarantined rejection : %v", err)
+	}
+
+	if err := jobPool.QueueJob("test", &typedJob{jobType: "good", label: "good-3", record: record}, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+
+	waitFor(t, 5*time.Second, "the other type to run", func() bool {
+		return len(ran()) == 3
+	})
+
+	// Release: the parked jobs run in the order they were admitted.
+	released, err := jobPool.Unquarantine("bad")
+	if err != nil || released != 3 {
+		t.Fatalf("Unquarantine : Released[%d] : %v", released, err)
+	}
+
+	waitFor(t, 5*time.Second, "the parked jobs to run", func() bool {
+		return len(ran()) == 6
+	})
+
+	if got, want := ran(), []string{"good-1", "good-2", "good-3", "bad-1", "bad-2", "bad-3"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("Ran %v : Want %v", got, want)
+	}
+
+	if parked := jobPool.Stats().ParkedJobs; parked != 0 {
+		t.Fatalf("ParkedJobs[%d] : Expected 0", parked)
+	}
+
+	if _, err := jobPool.Unquarantine("bad"); errors.Is(err, ErrNotQuarantined) == false {
+		t.Fatalf("Unquarantine : Expected ErrNotQuarantined : %v", err)
+	}
+}
+
+// TestDrainWithParkedJobs checks a drain without a deadline does not wait for the jobs parked
+// by a quarantine and reports them abandoned.
+func TestDrainWithParkedJobs(t *testing.T) {
+	jobPool := newTestPool(t, 1, 10, WithQuarantine(1, time.Minute, nil))
+
+	release := make(chan struct{})
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+	<-started
+
+	record := func(string) {}
+	jobs := []*typedJob{
+		{jobType: "bad", panics: true},
+		{jobType: "bad", record: record},
+		{jobType: "bad", record: record},
+	}
+	for _, job := range jobs {
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob : %v", err)
+		}
+	}
+
+	close(release)
+	waitFor(t, 5*time.Second, "the jobs to be parked", func() bool {
+		return jobPool.Stats().ParkedJobs == 2
+	})
+
+	drained := make(chan ShutdownReport, 1)
+	go func() {
+		report, _ := jobPool.DrainWithDeadline(context.Background())
+		drained <- report
+	}()
+
+	select {
+	case report := <-drained:
+		if abandoned := report.AbandonedPriorityJobs + report.AbandonedNormalJobs; abandoned != 2 {
+			t.Fatalf("Abandoned[%d] : Expected the 2 parked jobs", abandoned)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("DrainWithDeadline waited for the parked jobs")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+//** CONSTANTS
+
+const (
+	// admissionPending is the state of a job the queue routine has not decided on.
+	admissionPending int32 = iota
+
+	// admissionClaimed is the state of a job the queue routine is deciding on.
+	admissionClaimed
+
+	// admissionWithdrawn is the state of a job its submitter gave up on first.
+	admissionWithdrawn
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobContext queues a job like QueueJob but gives up once ctx is done. The outcome is
+// exact: if ctx is done before the queue routine takes the job, the job is withdrawn, never
+// runs and ctx.Err() is returned. Once the queue routine has taken the job its outcome is
+// returned even if ctx is done by then.
+func (jobPool *JobPool) QueueJobContext(ctx context.Context, goRoutine string, jober Jobber, priority bool, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "QueueJobContext")
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// Jobs can't be queued once the pool is shutting down.
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return jobPool.reject(goRoutine, jober, ErrPoolClosed)
+	}
+
+	// A nil or malformed job is rejected at the call site rather than on a job routine.
+	if err = jobPool.checkJob(jober); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// A FaultInjector can delay or refuse the job.
+	if err = jobPool.admitFault(jober); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// A gang larger than the pool could never run.
+	gangSize := jobGangSize(jober)
+	if err = jobPool.checkGang(gangSize); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// Create the job object to queue. The result channel is buffered so the queue routine
+	// never blocks on a submitter that has given up.
+	job := queueJob{
+		Jobber:        jober,
+		name:          jobName(jober),
+		size:          jobSize(jober),
+		gangSize:      gangSize,
+		priority:      jobPool.admitPriority(jober, priority),
+		resultChannel: make(chan error, 1),
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	withdrawn, err := jobPool.submitJobContext(ctx, &job)
+	if err != nil && withdrawn == false {
+		err = jobPool.rejectJob(goRoutine, &job, err)
+	}
+
+	return err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// submitJobContext hands the job to the queue routine and waits for the outcome until ctx is
+// done. The submitter and the queue routine race to move the job out of admissionPending so
+// exactly one of them decides if the job is admitted.
+func (jobPool *JobPool) submitJobContext(ctx context.Context, queueJob *queueJob) (withdrawn bool, err error) {
+	if jobPool.enterQueue() == false {
+		return false, ErrPoolClosed
+	}
+	defer jobPool.exitQueue()
+
+	jobPool.countBacklog(1)
+	select {
+	case jobPool.queueChannel <- queueJob:
+	case <-ctx.Done():
+		jobPool.countBacklog(-1)
+		return true, ctx.Err()
+	}
+
+	select {
+	case err = <-queueJob.resultChannel:
+		return false, err
+
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&queueJob.admission, admissionPending, admissionWithdrawn) == true {
+			return true, ctx.Err()
+		}
+
+		// The queue routine took the job first so its outcome stands.
+		return false, <-queueJob.resultChannel
+	}
+}
+
+// claimAdmission moves the job out of admissionPending for the queue routine. It returns false
+// if the submitter withdrew the job first, in which case the job is dropped without a reply.
+func (queueJob *queueJob) claimAdmission() bool {
+	return atomic.CompareAndSwapInt32(&queueJob.admission, admissionPending, admissionClaimed)
+}
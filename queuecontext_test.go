@@ -0,0 +1,145 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestQueueJobContextWithdrawn holds the queue routine so the context ends before the job is
+// taken, and proves the job is withdrawn and never runs, whether it was still waiting to be
+// handed over or already sitting in the control buffer.
+func TestQueueJobContextWithdrawn(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"Unbuffered", nil},
+		{"ControlBuffer", []Option{WithControlBuffers(4, 0)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 10, test.options...)
+
+			// Hold the queue routine.
+			held := make(chan struct{})
+			unhold := make(chan struct{})
+			unholdOnce := sync.OnceFunc(func() { close(unhold) })
+			defer unholdOnce()
+
+			go jobPool.runInQueue(func() {
+				close(held)
+				<-unhold
+			})
+			<-held
+
+			var ran int32
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			err := jobPool.QueueJobContext(ctx, "test", funcJob(func(jobRoutine int) {
+				atomic.AddInt32(&ran, 1)
+			}), false)
+			if errors.Is(err, context.DeadlineExceeded) == false {
+				t.Fatalf("QueueJobContext returned %v, want context.DeadlineExceeded", err)
+			}
+
+			unholdOnce()
+
+			// A job queued after the withdrawn one runs once the queue routine has moved past it.
+			done := make(chan struct{})
+			if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) { close(done) }), false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the next job")
+			}
+
+			waitFor(t, 5*time.Second, "the pool to go idle", func() bool {
+				return jobPool.QueuedJobs() == 0 && jobPool.ActiveRoutines() == 0
+			})
+
+			if got := atomic.LoadInt32(&ran); got != 0 {
+				t.Fatalf("The withdrawn job ran %d times", got)
+			}
+		})
+	}
+}
+
+// TestQueueJobContextControlBufferRace races the deadlines of many producers against the queue
+// routine taking their jobs from the control buffer, and proves every job reported admitted runs
+// exactly once and no job reported withdrawn runs. Run it with -race.
+func TestQueueJobContextControlBufferRace(t *testing.T) {
+	producers, submissions := 8, 1000
+	if testing.Short() == true {
+		submissions = 200
+	}
+
+	jobPool := newTestPool(t, 4, int32(producers*submissions), WithControlBuffers(16, 0))
+
+	runs := make([]int32, producers*submissions)
+	admitted := make([]bool, producers*submissions)
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for producer := 0; producer < producers; producer++ {
+		go func(producer int) {
+			defer wg.Done()
+
+			for i := 0; i < submissions; i++ {
+				index := producer*submissions + i
+				job := funcJob(func(jobRoutine int) {
+					atomic.AddInt32(&runs[index], 1)
+				})
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%50)*time.Microsecond)
+				err := jobPool.QueueJobContext(ctx, "test", job, false)
+				cancel()
+
+				switch {
+				case err == nil:
+					admitted[index] = true
+
+				case errors.Is(err, context.DeadlineExceeded) == false:
+					t.Errorf("Producer %d : QueueJobContext : %v", producer, err)
+					return
+				}
+			}
+		}(producer)
+	}
+
+	wg.Wait()
+
+	waitFor(t, 10*time.Second, "the admitted jobs to run", func() bool {
+		return jobPool.QueuedJobs() == 0 && jobPool.ActiveRoutines() == 0
+	})
+
+	var withdrawn int
+	for index := range runs {
+		want := int32(0)
+		if admitted[index] == true {
+			want = 1
+		} else {
+			withdrawn++
+		}
+
+		if got := atomic.LoadInt32(&runs[index]); got != want {
+			t.Fatalf("Job %d : Admitted[%v] ran %d times", index, admitted[index], got)
+		}
+	}
+
+	t.Logf("Admitted[%d] Withdrawn[%d]", len(runs)-withdrawn, withdrawn)
+}
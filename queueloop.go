@@ -0,0 +1,146 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// QueueLoopStats describes how long the queue routine takes to handle its messages. Every
+	// submitter and job routine waits while the queue routine handles a message, so a slow
+	// message type or a growing backlog shows the queue routine is holding the pool back.
+	QueueLoopStats struct {
+		Backlog  int32               `json:"backlog"`  // The number of messages sent to the queue routine that it has not picked up.
+		Messages map[string]LoopTime `json:"messages"` // The time taken by each type of message over the last minute.
+	}
+
+	// LoopTime summarizes the time the queue routine took to handle one type of message.
+	LoopTime struct {
+		Handled int64         `json:"handled"` // The number of messages handled.
+		Max     time.Duration `json:"max"`     // The longest a message took.
+		P99     time.Duration `json:"p99"`     // The time 99% of the messages were handled within, rounded up to a power of two microseconds.
+	}
+
+	// loopMessage is a type of message handled by the queue routine.
+	loopMessage int
+
+	// loopTimes holds the handling times of the last minute for each type of message.
+	loopTimes struct {
+		windows [loopMessages]waitWindow // The handling times of each type of message.
+		mutex   sync.Mutex               // Protects the windows.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// loopEnqueue is a job queued by a submitter.
+	loopEnqueue loopMessage = iota
+
+	// loopAdmit is a job taken from the intake buffer.
+	loopAdmit
+
+	// loopDequeue is a job routine asking for its next job.
+	loopDequeue
+
+	// loopCancel is a purge of the pending jobs.
+	loopCancel
+
+	// loopTask is a function run against the queues, such as a snapshot, a dump or a removal.
+	loopTask
+
+	// loopSlot is a freed slot handed to the submitters waiting in QueueJobWait.
+	loopSlot
+
+	// loopMessages is the number of types of message.
+	loopMessages
+)
+
+//** VARIABLES
+
+var (
+	// loopMessageNames holds the name each type of message is reported under.
+	loopMessageNames = [loopMessages]string{
+		loopEnqueue: "enqueue",
+		loopAdmit:   "admit",
+		loopDequeue: "dequeue",
+		loopCancel:  "cancel",
+		loopTask:    "task",
+		loopSlot:    "slot",
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithSlowMessageWarning logs a warning each time the queue routine takes longer than threshold
+// to handle a single message, naming the type of message. Every QueueJob in the process waits
+// behind such a message, typically a callback or a large purge run on the queue routine.
+func WithSlowMessageWarning(threshold time.Duration) Option {
+	return func(config *Config) {
+		config.SlowMessageThreshold = threshold
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// countBacklog adjusts the number of messages waiting for the queue routine. A sender counts
+// its message before sending it and takes it back if the send is abandoned.
+func (jobPool *JobPool) countBacklog(delta int32) {
+	atomic.AddInt32(&jobPool.gauges.queueBacklog, delta)
+}
+
+// takeMessage counts a message the queue routine picked up off the backlog and returns when it
+// started handling it. It is only called by the queue routine.
+func (jobPool *JobPool) takeMessage() time.Time {
+	atomic.AddInt32(&jobPool.gauges.queueBacklog, -1)
+	return time.Now()
+}
+
+// recordMessage adds the time the queue routine took to handle a message and warns when it took
+// longer than the SlowMessageThreshold. It is only called by the queue routine.
+func (jobPool *JobPool) recordMessage(message loopMessage, started time.Time) {
+	now := time.Now()
+	handled := now.Sub(started)
+
+	loopTimes := jobPool.loopTimes
+	loopTimes.mutex.Lock()
+	loopTimes.windows[message].add(now.Unix(), handled)
+	loopTimes.mutex.Unlock()
+
+	if threshold := jobPool.config.SlowMessageThreshold; threshold > 0 && handled > threshold {
+		jobPool.writeLogf(LogError, "Queue", "recordMessage", "WARNING : Slow Message : Message[%s] Took[%v] Threshold[%v] Backlog[%d]", loopMessageNames[message], handled, threshold, atomic.LoadInt32(&jobPool.gauges.queueBacklog))
+	}
+}
+
+// queueLoopStats returns the backlog and the handling times of the last minute. Types of message
+// not handled in the last minute are left out.
+func (jobPool *JobPool) queueLoopStats() QueueLoopStats {
+	queueLoopStats := QueueLoopStats{
+		Backlog:  atomic.LoadInt32(&jobPool.gauges.queueBacklog),
+		Messages: make(map[string]LoopTime, loopMessages),
+	}
+
+	loopTimes := jobPool.loopTimes
+	now := time.Now().Unix()
+
+	loopTimes.mutex.Lock()
+	defer loopTimes.mutex.Unlock()
+
+	for message := range loopTimes.windows {
+		var loopTime LoopTime
+		loopTime.Handled, loopTime.Max, loopTime.P99 = loopTimes.windows[message].tally(now, 99)
+
+		if loopTime.Handled > 0 {
+			queueLoopStats.Messages[loopMessageNames[message]] = loopTime
+		}
+	}
+
+	return queueLoopStats
+}
@@ -0,0 +1,222 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobWait queues a job like QueueJob but blocks while the queue is at capacity instead of
+// rejecting the job. The submitters waiting are given a ticket and admitted one per freed slot in
+// the order they arrived, and while any submitter waits a job queued another way is refused as
+// if the queue were full, so a stream of new jobs can't take the slots from the submitters
+// already waiting. If ctx is done first the ticket is withdrawn without taking a slot and
+// ctx.Err() is returned. The job is checked again against barriers, its key, its tenant's quota
+// and the byte budget when its turn comes. Waiting submitters get ErrPoolClosed once the pool is
+// shut down, drained or its jobs transferred.
+//
+// A job that calls QueueJobWait from RunJob never waits, since the routine it holds may be the
+// one that has to free the slot. At capacity its job is admitted like a continuation, taking the
+// queue over its capacity within the ContinuationBudget, or refused with ErrWouldDeadlock.
+func (jobPool *JobPool) QueueJobWait(ctx context.Context, goRoutine string, jober Jobber, priority bool, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, goRoutine, "QueueJobWait")
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// Jobs can't be queued once the pool is shutting down.
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return jobPool.reject(goRoutine, jober, ErrPoolClosed)
+	}
+
+	// A nil or malformed job is rejected at the call site rather than on a job routine.
+	if err = jobPool.checkJob(jober); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// A FaultInjector can delay or refuse the job.
+	if err = jobPool.admitFault(jober); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// A gang larger than the pool could never run.
+	gangSize := jobGangSize(jober)
+	if err = jobPool.checkGang(gangSize); err != nil {
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	// Create the job object to queue. The result channel is buffered so the queue routine
+	// never blocks on a submitter that has given up.
+	job := queueJob{
+		Jobber:        jober,
+		name:          jobName(jober),
+		size:          jobSize(jober),
+		gangSize:      gangSize,
+		priority:      jobPool.admitPriority(jober, priority),
+		waitForSlot:   true,
+		resultChannel: make(chan error, 1),
+	}
+
+	// Apply the caller's options.
+	for _, option := range options {
+		option(&job)
+	}
+
+	// A job routine waiting for a slot could be holding the routine that frees it.
+	if jobPool.onJobRoutine() == true {
+		job.reentrant = true
+		job.continuation = true
+	}
+
+	withdrawn, err := jobPool.submitJobWait(ctx, &job)
+	if err != nil && withdrawn == false {
+		err = jobPool.rejectJob(goRoutine, &job, err)
+	}
+
+	return err
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// submitJobWait hands the job to the queue routine and waits for the outcome until ctx is done.
+// The queue routine is not held while the job waits for a slot, so Shutdown can answer the
+// waiting submitters.
+func (jobPool *JobPool) submitJobWait(ctx context.Context, queueJob *queueJob) (withdrawn bool, err error) {
+	if jobPool.enterQueue() == false {
+		return false, ErrPoolClosed
+	}
+
+	jobPool.countBacklog(1)
+	select {
+	case jobPool.queueChannel <- queueJob:
+		jobPool.exitQueue()
+
+	case <-ctx.Done():
+		jobPool.countBacklog(-1)
+		jobPool.exitQueue()
+		return true, ctx.Err()
+	}
+
+	select {
+	case err = <-queueJob.resultChannel:
+		return false, err
+
+	case <-ctx.Done():
+		// Take the ticket back unless the queue routine answered first. Once the queue routine is
+		// down every waiting submitter has been answered.
+		jobPool.runInQueue(func() {
+			if queueJob.slotWaiter != nil {
+				jobPool.unwaitSlot(queueJob)
+				withdrawn = true
+			}
+		})
+
+		if withdrawn == true {
+			jobPool.writeLogf(LogDebug, "Queue", "submitJobWait", "Withdrawn : Ticket[%d]", queueJob.ticket)
+			return true, ctx.Err()
+		}
+
+		return false, <-queueJob.resultChannel
+	}
+}
+
+// queueRoutineWaitSlot gives a job that found the queue at capacity a ticket and places it
+// behind the submitters already waiting. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineWaitSlot(queueJob *queueJob) {
+	jobPool.slotTickets++
+	queueJob.ticket = jobPool.slotTickets
+	queueJob.slotWaiter = jobPool.slotWaiters.PushBack(queueJob)
+	atomic.AddInt32(&jobPool.waitingSubmitters, 1)
+
+	// A slot freed before the submitter was counted as waiting sent no signal.
+	jobPool.queueRoutineAdmitWaiters()
+}
+
+// queueRoutineAdmitWaiters admits the waiting submitters in ticket order, one for each free slot.
+// It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineAdmitWaiters() {
+	defer jobPool.catchPanic(nil, "Queue", "queueRoutineAdmitWaiters")
+
+	for element := jobPool.slotWaiters.Front(); element != nil; element = jobPool.slotWaiters.Front() {
+		queueJob := element.Value.(*queueJob)
+
+		// The pool stopped accepting jobs while the submitter waited.
+		if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+			jobPool.unwaitSlot(queueJob)
+			queueJob.resultChannel <- ErrPoolClosed
+			continue
+		}
+
+		// What was checked when the job arrived may have changed while it waited.
+		refused := jobPool.checkUnique(queueJob)
+		switch {
+		case refused != nil:
+		case jobPool.barred(queueJob) == true:
+			refused = ErrBarrier
+		case jobPool.tenantAtCapacity(queueJob.tenant) == true:
+			refused = ErrTenantQuotaExceeded
+		case jobPool.bytesAtCapacity(queueJob) == true:
+			refused = ErrQueueBytesExceeded
+		case jobPool.memoryRefused(queueJob) == true:
+			refused = ErrMemoryGuarded
+		}
+
+		if refused != nil {
+			jobPool.unwaitSlot(queueJob)
+			queueJob.resultChannel <- refused
+			continue
+		}
+
+		if jobPool.claimSlot() == false {
+			return
+		}
+
+		jobPool.unwaitSlot(queueJob)
+		jobPool.pushJob(queueJob)
+		jobPool.holdUnique(queueJob)
+
+		if queueJob.admissionInfo != nil {
+			*queueJob.admissionInfo = jobPool.admissionInfo(queueJob)
+		}
+
+		// Tell the submitter the work is queued.
+		queueJob.resultChannel <- nil
+	}
+}
+
+// queueRoutineCloseWaiters answers every waiting submitter with ErrPoolClosed during shutdown.
+// It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineCloseWaiters() {
+	for element := jobPool.slotWaiters.Front(); element != nil; element = jobPool.slotWaiters.Front() {
+		queueJob := element.Value.(*queueJob)
+
+		jobPool.unwaitSlot(queueJob)
+		queueJob.resultChannel <- ErrPoolClosed
+	}
+}
+
+// unwaitSlot takes a job off the list of waiting submitters. It is only called by the queue
+// routine.
+func (jobPool *JobPool) unwaitSlot(queueJob *queueJob) {
+	jobPool.slotWaiters.Remove(queueJob.slotWaiter)
+	queueJob.slotWaiter = nil
+	atomic.AddInt32(&jobPool.waitingSubmitters, -1)
+}
+
+// signalSlotFreed tells the queue routine a slot was freed if a submitter is waiting for one.
+func (jobPool *JobPool) signalSlotFreed() {
+	if atomic.LoadInt32(&jobPool.waitingSubmitters) == 0 {
+		return
+	}
+
+	select {
+	case jobPool.slotFreed <- struct{}{}:
+	default:
+	}
+}
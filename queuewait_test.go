@@ -0,0 +1,123 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestQueueJobWaitFairness blocks 20 submitters on a full queue and checks they are admitted in
+// the order they arrived, and that the ticket of a submitter whose context is cancelled takes no
+// slot.
+func TestQueueJobWaitFairness(t *testing.T) {
+	const submitters = 20
+	const cancelled = 5
+
+	jobPool := newTestPool(t, 1, 1)
+
+	// Hold the job routine and fill the queue.
+	release := make(chan struct{})
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+	<-started
+
+	var mutex sync.Mutex
+	var order []int
+	record := func(i int) funcJob {
+		return funcJob(func(jobRoutine int) {
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+		})
+	}
+
+	if err := jobPool.QueueJob("test", record(-1), false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+
+	// Start the submitters one at a time so they arrive in order.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make([]error, submitters)
+	var wg sync.WaitGroup
+	wg.Add(submitters)
+	for i := 0; i < submitters; i++ {
+		submitterCtx := context.Background()
+		if i == cancelled {
+			submitterCtx = ctx
+		}
+
+		go func(i int, ctx context.Context) {
+			defer wg.Done()
+			errs[i] = jobPool.QueueJobWait(ctx, "test", record(i), false)
+		}(i, submitterCtx)
+
+		waitFor(t, 5*time.Second, "the submitter to wait", func() bool {
+			return jobPool.Stats().WaitingSubmitters == int32(i+1)
+		})
+	}
+
+	// A job queued another way can't take a slot from the waiting submitters.
+	if err := jobPool.QueueJob("test", record(-2), false); errors.Is(err, ErrPoolAtCapacity) == false {
+		t.Fatalf("QueueJob : Expected ErrPoolAtCapacity : %v", err)
+	}
+
+	cancel()
+	waitFor(t, 5*time.Second, "the cancelled ticket to be withdrawn", func() bool {
+		return jobPool.Stats().WaitingSubmitters == submitters-1
+	})
+
+	if reserved := atomic.LoadInt32(&jobPool.gauges.reservedSlots); reserved != 1 {
+		t.Fatalf("The cancelled ticket took a slot : ReservedSlots[%d]", reserved)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		switch {
+		case i == cancelled && errors.Is(err, context.Canceled) == false:
+			t.Fatalf("Submitter %d : Expected context.Canceled : %v", i, err)
+		case i != cancelled && err != nil:
+			t.Fatalf("Submitter %d : QueueJobWait : %v", i, err)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "the queue to empty", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(order) == submitters
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	want := []int{-1}
+	for i := 0; i < submitters; i++ {
+		if i != cancelled {
+			want = append(want, i)
+		}
+	}
+
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Admission order : Got %v : Want %v", order, want)
+		}
+	}
+
+	if reserved := atomic.LoadInt32(&jobPool.gauges.reservedSlots); reserved != 0 {
+		t.Fatalf("Slots left reserved : ReservedSlots[%d]", reserved)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+//** TYPES
+
+type (
+	// rampState is a ramp up in progress. The concurrency limit starts at one job and is raised
+	// by RampStep every RampInterval until every job routine can run or the limit set with
+	// SetConcurrencyLimit is reached.
+	rampState struct {
+		limit      int         // The concurrency limit set by the last step. Zero once the ramp is over.
+		userLimit  int         // The limit set with SetConcurrencyLimit, put back when a ramp ends. Zero is no limit.
+		generation uint64      // Counts the ramps so a step from a ramp that was stopped is ignored.
+		timerEntry *timerEntry // The next step waiting on the scheduler.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RampUp drops the concurrency limit to one job and raises it by RampStep every RampInterval
+// until every job routine can run, so a backlog doesn't hit a downstream service all at once.
+// A limit set with SetConcurrencyLimit caps the ramp and is in force again once it ends. A pool
+// created with WithRampUp ramps up when it is created. It does nothing if the pool was created
+// without a ramp.
+func (jobPool *JobPool) RampUp() {
+	if jobPool.config.RampStep <= 0 || jobPool.config.RampInterval <= 0 {
+		return
+	}
+
+	jobPool.rampMutex.Lock()
+	defer jobPool.rampMutex.Unlock()
+
+	jobPool.stopRamp()
+
+	if jobPool.rampTarget() <= 1 {
+		jobPool.concurrency.setLimit(jobPool.ramp.userLimit)
+		return
+	}
+
+	jobPool.ramp.limit = 1
+	jobPool.concurrency.setLimit(jobPool.ramp.limit)
+	jobPool.scheduleRampStep()
+}
+
+// FinishRamp ends a ramp up in progress and lets every job routine run, up to the limit set
+// with SetConcurrencyLimit.
+func (jobPool *JobPool) FinishRamp() {
+	jobPool.rampMutex.Lock()
+	defer jobPool.rampMutex.Unlock()
+
+	if jobPool.stopRamp() == true {
+		jobPool.concurrency.setLimit(jobPool.ramp.userLimit)
+	}
+}
+
+// Ramping returns true while a ramp up is in progress.
+func (jobPool *JobPool) Ramping() bool {
+	jobPool.rampMutex.Lock()
+	defer jobPool.rampMutex.Unlock()
+
+	return jobPool.ramp.limit > 0
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// rampTarget returns the concurrency limit a ramp up ends at: every job routine, or fewer if
+// the limit set with SetConcurrencyLimit is lower. The ramp mutex must be held.
+func (jobPool *JobPool) rampTarget() int {
+	target := jobPool.liveRoutines()
+	if userLimit := jobPool.ramp.userLimit; userLimit > 0 && userLimit < target {
+		target = userLimit
+	}
+
+	return target
+}
+
+// stopRamp ends a ramp up in progress without changing the concurrency limit. It returns false
+// if no ramp was in progress. The ramp mutex must be held.
+func (jobPool *JobPool) stopRamp() bool {
+	if jobPool.ramp.limit == 0 {
+		return false
+	}
+
+	jobPool.scheduler.cancel(jobPool.ramp.timerEntry)
+	jobPool.ramp.generation++
+	jobPool.ramp.limit = 0
+	jobPool.ramp.timerEntry = nil
+
+	return true
+}
+
+// scheduleRampStep registers the next step of the ramp with the scheduler. The ramp mutex must
+// be held.
+func (jobPool *JobPool) scheduleRampStep() {
+	generation := jobPool.ramp.generation
+
+	jobPool.ramp.timerEntry = jobPool.scheduler.schedule(jobPool.config.RampInterval, func() {
+		jobPool.stepRamp(generation)
+	})
+}
+
+// stepRamp raises the concurrency limit by RampStep and ends the ramp once it reaches its
+// target, putting back the limit set with SetConcurrencyLimit.
+func (jobPool *JobPool) stepRamp(generation uint64) {
+	jobPool.rampMutex.Lock()
+	defer jobPool.rampMutex.Unlock()
+
+	// The ramp this step belongs to was stopped or replaced.
+	if jobPool.ramp.limit == 0 || jobPool.ramp.generation != generation {
+		return
+	}
+
+	jobPool.ramp.limit += jobPool.config.RampStep
+	if jobPool.ramp.limit >= jobPool.rampTarget() {
+		jobPool.stopRamp()
+		jobPool.concurrency.setLimit(jobPool.ramp.userLimit)
+		return
+	}
+
+	jobPool.concurrency.setLimit(jobPool.ramp.limit)
+	jobPool.scheduleRampStep()
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestRampKeepsConcurrencyLimit proves a ramp up stops at the limit set with
+// SetConcurrencyLimit and puts it back when it ends, whether it runs out or is finished.
+func TestRampKeepsConcurrencyLimit(t *testing.T) {
+	t.Run("Step", func(t *testing.T) {
+		jobPool := newTestPool(t, 8, 10, WithRampUp(1, time.Millisecond))
+		jobPool.FinishRamp()
+
+		jobPool.SetConcurrencyLimit(3)
+		jobPool.RampUp()
+
+		if limit := jobPool.ConcurrencyLimit(); limit != 1 {
+			t.Fatalf("Limit at the start of the ramp is %d, want 1", limit)
+		}
+
+		waitFor(t, 5*time.Second, "the ramp to end", func() bool {
+			return jobPool.Ramping() == false
+		})
+
+		if limit := jobPool.ConcurrencyLimit(); limit != 3 {
+			t.Fatalf("Limit after the ramp is %d, want 3", limit)
+		}
+	})
+
+	t.Run("Finish", func(t *testing.T) {
+		jobPool := newTestPool(t, 8, 10, WithRampUp(1, time.Hour))
+		jobPool.FinishRamp()
+
+		jobPool.SetConcurrencyLimit(3)
+		jobPool.RampUp()
+		jobPool.FinishRamp()
+
+		if limit := jobPool.ConcurrencyLimit(); limit != 3 {
+			t.Fatalf("Limit after the ramp is %d, want 3", limit)
+		}
+	})
+
+	t.Run("NoLimit", func(t *testing.T) {
+		jobPool := newTestPool(t, 8, 10, WithRampUp(4, time.Millisecond))
+
+		waitFor(t, 5*time.Second, "the ramp to end", func() bool {
+			return jobPool.Ramping() == false
+		})
+
+		if limit := jobPool.ConcurrencyLimit(); limit != 0 {
+			t.Fatalf("Limit after the ramp is %d, want 0", limit)
+		}
+	})
+}
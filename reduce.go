@@ -0,0 +1,144 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// mapJob runs the map function against one input of Reduce.
+	mapJob[T any, R any] struct {
+		ctx     context.Context                     // Cancelled once Reduce returns.
+		mapFn   func(context.Context, T) (R, error) // The map function.
+		input   T                                   // The input to map.
+		results chan<- mapResult[R]                 // Receives the result.
+	}
+
+	// mapResult is the outcome of one map function.
+	mapResult[R any] struct {
+		value R     // The value returned by the map function.
+		err   error // The error returned by the map function.
+	}
+
+	// feedResult reports how many map jobs were queued and why queueing stopped early.
+	feedResult struct {
+		queued int   // The number of map jobs queued.
+		err    error // Why the rest of the inputs were not queued.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// ErrMapPanicked is the error Reduce returns when a map function panics.
+	ErrMapPanicked = errors.New("Map Function Panicked")
+
+	// reduceSequence names the group of each call to Reduce.
+	reduceSequence uint64
+)
+
+//** PUBLIC FUNCTIONS
+
+// Reduce runs mapFn against every input on the pool's job routines and folds the results into
+// init with reduceFn as they complete, in no particular order. reduceFn is only ever called on
+// the routine that called Reduce. Inputs are queued as the pool has room, so the inputs don't
+// need to fit in the queue. The first error cancels the context given to the map functions and
+// removes the map jobs that have not started from the queue.
+func Reduce[T any, R any, A any](ctx context.Context, jobPool *JobPool, inputs []T, mapFn func(context.Context, T) (R, error), reduceFn func(acc A, r R) A, init A) (A, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	group := fmt.Sprintf("Reduce %d", atomic.AddUint64(&reduceSequence, 1))
+	results := make(chan mapResult[R])
+	fed := make(chan feedResult, 1)
+
+	// Queue the inputs on their own routine so results can be reduced while the queue is full.
+	go func() {
+		var feedResult feedResult
+		for _, input := range inputs {
+			mapJob := mapJob[T, R]{
+				ctx:     ctx,
+				mapFn:   mapFn,
+				input:   input,
+				results: results,
+			}
+
+			if feedResult.err = jobPool.feed(ctx, &mapJob, false, []JobOption{WithGroup(group)}); feedResult.err != nil {
+				break
+			}
+
+			feedResult.queued++
+		}
+
+		fed <- feedResult
+	}()
+
+	// Stop the map jobs that have not started.
+	abandon := func() {
+		cancel()
+		jobPool.CancelGroup("Reduce", group)
+	}
+
+	acc := init
+	received, queued := 0, -1
+
+	for queued < 0 || received < queued {
+		select {
+		case mapResult := <-results:
+			received++
+
+			if mapResult.err != nil {
+				abandon()
+				return acc, mapResult.err
+			}
+
+			acc = reduceFn(acc, mapResult.value)
+
+		case feedResult := <-fed:
+			if feedResult.err != nil {
+				abandon()
+				return acc, feedResult.err
+			}
+
+			queued = feedResult.queued
+
+		case <-ctx.Done():
+			abandon()
+			return acc, ctx.Err()
+		}
+	}
+
+	return acc, nil
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob maps the input and hands the result to Reduce. A map function that panics is reported
+// to Reduce as ErrMapPanicked before the pool recovers the panic.
+func (mapJob *mapJob[T, R]) RunJob(jobRoutine int) {
+	mapResult := mapResult[R]{
+		err: ErrMapPanicked,
+	}
+
+	defer func() {
+		select {
+		case mapJob.results <- mapResult:
+		case <-mapJob.ctx.Done():
+		}
+	}()
+
+	if err := mapJob.ctx.Err(); err != nil {
+		mapResult.err = err
+		return
+	}
+
+	mapResult.value, mapResult.err = mapJob.mapFn(mapJob.ctx, mapJob.input)
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+//** VARIABLES
+
+var (
+	// ErrWouldDeadlock is returned by QueueJobWait called from one of the pool's own job routines
+	// while the queue is at capacity and the continuation budget is used up. Waiting would hold
+	// the routine that has to free the slot.
+	ErrWouldDeadlock = errors.New("Job Routine Would Deadlock Waiting For A Slot")
+)
+
+//** PRIVATE FUNCTIONS
+
+// goroutineID returns the ID of the calling goroutine from the header of its stack trace. It
+// returns 0 if the header can't be read.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if end := bytes.IndexByte(header, ' '); end > 0 {
+		header = header[:end]
+	}
+
+	id, err := strconv.ParseUint(string(header), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// onJobRoutine returns true if the caller is one of the pool's job routines, such as a job
+// queueing more work from RunJob. Goroutines started by the job are not recognized.
+func (jobPool *JobPool) onJobRoutine() bool {
+	id := goroutineID()
+	if id == 0 {
+		return false
+	}
+
+	for _, workerState := range jobPool.workerStates() {
+		if atomic.LoadUint64(&workerState.goroutineID) == id {
+			return true
+		}
+	}
+
+	return false
+}
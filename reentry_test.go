@@ -0,0 +1,107 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestQueueJobWaitFromEveryRoutine has every job routine call QueueJobWait while the queue is
+// full. Without a continuation budget each call must be refused with ErrWouldDeadlock instead
+// of waiting for a slot only those routines could free. With a budget each job is admitted
+// over capacity. Either way the pool keeps running.
+func TestQueueJobWaitFromEveryRoutine(t *testing.T) {
+	const routines = 2
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    error
+	}{
+		{name: "NoBudget", want: ErrWouldDeadlock},
+		{name: "Budget", options: []Option{WithContinuationBudget(routines)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, routines, routines, test.options...)
+
+			var ran int32
+			child := funcJob(func(jobRoutine int) {
+				atomic.AddInt32(&ran, 1)
+			})
+
+			// Each job waits at the gate until the queue is full, then queues a child and holds its
+			// routine until every child has been answered.
+			gate := make(chan struct{})
+			hold := make(chan struct{})
+			release := sync.OnceFunc(func() {
+				close(hold)
+			})
+			defer release()
+			var started int32
+			results := make(chan error, routines)
+			parent := funcJob(func(jobRoutine int) {
+				atomic.AddInt32(&started, 1)
+				<-gate
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				results <- jobPool.QueueJobWait(ctx, "test", child, false)
+				<-hold
+			})
+
+			for i := 0; i < routines; i++ {
+				if err := jobPool.QueueJob("test", parent, false); err != nil {
+					t.Fatalf("QueueJob : %v", err)
+				}
+			}
+
+			waitFor(t, 5*time.Second, "every job routine to be busy", func() bool {
+				return atomic.LoadInt32(&started) == routines
+			})
+
+			for i := 0; i < routines; i++ {
+				if err := jobPool.QueueJob("test", child, false); err != nil {
+					t.Fatalf("QueueJob : %v", err)
+				}
+			}
+
+			close(gate)
+
+			admitted := int32(routines)
+			for i := 0; i < routines; i++ {
+				select {
+				case err := <-results:
+					switch {
+					case test.want == nil && err != nil:
+						t.Fatalf("QueueJobWait : %v", err)
+					case test.want != nil && errors.Is(err, test.want) == false:
+						t.Fatalf("QueueJobWait : Expected %v : %v", test.want, err)
+					case err == nil:
+						admitted++
+					}
+
+				case <-time.After(5 * time.Second):
+					t.Fatalf("QueueJobWait blocked a job routine on a full queue")
+				}
+			}
+
+			release()
+
+			waitFor(t, 5*time.Second, "the admitted jobs to run", func() bool {
+				return atomic.LoadInt32(&ran) == admitted
+			})
+		})
+	}
+}
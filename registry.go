@@ -0,0 +1,236 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// UnknownJobTypeError is returned when a job type has not been registered.
+	UnknownJobTypeError struct {
+		Name string // The job type that was asked for.
+	}
+
+	// jobEnvelope is the serialized form of a job.
+	jobEnvelope struct {
+		Type     string `json:"type"`             // The registered name of the job type.
+		Priority bool   `json:"priority"`         // If the job is queued as a priority job.
+		Binary   []byte `json:"binary,omitempty"` // The job encoded by its BinaryMarshaler.
+		JSON     []byte `json:"json,omitempty"`   // The job encoded by encoding/json.
+	}
+
+	// jobRegistry maps job type names to the factories that create them.
+	jobRegistry struct {
+		factories map[string]func() Jobber // The factory for each registered name.
+		names     map[reflect.Type]string  // The registered name of each concrete type.
+		mutex     sync.RWMutex             // Protects the maps.
+	}
+)
+
+//** VARIABLES
+
+var (
+	// registry holds the job types registered with RegisterJobType.
+	registry = jobRegistry{
+		factories: make(map[string]func() Jobber),
+		names:     make(map[reflect.Type]string),
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// RegisterJobType registers a job type under a name so it can be serialized with MarshalJob and
+// reconstructed with UnmarshalJob or JobPool.Replay. The factory must return a new, empty job of
+// the type. Jobs that implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler use them,
+// every other job is encoded with encoding/json.
+func RegisterJobType(name string, factory func() Jobber) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.factories[name] = factory
+	registry.names[reflect.TypeOf(factory())] = name
+}
+
+// JobTypeName returns the registered name of the job's type. Jobs of an unregistered type are
+// named by their Go type.
+func JobTypeName(jober Jobber) string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	if name, found := registry.names[reflect.TypeOf(jober)]; found == true {
+		return name
+	}
+
+	return fmt.Sprintf("%T", jober)
+}
+
+// jobName returns the job's Name when it implements Namer and its type name otherwise. A Name
+// method that panics is ignored.
+func jobName(jober Jobber) (name string) {
+	if namer, ok := jober.(Namer); ok == true {
+		defer func() {
+			if r := recover(); r != nil {
+				name = JobTypeName(jober)
+			}
+		}()
+
+		return namer.Name()
+	}
+
+	return JobTypeName(jober)
+}
+
+// jobSize returns the job's SizeBytes when it implements Sizer and zero otherwise.
+func jobSize(jober Jobber) int64 {
+	if sizer, ok := jober.(Sizer); ok == true {
+		return sizer.SizeBytes()
+	}
+
+	return 0
+}
+
+// NewJob creates an empty job of the registered type.
+func NewJob(name string) (Jobber, error) {
+	registry.mutex.RLock()
+	factory, found := registry.factories[name]
+	registry.mutex.RUnlock()
+
+	if found == false {
+		return nil, &UnknownJobTypeError{Name: name}
+	}
+
+	return factory(), nil
+}
+
+// MarshalJob serializes a job of a registered type.
+func MarshalJob(jober Jobber, priority bool) ([]byte, error) {
+	registry.mutex.RLock()
+	name, found := registry.names[reflect.TypeOf(jober)]
+	registry.mutex.RUnlock()
+
+	if found == false {
+		return nil, &UnknownJobTypeError{Name: fmt.Sprintf("%T", jober)}
+	}
+
+	jobEnvelope := jobEnvelope{
+		Type:     name,
+		Priority: priority,
+	}
+
+	var err error
+	if binaryMarshaler, ok := jober.(encoding.BinaryMarshaler); ok == true {
+		jobEnvelope.Binary, err = binaryMarshaler.MarshalBinary()
+	} else {
+		jobEnvelope.JSON, err = json.Marshal(jober)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jobEnvelope)
+}
+
+// UnmarshalJob reconstructs a job serialized by MarshalJob.
+func UnmarshalJob(data []byte) (jober Jobber, priority bool, err error) {
+	var jobEnvelope jobEnvelope
+	if err = json.Unmarshal(data, &jobEnvelope); err != nil {
+		return nil, false, err
+	}
+
+	if jober, err = NewJob(jobEnvelope.Type); err != nil {
+		return nil, false, err
+	}
+
+	// Decode into a pointer so jobs of value types can be filled in as well.
+	value := reflect.ValueOf(jober)
+	target := value
+	if value.Kind() != reflect.Ptr {
+		target = reflect.New(value.Type())
+		target.Elem().Set(value)
+	}
+
+	if jobEnvelope.Binary != nil {
+		binaryUnmarshaler, ok := target.Interface().(encoding.BinaryUnmarshaler)
+		if ok == false {
+			return nil, false, fmt.Errorf("Job Type %s Can't Be Unmarshaled From Binary", jobEnvelope.Type)
+		}
+
+		err = binaryUnmarshaler.UnmarshalBinary(jobEnvelope.Binary)
+	} else if jobEnvelope.JSON != nil {
+		err = json.Unmarshal(jobEnvelope.JSON, target.Interface())
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if value.Kind() != reflect.Ptr {
+		jober = target.Elem().Interface().(Jobber)
+	}
+
+	return jober, jobEnvelope.Priority, nil
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Replay decodes a job serialized by MarshalJob and queues it with its original priority.
+func (jobPool *JobPool) Replay(data []byte, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, "Replay", "Replay")
+
+	jober, priority, err := UnmarshalJob(data)
+	if err != nil {
+		return err
+	}
+
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return jobPool.reject("Replay", jober, ErrPoolClosed)
+	}
+
+	job, err := jobPool.newQueueJob(jober, priority, options)
+	if err != nil {
+		return jobPool.reject("Replay", jober, err)
+	}
+
+	return jobPool.injectJob("Replay", job, false, injectReserve)
+}
+
+// ReplayAll decodes jobs serialized by MarshalJob and queues them in order with a single request
+// to the queue routine, each with its original priority. Nothing is queued if a job can't be
+// decoded or the queue can't hold every job.
+func (jobPool *JobPool) ReplayAll(data [][]byte, options ...JobOption) (err error) {
+	defer jobPool.catchPanic(&err, "ReplayAll", "ReplayAll")
+
+	if atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return ErrPoolClosed
+	}
+
+	queueJobs := make([]*queueJob, len(data))
+	for i := range data {
+		jober, priority, err := UnmarshalJob(data[i])
+		if err != nil {
+			return err
+		}
+
+		if queueJobs[i], err = jobPool.newQueueJob(jober, priority, options); err != nil {
+			return jobPool.reject("ReplayAll", jober, err)
+		}
+	}
+
+	return jobPool.injectJobs("ReplayAll", queueJobs, false, injectReserve)
+}
+
+// Error implements the error interface.
+func (unknownJobTypeError *UnknownJobTypeError) Error() string {
+	return fmt.Sprintf("Unknown Job Type : %s", unknownJobTypeError.Name)
+}
@@ -0,0 +1,244 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// RejectReason names why a job was not admitted.
+	RejectReason int
+
+	// RejectError is the error a job is rejected with. It wraps the exported sentinel for the
+	// reason, so errors.Is works against the sentinel, and carries the state of the pool that
+	// caused the rejection, such as the queue depth against its capacity.
+	RejectError struct {
+		reason  RejectReason           // Why the job was rejected.
+		err     error                  // The error wrapping the sentinel for the reason.
+		details map[string]interface{} // The state of the pool at the time of the rejection.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// RejectOther is a rejection that has no reason of its own.
+	RejectOther RejectReason = iota
+
+	// RejectCapacity is a job refused because the queue is at capacity, ErrPoolAtCapacity.
+	RejectCapacity
+
+	// RejectTenantQuota is a job refused because its tenant holds its quota of pending jobs,
+	// ErrTenantQuotaExceeded.
+	RejectTenantQuota
+
+	// RejectQueueBytes is a job refused because it would take the queue over its byte budget,
+	// ErrQueueBytesExceeded.
+	RejectQueueBytes
+
+	// RejectQuarantined is a job refused because its type is quarantined, ErrQuarantined.
+	RejectQuarantined
+
+	// RejectClosed is a job refused because the pool is shut down, ErrPoolClosed.
+	RejectClosed
+
+	// RejectBarrier is a job held back by a barrier, ErrBarrier.
+	RejectBarrier
+
+	// RejectDuplicate is a unique job whose key is held by another job, ErrDuplicateJob.
+	RejectDuplicate
+
+	// RejectRecentlyProcessed is a unique job whose key is cooling down, ErrRecentlyProcessed.
+	RejectRecentlyProcessed
+
+	// RejectInvalid is a job that is nil, failed the validator or could not be serialized,
+	// ErrNilJob or ErrInvalidJob.
+	RejectInvalid
+
+	// RejectGangTooLarge is a gang that needs more job routines than the pool has,
+	// ErrGangTooLarge.
+	RejectGangTooLarge
+
+	// RejectBackendUnavailable is a job the shared backend could not take,
+	// ErrBackendUnavailable.
+	RejectBackendUnavailable
+
+	// RejectMemory is a normal job refused while the heap is over the memory guard's soft
+	// limit, ErrMemoryGuarded.
+	RejectMemory
+
+	// RejectWouldDeadlock is a job a job routine would have waited for a slot for,
+	// ErrWouldDeadlock.
+	RejectWouldDeadlock
+)
+
+//** VARIABLES
+
+var (
+	// rejectSentinels maps each sentinel to the reason it is rejected with.
+	rejectSentinels = []struct {
+		sentinel error
+		reason   RejectReason
+	}{
+		{ErrPoolAtCapacity, RejectCapacity},
+		{ErrTenantQuotaExceeded, RejectTenantQuota},
+		{ErrQueueBytesExceeded, RejectQueueBytes},
+		{ErrQuarantined, RejectQuarantined},
+		{ErrPoolClosed, RejectClosed},
+		{ErrBarrier, RejectBarrier},
+		{ErrDuplicateJob, RejectDuplicate},
+		{ErrRecentlyProcessed, RejectRecentlyProcessed},
+		{ErrNilJob, RejectInvalid},
+		{ErrInvalidJob, RejectInvalid},
+		{ErrGangTooLarge, RejectGangTooLarge},
+		{ErrBackendUnavailable, RejectBackendUnavailable},
+		{ErrMemoryGuarded, RejectMemory},
+		{ErrWouldDeadlock, RejectWouldDeadlock},
+	}
+
+	// rejectReasonNames holds the name of each reason.
+	rejectReasonNames = map[RejectReason]string{
+		RejectOther:              "Other",
+		RejectCapacity:           "Capacity",
+		RejectTenantQuota:        "TenantQuota",
+		RejectQueueBytes:         "QueueBytes",
+		RejectQuarantined:        "Quarantined",
+		RejectClosed:             "Closed",
+		RejectBarrier:            "Barrier",
+		RejectDuplicate:          "Duplicate",
+		RejectRecentlyProcessed:  "RecentlyProcessed",
+		RejectInvalid:            "Invalid",
+		RejectGangTooLarge:       "GangTooLarge",
+		RejectBackendUnavailable: "BackendUnavailable",
+		RejectMemory:             "Memory",
+		RejectWouldDeadlock:      "WouldDeadlock",
+	}
+)
+
+//** INTERFACES
+
+// Rejection is implemented by every error a job is rejected with. Every rejection returned by
+// the pool, or passed to the RejectionHandler, is a *RejectError and unwraps to one of the
+// exported sentinels named by its reason.
+type Rejection interface {
+	error
+	Reason() RejectReason
+	Details() map[string]interface{}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// String returns the name of the reason.
+func (rejectReason RejectReason) String() string {
+	if name, found := rejectReasonNames[rejectReason]; found == true {
+		return name
+	}
+
+	return "Unknown"
+}
+
+// Error returns the message of the wrapped error.
+func (rejectError *RejectError) Error() string {
+	return rejectError.err.Error()
+}
+
+// Unwrap returns the wrapped error so errors.Is and errors.As reach the sentinel.
+func (rejectError *RejectError) Unwrap() error {
+	return rejectError.err
+}
+
+// Reason returns why the job was rejected.
+func (rejectError *RejectError) Reason() RejectReason {
+	return rejectError.reason
+}
+
+// Details returns the state of the pool at the time of the rejection, such as the queue depth
+// and capacity for RejectCapacity or the tenant's pending jobs and quota for RejectTenantQuota.
+// The job type is always included when the job is known.
+func (rejectError *RejectError) Details() map[string]interface{} {
+	return rejectError.details
+}
+
+//** PRIVATE FUNCTIONS
+
+// rejectReasonOf returns the reason for the sentinel the error wraps.
+func rejectReasonOf(err error) RejectReason {
+	for _, rejectSentinel := range rejectSentinels {
+		if errors.Is(err, rejectSentinel.sentinel) == true {
+			return rejectSentinel.reason
+		}
+	}
+
+	return RejectOther
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// rejection wraps the reason a job was refused in a RejectError holding the state of the pool
+// that caused it. The job is optional and a reason that is already a RejectError is returned
+// as it is.
+func (jobPool *JobPool) rejection(reason error, jober Jobber, queueJob *queueJob) error {
+	var rejectError *RejectError
+	if reason == nil || errors.As(reason, &rejectError) == true {
+		return reason
+	}
+
+	rejectError = &RejectError{
+		reason:  rejectReasonOf(reason),
+		err:     reason,
+		details: make(map[string]interface{}),
+	}
+
+	details := rejectError.details
+	if jober != nil {
+		details["job_type"] = jobName(jober)
+	}
+
+	switch rejectError.reason {
+	case RejectCapacity, RejectWouldDeadlock:
+		details["depth"] = atomic.LoadInt32(&jobPool.gauges.reservedSlots)
+		details["capacity"] = jobPool.config.QueueCapacity
+		details["waiting_submitters"] = atomic.LoadInt32(&jobPool.waitingSubmitters)
+
+	case RejectTenantQuota:
+		if queueJob != nil {
+			jobPool.tenantMutex.Lock()
+			details["tenant_pending"] = jobPool.tenantJobs[queueJob.tenant]
+			jobPool.tenantMutex.Unlock()
+
+			details["tenant"] = queueJob.tenant
+		}
+		details["tenant_capacity"] = jobPool.config.TenantCapacity
+
+	case RejectQueueBytes:
+		if queueJob != nil {
+			details["job_bytes"] = queueJob.size
+		}
+		details["pending_bytes"] = atomic.LoadInt64(&jobPool.gauges.pendingBytes)
+		details["max_bytes"] = jobPool.config.MaxQueueBytes
+
+	case RejectDuplicate, RejectRecentlyProcessed:
+		if queueJob != nil {
+			details["key"] = queueJob.key
+		}
+
+	case RejectMemory:
+		details["heap_bytes"] = atomic.LoadInt64(&jobPool.heapBytes)
+		details["soft_limit"] = jobPool.config.MemorySoftLimit
+
+	case RejectGangTooLarge:
+		var gangTooLargeError *GangTooLargeError
+		if errors.As(reason, &gangTooLargeError) == true {
+			details["gang_size"] = gangTooLargeError.GangSize
+			details["routines"] = gangTooLargeError.Routines
+		}
+	}
+
+	return rejectError
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"testing"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestRejectSentinels checks every sentinel a job can be rejected with comes back as a
+// RejectError that matches the sentinel, names its reason and carries the state of the pool
+// behind the reason.
+func TestRejectSentinels(t *testing.T) {
+	jobPool := newTestPool(t, 2, 10, WithTenantCapacity(5), WithMaxQueueBytes(100))
+
+	// The details each reason carries besides the job type.
+	details := map[RejectReason][]string{
+		RejectCapacity:          {"depth", "capacity", "waiting_submitters"},
+		RejectWouldDeadlock:     {"depth", "capacity", "waiting_submitters"},
+		RejectTenantQuota:       {"tenant", "tenant_pending", "tenant_capacity"},
+		RejectQueueBytes:        {"job_bytes", "pending_bytes", "max_bytes"},
+		RejectDuplicate:         {"key"},
+		RejectRecentlyProcessed: {"key"},
+		RejectMemory:            {"heap_bytes", "soft_limit"},
+		RejectGangTooLarge:      {"gang_size", "routines"},
+	}
+
+	job := funcJob(func(jobRoutine int) {})
+	queueJob := &queueJob{Jobber: job, tenant: "tenant", key: "key", size: 10}
+
+	for _, rejectSentinel := range rejectSentinels {
+		rejectSentinel := rejectSentinel
+
+		t.Run(rejectSentinel.sentinel.Error(), func(t *testing.T) {
+			reason := rejectSentinel.sentinel
+			if rejectSentinel.reason == RejectGangTooLarge {
+				reason = jobPool.checkGang(3)
+			}
+
+			err := jobPool.rejection(reason, job, queueJob)
+
+			if errors.Is(err, rejectSentinel.sentinel) == false {
+				t.Fatalf("errors.Is : %v does not match %v", err, rejectSentinel.sentinel)
+			}
+
+			var rejectError *RejectError
+			if errors.As(err, &rejectError) == false {
+				t.Fatalf("errors.As : %T is not a *RejectError", err)
+			}
+
+			if rejectError.Reason() != rejectSentinel.reason {
+				t.Fatalf("Reason : Got %s : Want %s", rejectError.Reason(), rejectSentinel.reason)
+			}
+
+			if rejectError.Reason().String() == "Unknown" {
+				t.Fatalf("Reason %d has no name", rejectError.Reason())
+			}
+
+			for _, key := range append([]string{"job_type"}, details[rejectSentinel.reason]...) {
+				if _, found := rejectError.Details()[key]; found == false {
+					t.Fatalf("Details : Missing %q : %v", key, rejectError.Details())
+				}
+			}
+
+			// A RejectError is not wrapped a second time.
+			if again := jobPool.rejection(err, job, queueJob); again != err {
+				t.Fatalf("Rejection wrapped again : %v", again)
+			}
+		})
+	}
+}
+
+// TestRejectCapacityDetails checks a job refused by a full queue reports the depth of the
+// queue against its capacity.
+func TestRejectCapacityDetails(t *testing.T) {
+	jobPool := newTestPool(t, 1, 1)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+	<-started
+
+	job := funcJob(func(jobRoutine int) {})
+	if err := jobPool.QueueJob("test", job, false); err != nil {
+		t.Fatalf("QueueJob : %v", err)
+	}
+
+	err := jobPool.QueueJob("test", job, false)
+
+	var rejection Rejection
+	if errors.As(err, &rejection) == false || rejection.Reason() != RejectCapacity {
+		t.Fatalf("QueueJob : Expected a RejectCapacity rejection : %v", err)
+	}
+
+	details := rejection.Details()
+	if details["depth"] != int32(1) || details["capacity"] != int32(1) {
+		t.Fatalf("Details : Expected a depth and capacity of 1 : %v", details)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"io"
+	"sync/atomic"
+)
+
+//** INTERFACES
+
+// Releaser is implemented by jobs that hold resources, such as temporary files or pooled
+// buffers, that must be given back when the job will never run. The pool calls Release once
+// for a job that is rejected, evicted, cancelled, purged, abandoned at shutdown or handed to the
+// dead letter handler. A job that runs manages its own resources and is not released. A job
+// that implements io.Closer instead is closed in the same places, and a job that implements
+// both is only released. A job merged into a pending job by a Coalescer is left to the
+// Coalescer.
+type Releaser interface {
+	Release() error
+}
+
+//** PUBLIC FUNCTIONS
+
+// WithReleaseErrorHandler sets the handler that receives the errors returned by Release or
+// Close for a job that never ran. When no handler is set the errors are logged.
+func WithReleaseErrorHandler(onReleaseError func(jober Jobber, err error)) Option {
+	return func(config *Config) {
+		config.OnReleaseError = onReleaseError
+	}
+}
+
+// WithRetainOnReject keeps a job that is refused because the queue or its tenant is full, or
+// the shared backend is failing, from being released, for a submitter that offers the same job
+// again. A job refused for any other reason is still released.
+func WithRetainOnReject() JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.retained = true
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// cancelJob moves a job that has not started to cancelled and releases it. It returns false if
+// the job was not in the from state, in which case another path has already won the job.
+func (jobPool *JobPool) cancelJob(goRoutine string, queueJob *queueJob, from int32, disposition Disposition) bool {
+	if queueJob.dispose(from, disposition) == false {
+		return false
+	}
+
+	jobPool.releaseJob(goRoutine, queueJob)
+	return true
+}
+
+// cancelQueuedJobs marks every job in a queue that has been detached from the pool cancelled
+// and releases them.
+func (jobPool *JobPool) cancelQueuedJobs(goRoutine string, queue *list.List, disposition Disposition) {
+	for element := queue.Front(); element != nil; element = element.Next() {
+		jobPool.cancelJob(goRoutine, element.Value.(*queueJob), jobPending, disposition)
+	}
+}
+
+// releaseJob releases a queued job that will never run. A job is only released once however
+// many paths drop it.
+func (jobPool *JobPool) releaseJob(goRoutine string, queueJob *queueJob) {
+	if atomic.CompareAndSwapInt32(&queueJob.released, 0, 1) == false {
+		return
+	}
+
+	jobPool.releaseJober(goRoutine, queueJob.Jobber)
+}
+
+// releaseJober calls Release or Close on a job that will never run and reports the error.
+func (jobPool *JobPool) releaseJober(goRoutine string, jober Jobber) {
+	var release func() error
+	switch releaser := jober.(type) {
+	case Releaser:
+		release = releaser.Release
+	case io.Closer:
+		release = releaser.Close
+	default:
+		return
+	}
+
+	var err error
+	jobPool.callbackSafely(goRoutine, "Release", func() {
+		err = release()
+	})
+
+	if err == nil {
+		return
+	}
+
+	if onReleaseError := jobPool.config.OnReleaseError; onReleaseError != nil {
+		jobPool.callbackSafely(goRoutine, "OnReleaseError", func() {
+			onReleaseError(jober, err)
+		})
+		return
+	}
+
+	jobPool.writeLogf(LogError, goRoutine, "releaseJober", "ERROR : %s : Job[%s]", err, jobName(jober))
+}
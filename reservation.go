@@ -0,0 +1,249 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+)
+
+//** TYPES
+
+type (
+	// TagStats holds the counters for the jobs queued with a tag, see WithTagReservations. Jobs
+	// without a tag are not included.
+	TagStats struct {
+		Running  int `json:"running"`  // The number of the tag's jobs handed to a job routine and not yet finished.
+		Pending  int `json:"pending"`  // The number of the tag's jobs waiting in queue.
+		Reserved int `json:"reserved"` // The number of job routines guaranteed to the tag.
+	}
+
+	// tagReservations hands the job routines out so each tag with a reservation can always get
+	// its guaranteed minimum when it has work.
+	tagReservations struct {
+		reserved   map[string]int // The job routines guaranteed to each tag.
+		running    map[string]int // The jobs of each tag handed to a job routine and not yet finished.
+		pending    map[string]int // The jobs of each tag waiting in queue.
+		dispatched int            // The jobs handed to a job routine and not yet finished, whatever their tag.
+		finished   uint64         // Counts the jobs finished so a dequeue can tell if one finished while it looked.
+		held       int            // The wake ups spent by job routines that found only jobs held back for a reservation.
+		mutex      sync.Mutex     // Protects the counters.
+	}
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithTagReservations guarantees each tag a minimum number of job routines, for example 2 of 10
+// for "emails" and 3 for "search-indexing" with the rest floating. Jobs are tagged with WithTag.
+// A job routine is not given a job that would leave too few routines to honor the minimum of a
+// tag with pending jobs. A reservation the tag isn't using is lent to other jobs and reclaimed
+// as those jobs finish once the tag has jobs pending. Running jobs are never interrupted.
+func WithTagReservations(reservations map[string]int) Option {
+	return func(config *Config) {
+		config.TagReservations = copyCounts(reservations)
+	}
+}
+
+// WithTag tags a job so it counts against the tag's reservation, see WithTagReservations.
+func WithTag(tag string) JobOption {
+	return func(queueJob *queueJob) {
+		queueJob.tag = tag
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// newTagReservations creates the reservations or returns nil if the pool has none.
+func newTagReservations(config Config) *tagReservations {
+	if len(config.TagReservations) == 0 {
+		return nil
+	}
+
+	reserved := make(map[string]int, len(config.TagReservations))
+	for tag, routines := range config.TagReservations {
+		if routines > 0 {
+			reserved[tag] = routines
+		}
+	}
+
+	return &tagReservations{
+		reserved: reserved,
+		running:  make(map[string]int),
+		pending:  make(map[string]int),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// finishTag records that a job handed to a job routine has finished or was never run, and hands
+// back the wake ups held for a reservation so the jobs passed over are looked at again.
+func (jobPool *JobPool) finishTag(queueJob *queueJob) {
+	if held := jobPool.tags.finish(queueJob); held > 0 {
+		jobPool.wakeUps.post(held)
+	}
+}
+
+// holdTagWakeUp keeps the wake up of a job routine that found only jobs held back for a
+// reservation until a job finishes. When a job finished while the queues were looked at the
+// wake up is posted again straight away. It is only called by the queue routine.
+func (jobPool *JobPool) holdTagWakeUp(seen uint64) {
+	if jobPool.tags.hold(seen) == false {
+		jobPool.wakeUps.post(1)
+	}
+}
+
+// count adds delta to the tag's pending jobs.
+func (tagReservations *tagReservations) count(tag string, delta int) {
+	if tagReservations == nil {
+		return
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	tagReservations.pending[tag] += delta
+	if tagReservations.pending[tag] <= 0 {
+		delete(tagReservations.pending, tag)
+	}
+}
+
+// admits returns true if a job with the tag can be handed to a job routine. A tag under its
+// reservation always can. Any other job takes a floating routine, which it can only have if the
+// routines left are enough to bring every other tag with pending jobs up to its reservation.
+func (tagReservations *tagReservations) admits(tag string, routines int) bool {
+	if tagReservations == nil {
+		return true
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	if tagReservations.running[tag] < tagReservations.reserved[tag] {
+		return true
+	}
+
+	var owed int
+	for reservedTag, reserved := range tagReservations.reserved {
+		if reservedTag == tag {
+			continue
+		}
+
+		short := reserved - tagReservations.running[reservedTag]
+		if pending := tagReservations.pending[reservedTag]; pending < short {
+			short = pending
+		}
+
+		if short > 0 {
+			owed += short
+		}
+	}
+
+	return routines-tagReservations.dispatched-1 >= owed
+}
+
+// dispatch records that the job has been handed to a job routine.
+func (tagReservations *tagReservations) dispatch(queueJob *queueJob) {
+	if tagReservations == nil {
+		return
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	queueJob.tagDispatched = true
+	tagReservations.running[queueJob.tag]++
+	tagReservations.dispatched++
+}
+
+// finish records that a job handed to a job routine is done with it and returns the wake ups
+// held for a reservation. A job that was not handed out by dispatch is ignored.
+func (tagReservations *tagReservations) finish(queueJob *queueJob) (held int) {
+	if tagReservations == nil {
+		return 0
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	if queueJob.tagDispatched == false {
+		return 0
+	}
+	queueJob.tagDispatched = false
+
+	tagReservations.running[queueJob.tag]--
+	if tagReservations.running[queueJob.tag] <= 0 {
+		delete(tagReservations.running, queueJob.tag)
+	}
+	tagReservations.dispatched--
+	tagReservations.finished++
+
+	held = tagReservations.held
+	tagReservations.held = 0
+
+	return held
+}
+
+// generation returns the count of finished jobs to hand to hold.
+func (tagReservations *tagReservations) generation() uint64 {
+	if tagReservations == nil {
+		return 0
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	return tagReservations.finished
+}
+
+// hold keeps a wake up until the next job finishes. It returns false if a job has finished since
+// seen was read, in which case the wake up is not kept.
+func (tagReservations *tagReservations) hold(seen uint64) bool {
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	if tagReservations.finished != seen {
+		return false
+	}
+
+	tagReservations.held++
+	return true
+}
+
+// stats returns a copy of the counters for every tag with a reservation, a running job or a
+// pending job.
+func (tagReservations *tagReservations) stats() map[string]TagStats {
+	if tagReservations == nil {
+		return nil
+	}
+
+	tagReservations.mutex.Lock()
+	defer tagReservations.mutex.Unlock()
+
+	tagStats := make(map[string]TagStats, len(tagReservations.reserved))
+	for tag, reserved := range tagReservations.reserved {
+		tagStats[tag] = TagStats{Reserved: reserved}
+	}
+
+	for tag, running := range tagReservations.running {
+		if tag == "" {
+			continue
+		}
+
+		stats := tagStats[tag]
+		stats.Running = running
+		tagStats[tag] = stats
+	}
+
+	for tag, pending := range tagReservations.pending {
+		if tag == "" {
+			continue
+		}
+
+		stats := tagStats[tag]
+		stats.Pending = pending
+		tagStats[tag] = stats
+	}
+
+	return tagStats
+}
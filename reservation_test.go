@@ -0,0 +1,132 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestTagReservationReclaim checks an idle reservation is lent to untagged jobs and reclaimed as
+// they finish once the tag has jobs pending: the freed routines go to the tag's jobs ahead of
+// untagged jobs queued before them, and no running job is interrupted.
+func TestTagReservationReclaim(t *testing.T) {
+	const routines = 4
+
+	jobPool := newTestPool(t, routines, 20, WithTagReservations(map[string]int{"emails": 2}))
+
+	var mutex sync.Mutex
+	var order []string
+	releases := make(map[string]chan struct{})
+	job := func(label string) funcJob {
+		release := make(chan struct{})
+		releases[label] = release
+
+		return funcJob(func(jobRoutine int) {
+			mutex.Lock()
+			order = append(order, label)
+			mutex.Unlock()
+
+			<-release
+		})
+	}
+	started := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), order...)
+	}
+	free := func(label string) {
+		if release, found := releases[label]; found == true {
+			close(release)
+			delete(releases, label)
+		}
+	}
+	defer func() {
+		for label := range releases {
+			free(label)
+		}
+	}()
+	tagStats := func() TagStats {
+		return jobPool.Stats().Tags["emails"]
+	}
+
+	// Borrow: with no emails pending every routine runs an untagged job.
+	floating := []string{"float-1", "float-2", "float-3", "float-4"}
+	for _, label := range floating {
+		if err := jobPool.QueueJob("test", job(label), false); err != nil {
+			t.Fatalf("QueueJob : %v", err)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "every routine to borrow", func() bool {
+		return len(started()) == routines
+	})
+
+	// Queue an untagged job ahead of the tag's jobs.
+	queued := []struct {
+		label string
+		tag   string
+	}{
+		{label: "float-5"},
+		{label: "email-1", tag: "emails"},
+		{label: "email-2", tag: "emails"},
+	}
+	for _, queued := range queued {
+		var options []JobOption
+		if queued.tag != "" {
+			options = append(options, WithTag(queued.tag))
+		}
+
+		if err := jobPool.QueueJob("test", job(queued.label), false, options...); err != nil {
+			t.Fatalf("QueueJob : %v", err)
+		}
+	}
+
+	if stats := tagStats(); stats.Pending != 2 || stats.Running != 0 || stats.Reserved != 2 {
+		t.Fatalf("Emails : %+v : Expected 2 pending, none running and 2 reserved", stats)
+	}
+
+	// Reclaim: each untagged job that finishes hands its routine to the tag.
+	free("float-1")
+	waitFor(t, 5*time.Second, "the first routine to be reclaimed", func() bool {
+		return len(started()) == routines+1
+	})
+
+	free("float-2")
+	waitFor(t, 5*time.Second, "the second routine to be reclaimed", func() bool {
+		return len(started()) == routines+2
+	})
+
+	if stats := tagStats(); stats.Pending != 0 || stats.Running != 2 {
+		t.Fatalf("Emails : %+v : Expected none pending and 2 running", stats)
+	}
+
+	// The tag holds its minimum, so the untagged job runs once another untagged job finishes.
+	time.Sleep(20 * time.Millisecond)
+	if len(started()) != routines+2 {
+		t.Fatalf("Started %v : An untagged job took a reserved routine", started())
+	}
+
+	free("float-3")
+	waitFor(t, 5*time.Second, "the untagged job to run", func() bool {
+		return len(started()) == routines+3
+	})
+
+	if got, want := started()[routines:], []string{"email-1", "email-2", "float-5"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("Started %v : Want %v", got, want)
+	}
+
+	for _, label := range []string{"float-4", "float-5", "email-1", "email-2"} {
+		free(label)
+	}
+
+	waitFor(t, 5*time.Second, "the tag's jobs to finish", func() bool {
+		return tagStats().Running == 0
+	})
+}
@@ -0,0 +1,366 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// DeadLetter describes a job that has failed for good.
+	DeadLetter struct {
+		Jobber   Jobber    // The job that failed.
+		Attempts int       // The number of times the job was started.
+		Reason   error     // Why the job failed.
+		Time     time.Time // When the job was given up on.
+	}
+
+	// JobMeta describes the attempt a job is running. It is passed to a ContextJobber through
+	// its context, see MetaFromContext.
+	JobMeta struct {
+		ID              uint64            // The sequence number the pool gave the job when it was first queued.
+		TraceID         string            // The trace ID given to the job with WithTraceID.
+		Attempt         int               // The attempt being run. The first run of a job is attempt 1.
+		Deliveries      int               // The number of times a backend has handed the job out, or zero for a job not fed from a backend.
+		FirstEnqueuedAt time.Time         // When the job was first placed in the queue.
+		LastError       error             // Why the previous attempt failed or nil on the first attempt.
+		Metadata        map[string]string // The key/value pairs attached with WithMetadata. It must not be modified.
+	}
+
+	// retryBudget is a token bucket that limits the number of retries across the pool.
+	retryBudget struct {
+		tokens     float64    // The number of retries available right now.
+		maxTokens  float64    // The number of retries the bucket holds when full.
+		refillRate float64    // The number of retries added per second.
+		refilled   time.Time  // When the tokens were last refilled.
+		mutex      sync.Mutex // Protects the bucket.
+	}
+
+	// Retryability decides what the retry machinery does with a job that failed.
+	Retryability int
+
+	// contextKey is the type of the keys the pool stores in a job's context.
+	contextKey int
+)
+
+//** CONSTANTS
+
+const (
+	// metaKey is the context key for the job's JobMeta.
+	metaKey contextKey = iota
+
+	// loggerKey is the context key for the job's Logger.
+	loggerKey
+
+	// continueKey is the context key for the continuer used by Continue.
+	continueKey
+)
+
+const (
+	// Retryable lets the job be retried within the configured limits.
+	Retryable Retryability = iota
+
+	// Permanent sends the job to the dead letter handler without retrying it.
+	Permanent
+
+	// Fatal sends the job to the dead letter handler and cancels the pending jobs of its group.
+	Fatal
+)
+
+//** VARIABLES
+
+var (
+	// ErrRetryBudgetExhausted is the reason given to the dead letter handler for a job that would
+	// have been retried if the pool's retry budget had not been used up.
+	ErrRetryBudgetExhausted = errors.New("Retry Budget Exhausted")
+)
+
+//** PUBLIC FUNCTIONS
+
+// DefaultErrorClassifier treats a cancelled or expired context as Permanent and every other
+// failure as Retryable.
+func DefaultErrorClassifier(err error) Retryability {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Permanent
+	}
+
+	return Retryable
+}
+
+// MetaFromContext returns the JobMeta of the job the context was passed to. It returns the zero
+// JobMeta for a context not created by the pool.
+func MetaFromContext(ctx context.Context) JobMeta {
+	jobMeta, _ := ctx.Value(metaKey).(JobMeta)
+	return jobMeta
+}
+
+// LoggerFromContext returns the logger tagged for the job running with the context. It returns
+// NopLogger if the context was not created by the pool.
+func LoggerFromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey).(Logger); ok == true {
+		return logger
+	}
+
+	return NopLogger
+}
+
+// AttemptFromContext returns the attempt number of the job the context was passed to. The
+// first run of a job is attempt 1. It returns 0 for a context not created by the pool.
+func AttemptFromContext(ctx context.Context) int {
+	return MetaFromContext(ctx).Attempt
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// jobMeta returns the JobMeta for the attempt the job is about to run.
+func (queueJob *queueJob) jobMeta() JobMeta {
+	return JobMeta{
+		ID:              queueJob.id,
+		TraceID:         queueJob.traceID,
+		Attempt:         queueJob.attempts,
+		Deliveries:      queueJob.deliveries,
+		FirstEnqueuedAt: queueJob.firstEnqueuedAt,
+		LastError:       queueJob.lastError,
+		Metadata:        queueJob.metadata,
+	}
+}
+
+// jobContext returns the context passed to a ContextJobber.
+func (jobPool *JobPool) jobContext(ctx context.Context, queueJob *queueJob, jobRoutine int) context.Context {
+	ctx = context.WithValue(ctx, metaKey, queueJob.jobMeta())
+	ctx = context.WithValue(ctx, continueKey, &continuer{jobPool: jobPool, parent: queueJob})
+	return context.WithValue(ctx, loggerKey, jobPool.jobLogger(queueJob, jobRoutine))
+}
+
+// retry places a job that panicked or returned an error back in its original queue after the
+// configured delay. It returns false if the job is not retried, in which case a job that used
+// up its retries is given to the dead letter handler.
+func (jobPool *JobPool) retry(queueJob *queueJob, reason error, panicked bool) bool {
+	queueJob.lastError = reason
+
+	maxAttempts, delay := jobPool.config.MaxRetries, jobPool.config.RetryDelay
+	if panicked == true {
+		maxAttempts, delay = jobPool.config.MaxRequeues, jobPool.config.RequeueDelay
+	}
+
+	switch jobPool.classify(reason) {
+	case Permanent:
+		jobPool.deadLetter(queueJob, reason)
+		return false
+
+	case Fatal:
+		jobPool.deadLetter(queueJob, reason)
+		if queueJob.group != "" {
+			jobPool.CancelGroup("jobRoutine", queueJob.group)
+		}
+		return false
+	}
+
+	if queueJob.attempts > maxAttempts {
+		if maxAttempts > 0 {
+			jobPool.deadLetter(queueJob, reason)
+		}
+
+		return false
+	}
+
+	if jobPool.retryBudget.take(jobPool.clock().Now()) == false {
+		jobPool.deadLetter(queueJob, fmt.Errorf("%w : %v", ErrRetryBudgetExhausted, reason))
+		return false
+	}
+
+	if jobPool.config.Backoff != nil {
+		delay = jobPool.nextDelay(queueJob, reason)
+	}
+
+	queueJob.front = jobPool.config.RequeueFront
+	queueJob.boosted = jobPool.config.RetryPriorityBoost > 0
+
+	// The job kept its slot in the queue. It is pending again before it can be queued.
+	atomic.AddInt32(&jobPool.gauges.reservedSlots, 1)
+	queueJob.setState(jobPending)
+
+	if delay <= 0 {
+		go jobPool.requeue(queueJob)
+		return true
+	}
+
+	jobPool.retryMutex.Lock()
+	defer jobPool.retryMutex.Unlock()
+
+	// The scheduled retries have been abandoned by Shutdown.
+	if jobPool.scheduledRetries == nil {
+		jobPool.releaseSlots(1)
+		queueJob.boosted = false
+		jobPool.deadLetter(queueJob, ErrPoolClosed)
+		return false
+	}
+
+	jobPool.scheduledRetries[queueJob] = jobPool.scheduler.schedule(delay, func() {
+		jobPool.retryMutex.Lock()
+		_, scheduled := jobPool.scheduledRetries[queueJob]
+		delete(jobPool.scheduledRetries, queueJob)
+		jobPool.retryMutex.Unlock()
+
+		if scheduled == true {
+			jobPool.requeue(queueJob)
+		}
+	})
+
+	return true
+}
+
+// nextDelay asks the backoff strategy how long to wait before retrying the job. A strategy that
+// panics or returns a negative delay retries the job immediately.
+func (jobPool *JobPool) nextDelay(queueJob *queueJob, reason error) (delay time.Duration) {
+	jobPool.callbackSafely("jobRoutine", "NextDelay", func() {
+		delay = jobPool.config.Backoff.NextDelay(queueJob.attempts, reason)
+	})
+
+	return delay
+}
+
+// cancelRetries stops every retry waiting on its delay and releases the jobs. It returns the
+// number of retries abandoned. Retries can't be scheduled once it has been called.
+func (jobPool *JobPool) cancelRetries() (n int) {
+	jobPool.retryMutex.Lock()
+	scheduledRetries := jobPool.scheduledRetries
+	jobPool.scheduledRetries = nil
+	jobPool.retryMutex.Unlock()
+
+	for queueJob, timerEntry := range scheduledRetries {
+		jobPool.scheduler.cancel(timerEntry)
+
+		jobPool.releaseSlots(1)
+		jobPool.finishGroupJob(queueJob)
+		jobPool.cancelJob("Shutdown", queueJob, jobPending, DispositionAbandoned)
+
+		if queueJob.child != nil {
+			queueJob.child.finished("Shutdown")
+		}
+
+		n++
+	}
+
+	return n
+}
+
+// classify runs the error classifier against the reason a job failed. A classifier that panics
+// leaves the job Retryable.
+func (jobPool *JobPool) classify(reason error) (retryability Retryability) {
+	classifier := jobPool.config.ErrorClassifier
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+
+	jobPool.callbackSafely("jobRoutine", "ErrorClassifier", func() {
+		retryability = classifier(reason)
+	})
+
+	return retryability
+}
+
+// newRetryBudget creates a full budget of retries at now that refills at retries per interval.
+// It returns nil, meaning unlimited, when retries is not positive.
+func newRetryBudget(retries int, interval time.Duration, now time.Time) *retryBudget {
+	if retries <= 0 || interval <= 0 {
+		return nil
+	}
+
+	return &retryBudget{
+		tokens:     float64(retries),
+		maxTokens:  float64(retries),
+		refillRate: float64(retries) / interval.Seconds(),
+		refilled:   now,
+	}
+}
+
+// take uses one retry from the budget. It returns false if the budget is used up.
+func (retryBudget *retryBudget) take(now time.Time) bool {
+	if retryBudget == nil {
+		return true
+	}
+
+	retryBudget.mutex.Lock()
+	defer retryBudget.mutex.Unlock()
+
+	retryBudget.refill(now)
+
+	if retryBudget.tokens < 1 {
+		return false
+	}
+
+	retryBudget.tokens--
+	return true
+}
+
+// level returns the number of retries available. It returns -1 for an unlimited budget.
+func (retryBudget *retryBudget) level(now time.Time) float64 {
+	if retryBudget == nil {
+		return -1
+	}
+
+	retryBudget.mutex.Lock()
+	defer retryBudget.mutex.Unlock()
+
+	retryBudget.refill(now)
+	return retryBudget.tokens
+}
+
+// refill adds the retries earned since the last refill. The mutex must be held.
+func (retryBudget *retryBudget) refill(now time.Time) {
+	elapsed := now.Sub(retryBudget.refilled).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	retryBudget.tokens += elapsed * retryBudget.refillRate
+	if retryBudget.tokens > retryBudget.maxTokens {
+		retryBudget.tokens = retryBudget.maxTokens
+	}
+
+	retryBudget.refilled = now
+}
+
+// requeue places the job back in the queue with the slot it kept. A job that can't be placed
+// back because the pool has shut down is given to the dead letter handler.
+func (jobPool *JobPool) requeue(queueJob *queueJob) {
+	defer jobPool.catchPanic(nil, "Requeue", "requeue")
+
+	if err := jobPool.injectJob("Requeue", queueJob, queueJob.front, injectHeld); err != nil {
+		jobPool.releaseSlots(1)
+		queueJob.dispose(jobPending, DispositionAbandoned)
+		queueJob.boosted = false
+		jobPool.deadLetter(queueJob, err)
+	}
+}
+
+// deadLetter hands a job that has failed for good to the dead letter handler.
+func (jobPool *JobPool) deadLetter(queueJob *queueJob, reason error) {
+	if jobPool.config.DeadLetter == nil {
+		jobPool.releaseJob("jobRoutine", queueJob)
+		return
+	}
+
+	deadLetter := DeadLetter{
+		Jobber:   queueJob.Jobber,
+		Attempts: queueJob.attempts,
+		Reason:   reason,
+		Time:     time.Now(),
+	}
+
+	jobPool.callbackSafely("jobRoutine", "DeadLetter", func() {
+		jobPool.config.DeadLetter(deadLetter)
+	})
+
+	jobPool.releaseJob("jobRoutine", queueJob)
+}
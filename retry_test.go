@@ -0,0 +1,187 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// metaJob is a job that records the JobMeta of every attempt and fails its first attempts.
+type metaJob struct {
+	failures int           // The number of attempts that fail.
+	metas    []JobMeta     // The JobMeta each attempt was given.
+	mutex    sync.Mutex    // Protects metas.
+	done     chan struct{} // Closed once an attempt succeeds.
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestJobMeta drives a failing job through its retries and checks the JobMeta it was given on
+// each attempt.
+func TestJobMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		failures int
+	}{
+		{"NoRetries", nil, 0},
+		{"Retried", []Option{WithRetry(4, time.Millisecond)}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 10, test.options...)
+
+			job := &metaJob{failures: test.failures, done: make(chan struct{})}
+
+			queued := time.Now()
+			if err := jobPool.QueueJob("test", job, false); err != nil {
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			select {
+			case <-job.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the job to succeed")
+			}
+
+			job.mutex.Lock()
+			defer job.mutex.Unlock()
+
+			if len(job.metas) != test.failures+1 {
+				t.Fatalf("Job ran %d times, want %d", len(job.metas), test.failures+1)
+			}
+
+			firstEnqueuedAt := job.metas[0].FirstEnqueuedAt
+			if firstEnqueuedAt.Before(queued) == true {
+				t.Fatalf("FirstEnqueuedAt[%v] is before the job was queued at %v", firstEnqueuedAt, queued)
+			}
+
+			for i, jobMeta := range job.metas {
+				if jobMeta.Attempt != i+1 {
+					t.Errorf("Attempt %d : Attempt[%d]", i+1, jobMeta.Attempt)
+				}
+
+				if jobMeta.FirstEnqueuedAt.Equal(firstEnqueuedAt) == false {
+					t.Errorf("Attempt %d : FirstEnqueuedAt[%v], want %v", i+1, jobMeta.FirstEnqueuedAt, firstEnqueuedAt)
+				}
+
+				switch {
+				case i == 0 && jobMeta.LastError != nil:
+					t.Errorf("Attempt 1 : LastError[%v], want nil", jobMeta.LastError)
+
+				case i > 0 && (jobMeta.LastError == nil || jobMeta.LastError.Error() != fmt.Sprintf("Attempt %d Failed", i)):
+					t.Errorf("Attempt %d : LastError[%v], want Attempt %d Failed", i+1, jobMeta.LastError, i)
+				}
+			}
+		})
+	}
+}
+
+// TestRetryBudget proves a job that would retry goes to the dead letter handler once the pool's
+// retry budget is used up, and retries again once the budget has refilled.
+func TestRetryBudget(t *testing.T) {
+	clock := newFakeClock()
+	deadLetters := make(chan DeadLetter, 10)
+
+	jobPool := newTestPool(t, 1, 10,
+		WithClock(clock),
+		WithRetry(5, 0),
+		WithRetryBudget(2, time.Minute),
+		WithDeadLetter(func(deadLetter DeadLetter) { deadLetters <- deadLetter }),
+	)
+
+	if level := jobPool.Stats().RetryBudget; level != 2 {
+		t.Fatalf("RetryBudget[%v], want 2", level)
+	}
+
+	// The job uses both retries and is given up on at its third failure.
+	exhausted := &flakyJob{failures: 10}
+	if err := jobPool.QueueJob("test", exhausted, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	select {
+	case deadLetter := <-deadLetters:
+		if errors.Is(deadLetter.Reason, ErrRetryBudgetExhausted) == false {
+			t.Fatalf("Reason[%v], want ErrRetryBudgetExhausted", deadLetter.Reason)
+		}
+
+		if deadLetter.Attempts != 3 {
+			t.Fatalf("Attempts[%d], want 3", deadLetter.Attempts)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the dead letter")
+	}
+
+	if level := jobPool.Stats().RetryBudget; level != 0 {
+		t.Fatalf("RetryBudget[%v], want 0", level)
+	}
+
+	// Half the interval earns back one retry, which the next job uses to succeed.
+	clock.Advance(30 * time.Second)
+
+	if level := jobPool.Stats().RetryBudget; level != 1 {
+		t.Fatalf("RetryBudget[%v], want 1", level)
+	}
+
+	recovered := &flakyJob{failures: 1}
+	if err := jobPool.QueueJob("test", recovered, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	waitFor(t, 5*time.Second, "the job to succeed", func() bool {
+		return atomic.LoadInt32(&recovered.attempts) == 2
+	})
+
+	if level := jobPool.Stats().RetryBudget; level != 0 {
+		t.Fatalf("RetryBudget[%v], want 0", level)
+	}
+
+	// The budget never refills past its size.
+	clock.Advance(5 * time.Minute)
+
+	if level := jobPool.Stats().RetryBudget; level != 2 {
+		t.Fatalf("RetryBudget[%v], want 2", level)
+	}
+
+	select {
+	case deadLetter := <-deadLetters:
+		t.Fatalf("Unexpected dead letter : %v", deadLetter.Reason)
+	default:
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// RunJob runs the job with a background context.
+func (metaJob *metaJob) RunJob(jobRoutine int) {
+	metaJob.RunJobContext(context.Background(), jobRoutine)
+}
+
+// RunJobContext records the attempt's JobMeta and fails the first attempts.
+func (metaJob *metaJob) RunJobContext(ctx context.Context, jobRoutine int) error {
+	jobMeta := MetaFromContext(ctx)
+
+	metaJob.mutex.Lock()
+	metaJob.metas = append(metaJob.metas, jobMeta)
+	metaJob.mutex.Unlock()
+
+	if jobMeta.Attempt <= metaJob.failures {
+		return fmt.Errorf("Attempt %d Failed", jobMeta.Attempt)
+	}
+
+	close(metaJob.done)
+	return nil
+}
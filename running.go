@@ -0,0 +1,117 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"context"
+	"sync/atomic"
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// CancelRunning cancels the context passed to the running ContextJobber with the ID and returns
+// true if one was found. The job decides when to return. Jobs that don't take a context can't
+// be cancelled once they are running.
+func (jobPool *JobPool) CancelRunning(id uint64) bool {
+	jobPool.runningMutex.Lock()
+	cancel, found := jobPool.runningJobs[id]
+	jobPool.runningMutex.Unlock()
+
+	if found == false {
+		return false
+	}
+
+	cancel()
+	return true
+}
+
+// Cancel removes the job with the ID from the queues, or from the job routine that prefetched it,
+// or, if it is already running, cancels its context. It returns true if the job was found in either state. A job that is between the
+// queue and a job routine at the moment of the call is not found.
+func (jobPool *JobPool) Cancel(id uint64) (found bool, err error) {
+	defer jobPool.catchPanic(&err, "Cancel", "Cancel")
+
+	err = jobPool.runInQueue(func() {
+		queueJob := jobPool.findQueuedJob(id)
+		switch {
+		case queueJob != nil:
+			if jobPool.removeQueuedJob(queueJob, DispositionCancelled) == false {
+				return
+			}
+
+		default:
+			// A job prefetched by a job routine has left the queues but hasn't started.
+			if queueJob = jobPool.takePrefetched(id); queueJob == nil {
+				return
+			}
+
+			go jobPool.releaseJob("Queue", queueJob)
+
+			if queueJob.child != nil {
+				go queueJob.child.dropped("Queue", queueJob)
+			}
+		}
+
+		jobPool.finishGroupJob(queueJob)
+
+		found = true
+	})
+
+	if found == true || err != nil {
+		return found, err
+	}
+
+	return jobPool.CancelRunning(id), nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// findQueuedJob returns the pending job with the ID or nil. It is only called by the queue
+// routine.
+func (jobPool *JobPool) findQueuedJob(id uint64) *queueJob {
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		for _, queue := range []*list.List{tenantQueue.priorityJobQueue, tenantQueue.normalJobQueue} {
+			for element := queue.Front(); element != nil; element = element.Next() {
+				if queueJob := element.Value.(*queueJob); queueJob.id == id {
+					return queueJob
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// cancelRunningJobs cancels the context of every running ContextJobber.
+func (jobPool *JobPool) cancelRunningJobs() {
+	jobPool.runningMutex.Lock()
+	defer jobPool.runningMutex.Unlock()
+
+	for _, cancel := range jobPool.runningJobs {
+		cancel()
+	}
+}
+
+// runningContext returns a context for the job that CancelRunning can cancel and a function
+// that must be called once the job returns.
+func (jobPool *JobPool) runningContext(ctx context.Context, queueJob *queueJob) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	jobPool.runningMutex.Lock()
+	jobPool.runningJobs[queueJob.id] = cancel
+	if atomic.LoadInt32(&jobPool.cancelOnStart) == 1 {
+		cancel()
+	}
+	jobPool.runningMutex.Unlock()
+
+	return ctx, func() {
+		jobPool.runningMutex.Lock()
+		delete(jobPool.runningJobs, queueJob.id)
+		jobPool.runningMutex.Unlock()
+
+		cancel()
+	}
+}
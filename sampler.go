@@ -0,0 +1,230 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// DepthSample is the queue depth and the number of running jobs at one tick of the depth
+	// sampler.
+	DepthSample struct {
+		At         time.Time `json:"at"`          // When the sample was taken.
+		QueuedJobs int32     `json:"queued_jobs"` // The number of pending jobs in queue.
+		ActiveJobs int32     `json:"active_jobs"` // The number of job routines running a job.
+	}
+
+	// DepthSummary combines the depth samples taken over a window.
+	DepthSummary struct {
+		Samples   int     `json:"samples"`    // The number of samples in the window.
+		MinQueued int32   `json:"min_queued"` // The lowest queue depth sampled.
+		MaxQueued int32   `json:"max_queued"` // The highest queue depth sampled.
+		AvgQueued float64 `json:"avg_queued"` // The average queue depth.
+		MinActive int32   `json:"min_active"` // The fewest running jobs sampled.
+		MaxActive int32   `json:"max_active"` // The most running jobs sampled.
+		AvgActive float64 `json:"avg_active"` // The average number of running jobs.
+	}
+
+	// depthSample is a sample as it is held in the ring.
+	depthSample struct {
+		at         int64 // When the sample was taken in unix nanoseconds.
+		queuedJobs int32 // The number of pending jobs in queue.
+		activeJobs int32 // The number of job routines running a job.
+	}
+
+	// depthSampler holds the samples of the last DepthRetention in a ring that is overwritten
+	// from the oldest sample once it is full.
+	depthSampler struct {
+		samples []depthSample // The ring of samples.
+		next    int           // The slot the next sample is written to.
+		full    bool          // Set once the ring has wrapped.
+		mutex   sync.Mutex    // Protects the ring.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// defaultDepthInterval is how often the depth sampler takes a sample when no interval is set.
+	defaultDepthInterval = 100 * time.Millisecond
+
+	// defaultDepthRetention is how long the depth samples are kept when no retention is set.
+	defaultDepthRetention = time.Hour
+)
+
+//** PUBLIC FUNCTIONS
+
+// WithDepthSampler starts a routine that samples the queue depth and the number of running jobs
+// every interval and keeps the samples of the last retention in a fixed ring, so a burst that
+// drains between two scrapes of QueuedJobs still shows in DepthSummary and DepthSamples. A zero
+// interval samples every 100ms and a zero retention keeps an hour. Each tick loads the two
+// gauges and writes one slot of the ring, and the routine stops when the pool is shut down.
+func WithDepthSampler(interval time.Duration, retention time.Duration) Option {
+	return func(config *Config) {
+		config.DepthSampling = true
+		config.DepthInterval = interval
+		config.DepthRetention = retention
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// DepthSamples returns the samples taken over the last window, oldest first, for plotting. A
+// window of zero returns every sample kept. It returns nil without a depth sampler.
+func (jobPool *JobPool) DepthSamples(window time.Duration) []DepthSample {
+	if jobPool.depthSampler == nil {
+		return nil
+	}
+
+	var depthSamples []DepthSample
+	jobPool.depthSampler.each(window, func(depthSample depthSample) {
+		depthSamples = append(depthSamples, DepthSample{
+			At:         time.Unix(0, depthSample.at),
+			QueuedJobs: depthSample.queuedJobs,
+			ActiveJobs: depthSample.activeJobs,
+		})
+	})
+
+	return depthSamples
+}
+
+// DepthSummary returns the lowest, highest and average queue depth and running jobs sampled over
+// the last window, such as the peak depth over the last hour. A window of zero covers every
+// sample kept. It returns the zero DepthSummary without a depth sampler.
+func (jobPool *JobPool) DepthSummary(window time.Duration) DepthSummary {
+	var depthSummary DepthSummary
+	if jobPool.depthSampler == nil {
+		return depthSummary
+	}
+
+	var queued, active int64
+	jobPool.depthSampler.each(window, func(depthSample depthSample) {
+		if depthSummary.Samples == 0 || depthSample.queuedJobs < depthSummary.MinQueued {
+			depthSummary.MinQueued = depthSample.queuedJobs
+		}
+		if depthSample.queuedJobs > depthSummary.MaxQueued {
+			depthSummary.MaxQueued = depthSample.queuedJobs
+		}
+		if depthSummary.Samples == 0 || depthSample.activeJobs < depthSummary.MinActive {
+			depthSummary.MinActive = depthSample.activeJobs
+		}
+		if depthSample.activeJobs > depthSummary.MaxActive {
+			depthSummary.MaxActive = depthSample.activeJobs
+		}
+
+		queued += int64(depthSample.queuedJobs)
+		active += int64(depthSample.activeJobs)
+		depthSummary.Samples++
+	})
+
+	if depthSummary.Samples > 0 {
+		depthSummary.AvgQueued = float64(queued) / float64(depthSummary.Samples)
+		depthSummary.AvgActive = float64(active) / float64(depthSummary.Samples)
+	}
+
+	return depthSummary
+}
+
+//** PRIVATE FUNCTIONS
+
+// newDepthSampler creates the ring for the samples or returns nil if the pool has no sampler.
+func newDepthSampler(config Config) *depthSampler {
+	if config.DepthSampling == false {
+		return nil
+	}
+
+	size := int(depthRetention(config) / depthInterval(config))
+	if size < 1 {
+		size = 1
+	}
+
+	return &depthSampler{
+		samples: make([]depthSample, size),
+	}
+}
+
+// depthInterval returns how often the depth sampler takes a sample.
+func depthInterval(config Config) time.Duration {
+	if config.DepthInterval <= 0 {
+		return defaultDepthInterval
+	}
+
+	return config.DepthInterval
+}
+
+// depthRetention returns how long the depth samples are kept.
+func depthRetention(config Config) time.Duration {
+	if config.DepthRetention <= 0 {
+		return defaultDepthRetention
+	}
+
+	return config.DepthRetention
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// depthSamplerRoutine samples the queue depth every DepthInterval until the pool is shut down.
+func (jobPool *JobPool) depthSamplerRoutine() {
+	ticker := time.NewTicker(depthInterval(jobPool.config))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-jobPool.shutdownStatsChannel:
+			jobPool.writeLog(LogDebug, "Sampler", "depthSamplerRoutine", "Going Down")
+			return
+
+		case now := <-ticker.C:
+			jobPool.depthSampler.add(depthSample{
+				at:         now.UnixNano(),
+				queuedJobs: atomic.LoadInt32(&jobPool.gauges.queuedJobs),
+				activeJobs: atomic.LoadInt32(&jobPool.gauges.activeRoutines),
+			})
+		}
+	}
+}
+
+// add writes the sample over the oldest one once the ring is full.
+func (depthSampler *depthSampler) add(sample depthSample) {
+	depthSampler.mutex.Lock()
+	defer depthSampler.mutex.Unlock()
+
+	depthSampler.samples[depthSampler.next] = sample
+	depthSampler.next++
+
+	if depthSampler.next == len(depthSampler.samples) {
+		depthSampler.next = 0
+		depthSampler.full = true
+	}
+}
+
+// each calls fn for the samples taken over the last window, oldest first. A window of zero
+// covers every sample in the ring.
+func (depthSampler *depthSampler) each(window time.Duration, fn func(depthSample depthSample)) {
+	depthSampler.mutex.Lock()
+	defer depthSampler.mutex.Unlock()
+
+	var since int64
+	if window > 0 {
+		since = time.Now().Add(-window).UnixNano()
+	}
+
+	start, count := 0, depthSampler.next
+	if depthSampler.full == true {
+		start, count = depthSampler.next, len(depthSampler.samples)
+	}
+
+	for i := 0; i < count; i++ {
+		sample := depthSampler.samples[(start+i)%len(depthSampler.samples)]
+		if sample.at >= since {
+			fn(sample)
+		}
+	}
+}
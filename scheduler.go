@@ -0,0 +1,223 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// timerEntry is an action waiting in the scheduler for its time to fire.
+	timerEntry struct {
+		fireAt   time.Time // When the action is run.
+		sequence uint64    // Orders entries that fire at the same time.
+		action   func()    // The work to run once the entry fires.
+		index    int       // The entry's place in the heap or -1 once it has left the heap.
+	}
+
+	// timerHeap is a min heap of entries ordered by fire time.
+	timerHeap []*timerEntry
+
+	// scheduler runs every timed action of the pool from a single routine.
+	scheduler struct {
+		clock    Clock         // Tells the time and creates the timer for the next entry.
+		entries  timerHeap     // The entries waiting to fire.
+		sequence uint64        // The sequence given to the last entry.
+		stopped  bool          // If the scheduler no longer accepts entries.
+		wake     chan struct{} // Tells the routine the head of the heap has changed.
+		done     chan struct{} // Closed to stop the routine.
+		mutex    sync.Mutex    // Protects the entries.
+	}
+)
+
+//** PRIVATE FUNCTIONS
+
+// newScheduler creates a scheduler and starts its routine.
+func newScheduler(clock Clock) *scheduler {
+	scheduler := scheduler{
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	go scheduler.run()
+
+	return &scheduler
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// Len implements heap.Interface.
+func (timerHeap timerHeap) Len() int {
+	return len(timerHeap)
+}
+
+// Less implements heap.Interface.
+func (timerHeap timerHeap) Less(i int, j int) bool {
+	if timerHeap[i].fireAt.Equal(timerHeap[j].fireAt) {
+		return timerHeap[i].sequence < timerHeap[j].sequence
+	}
+
+	return timerHeap[i].fireAt.Before(timerHeap[j].fireAt)
+}
+
+// Swap implements heap.Interface.
+func (timerHeap timerHeap) Swap(i int, j int) {
+	timerHeap[i], timerHeap[j] = timerHeap[j], timerHeap[i]
+	timerHeap[i].index = i
+	timerHeap[j].index = j
+}
+
+// Push implements heap.Interface.
+func (timerHeap *timerHeap) Push(x interface{}) {
+	timerEntry := x.(*timerEntry)
+	timerEntry.index = len(*timerHeap)
+	*timerHeap = append(*timerHeap, timerEntry)
+}
+
+// Pop implements heap.Interface.
+func (timerHeap *timerHeap) Pop() interface{} {
+	old := *timerHeap
+	timerEntry := old[len(old)-1]
+	old[len(old)-1] = nil
+	timerEntry.index = -1
+	*timerHeap = old[:len(old)-1]
+
+	return timerEntry
+}
+
+// schedule registers an action to run after the delay. It returns nil if the scheduler has
+// been stopped.
+func (scheduler *scheduler) schedule(delay time.Duration, action func()) *timerEntry {
+	return scheduler.scheduleAt(scheduler.clock.Now().Add(delay), action)
+}
+
+// scheduleAt registers an action to run at the given time. It returns nil if the scheduler has
+// been stopped.
+func (scheduler *scheduler) scheduleAt(fireAt time.Time, action func()) *timerEntry {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	if scheduler.stopped == true {
+		return nil
+	}
+
+	scheduler.sequence++
+	timerEntry := timerEntry{
+		fireAt:   fireAt,
+		sequence: scheduler.sequence,
+		action:   action,
+	}
+
+	heap.Push(&scheduler.entries, &timerEntry)
+
+	// The routine only needs to wake up if the new entry is next to fire.
+	if timerEntry.index == 0 {
+		scheduler.signal()
+	}
+
+	return &timerEntry
+}
+
+// cancel removes an entry before it fires. It returns false if the entry has already fired or
+// been cancelled.
+func (scheduler *scheduler) cancel(timerEntry *timerEntry) bool {
+	if timerEntry == nil {
+		return false
+	}
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	if timerEntry.index < 0 {
+		return false
+	}
+
+	head := timerEntry.index == 0
+	heap.Remove(&scheduler.entries, timerEntry.index)
+
+	if head == true {
+		scheduler.signal()
+	}
+
+	return true
+}
+
+// stop shuts the routine down and returns the entries that never fired.
+func (scheduler *scheduler) stop() []*timerEntry {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	if scheduler.stopped == true {
+		return nil
+	}
+
+	scheduler.stopped = true
+	close(scheduler.done)
+
+	entries := make([]*timerEntry, 0, len(scheduler.entries))
+	for len(scheduler.entries) > 0 {
+		entries = append(entries, heap.Pop(&scheduler.entries).(*timerEntry))
+	}
+
+	return entries
+}
+
+// signal wakes the routine without blocking. The mutex must be held.
+func (scheduler *scheduler) signal() {
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run fires the entries as their time arrives. The wait is always measured from the clock to
+// the head's fire time so rescheduling never drifts.
+func (scheduler *scheduler) run() {
+	for {
+		scheduler.mutex.Lock()
+
+		var wait time.Duration = -1
+		for len(scheduler.entries) > 0 {
+			wait = scheduler.entries[0].fireAt.Sub(scheduler.clock.Now())
+			if wait > 0 {
+				break
+			}
+
+			// Actions are run on their own routine so a slow action can't hold up the timers.
+			timerEntry := heap.Pop(&scheduler.entries).(*timerEntry)
+			go timerEntry.action()
+			wait = -1
+		}
+
+		scheduler.mutex.Unlock()
+
+		if wait < 0 {
+			select {
+			case <-scheduler.wake:
+			case <-scheduler.done:
+				return
+			}
+
+			continue
+		}
+
+		timer := scheduler.clock.NewTimer(wait)
+
+		select {
+		case <-timer.C():
+		case <-scheduler.wake:
+		case <-scheduler.done:
+			timer.Stop()
+			return
+		}
+
+		timer.Stop()
+	}
+}
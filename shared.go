@@ -0,0 +1,110 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+//** CONSTANTS
+
+const (
+	// sharedDepthInterval is how often a pool sharing a backend measures its depth.
+	sharedDepthInterval = 100 * time.Millisecond
+)
+
+//** VARIABLES
+
+var (
+	// ErrBackendUnavailable is returned by QueueJob when the shared backend refused the job.
+	ErrBackendUnavailable = errors.New("Backend Unavailable")
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// startSharing starts the routine feeding the jobs of the shared backend into the pool.
+func (jobPool *JobPool) startSharing() {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobPool.sharedCancel = cancel
+
+	go func() {
+		err := jobPool.feedBackend(ctx, jobPool.config.SharedBackend, true)
+		jobPool.writeLogf(LogDebug, "Backend", "startSharing", "Going Down : %v", err)
+	}()
+}
+
+// stopSharing stops the feeding of jobs from the shared backend.
+func (jobPool *JobPool) stopSharing() {
+	if jobPool.sharedCancel != nil {
+		jobPool.sharedCancel()
+	}
+}
+
+// putShared serializes a job and puts it in the shared backend. A job its submitter offers again
+// is not released while the backend is failing.
+func (jobPool *JobPool) putShared(goRoutine string, jober Jobber, priority bool, offeredAgain bool) error {
+	data, err := MarshalJob(jober, priority)
+	if err != nil {
+		err = fmt.Errorf("%w : %w", ErrInvalidJob, err)
+		return jobPool.reject(goRoutine, jober, err)
+	}
+
+	if err := jobPool.config.SharedBackend.Put(context.Background(), data, priority); err != nil {
+		err = fmt.Errorf("%w : %v", ErrBackendUnavailable, err)
+		return jobPool.rejectOffer(goRoutine, jober, offeredAgain, err)
+	}
+
+	atomic.AddInt64(&jobPool.sharedDepth, 1)
+	return nil
+}
+
+// sharedRoom reports if the pool can take a job from the shared backend. A job is only taken
+// while fewer jobs are pending in the pool than it has job routines, leaving the rest of the
+// jobs to the other pools.
+func (jobPool *JobPool) sharedRoom() bool {
+	return atomic.LoadInt32(&jobPool.gauges.queuedJobs) < atomic.LoadInt32(&jobPool.routines)
+}
+
+// measureShared records the number of jobs waiting in the shared backend. A failed measure
+// keeps the last one.
+func (jobPool *JobPool) measureShared(ctx context.Context, backend Backend) {
+	depth, err := backend.Len(ctx)
+	if err != nil {
+		jobPool.writeLogf(LogDebug, "Backend", "measureShared", "Len Failed : %s", err)
+		return
+	}
+
+	atomic.StoreInt64(&jobPool.sharedDepth, depth)
+}
+
+// markShared records if the shared backend is failing.
+func (jobPool *JobPool) markShared(failing bool) {
+	var unavailable int32
+	if failing == true {
+		unavailable = 1
+	}
+
+	atomic.StoreInt32(&jobPool.backendUnavailable, unavailable)
+}
+
+// sharedQueuedJobs returns the number of jobs waiting in the shared backend as last measured.
+func (jobPool *JobPool) sharedQueuedJobs() int32 {
+	depth := atomic.LoadInt64(&jobPool.sharedDepth)
+
+	switch {
+	case depth < 0:
+		return 0
+
+	case depth > math.MaxInt32:
+		return math.MaxInt32
+	}
+
+	return int32(depth)
+}
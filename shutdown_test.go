@@ -0,0 +1,114 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// errorLogger is a Logger that keeps the error messages written to it.
+type errorLogger struct {
+	messages []string   // The error messages written.
+	mutex    sync.Mutex // Protects messages.
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestShutdownUnderLoad shuts the pool down while producers queue jobs through every submit
+// path and job routines are busy and waiting for jobs, and proves the teardown raises no panic
+// and logs no error.
+func TestShutdownUnderLoad(t *testing.T) {
+	rounds := 10
+	if testing.Short() == true {
+		rounds = 3
+	}
+
+	for round := 0; round < rounds; round++ {
+		var panics int32
+		logger := &errorLogger{}
+
+		jobPool := newTestPool(t, 8, 64,
+			WithLogger(logger),
+			WithLogLevel(LogError, false),
+			WithControlBuffers(8, 4),
+			WithPanicHandler(func(panicInfo PanicInfo) {
+				atomic.AddInt32(&panics, 1)
+			}),
+		)
+
+		job := funcJob(func(jobRoutine int) {
+			time.Sleep(100 * time.Microsecond)
+		})
+
+		var wg sync.WaitGroup
+		for producer := 0; producer < 8; producer++ {
+			wg.Add(1)
+			go func(producer int) {
+				defer wg.Done()
+
+				for i := 0; ; i++ {
+					var err error
+					switch i % 3 {
+					case 0:
+						err = jobPool.QueueJob("test", job, i%5 == 0)
+
+					case 1:
+						err = jobPool.QueueJobAsync("test", job, false).Wait()
+
+					case 2:
+						err = jobPool.QueueJobContext(context.Background(), "test", job, false)
+					}
+
+					if errors.Is(err, ErrPoolClosed) == true {
+						return
+					}
+				}
+			}(producer)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		if err := jobPool.Shutdown("test"); err != nil {
+			t.Fatalf("Round %d : Shutdown : %s", round, err)
+		}
+
+		wg.Wait()
+
+		if got := atomic.LoadInt32(&panics); got != 0 {
+			t.Fatalf("Round %d : %d panics were recovered during the shutdown", round, got)
+		}
+
+		logger.mutex.Lock()
+		messages := logger.messages
+		logger.mutex.Unlock()
+
+		if len(messages) != 0 {
+			t.Fatalf("Round %d : Errors were logged during the shutdown :\n%s", round, strings.Join(messages, "\n"))
+		}
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Printf keeps the message if it is an error.
+func (errorLogger *errorLogger) Printf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if strings.HasPrefix(message, LogError.String()) == false {
+		return
+	}
+
+	errorLogger.mutex.Lock()
+	errorLogger.messages = append(errorLogger.messages, message)
+	errorLogger.mutex.Unlock()
+}
@@ -0,0 +1,277 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// Stats is a snapshot of the state of the pool.
+	Stats struct {
+		TakenAt            time.Time               `json:"taken_at"`             // When the snapshot was taken.
+		ResetAt            time.Time               `json:"reset_at"`             // When the cumulative counters were last reset or the pool was created.
+		EnqueuedJobs       int64                   `json:"enqueued_jobs"`        // The number of jobs placed in the queues, counting requeues.
+		DequeuedJobs       int64                   `json:"dequeued_jobs"`        // The number of jobs taken from the queues by the job routines.
+		QueuedJobs         int32                   `json:"queued_jobs"`          // The number of pending jobs in queue, or in the shared backend when there is one.
+		LocalPendingJobs   int32                   `json:"local_pending_jobs"`   // The number of jobs pending in the pool's own queues.
+		BackendPendingJobs int64                   `json:"backend_pending_jobs"` // The number of jobs waiting in the shared backend as last measured.
+		BackendUnavailable bool                    `json:"backend_unavailable"`  // If the shared backend is failing and the pool has paused feeding from it.
+		ParkedJobs         int32                   `json:"parked_jobs"`          // The number of pending jobs parked because their type is quarantined.
+		WaitingSubmitters  int32                   `json:"waiting_submitters"`   // The number of QueueJobWait calls waiting for a slot.
+		ActiveRoutines     int32                   `json:"active_routines"`      // The number of routines active.
+		ConcurrencyLimit   int                     `json:"concurrency_limit"`    // The limit set with SetConcurrencyLimit. Zero is no limit.
+		Executing          int                     `json:"executing"`            // The number of job routines running or about to run a job under the concurrency limit.
+		Ramping            bool                    `json:"ramping"`              // If a ramp up is raising the concurrency limit.
+		CompletedJobs      int32                   `json:"completed_jobs"`       // The number of jobs that have run to completion.
+		QueueCapacity      int32                   `json:"queue_capacity"`       // The max number of jobs we can store in the queue.
+		AboveHighWatermark bool                    `json:"above_high_watermark"` // If the queue has crossed the high watermark and not yet fallen under the low watermark.
+		QueueLatencyAlerts int64                   `json:"queue_latency_alerts"` // The number of jobs that waited longer than MaxAcceptableQueueLatency.
+		Evictions          int64                   `json:"evictions"`            // The number of jobs evicted by the DropOldest overflow policy.
+		CallerRuns         int64                   `json:"caller_runs"`          // The number of jobs run by their submitter under the CallerRuns overflow policy.
+		InlineRuns         int64                   `json:"inline_runs"`          // The number of jobs run by their submitter with WithInlineIfIdle.
+		PriorityOverrides  int64                   `json:"priority_overrides"`   // The number of jobs the PriorityFunc queued with a different priority than asked for.
+		Continuations      int64                   `json:"continuations"`        // The number of jobs queued by running jobs with Continue.
+		Violations         int64                   `json:"violations"`           // The number of jobs found unaccounted for or accounted for twice by the integrity checks.
+		RetryBudget        float64                 `json:"retry_budget"`         // The number of retries available or -1 when retries are unlimited.
+		PendingBytes       int64                   `json:"pending_bytes"`        // The total SizeBytes of the pending jobs.
+		MemoryGuarded      bool                    `json:"memory_guarded"`       // If the heap is over the memory guard's soft limit and normal jobs are refused.
+		HeapBytes          int64                   `json:"heap_bytes,omitempty"` // The heap size the memory guard last sampled.
+		WaitTimes          WaitStats               `json:"wait_times"`           // The time jobs waited in queue before they were started over the last minute.
+		QueueLoop          QueueLoopStats          `json:"queue_loop"`           // The time the queue routine took to handle its messages over the last minute and its backlog.
+		JobTypes           map[string]JobTypeStats `json:"job_types"`            // The counters for each type of job.
+		Tags               map[string]TagStats     `json:"tags,omitempty"`       // The counters for each tag when the pool has tag reservations.
+		History            []JobRecord             `json:"history,omitempty"`    // The most recent jobs when the pool keeps a history.
+	}
+
+	// Rates holds the number of jobs per second between two Stats snapshots.
+	Rates struct {
+		Interval  time.Duration `json:"interval"`  // The time the rates are measured over.
+		Enqueued  float64       `json:"enqueued"`  // The jobs placed in the queues per second.
+		Dequeued  float64       `json:"dequeued"`  // The jobs taken from the queues per second.
+		Completed float64       `json:"completed"` // The jobs run to completion per second.
+	}
+
+	// JobTypeStats holds the counters for a single type of job.
+	JobTypeStats struct {
+		Processed     int64         `json:"processed"`      // The number of jobs that have been run.
+		Errors        int64         `json:"errors"`         // The number of jobs that returned an error.
+		Panics        int64         `json:"panics"`         // The number of jobs that panicked.
+		Rejected      int64         `json:"rejected"`       // The number of jobs that could not be admitted.
+		TotalDuration time.Duration `json:"total_duration"` // The time spent running jobs.
+		MaxDuration   time.Duration `json:"max_duration"`   // The longest time spent running a single job.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// OtherJobTypes is the key in Stats.JobTypes that combines the counters for the job types
+	// seen after the MaxJobTypes limit was reached.
+	OtherJobTypes = "other"
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Stats returns a snapshot of the state of the pool.
+func (jobPool *JobPool) Stats() Stats {
+	concurrencyLimit, executing := jobPool.concurrency.counts()
+
+	return Stats{
+		TakenAt:            time.Now(),
+		ResetAt:            time.Unix(0, atomic.LoadInt64(&jobPool.resetAt)),
+		EnqueuedJobs:       atomic.LoadInt64(&jobPool.enqueuedJobs),
+		DequeuedJobs:       atomic.LoadInt64(&jobPool.dequeuedJobs),
+		QueuedJobs:         jobPool.QueuedJobs(),
+		LocalPendingJobs:   atomic.LoadInt32(&jobPool.gauges.queuedJobs),
+		BackendPendingJobs: atomic.LoadInt64(&jobPool.sharedDepth),
+		BackendUnavailable: atomic.LoadInt32(&jobPool.backendUnavailable) == 1,
+		ParkedJobs:         atomic.LoadInt32(&jobPool.parkedJobs),
+		WaitingSubmitters:  atomic.LoadInt32(&jobPool.waitingSubmitters),
+		ActiveRoutines:     atomic.LoadInt32(&jobPool.gauges.activeRoutines),
+		ConcurrencyLimit:   concurrencyLimit,
+		Executing:          executing,
+		Ramping:            jobPool.Ramping(),
+		CompletedJobs:      atomic.LoadInt32(&jobPool.completedJobs) - atomic.LoadInt32(&jobPool.completedBaseline),
+		QueueCapacity:      jobPool.config.QueueCapacity,
+		AboveHighWatermark: atomic.LoadInt32(&jobPool.aboveHighWatermark) == 1,
+		QueueLatencyAlerts: atomic.LoadInt64(&jobPool.queueLatencyAlerts),
+		Evictions:          atomic.LoadInt64(&jobPool.evictions),
+		CallerRuns:         atomic.LoadInt64(&jobPool.callerRuns),
+		InlineRuns:         atomic.LoadInt64(&jobPool.inlineRuns),
+		PriorityOverrides:  atomic.LoadInt64(&jobPool.priorityOverrides),
+		Continuations:      atomic.LoadInt64(&jobPool.continuations),
+		Violations:         atomic.LoadInt64(&jobPool.integrityViolations),
+		RetryBudget:        jobPool.retryBudget.level(jobPool.clock().Now()),
+		PendingBytes:       atomic.LoadInt64(&jobPool.gauges.pendingBytes),
+		MemoryGuarded:      jobPool.MemoryGuarded(),
+		HeapBytes:          atomic.LoadInt64(&jobPool.heapBytes),
+		WaitTimes:          jobPool.waitStats(),
+		QueueLoop:          jobPool.queueLoopStats(),
+		JobTypes:           jobPool.jobTypeStats(),
+		Tags:               jobPool.tags.stats(),
+		History:            jobPool.History(),
+	}
+}
+
+// ResetStats zeroes the cumulative counters: the enqueued, dequeued and completed jobs, the
+// queue latency alerts, the evictions, the caller and inline runs, the priority overrides, the continuations, the counters for each job
+// type and the jobs processed and busy time of each job routine. Gauges such as the queue depth and the
+// windowed wait times and utilization are not affected. Jobs finishing during the reset are counted either before or after it.
+// The completed jobs are counted from a baseline so the ShutdownReport still counts every job.
+func (jobPool *JobPool) ResetStats() {
+	atomic.StoreInt64(&jobPool.enqueuedJobs, 0)
+	atomic.StoreInt64(&jobPool.dequeuedJobs, 0)
+	atomic.StoreInt32(&jobPool.completedBaseline, atomic.LoadInt32(&jobPool.completedJobs))
+	atomic.StoreInt64(&jobPool.queueLatencyAlerts, 0)
+	atomic.StoreInt64(&jobPool.evictions, 0)
+	atomic.StoreInt64(&jobPool.callerRuns, 0)
+	atomic.StoreInt64(&jobPool.inlineRuns, 0)
+	atomic.StoreInt64(&jobPool.priorityOverrides, 0)
+	atomic.StoreInt64(&jobPool.continuations, 0)
+
+	jobPool.jobTypeMutex.Lock()
+	jobPool.jobTypes = make(map[string]*JobTypeStats)
+	jobPool.jobTypeMutex.Unlock()
+
+	for _, workerState := range jobPool.workerStates() {
+		workerState.reset()
+	}
+
+	atomic.StoreInt64(&jobPool.resetAt, time.Now().UnixNano())
+}
+
+// RatesSince returns the rates between an earlier snapshot and this one. When the counters were
+// reset in between the rates are measured from the reset.
+func (stats Stats) RatesSince(last Stats) Rates {
+	enqueued, dequeued, completed := stats.EnqueuedJobs-last.EnqueuedJobs, stats.DequeuedJobs-last.DequeuedJobs, int64(stats.CompletedJobs-last.CompletedJobs)
+	since := last.TakenAt
+
+	if stats.ResetAt.After(last.TakenAt) == true {
+		enqueued, dequeued, completed = stats.EnqueuedJobs, stats.DequeuedJobs, int64(stats.CompletedJobs)
+		since = stats.ResetAt
+	}
+
+	rates := Rates{
+		Interval: stats.TakenAt.Sub(since),
+	}
+
+	if seconds := rates.Interval.Seconds(); seconds > 0 {
+		rates.Enqueued = float64(enqueued) / seconds
+		rates.Dequeued = float64(dequeued) / seconds
+		rates.Completed = float64(completed) / seconds
+	}
+
+	return rates
+}
+
+// StatusJSON returns the Stats snapshot encoded as JSON.
+func (jobPool *JobPool) StatusJSON() ([]byte, error) {
+	return json.Marshal(jobPool.Stats())
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// statsRoutine emits a Stats snapshot every StatsInterval until the pool is shut down.
+func (jobPool *JobPool) statsRoutine() {
+	ticker := time.NewTicker(jobPool.config.StatsInterval)
+	defer ticker.Stop()
+
+	var lastCompleted int32
+
+	for {
+		select {
+		case <-jobPool.shutdownStatsChannel:
+			jobPool.writeLog(LogDebug, "Stats", "statsRoutine", "Going Down")
+			return
+
+		case <-ticker.C:
+			stats := jobPool.Stats()
+
+			idle := stats.QueuedJobs == 0 && stats.LocalPendingJobs == 0 && stats.ActiveRoutines == 0 && stats.CompletedJobs == lastCompleted
+			lastCompleted = stats.CompletedJobs
+
+			if idle == true && jobPool.config.StatsSkipIdle == true {
+				break
+			}
+
+			jobPool.reportStats(stats)
+		}
+	}
+}
+
+// reportStats hands a snapshot to the stats reporter within a safe context.
+func (jobPool *JobPool) reportStats(stats Stats) {
+	defer jobPool.catchPanic(nil, "Stats", "reportStats")
+
+	if jobPool.config.StatsReporter == nil {
+		jobPool.writeLogf(LogInfo, "Stats", "reportStats", "QW[%d] AR[%d] Completed[%d] Capacity[%d]", stats.QueuedJobs, stats.ActiveRoutines, stats.CompletedJobs, stats.QueueCapacity)
+		return
+	}
+
+	jobPool.config.StatsReporter(stats)
+}
+
+// record updates the counters for a job that has been run.
+func (jobTypeStats *JobTypeStats) record(duration time.Duration, err error, panicked bool) {
+	jobTypeStats.Processed++
+	jobTypeStats.TotalDuration += duration
+
+	if duration > jobTypeStats.MaxDuration {
+		jobTypeStats.MaxDuration = duration
+	}
+
+	switch {
+	case panicked == true:
+		jobTypeStats.Panics++
+
+	case err != nil:
+		jobTypeStats.Errors++
+	}
+}
+
+// recordJobType updates the counters for the job's type, named by Namer when the job implements
+// it. Once the number of types reaches MaxJobTypes the counters for new types are combined
+// under OtherJobTypes.
+func (jobPool *JobPool) recordJobType(jobType string, update func(jobTypeStats *JobTypeStats)) {
+	maxJobTypes := jobPool.config.MaxJobTypes
+	if maxJobTypes <= 0 {
+		maxJobTypes = defaultMaxJobTypes
+	}
+
+	jobPool.jobTypeMutex.Lock()
+	defer jobPool.jobTypeMutex.Unlock()
+
+	jobTypeStats, found := jobPool.jobTypes[jobType]
+	if found == false {
+		if len(jobPool.jobTypes) >= maxJobTypes {
+			jobType = OtherJobTypes
+		}
+
+		if jobTypeStats, found = jobPool.jobTypes[jobType]; found == false {
+			jobTypeStats = &JobTypeStats{}
+			jobPool.jobTypes[jobType] = jobTypeStats
+		}
+	}
+
+	update(jobTypeStats)
+}
+
+// jobTypeStats returns a copy of the counters for each type of job.
+func (jobPool *JobPool) jobTypeStats() map[string]JobTypeStats {
+	jobPool.jobTypeMutex.Lock()
+	defer jobPool.jobTypeMutex.Unlock()
+
+	jobTypes := make(map[string]JobTypeStats, len(jobPool.jobTypes))
+	for jobType, jobTypeStats := range jobPool.jobTypes {
+		jobTypes[jobType] = *jobTypeStats
+	}
+
+	return jobTypes
+}
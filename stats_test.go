@@ -0,0 +1,68 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestResetStatsThenShutdown proves ResetStats zeroes the completed jobs in Stats without
+// changing the jobs the ShutdownReport counts as completed during the shutdown.
+func TestResetStatsThenShutdown(t *testing.T) {
+	jobPool := New(1, 10, WithLogger(NopLogger), WithoutManager())
+
+	for i := 0; i < 3; i++ {
+		if err := jobPool.QueueJob("test", funcJob(func(jobRoutine int) {}), false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "the jobs to complete", func() bool {
+		return jobPool.Stats().CompletedJobs == 3
+	})
+
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	blocker, started := blockingJob(release)
+	if err := jobPool.QueueJob("test", blocker, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+	<-started
+
+	reported := make(chan ShutdownReport, 1)
+	go func() {
+		report, _ := jobPool.ShutdownWithReport("test")
+		reported <- report
+	}()
+
+	// Reset while the shutdown waits for the running job.
+	waitFor(t, 5*time.Second, "the shutdown to start", func() bool {
+		return atomic.LoadInt32(&jobPool.shutdown) == 1
+	})
+	jobPool.ResetStats()
+
+	if completed := jobPool.Stats().CompletedJobs; completed != 0 {
+		t.Fatalf("CompletedJobs after ResetStats is %d, want 0", completed)
+	}
+
+	releaseOnce()
+
+	select {
+	case report := <-reported:
+		if report.CompletedJobs != 1 {
+			t.Fatalf("ShutdownReport.CompletedJobs is %d, want 1", report.CompletedJobs)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+}
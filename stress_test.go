@@ -0,0 +1,92 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestStressProducersAndShutdown has many producers queue and cancel jobs that read the pool's
+// counters while another routine shuts the pool down, and checks every accepted job ran, was
+// cancelled or was abandoned by the shutdown exactly once. Run it with -race.
+func TestStressProducersAndShutdown(t *testing.T) {
+	rounds, producers, jobsPerProducer, routines := 10, 16, 2000, 8
+	if testing.Short() == true {
+		rounds, producers, jobsPerProducer, routines = 3, 4, 200, 2
+	}
+
+	for round := 0; round < rounds; round++ {
+		stressRound(t, producers, jobsPerProducer, routines)
+	}
+}
+
+// stressRound runs one round of the stress test.
+func stressRound(t *testing.T, producers int, jobsPerProducer int, routines int) {
+	jobPool := New(routines, 64, WithLogger(NopLogger), WithoutManager(), WithIntegrityChecks(nil))
+
+	var accepted, ran, cancelled int64
+	job := funcJob(func(jobRoutine int) {
+		atomic.AddInt64(&ran, 1)
+		jobPool.QueuedJobs()
+		jobPool.ActiveRoutines()
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for producer := 0; producer < producers; producer++ {
+		go func(producer int) {
+			defer wg.Done()
+
+			for i := 0; i < jobsPerProducer; i++ {
+				err := jobPool.QueueJob("stress", job, i%4 == 0)
+				switch {
+				case err == nil:
+					atomic.AddInt64(&accepted, 1)
+				case errors.Is(err, ErrPoolClosed) == true:
+					return
+				case errors.Is(err, ErrPoolAtCapacity) == true:
+					// Offer the same job again once the job routines have made room.
+					i--
+					runtime.Gosched()
+					continue
+				default:
+					t.Errorf("Producer %d : QueueJob : %v", producer, err)
+					return
+				}
+
+				if i%100 == 99 && producer%2 == 0 {
+					n, _ := jobPool.CancelPending("stress", false, false, nil)
+					atomic.AddInt64(&cancelled, int64(n))
+				}
+			}
+		}(producer)
+	}
+
+	// Shut down once about half the jobs have been accepted.
+	half := int64(producers * jobsPerProducer / 2)
+	for atomic.LoadInt64(&accepted) < half {
+		runtime.Gosched()
+	}
+
+	report, err := jobPool.ShutdownWithReport("stress")
+	if err != nil {
+		t.Fatalf("ShutdownWithReport : %v", err)
+	}
+
+	wg.Wait()
+
+	abandoned := int64(report.AbandonedPriorityJobs + report.AbandonedNormalJobs + report.AbandonedRetries)
+	if got, want := atomic.LoadInt64(&ran)+atomic.LoadInt64(&cancelled)+abandoned, atomic.LoadInt64(&accepted); got != want {
+		t.Fatalf("Ran[%d] Cancelled[%d] Abandoned[%d] : Accounted for %d of %d accepted jobs", ran, cancelled, abandoned, got, want)
+	}
+
+	if violations := jobPool.Stats().Violations; violations != 0 {
+		t.Fatalf("Integrity violations : %d", violations)
+	}
+}
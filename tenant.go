@@ -0,0 +1,309 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+)
+
+//** TYPES
+
+type (
+	// tenantQueue holds the priority and normal queues for a single tenant. Without fair queuing
+	// every job is placed in the default tenant queue.
+	tenantQueue struct {
+		tenant           string        // The tenant the queues belong to.
+		priorityJobQueue *list.List    // The priority job queue.
+		normalJobQueue   *list.List    // The normal job queue.
+		served           int           // The number of jobs dequeued during the tenant's current turn.
+		turn             *list.Element // The tenant's place in the round robin or nil if it has no jobs.
+	}
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueuedJobsByTenant returns the number of pending jobs for each tenant that has jobs in queue.
+// Jobs queued without a tenant are not included.
+func (jobPool *JobPool) QueuedJobsByTenant() map[string]int32 {
+	jobPool.tenantMutex.Lock()
+	defer jobPool.tenantMutex.Unlock()
+
+	tenantJobs := make(map[string]int32, len(jobPool.tenantJobs))
+	for tenant, queuedJobs := range jobPool.tenantJobs {
+		tenantJobs[tenant] = queuedJobs
+	}
+
+	return tenantJobs
+}
+
+//** PRIVATE FUNCTIONS
+
+// newTenantQueue creates the queues for a tenant.
+func newTenantQueue(tenant string) *tenantQueue {
+	return &tenantQueue{
+		tenant:           tenant,
+		priorityJobQueue: list.New(),
+		normalJobQueue:   list.New(),
+	}
+}
+
+// nextReleased returns the element from this one on that holds a job released can hand out,
+// passing over boosted retries when skipBoosted is set.
+func nextReleased(element *list.Element, released func(queueJob *queueJob) bool, skipBoosted bool) *list.Element {
+	for ; element != nil; element = element.Next() {
+		queueJob := element.Value.(*queueJob)
+		if (skipBoosted == false || queueJob.boosted == false) && released(queueJob) == true {
+			return element
+		}
+	}
+
+	return nil
+}
+
+// releaseAll hands out every job.
+func releaseAll(queueJob *queueJob) bool {
+	return true
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// len returns the number of jobs in both of the tenant's queues.
+func (tenantQueue *tenantQueue) len() int {
+	return tenantQueue.priorityJobQueue.Len() + tenantQueue.normalJobQueue.Len()
+}
+
+// push places a job on either the normal or priority queue. Boosted retries are placed on the
+// priority queue.
+func (tenantQueue *tenantQueue) push(queueJob *queueJob) {
+	if queueJob.front == true {
+		tenantQueue.pushFront(queueJob)
+		return
+	}
+
+	queueJob.queue = tenantQueue.jobQueue(queueJob)
+	queueJob.element = queueJob.queue.PushBack(queueJob)
+}
+
+// pushFront places a job at the front of either the normal or priority queue.
+func (tenantQueue *tenantQueue) pushFront(queueJob *queueJob) {
+	queueJob.queue = tenantQueue.jobQueue(queueJob)
+	queueJob.element = queueJob.queue.PushFront(queueJob)
+}
+
+// jobQueue returns the queue the job belongs in and records the tenant queue on the job.
+func (tenantQueue *tenantQueue) jobQueue(queueJob *queueJob) *list.List {
+	queueJob.tenantQueue = tenantQueue
+
+	if queueJob.priority == true || queueJob.boosted == true {
+		return tenantQueue.priorityJobQueue
+	}
+
+	return tenantQueue.normalJobQueue
+}
+
+// pop removes the next job, taking priority jobs first. When boosted retries are not allowed they
+// are passed over in favor of any other job. It returns nil if both queues are empty.
+func (tenantQueue *tenantQueue) pop(allowBoosted bool) *queueJob {
+	return tenantQueue.popReleased(allowBoosted, releaseAll)
+}
+
+// popReleased removes the next job like pop, passing over the jobs released holds back for a
+// barrier or a tag reservation. It returns nil if every job is held back.
+func (tenantQueue *tenantQueue) popReleased(allowBoosted bool, released func(queueJob *queueJob) bool) *queueJob {
+	nextJob := nextReleased(tenantQueue.priorityJobQueue.Front(), released, allowBoosted == false)
+
+	if nextJob == nil {
+		nextJob = nextReleased(tenantQueue.normalJobQueue.Front(), released, false)
+	}
+
+	if nextJob == nil {
+		// Only boosted retries are left so run them anyway.
+		nextJob = nextReleased(tenantQueue.priorityJobQueue.Front(), released, false)
+	}
+
+	if nextJob == nil {
+		return nil
+	}
+
+	// Cast the list element back to a Job.
+	queueJob := nextJob.Value.(*queueJob)
+	queueJob.queue.Remove(nextJob)
+	queueJob.queue = nil
+	queueJob.element = nil
+
+	return queueJob
+}
+
+// tenantQueue returns the queues a job for the tenant is placed in.
+func (jobPool *JobPool) tenantQueue(tenant string) *tenantQueue {
+	if jobPool.config.FairQueuing == false || tenant == "" {
+		return jobPool.defaultQueue
+	}
+
+	tenantQueue, found := jobPool.tenantQueues[tenant]
+	if found == false {
+		tenantQueue = newTenantQueue(tenant)
+		jobPool.tenantQueues[tenant] = tenantQueue
+	}
+
+	return tenantQueue
+}
+
+// tenantQueuesSnapshot returns every tenant queue, starting with the default queue.
+func (jobPool *JobPool) tenantQueuesSnapshot() []*tenantQueue {
+	tenantQueues := make([]*tenantQueue, 0, len(jobPool.tenantQueues)+1)
+	tenantQueues = append(tenantQueues, jobPool.defaultQueue)
+
+	for _, tenantQueue := range jobPool.tenantQueues {
+		tenantQueues = append(tenantQueues, tenantQueue)
+	}
+
+	return tenantQueues
+}
+
+// pushTenantJob places a job in its tenant's queues and gives the tenant a turn if it needs one.
+func (jobPool *JobPool) pushTenantJob(queueJob *queueJob) {
+	tenantQueue := jobPool.tenantQueue(queueJob.tenant)
+	tenantQueue.push(queueJob)
+
+	if tenantQueue.turn == nil {
+		tenantQueue.turn = jobPool.activeTenants.PushBack(tenantQueue)
+	}
+
+	jobPool.countTenantJob(queueJob.tenant, 1)
+	jobPool.tags.count(queueJob.tag, 1)
+}
+
+// popTenantJob removes the next job. With fair queuing the tenants take turns, each tenant
+// receiving as many consecutive dequeues as its weight before the next tenant is served. A
+// tenant whose jobs are all held back by a barrier or a tag reservation is passed over. It
+// returns nil if no job can be handed out, with tagHeld set if a job was held back for a tag
+// reservation.
+func (jobPool *JobPool) popTenantJob() (*queueJob, bool) {
+	heldAfter := jobPool.heldAfter()
+	routines := jobPool.liveRoutines()
+
+	var tagHeld bool
+	released := func(queueJob *queueJob) bool {
+		if queueJob.id > heldAfter {
+			return false
+		}
+
+		if jobPool.tags.admits(queueJob.tag, routines) == false {
+			tagHeld = true
+			return false
+		}
+
+		return true
+	}
+
+	for turn := jobPool.activeTenants.Front(); turn != nil; turn = turn.Next() {
+		tenantQueue := turn.Value.(*tenantQueue)
+		priorityJobs := tenantQueue.priorityJobQueue.Len()
+
+		queueJob := tenantQueue.popReleased(jobPool.boostCredit >= 1, released)
+		if queueJob == nil {
+			continue
+		}
+
+		jobPool.takeTurn(turn, tenantQueue, priorityJobs, queueJob)
+		return queueJob, false
+	}
+
+	return nil, tagHeld
+}
+
+// takeTurn accounts for a job taken from the tenant's queues and moves the round robin on once
+// the tenant has had its turn.
+func (jobPool *JobPool) takeTurn(turn *list.Element, tenantQueue *tenantQueue, priorityJobs int, queueJob *queueJob) {
+	tenantQueue.served++
+
+	jobPool.countPriorityJobs(tenantQueue.priorityJobQueue.Len() - priorityJobs)
+
+	jobPool.chargeBoost(queueJob)
+
+	switch {
+	case tenantQueue.len() == 0:
+		jobPool.retireTenant(tenantQueue)
+
+	case tenantQueue.served >= jobPool.tenantWeight(tenantQueue.tenant):
+		tenantQueue.served = 0
+		jobPool.activeTenants.MoveToBack(turn)
+	}
+
+	jobPool.unqueueJob(queueJob)
+}
+
+// chargeBoost earns the share of dequeues that may go to boosted retries and spends it when a
+// boosted retry is dequeued. It is only called by the queue routine.
+func (jobPool *JobPool) chargeBoost(queueJob *queueJob) {
+	if jobPool.config.RetryPriorityBoost <= 0 || queueJob == nil {
+		return
+	}
+
+	jobPool.boostCredit += jobPool.config.RetryPriorityBoost
+	if jobPool.boostCredit > 1 {
+		jobPool.boostCredit = 1
+	}
+
+	if queueJob.boosted == true {
+		queueJob.boosted = false
+
+		jobPool.boostCredit--
+		if jobPool.boostCredit < 0 {
+			jobPool.boostCredit = 0
+		}
+	}
+}
+
+// retireTenant removes a tenant with no pending jobs from the round robin.
+func (jobPool *JobPool) retireTenant(tenantQueue *tenantQueue) {
+	if tenantQueue.turn != nil {
+		jobPool.activeTenants.Remove(tenantQueue.turn)
+		tenantQueue.turn = nil
+	}
+
+	tenantQueue.served = 0
+
+	if tenantQueue != jobPool.defaultQueue {
+		delete(jobPool.tenantQueues, tenantQueue.tenant)
+	}
+}
+
+// tenantWeight returns the number of consecutive dequeues a tenant receives per turn.
+func (jobPool *JobPool) tenantWeight(tenant string) int {
+	if weight := jobPool.config.TenantWeights[tenant]; weight > 0 {
+		return weight
+	}
+
+	return 1
+}
+
+// tenantAtCapacity returns true if the tenant holds the maximum number of pending jobs.
+func (jobPool *JobPool) tenantAtCapacity(tenant string) bool {
+	if tenant == "" || jobPool.config.TenantCapacity <= 0 {
+		return false
+	}
+
+	jobPool.tenantMutex.Lock()
+	defer jobPool.tenantMutex.Unlock()
+
+	return jobPool.tenantJobs[tenant] >= jobPool.config.TenantCapacity
+}
+
+// countTenantJob adjusts the number of pending jobs held by a tenant.
+func (jobPool *JobPool) countTenantJob(tenant string, delta int32) {
+	if tenant == "" {
+		return
+	}
+
+	jobPool.tenantMutex.Lock()
+	defer jobPool.tenantMutex.Unlock()
+
+	jobPool.tenantJobs[tenant] += delta
+	if jobPool.tenantJobs[tenant] <= 0 {
+		delete(jobPool.tenantJobs, tenant)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+//** INTERFACES
+
+// ThreadAffinity is implemented by jobs that must run on a single OS thread, such as jobs that
+// call into C libraries with thread local state. When LockOSThread returns true the job routine
+// locks its OS thread before the job runs and unlocks it once the job returns.
+type ThreadAffinity interface {
+	LockOSThread() bool
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// lockThread returns true if the job routine must lock its OS thread while the job runs.
+func (jobPool *JobPool) lockThread(queueJob *queueJob) bool {
+	if jobPool.config.LockOSThread == true {
+		return true
+	}
+
+	threadAffinity, ok := queueJob.Jobber.(ThreadAffinity)
+	return ok == true && threadAffinity.LockOSThread() == true
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// affinityJob is a job that asks for a locked thread and records whether it kept its thread
+// while it yielded.
+type affinityJob struct {
+	moved int32         // Set to 1 if the job changed threads.
+	done  chan struct{} // Closed once the job has run.
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestPinnedWorkers proves each pinned job routine runs every job on the same OS thread, and a
+// retired pinned routine unlocks its thread so the runtime keeps it rather than destroying it.
+func TestPinnedWorkers(t *testing.T) {
+	jobPool := newTestPool(t, 2, 100, WithLockOSThread(true))
+
+	var mutex sync.Mutex
+	threads := make(map[int]map[int]bool)
+
+	var ran int32
+	for i := 0; i < 50; i++ {
+		job := funcJob(func(jobRoutine int) {
+			runtime.Gosched()
+
+			mutex.Lock()
+			if threads[jobRoutine] == nil {
+				threads[jobRoutine] = make(map[int]bool)
+			}
+			threads[jobRoutine][syscall.Gettid()] = true
+			mutex.Unlock()
+
+			atomic.AddInt32(&ran, 1)
+		})
+
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+	}
+
+	waitFor(t, 5*time.Second, "the jobs to run", func() bool {
+		return atomic.LoadInt32(&ran) == 50
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var retiredThread int
+	for jobRoutine, tids := range threads {
+		if len(tids) != 1 {
+			t.Fatalf("Routine %d ran jobs on %d threads, want 1", jobRoutine, len(tids))
+		}
+
+		if jobRoutine == 0 {
+			for tid := range tids {
+				retiredThread = tid
+			}
+		}
+	}
+
+	if retiredThread == 0 {
+		t.Skip("Routine 0 ran no jobs")
+	}
+
+	if err := jobPool.RetireWorker(0); err != nil {
+		t.Fatalf("RetireWorker : %s", err)
+	}
+
+	jobPool.workerMutex.RLock()
+	workerState := jobPool.workers[0]
+	jobPool.workerMutex.RUnlock()
+
+	waitFor(t, 5*time.Second, "the retired routine to exit", func() bool {
+		return atomic.LoadUint64(&workerState.goroutineID) == 0
+	})
+
+	// A routine exiting with its thread locked takes the thread down with it.
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := os.Stat(fmt.Sprintf("/proc/self/task/%d", retiredThread)); err != nil {
+		t.Fatalf("The retired routine's thread is gone, it exited locked : %s", err)
+	}
+}
+
+// TestThreadAffinity proves a job implementing ThreadAffinity keeps its OS thread while it runs
+// on a pool that doesn't lock threads.
+func TestThreadAffinity(t *testing.T) {
+	jobPool := newTestPool(t, 1, 10)
+
+	job := &affinityJob{done: make(chan struct{})}
+	if err := jobPool.QueueJob("test", job, false); err != nil {
+		t.Fatalf("QueueJob : %s", err)
+	}
+
+	select {
+	case <-job.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the job to run")
+	}
+
+	if atomic.LoadInt32(&job.moved) == 1 {
+		t.Fatal("The job changed threads while it ran")
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// LockOSThread asks for a locked thread.
+func (affinityJob *affinityJob) LockOSThread() bool {
+	return true
+}
+
+// RunJob yields and sleeps, checking the job stays on its thread.
+func (affinityJob *affinityJob) RunJob(jobRoutine int) {
+	defer close(affinityJob.done)
+
+	tid := syscall.Gettid()
+	for i := 0; i < 20; i++ {
+		runtime.Gosched()
+		time.Sleep(time.Millisecond)
+
+		if syscall.Gettid() != tid {
+			atomic.StoreInt32(&affinityJob.moved, 1)
+			return
+		}
+	}
+}
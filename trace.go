@@ -0,0 +1,34 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"context"
+	"runtime/trace"
+	"time"
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// tracing returns true if jobs are to be wrapped in runtime/trace tasks right now.
+func (jobPool *JobPool) tracing() bool {
+	return jobPool.config.RuntimeTrace == true && trace.IsEnabled() == true
+}
+
+// traceJob starts a runtime/trace task named by the job and logs how long the job waited in
+// queue. It returns the task's context, derived from ctx, and the function that ends the task.
+// Without a trace being collected it returns ctx and does nothing else.
+func (jobPool *JobPool) traceJob(ctx context.Context, queueJob *queueJob, started time.Time) (context.Context, func()) {
+	if jobPool.tracing() == false {
+		return ctx, func() {}
+	}
+
+	ctx, task := trace.NewTask(ctx, queueJob.name)
+
+	// The wait started on the queue routine so it is logged rather than marked as a region.
+	trace.Logf(ctx, "queue-wait", "%v", started.Sub(queueJob.enqueuedAt))
+
+	return ctx, task.End
+}
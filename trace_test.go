@@ -0,0 +1,91 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"bytes"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+//** TYPES
+
+// namedJob is a job that reports its name and signals once it has run.
+type namedJob struct {
+	name string        // The name the job reports.
+	done chan struct{} // Closed once the job has run.
+}
+
+//** PUBLIC FUNCTIONS
+
+// TestRuntimeTrace runs a job under trace.Start and proves a task named by the job, with its
+// queue wait and execute region, is only emitted with WithRuntimeTrace.
+func TestRuntimeTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		traced  bool
+	}{
+		{"WithRuntimeTrace", []Option{WithRuntimeTrace()}, true},
+		{"WithoutRuntimeTrace", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobPool := newTestPool(t, 1, 10, test.options...)
+
+			var buffer bytes.Buffer
+			if err := trace.Start(&buffer); err != nil {
+				t.Skipf("A trace is already being collected : %s", err)
+			}
+
+			job := namedJob{name: "traced-" + test.name, done: make(chan struct{})}
+			if err := jobPool.QueueJob("test", job, false); err != nil {
+				trace.Stop()
+				t.Fatalf("QueueJob : %s", err)
+			}
+
+			select {
+			case <-job.done:
+			case <-time.After(5 * time.Second):
+				trace.Stop()
+				t.Fatal("Timed out waiting for the job to run")
+			}
+
+			// Let the task and region end before the trace is stopped.
+			waitFor(t, 5*time.Second, "the job routine to go idle", func() bool {
+				return jobPool.ActiveRoutines() == 0
+			})
+			trace.Stop()
+
+			for _, want := range []string{job.name, "queue-wait", "execute"} {
+				if found := traceHasString(buffer.Bytes(), want); found != test.traced {
+					t.Errorf("Trace contains %q is %v, want %v", want, found, test.traced)
+				}
+			}
+		})
+	}
+}
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// Name returns the job's name.
+func (namedJob namedJob) Name() string {
+	return namedJob.name
+}
+
+// RunJob signals the job has run.
+func (namedJob namedJob) RunJob(jobRoutine int) {
+	close(namedJob.done)
+}
+
+//** PRIVATE FUNCTIONS
+
+// traceHasString returns true if the trace's string table holds the string. Strings are written
+// after their length, which tells a task or region name apart from a function name holding it.
+func traceHasString(data []byte, s string) bool {
+	return bytes.Contains(data, append([]byte{byte(len(s))}, s...))
+}
@@ -0,0 +1,222 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//** VARIABLES
+
+var (
+	// ErrSamePool is returned by TransferPending when the destination is the source.
+	ErrSamePool = errors.New("Can't Transfer To The Same Pool")
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// TransferPending hands the pending jobs to another pool, for a deployment that drains one pool
+// and replaces it with another. The source stops admitting jobs first, as a drain does, then
+// every pending job, the jobs in the intake buffer included, is moved to dst with priority jobs
+// ahead of normal jobs and each queue kept in order. Each job is admitted by dst as a new
+// submission would be, subject to its capacity, byte budget, tenant quotas and overflow policy,
+// and a job dst refuses is cancelled and passed to dst's rejection handler. CallerRuns is
+// treated as RejectNew since the job has no submitter to run it. It returns the number of jobs
+// moved and the reasons the others were refused joined together.
+//
+// The jobs running, prefetched, waiting for a retry or parked by a quarantine stay with the
+// source, which still has to be shut down once they are done. A JobHandle held for a moved job
+// follows the job in dst and reports its state and outcome from there, though Sequence keeps
+// the number the source gave the job. A moved job leaves its group and its child pool in the
+// source.
+func (jobPool *JobPool) TransferPending(dst *JobPool) (moved int, err error) {
+	defer jobPool.catchPanic(&err, "TransferPending", "TransferPending")
+
+	if dst == jobPool {
+		return 0, ErrSamePool
+	}
+
+	if atomic.LoadInt32(&dst.shutdown) == 1 {
+		return 0, ErrPoolClosed
+	}
+
+	// Stop accepting new jobs.
+	if atomic.CompareAndSwapInt32(&jobPool.shutdown, 0, 1) == false {
+		return 0, ErrPoolClosed
+	}
+
+	var queueJobs []*queueJob
+	if err = jobPool.runInQueue(func() {
+		queueJobs = jobPool.queueRoutineTransferOut()
+	}); err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	err = dst.runInQueue(func() {
+		for _, queueJob := range queueJobs {
+			if refused := dst.queueRoutineTransferIn(queueJob); refused != nil {
+				errs = append(errs, refused)
+				continue
+			}
+
+			moved++
+		}
+	})
+
+	// The destination shut down before it took the jobs so they are lost.
+	if err != nil {
+		for _, queueJob := range queueJobs {
+			jobPool.cancelJob("TransferPending", queueJob, jobPending, DispositionAbandoned)
+		}
+
+		return 0, err
+	}
+
+	jobPool.writeLogf(LogInfo, "TransferPending", "TransferPending", "Transferred : Pool[%s] Moved[%d] Refused[%d]", dst.config.Name, moved, len(errs))
+	return moved, errors.Join(errs...)
+}
+
+//** PRIVATE FUNCTIONS
+
+// appendQueue appends the jobs in the queue to queueJobs in order.
+func appendQueue(queueJobs []*queueJob, queue *list.List) []*queueJob {
+	for element := queue.Front(); element != nil; element = element.Next() {
+		queueJobs = append(queueJobs, element.Value.(*queueJob))
+	}
+
+	return queueJobs
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// queueRoutineTransferOut takes every pending job out of the pool and releases what it held,
+// leaving the job pending. The priority jobs come first and each queue keeps its order. It is
+// only called by the queue routine.
+func (jobPool *JobPool) queueRoutineTransferOut() []*queueJob {
+	jobPool.queueRoutineDrainIntake()
+
+	var priorityJobs []*queueJob
+	var normalJobs []*queueJob
+	for _, tenantQueue := range jobPool.tenantQueuesSnapshot() {
+		priorityJobs = appendQueue(priorityJobs, tenantQueue.priorityJobQueue)
+		normalJobs = appendQueue(normalJobs, tenantQueue.normalJobQueue)
+	}
+
+	queueJobs := append(priorityJobs, normalJobs...)
+	for _, queueJob := range queueJobs {
+		jobPool.detachJob(queueJob)
+	}
+
+	return queueJobs
+}
+
+// queueRoutineDrainIntake admits the jobs waiting in the intake buffer so they are transferred
+// with the rest. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineDrainIntake() {
+	for {
+		select {
+		case queueJob := <-jobPool.intakeChannel:
+			jobPool.countBacklog(-1)
+			jobPool.queueRoutineAdmit(queueJob)
+
+		default:
+			return
+		}
+	}
+}
+
+// detachJob takes a pending job out of its queue and releases what it held in the pool, its
+// slot, key, group, child pool and place among the outstanding jobs, without changing its state.
+// It is only called by the queue routine.
+func (jobPool *JobPool) detachJob(queueJob *queueJob) {
+	if queueJob.queue == queueJob.tenantQueue.priorityJobQueue {
+		jobPool.countPriorityJobs(-1)
+	}
+
+	queueJob.queue.Remove(queueJob.element)
+	queueJob.queue = nil
+	queueJob.element = nil
+
+	if queueJob.tenantQueue.len() == 0 {
+		jobPool.retireTenant(queueJob.tenantQueue)
+	}
+
+	jobPool.unqueueJob(queueJob)
+	jobPool.releaseUnique(queueJob, false)
+	jobPool.finishGroupJob(queueJob)
+
+	if queueJob.outstanding != nil {
+		queueJob.outstanding.finish(queueJob)
+		queueJob.outstanding = nil
+	}
+
+	if queueJob.child != nil {
+		go queueJob.child.dropped("Queue", queueJob)
+		queueJob.child = nil
+	}
+
+	// Decrement the queued work count.
+	atomic.AddInt32(&jobPool.gauges.queuedJobs, -1)
+	jobPool.releaseSlots(1)
+	jobPool.checkWatermarks()
+	jobPool.checkQueueEmpty()
+
+	jobPool.wakeUps.retract(1)
+}
+
+// queueRoutineTransferIn admits a job transferred from another pool. The job is checked like a
+// new submission and starts over with an ID from this pool. It returns the reason the job was
+// refused, in which case the job is cancelled. It is only called by the queue routine.
+func (jobPool *JobPool) queueRoutineTransferIn(queueJob *queueJob) error {
+	queueJob.id = 0
+	queueJob.firstEnqueuedAt = time.Time{}
+	queueJob.tenantQueue = nil
+	queueJob.front = false
+	queueJob.boosted = false
+
+	refused := jobPool.checkJob(queueJob.Jobber)
+	if refused == nil {
+		refused = jobPool.checkGang(queueJob.gangSize)
+	}
+	if refused == nil {
+		refused = jobPool.checkUnique(queueJob)
+	}
+
+	switch {
+	case refused != nil:
+	case jobPool.barred(queueJob) == true:
+		refused = ErrBarrier
+	case jobPool.tenantAtCapacity(queueJob.tenant) == true:
+		refused = ErrTenantQuotaExceeded
+	case jobPool.bytesAtCapacity(queueJob) == true:
+		refused = ErrQueueBytesExceeded
+	case jobPool.memoryRefused(queueJob) == true:
+		refused = ErrMemoryGuarded
+	case jobPool.reserveSlot() == false:
+		if jobPool.config.OverflowPolicy != DropOldest || jobPool.evictOldest() == false || jobPool.reserveSlot() == false {
+			refused = ErrPoolAtCapacity
+		}
+	}
+
+	if refused != nil {
+		queueJob.dispose(jobPending, DispositionRejected)
+		refused = jobPool.rejection(refused, queueJob.Jobber, queueJob)
+		go jobPool.reject("Queue", queueJob.Jobber, refused)
+		return refused
+	}
+
+	// The job leaves the source pool once it is admitted here.
+	queueJob.account(DispositionTransferred)
+	queueJob.integrity = nil
+
+	jobPool.pushJob(queueJob)
+	jobPool.holdUnique(queueJob)
+
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// cooldown remembers the keys of unique jobs that completed within the DedupCooldown. The
+	// keys are kept in the order they completed so the oldest is both the first to expire and
+	// the least recently used. It is only used by the queue routine.
+	cooldown struct {
+		window   time.Duration            // How long a key is remembered after its job completed.
+		capacity int                      // The most keys remembered at once.
+		entries  map[string]*list.Element // The element of each remembered key.
+		order    *list.List               // The remembered keys, the most recently completed at the front.
+		sweeping bool                     // If a sweep is waiting on the scheduler.
+	}
+
+	// cooldownEntry is a key in the cooldown.
+	cooldownEntry struct {
+		key       string    // The key of the job that completed.
+		expiresAt time.Time // When the key is forgotten.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// defaultCooldownKeys is the number of keys remembered when DedupCooldownKeys is not set.
+	defaultCooldownKeys = 10000
+)
+
+//** VARIABLES
+
+var (
+	// ErrDuplicateJob is returned by QueueJobUnique when a job with the same key is pending or
+	// running.
+	ErrDuplicateJob = errors.New("Duplicate Job")
+
+	// ErrRecentlyProcessed is returned by QueueJobUnique when a job with the same key completed
+	// within the DedupCooldown.
+	ErrRecentlyProcessed = errors.New("Job Recently Processed")
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// QueueJobUnique queues a job like QueueJob unless a job with the same key is pending or
+// running, in which case ErrDuplicateJob is returned. With a DedupCooldown set the key is also
+// refused with ErrRecentlyProcessed for the window after its job completed without an error.
+// A job that failed or was cancelled frees its key straight away. A job with an empty key is
+// queued like QueueJob.
+//
+// A job that implements Coalescer is merged into the pending job holding its key instead, and
+// nil is returned. If the job holding the key has already been dequeued the job is queued on
+// its own and takes over the key.
+func (jobPool *JobPool) QueueJobUnique(goRoutine string, key string, jober Jobber, priority bool, options ...JobOption) error {
+	options = append(options[:len(options):len(options)], func(queueJob *queueJob) {
+		queueJob.key = key
+	})
+
+	return jobPool.QueueJob(goRoutine, jober, priority, options...)
+}
+
+// QueueJobUniqueAsync queues a job like QueueJobAsync with the checks of QueueJobUnique. The
+// handle of a job merged into the pending job holding its key is admitted straight away and
+// follows the merged job, so its Done channel is closed once the merged job finishes.
+func (jobPool *JobPool) QueueJobUniqueAsync(goRoutine string, key string, jober Jobber, priority bool, options ...JobOption) *JobHandle {
+	options = append(options[:len(options):len(options)], func(queueJob *queueJob) {
+		queueJob.key = key
+	})
+
+	return jobPool.QueueJobAsync(goRoutine, jober, priority, options...)
+}
+
+//** PRIVATE FUNCTIONS
+
+// newCooldown creates the cooldown for the configuration or returns nil when there is no
+// DedupCooldown.
+func newCooldown(config Config) *cooldown {
+	if config.DedupCooldown <= 0 {
+		return nil
+	}
+
+	capacity := config.DedupCooldownKeys
+	if capacity <= 0 {
+		capacity = defaultCooldownKeys
+	}
+
+	return &cooldown{
+		window:   config.DedupCooldown,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// checkUnique returns the error a unique job is refused with while its key is held by another
+// job or cooling down. It is only called by the queue routine.
+func (jobPool *JobPool) checkUnique(queueJob *queueJob) error {
+	if queueJob.key == "" {
+		return nil
+	}
+
+	if holder, found := jobPool.uniqueJobs[queueJob.key]; found == true {
+		// A job cancelled away from the queue routine frees its key once it is seen.
+		switch {
+		case atomic.LoadInt32(&holder.state) == jobCancelled:
+			delete(jobPool.uniqueJobs, queueJob.key)
+
+		case queueJob.supersedes(holder) == false:
+			return fmt.Errorf("%w : Key[%s]", ErrDuplicateJob, queueJob.key)
+		}
+	}
+
+	if jobPool.cooldown.active(queueJob.key, jobPool.clock().Now()) == true {
+		return fmt.Errorf("%w : Key[%s]", ErrRecentlyProcessed, queueJob.key)
+	}
+
+	return nil
+}
+
+// holdUnique records the job as the holder of its key once it is admitted. It is only called
+// by the queue routine.
+func (jobPool *JobPool) holdUnique(queueJob *queueJob) {
+	if queueJob.key == "" {
+		return
+	}
+
+	jobPool.uniqueJobs[queueJob.key] = queueJob
+}
+
+// releaseUnique frees the key held by the job and starts its cool-down when the job completed.
+// It is only called by the queue routine.
+func (jobPool *JobPool) releaseUnique(queueJob *queueJob, completed bool) {
+	if queueJob.key == "" || jobPool.uniqueJobs[queueJob.key] != queueJob {
+		return
+	}
+
+	delete(jobPool.uniqueJobs, queueJob.key)
+
+	if completed == false || jobPool.cooldown == nil {
+		return
+	}
+
+	jobPool.cooldown.add(queueJob.key, jobPool.clock().Now())
+	jobPool.scheduleCooldownSweep()
+}
+
+// finishUnique has the queue routine free the key of a job that finished running.
+func (jobPool *JobPool) finishUnique(queueJob *queueJob, completed bool) {
+	if queueJob.key == "" {
+		return
+	}
+
+	jobPool.runInQueue(func() {
+		jobPool.releaseUnique(queueJob, completed)
+	})
+}
+
+// scheduleCooldownSweep registers a sweep of the cooldown with the scheduler for when its
+// oldest key expires. It is only called by the queue routine.
+func (jobPool *JobPool) scheduleCooldownSweep() {
+	cooldown := jobPool.cooldown
+	if cooldown.sweeping == true || cooldown.order.Len() == 0 {
+		return
+	}
+
+	oldest := cooldown.order.Back().Value.(*cooldownEntry)
+	if jobPool.scheduler.scheduleAt(oldest.expiresAt, jobPool.sweepCooldown) != nil {
+		cooldown.sweeping = true
+	}
+}
+
+// sweepCooldown forgets the keys whose cool-down is over. It runs on the scheduler and hands
+// the work to the queue routine.
+func (jobPool *JobPool) sweepCooldown() {
+	jobPool.runInQueue(func() {
+		jobPool.cooldown.sweeping = false
+		jobPool.cooldown.sweep(jobPool.clock().Now())
+		jobPool.scheduleCooldownSweep()
+	})
+}
+
+// active returns true if the key completed within the window.
+func (cooldown *cooldown) active(key string, now time.Time) bool {
+	if cooldown == nil {
+		return false
+	}
+
+	element, found := cooldown.entries[key]
+	if found == false {
+		return false
+	}
+
+	return now.Before(element.Value.(*cooldownEntry).expiresAt)
+}
+
+// add remembers the key for the window and forgets the least recently completed key when the
+// cooldown is over its capacity.
+func (cooldown *cooldown) add(key string, now time.Time) {
+	expiresAt := now.Add(cooldown.window)
+
+	if element, found := cooldown.entries[key]; found == true {
+		element.Value.(*cooldownEntry).expiresAt = expiresAt
+		cooldown.order.MoveToFront(element)
+		return
+	}
+
+	cooldown.entries[key] = cooldown.order.PushFront(&cooldownEntry{
+		key:       key,
+		expiresAt: expiresAt,
+	})
+
+	for cooldown.order.Len() > cooldown.capacity {
+		cooldown.remove(cooldown.order.Back())
+	}
+}
+
+// sweep forgets the keys that have expired.
+func (cooldown *cooldown) sweep(now time.Time) {
+	for element := cooldown.order.Back(); element != nil; element = cooldown.order.Back() {
+		if now.Before(element.Value.(*cooldownEntry).expiresAt) == true {
+			return
+		}
+
+		cooldown.remove(element)
+	}
+}
+
+// remove forgets the key held by the element.
+func (cooldown *cooldown) remove(element *list.Element) {
+	delete(cooldown.entries, element.Value.(*cooldownEntry).key)
+	cooldown.order.Remove(element)
+}
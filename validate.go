@@ -0,0 +1,44 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+//** VARIABLES
+
+var (
+	// ErrNilJob is returned when a nil Jobber is queued.
+	ErrNilJob = errors.New("Nil Job")
+
+	// ErrInvalidJob is returned with the Validator's error when the Validator rejects a job.
+	ErrInvalidJob = errors.New("Invalid Job")
+)
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// checkJob returns ErrNilJob for a nil job, ErrQuarantined for a job whose type is in quarantine
+// and ErrInvalidJob joined with the Validator's error for a job the Validator rejects.
+func (jobPool *JobPool) checkJob(jober Jobber) error {
+	if jober == nil {
+		return ErrNilJob
+	}
+
+	if err := jobPool.checkQuarantine(jober); err != nil {
+		return err
+	}
+
+	if jobPool.config.Validator == nil {
+		return nil
+	}
+
+	if err := jobPool.config.Validator(jober); err != nil {
+		return fmt.Errorf("%w : %w", ErrInvalidJob, err)
+	}
+
+	return nil
+}
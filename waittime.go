@@ -0,0 +1,247 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// WaitStats holds the time jobs waited in queue before a job routine started them over the
+	// last minute.
+	WaitStats struct {
+		Priority WaitTime            `json:"priority"`          // The wait of jobs queued as priority jobs.
+		Normal   WaitTime            `json:"normal"`            // The wait of jobs queued as normal jobs.
+		Tenants  map[string]WaitTime `json:"tenants,omitempty"` // The wait of the jobs of each tenant.
+		Groups   map[string]WaitTime `json:"groups,omitempty"`  // The wait of the jobs of each group.
+	}
+
+	// WaitTime summarizes the time jobs waited in queue.
+	WaitTime struct {
+		Started int64         `json:"started"` // The number of jobs started.
+		Max     time.Duration `json:"max"`     // The longest wait.
+		P95     time.Duration `json:"p95"`     // The wait 95% of the jobs started within, rounded up to a power of two microseconds.
+	}
+
+	// waitWindow counts the waits of the last minute in a histogram for each second. Recording a
+	// wait is O(1) and the memory used is fixed.
+	waitWindow struct {
+		seconds [utilizationWindow]int64              // The unix second each slot holds.
+		counts  [utilizationWindow][waitBuckets]int32 // The number of waits in each bucket for each second.
+		max     [utilizationWindow]time.Duration      // The longest wait for each second.
+	}
+
+	// waitTimes holds the wait windows for the priority levels, tenants and groups.
+	waitTimes struct {
+		priority *waitWindow            // The wait of priority jobs.
+		normal   *waitWindow            // The wait of normal jobs.
+		tenants  map[string]*waitWindow // The wait for each tenant.
+		groups   map[string]*waitWindow // The wait for each group.
+		mutex    sync.Mutex             // Protects the windows.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// waitBuckets is the number of histogram buckets. Bucket i holds the waits up to 2^i
+	// microseconds and the last bucket holds every longer wait.
+	waitBuckets = 32
+
+	// maxWaitKeys is the number of tenants or groups given their own wait window. The waits of
+	// the tenants or groups seen after the limit is reached are combined under OtherJobTypes.
+	maxWaitKeys = 64
+)
+
+//** PRIVATE FUNCTIONS
+
+// newWaitTimes creates the wait windows.
+func newWaitTimes() *waitTimes {
+	return &waitTimes{
+		priority: &waitWindow{},
+		normal:   &waitWindow{},
+		tenants:  make(map[string]*waitWindow),
+		groups:   make(map[string]*waitWindow),
+	}
+}
+
+// waitBucket returns the histogram bucket for the wait.
+func waitBucket(waited time.Duration) int {
+	bucket := 0
+	for limit := time.Microsecond; waited > limit && bucket < waitBuckets-1; limit <<= 1 {
+		bucket++
+	}
+
+	return bucket
+}
+
+// keyedWindow returns the window for the key. Once the limit is reached a window with nothing
+// left inside the last minute makes room for the key, otherwise the key is combined under
+// OtherJobTypes. The mutex must be held.
+func keyedWindow(windows map[string]*waitWindow, key string, second int64) *waitWindow {
+	if waitWindow, found := windows[key]; found == true {
+		return waitWindow
+	}
+
+	if len(windows) >= maxWaitKeys {
+		for staleKey, waitWindow := range windows {
+			if waitWindow.newest() <= second-utilizationWindow {
+				delete(windows, staleKey)
+				break
+			}
+		}
+	}
+
+	if len(windows) >= maxWaitKeys {
+		key = OtherJobTypes
+		if waitWindow, found := windows[key]; found == true {
+			return waitWindow
+		}
+	}
+
+	waitWindow := &waitWindow{}
+	windows[key] = waitWindow
+
+	return waitWindow
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// recordWait adds the time the job waited in queue before it was started.
+func (jobPool *JobPool) recordWait(queueJob *queueJob, started time.Time) {
+	waitTimes := jobPool.waitTimes
+	waited := started.Sub(queueJob.enqueuedAt)
+	second := started.Unix()
+
+	waitTimes.mutex.Lock()
+	defer waitTimes.mutex.Unlock()
+
+	if queueJob.priority == true {
+		waitTimes.priority.add(second, waited)
+	} else {
+		waitTimes.normal.add(second, waited)
+	}
+
+	if queueJob.tenant != "" {
+		keyedWindow(waitTimes.tenants, queueJob.tenant, second).add(second, waited)
+	}
+
+	if queueJob.group != "" {
+		keyedWindow(waitTimes.groups, queueJob.group, second).add(second, waited)
+	}
+}
+
+// waitStats returns the waits over the last minute. Tenants and groups without a job started
+// in the last minute are left out.
+func (jobPool *JobPool) waitStats() WaitStats {
+	waitTimes := jobPool.waitTimes
+	now := time.Now().Unix()
+
+	waitTimes.mutex.Lock()
+	defer waitTimes.mutex.Unlock()
+
+	waitStats := WaitStats{
+		Priority: waitTimes.priority.summary(now),
+		Normal:   waitTimes.normal.summary(now),
+	}
+
+	if len(waitTimes.tenants) > 0 {
+		waitStats.Tenants = make(map[string]WaitTime, len(waitTimes.tenants))
+		for tenant, waitWindow := range waitTimes.tenants {
+			if waitTime := waitWindow.summary(now); waitTime.Started > 0 {
+				waitStats.Tenants[tenant] = waitTime
+			}
+		}
+	}
+
+	if len(waitTimes.groups) > 0 {
+		waitStats.Groups = make(map[string]WaitTime, len(waitTimes.groups))
+		for group, waitWindow := range waitTimes.groups {
+			if waitTime := waitWindow.summary(now); waitTime.Started > 0 {
+				waitStats.Groups[group] = waitTime
+			}
+		}
+	}
+
+	return waitStats
+}
+
+// add counts a wait in the slot for the second.
+func (waitWindow *waitWindow) add(second int64, waited time.Duration) {
+	slot := second % utilizationWindow
+	if waitWindow.seconds[slot] != second {
+		waitWindow.seconds[slot] = second
+		waitWindow.counts[slot] = [waitBuckets]int32{}
+		waitWindow.max[slot] = 0
+	}
+
+	waitWindow.counts[slot][waitBucket(waited)]++
+
+	if waited > waitWindow.max[slot] {
+		waitWindow.max[slot] = waited
+	}
+}
+
+// newest returns the latest second a wait was counted in.
+func (waitWindow *waitWindow) newest() int64 {
+	var newest int64
+	for _, second := range waitWindow.seconds {
+		if second > newest {
+			newest = second
+		}
+	}
+
+	return newest
+}
+
+// summary combines the slots inside the window.
+func (waitWindow *waitWindow) summary(now int64) WaitTime {
+	var waitTime WaitTime
+	waitTime.Started, waitTime.Max, waitTime.P95 = waitWindow.tally(now, 95)
+
+	return waitTime
+}
+
+// tally combines the slots inside the window and returns the number of durations counted, the
+// longest and the one the percentile of them are within.
+func (waitWindow *waitWindow) tally(now int64, percentile int64) (total int64, longest time.Duration, within time.Duration) {
+	var counts [waitBuckets]int64
+
+	oldest := now - utilizationWindow
+	for slot, second := range waitWindow.seconds {
+		if second <= oldest {
+			continue
+		}
+
+		for bucket, count := range waitWindow.counts[slot] {
+			counts[bucket] += int64(count)
+			total += int64(count)
+		}
+
+		if waitWindow.max[slot] > longest {
+			longest = waitWindow.max[slot]
+		}
+	}
+
+	// Find the bucket that holds the percentile.
+	threshold := (total*percentile + 99) / 100
+	var seen int64
+	for bucket, count := range counts {
+		seen += count
+		if seen > 0 && seen >= threshold {
+			within = time.Microsecond << uint(bucket)
+			break
+		}
+	}
+
+	if within > longest {
+		within = longest
+	}
+
+	return total, longest, within
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//** TYPES
+
+type (
+	// wakeUps counts the jobs that job routines can dequeue. The queue routine posts a wake up for
+	// every job placed in the queues and retracts one for every job taken out other than by a
+	// dequeue, so the count always matches the jobs no routine has claimed yet.
+	wakeUps struct {
+		pending int        // The number of jobs waiting for a job routine to claim them.
+		closed  bool       // Set once the job routines are told to shut down.
+		mutex   sync.Mutex // Protects the count.
+		cond    *sync.Cond // Signals the job routines waiting for a job.
+	}
+)
+
+//** PRIVATE FUNCTIONS
+
+// newWakeUps creates a count with no pending jobs.
+func newWakeUps() *wakeUps {
+	wakeUps := wakeUps{}
+	wakeUps.cond = sync.NewCond(&wakeUps.mutex)
+
+	return &wakeUps
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// post adds wake ups for jobs placed in the queues.
+func (wakeUps *wakeUps) post(posted int) {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	wakeUps.pending += posted
+
+	if posted == 1 {
+		wakeUps.cond.Signal()
+		return
+	}
+
+	wakeUps.cond.Broadcast()
+}
+
+// retract removes the wake ups for jobs taken out of the queues without being dequeued. A job
+// routine that claimed a wake up before the job was removed finds nothing to dequeue, so the
+// count never drops below zero.
+func (wakeUps *wakeUps) retract(removed int) {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	wakeUps.pending -= removed
+	if wakeUps.pending < 0 {
+		wakeUps.pending = 0
+	}
+}
+
+// wait blocks until there is a job to claim and claims it. It returns false once the job
+// routines are told to shut down or the routine waiting is retired, in which case a wake up
+// meant for it is passed on to another routine.
+func (wakeUps *wakeUps) wait(retired *int32) bool {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	for wakeUps.pending == 0 && wakeUps.closed == false && atomic.LoadInt32(retired) == 0 {
+		wakeUps.cond.Wait()
+	}
+
+	if wakeUps.closed == true {
+		return false
+	}
+
+	if atomic.LoadInt32(retired) == 1 {
+		if wakeUps.pending > 0 {
+			wakeUps.cond.Signal()
+		}
+		return false
+	}
+
+	wakeUps.pending--
+	return true
+}
+
+// tryWait claims a job without blocking. It returns false if there is no job to claim or the
+// job routines are told to shut down.
+func (wakeUps *wakeUps) tryWait() bool {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	if wakeUps.pending == 0 || wakeUps.closed == true {
+		return false
+	}
+
+	wakeUps.pending--
+	return true
+}
+
+// retire tells the job routine waiting on retired to shut down.
+func (wakeUps *wakeUps) retire(retired *int32) {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	atomic.StoreInt32(retired, 1)
+	wakeUps.cond.Broadcast()
+}
+
+// close tells every job routine waiting for a job to shut down.
+func (wakeUps *wakeUps) close() {
+	wakeUps.mutex.Lock()
+	defer wakeUps.mutex.Unlock()
+
+	wakeUps.closed = true
+	wakeUps.cond.Broadcast()
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//** PUBLIC FUNCTIONS
+
+// TestWakeUpsMatchQueue queues jobs while every job routine is busy and removes them by cancel,
+// group cancel, cancel by ID and eviction, checking after each step that the pending wake ups
+// equal the jobs in the queues. Once the routines are released every job left must run and no
+// wake up may be left over.
+func TestWakeUpsMatchQueue(t *testing.T) {
+	steps, routines := 2000, 4
+	if testing.Short() == true {
+		steps = 200
+	}
+
+	var evicted int64
+	jobPool := newTestPool(t, routines, 8, WithOverflowPolicy(DropOldest, func(jober Jobber, waited time.Duration) {
+		atomic.AddInt64(&evicted, 1)
+	}))
+
+	// Hold every job routine so nothing leaves the queues by being dequeued.
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	for i := 0; i < routines; i++ {
+		blocker, started := blockingJob(release)
+		if err := jobPool.QueueJob("test", blocker, false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+		<-started
+	}
+
+	var ran int64
+	job := funcJob(func(jobRoutine int) {
+		atomic.AddInt64(&ran, 1)
+	})
+
+	random := rand.New(rand.NewSource(1))
+
+	var queued, cancelled int64
+	for step := 0; step < steps; step++ {
+		switch random.Intn(6) {
+		case 0, 1:
+			if err := jobPool.QueueJob("test", job, random.Intn(4) == 0, WithGroup("group")); err != nil {
+				t.Fatalf("Step %d : QueueJob : %s", step, err)
+			}
+			queued++
+
+		case 2:
+			if err := jobPool.QueueJob("test", job, false); err != nil {
+				t.Fatalf("Step %d : QueueJob : %s", step, err)
+			}
+			queued++
+
+		case 3:
+			// QueueJobAsync doesn't evict, it refuses the job once the queue is full.
+			handle := jobPool.QueueJobAsync("test", job, false)
+			err := handle.Wait()
+			if errors.Is(err, ErrPoolAtCapacity) == true {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("Step %d : QueueJobAsync : %s", step, err)
+			}
+			queued++
+
+			if random.Intn(2) == 0 {
+				found, err := jobPool.Cancel(handle.Sequence())
+				if err != nil {
+					t.Fatalf("Step %d : Cancel : %s", step, err)
+				}
+
+				if found == true {
+					cancelled++
+				}
+			}
+
+		case 4:
+			n, err := jobPool.CancelPending("test", false, random.Intn(2) == 0, nil)
+			if err != nil {
+				t.Fatalf("Step %d : CancelPending : %s", step, err)
+			}
+			cancelled += int64(n)
+
+		case 5:
+			n, err := jobPool.CancelGroup("test", "group")
+			if err != nil {
+				t.Fatalf("Step %d : CancelGroup : %s", step, err)
+			}
+			cancelled += int64(n)
+		}
+
+		if pending, queuedJobs := pendingWakeUps(jobPool), int(jobPool.QueuedJobs()); pending != queuedJobs {
+			t.Fatalf("Step %d : Pending wake ups[%d] QueuedJobs[%d]", step, pending, queuedJobs)
+		}
+	}
+
+	// Leave jobs behind for the released routines to run.
+	for i := 0; i < routines; i++ {
+		if err := jobPool.QueueJob("test", job, false); err != nil {
+			t.Fatalf("QueueJob : %s", err)
+		}
+		queued++
+	}
+
+	releaseOnce()
+
+	waitFor(t, 5*time.Second, "the jobs left to run", func() bool {
+		return atomic.LoadInt64(&ran)+cancelled+atomic.LoadInt64(&evicted) == queued
+	})
+
+	waitFor(t, 5*time.Second, "the job routines to go idle", func() bool {
+		return jobPool.ActiveRoutines() == 0
+	})
+
+	if pending := pendingWakeUps(jobPool); pending != 0 {
+		t.Fatalf("Pending wake ups[%d] with nothing queued", pending)
+	}
+}
+
+//** PRIVATE FUNCTIONS
+
+// pendingWakeUps returns the wake ups no job routine has claimed.
+func pendingWakeUps(jobPool *JobPool) int {
+	jobPool.wakeUps.mutex.Lock()
+	defer jobPool.wakeUps.mutex.Unlock()
+
+	return jobPool.wakeUps.pending
+}
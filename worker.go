@@ -0,0 +1,296 @@
+// Copyright 2013 Ardan Studios. All rights reserved.
+// Use of jobPool source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobpool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//** TYPES
+
+type (
+	// WorkerStat describes the work performed by a single job routine.
+	WorkerStat struct {
+		Routine        int               `json:"routine"`            // The index of the job routine.
+		Name           string            `json:"name"`               // The name the routine uses in logs and panic reports.
+		JobsProcessed  int64             `json:"jobs_processed"`     // The number of jobs the routine has run.
+		BusyTime       time.Duration     `json:"busy_time"`          // The time the routine has spent running jobs.
+		LastJobStarted time.Time         `json:"last_job_started"`   // When the routine started its most recent job.
+		Running        bool              `json:"running"`            // If the routine is running a job right now.
+		JobID          uint64            `json:"job_id,omitempty"`   // The ID of the job the routine is running or ran last.
+		Job            string            `json:"job,omitempty"`      // The name of the job the routine is running or ran last.
+		Metadata       map[string]string `json:"metadata,omitempty"` // The key/value pairs attached to the job the routine is running or ran last.
+		Utilization    float64           `json:"utilization"`        // The fraction of the last minute the routine spent running jobs.
+		Retired        bool              `json:"retired,omitempty"`  // If the routine has been retired with RetireWorker. It exits once its current job is done.
+	}
+
+	// workerState holds the counters for a single job routine.
+	workerState struct {
+		name           string                   // The name the routine uses in logs and panic reports.
+		jobsProcessed  int64                    // The number of jobs the routine has run.
+		busyTime       time.Duration            // The time the routine has spent running jobs.
+		lastJobStarted time.Time                // When the routine started its most recent job.
+		running        bool                     // If the routine is running a job right now.
+		jobID          uint64                   // The ID of the job the routine is running or ran last.
+		job            string                   // The name of the job the routine is running or ran last.
+		metadata       map[string]string        // The key/value pairs attached to the job the routine is running or ran last.
+		busy           int32                    // Set to 1 while the routine is running a job.
+		retired        int32                    // Set to 1 once the routine has been told to exit by RetireWorker.
+		goroutineID    uint64                   // The ID of the goroutine running the routine, see onJobRoutine. Zero once the routine is down.
+		busySeconds    [utilizationWindow]int64 // The busy nanoseconds for each of the last seconds.
+		seconds        [utilizationWindow]int64 // The unix second each busySeconds slot holds.
+		mutex          sync.Mutex               // Protects the counters.
+	}
+)
+
+//** CONSTANTS
+
+const (
+	// utilizationWindow is the number of seconds utilization is measured over.
+	utilizationWindow = 60
+)
+
+//** VARIABLES
+
+var (
+	// ErrUnknownWorker is returned by RetireWorker for a routine the pool doesn't have.
+	ErrUnknownWorker = errors.New("Unknown Worker")
+
+	// ErrWorkerRetired is returned by RetireWorker for a routine that has already been retired.
+	ErrWorkerRetired = errors.New("Worker Already Retired")
+
+	// ErrLastWorker is returned by RetireWorker when retiring the routine would leave the pool
+	// with none.
+	ErrLastWorker = errors.New("Can't Retire The Last Worker")
+)
+
+//** PUBLIC MEMBER FUNCTIONS
+
+// WorkerStats returns the counters for each job routine, including the routines that have been
+// retired.
+func (jobPool *JobPool) WorkerStats() []WorkerStat {
+	now := time.Now()
+
+	workerStates := jobPool.workerStates()
+
+	workerStats := make([]WorkerStat, len(workerStates))
+	for jobRoutine, workerState := range workerStates {
+		workerStats[jobRoutine] = workerState.stat(jobRoutine, now)
+	}
+
+	return workerStats
+}
+
+// RetireWorker tells the job routine to finish the job it is running and exit, leaving the other
+// routines alone, for example to take a routine stuck on a wedged OS thread out of rotation. It
+// returns without waiting for the routine. WorkerStats keeps reporting the routine as retired
+// and gangs can only use the routines left. A gang already dequeued that needs more routines
+// than are left is cancelled.
+func (jobPool *JobPool) RetireWorker(routineID int) error {
+	jobPool.workerMutex.Lock()
+	defer jobPool.workerMutex.Unlock()
+
+	if jobPool.workersClosed == true || atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return ErrPoolClosed
+	}
+
+	if routineID < 0 || routineID >= len(jobPool.workers) {
+		return fmt.Errorf("%w : Routine[%d]", ErrUnknownWorker, routineID)
+	}
+
+	workerState := jobPool.workers[routineID]
+	if atomic.LoadInt32(&workerState.retired) == 1 {
+		return fmt.Errorf("%w : Routine[%d]", ErrWorkerRetired, routineID)
+	}
+
+	if atomic.LoadInt32(&jobPool.routines) <= 1 {
+		return fmt.Errorf("%w : Routine[%d]", ErrLastWorker, routineID)
+	}
+
+	atomic.AddInt32(&jobPool.routines, -1)
+	jobPool.wakeUps.retire(&workerState.retired)
+
+	jobPool.writeLogf(LogInfo, workerState.name, "RetireWorker", "Retired : Routine[%d]", routineID)
+	return nil
+}
+
+// AddWorker starts a new job routine, for example to replace one that was retired, and returns
+// its ID. The routine starts like the routines created with the pool and takes the next ID
+// rather than reusing a retired one.
+func (jobPool *JobPool) AddWorker() (routineID int, err error) {
+	jobPool.workerMutex.Lock()
+	defer jobPool.workerMutex.Unlock()
+
+	if jobPool.workersClosed == true || atomic.LoadInt32(&jobPool.shutdown) == 1 {
+		return 0, ErrPoolClosed
+	}
+
+	routineID = len(jobPool.workers)
+	jobPool.workers = append(jobPool.workers, &workerState{
+		name: jobPool.routineName(routineID),
+	})
+
+	atomic.AddInt32(&jobPool.routines, 1)
+	jobPool.workerSlots.add(1)
+
+	jobPool.shutdownWaitGroup.Add(1)
+	go jobPool.jobRoutine(routineID)
+
+	jobPool.writeLogf(LogInfo, jobPool.workers[routineID].name, "AddWorker", "Added : Routine[%d]", routineID)
+	return routineID, nil
+}
+
+//** PRIVATE MEMBER FUNCTIONS
+
+// worker returns the counters of the job routine.
+func (jobPool *JobPool) worker(jobRoutine int) *workerState {
+	jobPool.workerMutex.RLock()
+	defer jobPool.workerMutex.RUnlock()
+
+	return jobPool.workers[jobRoutine]
+}
+
+// workerStates returns the counters of every job routine, retired routines included.
+func (jobPool *JobPool) workerStates() []*workerState {
+	jobPool.workerMutex.RLock()
+	defer jobPool.workerMutex.RUnlock()
+
+	return jobPool.workers
+}
+
+// liveRoutines returns the number of job routines that have not been retired.
+func (jobPool *JobPool) liveRoutines() int {
+	return int(atomic.LoadInt32(&jobPool.routines))
+}
+
+// closeWorkers stops routines being added or retired once the pool is shutting down and returns
+// the counters of every job routine.
+func (jobPool *JobPool) closeWorkers() []*workerState {
+	jobPool.workerMutex.Lock()
+	defer jobPool.workerMutex.Unlock()
+
+	jobPool.workersClosed = true
+	return jobPool.workers
+}
+
+// routineName returns the name a job routine uses in logs and panic reports.
+func (jobPool *JobPool) routineName(jobRoutine int) string {
+	routine := fmt.Sprintf("JobRoutine %d", jobRoutine)
+	if jobRoutine == CallerRoutine {
+		routine = "CallerRuns"
+	}
+
+	if jobPool.config.Name == "" {
+		return routine
+	}
+
+	return fmt.Sprintf("%s Pool[%s]", routine, jobPool.config.Name)
+}
+
+// start records that the routine has started a job.
+func (workerState *workerState) start(started time.Time, jobID uint64, job string, metadata map[string]string) {
+	workerState.mutex.Lock()
+	defer workerState.mutex.Unlock()
+
+	workerState.lastJobStarted = started
+	workerState.running = true
+	workerState.jobID = jobID
+	workerState.job = job
+	workerState.metadata = metadata
+}
+
+// reset zeroes the jobs processed and busy time. The utilization window is left alone.
+func (workerState *workerState) reset() {
+	workerState.mutex.Lock()
+	defer workerState.mutex.Unlock()
+
+	workerState.jobsProcessed = 0
+	workerState.busyTime = 0
+}
+
+// finish records that the routine has finished the job it started.
+func (workerState *workerState) finish(finished time.Time) {
+	workerState.mutex.Lock()
+	defer workerState.mutex.Unlock()
+
+	started := workerState.lastJobStarted
+
+	workerState.jobsProcessed++
+	workerState.busyTime += finished.Sub(started)
+	workerState.running = false
+	workerState.addBusy(started, finished)
+}
+
+// addBusy spreads the busy interval across the per second slots it covers. The mutex must be held.
+func (workerState *workerState) addBusy(started time.Time, finished time.Time) {
+	if earliest := finished.Add(-utilizationWindow * time.Second); started.Before(earliest) {
+		started = earliest
+	}
+
+	for started.Before(finished) {
+		second := started.Unix()
+		next := time.Unix(second+1, 0)
+		if next.After(finished) {
+			next = finished
+		}
+
+		slot := second % utilizationWindow
+		if workerState.seconds[slot] != second {
+			workerState.seconds[slot] = second
+			workerState.busySeconds[slot] = 0
+		}
+
+		workerState.busySeconds[slot] += int64(next.Sub(started))
+		started = next
+	}
+}
+
+// stat returns a copy of the routine's counters.
+func (workerState *workerState) stat(jobRoutine int, now time.Time) WorkerStat {
+	workerState.mutex.Lock()
+	defer workerState.mutex.Unlock()
+
+	// Sum the busy time of the completed jobs inside the window.
+	var busy int64
+	oldest := now.Unix() - utilizationWindow
+	for slot, second := range workerState.seconds {
+		if second > oldest {
+			busy += workerState.busySeconds[slot]
+		}
+	}
+
+	// Add the part of the running job inside the window.
+	if workerState.running == true {
+		started := workerState.lastJobStarted
+		if earliest := now.Add(-utilizationWindow * time.Second); started.Before(earliest) {
+			started = earliest
+		}
+
+		busy += int64(now.Sub(started))
+	}
+
+	utilization := float64(busy) / float64(utilizationWindow*time.Second)
+	if utilization > 1 {
+		utilization = 1
+	}
+
+	return WorkerStat{
+		Routine:        jobRoutine,
+		Name:           workerState.name,
+		JobsProcessed:  workerState.jobsProcessed,
+		BusyTime:       workerState.busyTime,
+		LastJobStarted: workerState.lastJobStarted,
+		Running:        workerState.running,
+		JobID:          workerState.jobID,
+		Job:            workerState.job,
+		Metadata:       workerState.metadata,
+		Utilization:    utilization,
+		Retired:        atomic.LoadInt32(&workerState.retired) == 1,
+	}
+}